@@ -5,11 +5,26 @@ go 1.13
 require (
 	github.com/alecthomas/template v0.0.0-20190718012654-fb15b899a751 // indirect
 	github.com/alecthomas/units v0.0.0-20190924025748-f65c72e2690d // indirect
-	github.com/golang/mock v1.4.1
-	github.com/json-iterator/go v1.1.9
+	github.com/golang/mock v1.4.4
+	github.com/json-iterator/go v1.1.12
 	github.com/mholt/archiver/v3 v3.3.0
+	github.com/nwaples/rardecode v1.0.0
 	github.com/onsi/ginkgo v1.12.0
 	github.com/onsi/gomega v1.9.0
+	github.com/prometheus/client_golang v1.13.0
+	golang.org/x/crypto v0.0.0-20220722155217-630584e8d5aa // indirect
+	golang.org/x/net v0.5.0 // indirect
+	golang.org/x/sys v0.4.0 // indirect
+	golang.org/x/xerrors v0.0.0-20220907171357-04be3eba64a2 // indirect
 	gopkg.in/alecthomas/kingpin.v2 v2.2.6
-	gopkg.in/yaml.v2 v2.2.4
+	gopkg.in/yaml.v2 v2.4.0
+	k8s.io/api v0.0.0-20191016110408-35e52d86657a
+	k8s.io/apimachinery v0.0.0-20191004115801-a2eda9f80ab8
+	k8s.io/client-go v0.0.0-00010101000000-000000000000
 )
+
+replace k8s.io/api => k8s.io/api v0.0.0-20191016110408-35e52d86657a
+
+replace k8s.io/apimachinery => k8s.io/apimachinery v0.0.0-20191004115801-a2eda9f80ab8
+
+replace k8s.io/client-go => k8s.io/client-go v0.0.0-20191016111102-bec269661e48
@@ -1,48 +1,241 @@
 package main
 
 import (
+	"fmt"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 
+	jsoniter "github.com/json-iterator/go"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka/kafka-connectors-setup/pkgs/client"
 	"github.com/mesosphere/kudo-kafka-operator/images/kafka/kafka-connectors-setup/pkgs/config"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka/kafka-connectors-setup/pkgs/supervisor"
 	"github.com/mesosphere/kudo-kafka-operator/images/kafka/kafka-connectors-setup/pkgs/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"gopkg.in/alecthomas/kingpin.v2"
 	"gopkg.in/yaml.v2"
 )
 
 var (
 	app           = kingpin.New("kafka-connectors-setup", "A command-line kafka connectors configuration parser and setup helper.")
-	configFlag    = app.Flag("config", "Path to the configuration file.").Required().File()
-	_             = app.Command("download", "Downloads the resources needed for connectors.")
+	configFlag    = app.Flag("config", "Path to the configuration file, required by download, register, sync and validate.").File()
+	readyTimeout  = app.Flag("ready-timeout", "Maximum time to wait for Kafka Connect to be ready.").Default("5m").Duration()
+	retryTimeout  = app.Flag("retry-timeout", "Maximum time to retry a request while Kafka Connect rebalances or fails.").Default(utils.DEFAULT_RETRY_TIMEOUT.String()).Duration()
+	download      = app.Command("download", "Downloads and extracts the resources of every connector into its own directory of the plugin path.")
+	pluginPath    = download.Flag("plugin-path", "Root of the plugin directories, the plugin.path of kafka-connect, the working directory when not set.").String()
 	register      = app.Command("register", "Register the connectors in kafka-connect.")
 	endpoint      = register.Arg("endpoint", "Kafka Connect REST endpoint").Required().String()
+	sync          = app.Command("sync", "Makes the connectors of kafka-connect match the configuration file.")
+	syncEndpoint  = sync.Arg("endpoint", "Kafka Connect REST endpoint").Required().String()
+	pruneFlag     = sync.Flag("prune", "Delete the running connectors missing from the configuration file.").Bool()
+	prunePrefix   = sync.Flag("prune-prefix", "Only prune the connectors whose name starts with this prefix.").String()
+	dryRunFlag    = sync.Flag("dry-run", "Print the plan without changing kafka-connect.").Bool()
+	validate      = app.Command("validate", "Validates the connector configs with their connector plugins.")
+	validateURL   = validate.Arg("endpoint", "Kafka Connect REST endpoint").Required().String()
+	status        = app.Command("status", "Shows the state of the connectors and of their tasks.")
+	statusURL     = status.Arg("endpoint", "Kafka Connect REST endpoint").Required().String()
+	statusNames   = status.Arg("connector", "Name of a connector, all the running connectors when none is given.").Strings()
+	statusOutput  = status.Flag("output", "Output format.").Default("table").Enum("table", "json")
+	restart       = app.Command("restart", "Restarts connectors and their tasks.")
+	restartURL    = restart.Arg("endpoint", "Kafka Connect REST endpoint").Required().String()
+	restartNames  = restart.Arg("connector", "Name of a connector.").Strings()
+	restartAll    = restart.Flag("all", "Restart all the running connectors.").Bool()
+	failedOnly    = restart.Flag("failed-only", "Only restart the failed connectors and tasks.").Bool()
+	pause         = app.Command("pause", "Pauses connectors and their tasks.")
+	pauseURL      = pause.Arg("endpoint", "Kafka Connect REST endpoint").Required().String()
+	pauseNames    = pause.Arg("connector", "Name of a connector.").Strings()
+	pauseAll      = pause.Flag("all", "Pause all the running connectors.").Bool()
+	resume        = app.Command("resume", "Resumes paused connectors and their tasks.")
+	resumeURL     = resume.Arg("endpoint", "Kafka Connect REST endpoint").Required().String()
+	resumeNames   = resume.Arg("connector", "Name of a connector.").Strings()
+	resumeAll     = resume.Flag("all", "Resume all the running connectors.").Bool()
+	export        = app.Command("export", "Exports the connectors running in kafka-connect as a configuration file.")
+	exportURL     = export.Arg("endpoint", "Kafka Connect REST endpoint").Required().String()
+	exportOutput  = export.Flag("output", "Path of the exported configuration file, the standard output when not set.").Short('o').String()
+	maskFlag      = export.Flag("mask", "Mask the values of the sensitive configs.").Bool()
+	sensitiveKeys = export.Flag("sensitive-keys", "Regular expression matching the keys of the sensitive configs.").Default(config.DEFAULT_SENSITIVE_KEYS).Regexp()
+	supervise     = app.Command("supervise", "Keeps restarting the failed connectors and tasks and exposes their state as prometheus metrics.")
+	superviseURL  = supervise.Arg("endpoint", "Kafka Connect REST endpoint").Required().String()
+	superviseOnly = supervise.Arg("connector", "Name of a supervised connector, all the running connectors when none is given.").Strings()
+	interval      = supervise.Flag("interval", "Interval between two checks of the connectors.").Default(supervisor.DEFAULT_CHECK_INTERVAL.String()).Duration()
+	metricsAddr   = supervise.Flag("metrics-address", "Address serving the prometheus metrics on /metrics.").Default(":9404").String()
+	maxRestarts   = supervise.Flag("max-restarts", "Maximum number of restarts of a connector within the restart window.").Default(fmt.Sprint(supervisor.DEFAULT_MAX_RESTARTS)).Int()
+	restartWindow = supervise.Flag("restart-window", "Window over which the restarts of a connector are counted.").Default(supervisor.DEFAULT_RESTART_WINDOW.String()).Duration()
+	backoff       = supervise.Flag("restart-backoff", "Delay before restarting a connector failing again, doubled on every restart.").Default(supervisor.DEFAULT_RESTART_BACKOFF.String()).Duration()
+	maxBackoff    = supervise.Flag("max-restart-backoff", "Maximum delay before restarting a connector failing again.").Default(supervisor.DEFAULT_MAX_RESTART_BACKOFF.String()).Duration()
 	configuration = &config.ConfigurationSetup{
 		Utils:      &utils.UtilsImpl{},
 		ConfigFile: &config.ConfigFile{},
+		Retry:      utils.DefaultBackoff(),
+		Resolver:   &config.Resolver{NewClient: client.GetKubernetesClient},
 	}
 )
 
 func main() {
 	log.Printf("Running kafka-connectors-setup...")
-	parsed, _ := app.Parse(os.Args[1:])
-	if *configFlag != nil {
-		b, _ := ioutil.ReadAll(*configFlag)
-		if err := yaml.Unmarshal(b, configuration.ConfigFile); err != nil {
-			log.Fatalf("Error in parsing configuration file: %v", err)
-		}
-		switch parsed {
-		case "register":
-			log.Printf("Registering to endpoint %s", *endpoint)
-			configuration.RegisterConnectors(*endpoint)
-
-		case "download":
-			downloadDirectory, _ := os.Getwd()
-			configuration.DownloadConnectorResources(downloadDirectory)
-			log.Printf("Parsing download only connector resources to '%s'", downloadDirectory)
-			configuration.DownloadResources(downloadDirectory)
-		}
-	} else {
+	parsed := kingpin.MustParse(app.Parse(os.Args[1:]))
+	configuration.ReadyTimeout = *readyTimeout
+	configuration.Retry.Timeout = *retryTimeout
+
+	switch parsed {
+	case "status":
+		statuses, err := configuration.ConnectorStatuses(*statusURL, *statusNames)
+		if err != nil {
+			log.Fatalf("Error in getting the status of the connectors: %v", err)
+		}
+		if *statusOutput == "json" {
+			b, err := jsoniter.MarshalIndent(statuses, "", "  ")
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			fmt.Println(string(b))
+		} else {
+			fmt.Print(config.FormatStatusTable(statuses))
+		}
+
+	case "restart":
+		if err := configuration.RestartConnectors(*restartURL, *restartNames, *restartAll, *failedOnly); err != nil {
+			log.Fatalf("Error in restarting connectors: %v", err)
+		}
+
+	case "pause":
+		if err := configuration.PauseConnectors(*pauseURL, *pauseNames, *pauseAll); err != nil {
+			log.Fatalf("Error in pausing connectors: %v", err)
+		}
+
+	case "resume":
+		if err := configuration.ResumeConnectors(*resumeURL, *resumeNames, *resumeAll); err != nil {
+			log.Fatalf("Error in resuming connectors: %v", err)
+		}
+
+	case "export":
+		runExport()
+
+	case "supervise":
+		runSupervisor()
+
+	default:
+		runWithConfigFile(parsed)
+	}
+}
+
+// runExport writes the connectors of kafka-connect in the configuration file format
+func runExport() {
+	if err := configuration.Utils.WaitForReady(*exportURL, *readyTimeout); err != nil {
+		log.Fatalf("%v", err)
+	}
+	exported, err := configuration.Export(*exportURL, config.ExportOptions{
+		Mask:          *maskFlag,
+		SensitiveKeys: *sensitiveKeys,
+	})
+	if err != nil {
+		log.Fatalf("Error in exporting connectors: %v", err)
+	}
+	b, err := yaml.Marshal(exported)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if len(*exportOutput) == 0 {
+		fmt.Print(string(b))
+		return
+	}
+	// the exported configs may hold credentials
+	if err := ioutil.WriteFile(*exportOutput, b, 0600); err != nil {
+		log.Fatalf("Error in writing %s: %v", *exportOutput, err)
+	}
+	log.Printf("Exported %d connectors to %s", len(exported.Connectors), *exportOutput)
+}
+
+// runSupervisor supervises the connectors until the process is terminated
+func runSupervisor() {
+	metrics := supervisor.NewMetrics()
+	if err := metrics.Register(prometheus.DefaultRegisterer); err != nil {
+		log.Fatalf("Error in registering metrics: %v", err)
+	}
+	http.Handle("/metrics", promhttp.Handler())
+	go func() {
+		log.Fatalf("Error in serving metrics: %v", http.ListenAndServe(*metricsAddr, nil))
+	}()
+
+	if err := configuration.Utils.WaitForReady(*superviseURL, *readyTimeout); err != nil {
+		log.Fatalf("%v", err)
+	}
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		close(stop)
+	}()
+	log.Printf("Supervising the connectors of endpoint %s every %s", *superviseURL, *interval)
+	s := &supervisor.Supervisor{
+		Utils:             configuration.Utils,
+		Endpoint:          *superviseURL,
+		Connectors:        *superviseOnly,
+		RestartBackoff:    *backoff,
+		MaxRestartBackoff: *maxBackoff,
+		MaxRestarts:       *maxRestarts,
+		RestartWindow:     *restartWindow,
+		Metrics:           metrics,
+	}
+	s.Run(*interval, stop)
+}
+
+// runWithConfigFile runs the commands working on the connectors of the configuration file
+func runWithConfigFile(parsed string) {
+	if *configFlag == nil {
 		log.Fatalf("No configuration file provided or the file cannot be accessed")
 	}
+	b, _ := ioutil.ReadAll(*configFlag)
+	if err := yaml.Unmarshal(b, configuration.ConfigFile); err != nil {
+		log.Fatalf("Error in parsing configuration file: %v", err)
+	}
+	switch parsed {
+	case "register":
+		log.Printf("Registering to endpoint %s", *endpoint)
+		if err := configuration.RegisterConnectors(*endpoint); err != nil {
+			log.Fatalf("Error in registering connectors: %v", err)
+		}
+
+	case "sync":
+		log.Printf("Syncing the connectors of endpoint %s", *syncEndpoint)
+		plan, err := configuration.Sync(*syncEndpoint, config.SyncOptions{
+			Prune:       *pruneFlag,
+			PrunePrefix: *prunePrefix,
+			DryRun:      *dryRunFlag,
+		})
+		if *dryRunFlag {
+			fmt.Print(plan)
+		}
+		if err != nil {
+			log.Fatalf("Error in syncing connectors: %v", err)
+		}
+
+	case "validate":
+		if err := configuration.Utils.WaitForReady(*validateURL, *readyTimeout); err != nil {
+			log.Fatalf("%v", err)
+		}
+		report, err := configuration.ValidateConnectors(*validateURL)
+		if err != nil {
+			log.Fatalf("Error in validating connectors: %v", err)
+		}
+		if len(report) > 0 {
+			fmt.Print(report)
+			os.Exit(1)
+		}
+		log.Printf("All the connectors are valid")
+
+	case "download":
+		root := *pluginPath
+		if len(root) == 0 {
+			root, _ = os.Getwd()
+		}
+		configuration.DownloadConnectorResources(root)
+		log.Printf("Parsing download only connector resources to '%s'", root)
+		configuration.DownloadResources(root)
+	}
 }
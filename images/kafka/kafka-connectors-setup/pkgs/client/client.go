@@ -0,0 +1,27 @@
+package client
+
+import (
+	"log"
+	"os"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// GetKubernetesClient returns a client configured from $KUBECONFIG, or from the service account of the pod when it is not set
+func GetKubernetesClient() (kubernetes.Interface, error) {
+	var config *rest.Config
+	var err error
+	if kubeconfig := os.Getenv("KUBECONFIG"); kubeconfig != "" {
+		log.Printf("kubeconfig file: %s", kubeconfig)
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	} else {
+		log.Printf("kubeconfig file: using InClusterConfig.")
+		config, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(config)
+}
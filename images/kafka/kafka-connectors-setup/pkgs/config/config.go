@@ -1,72 +1,175 @@
 package config
 
 import (
+	"fmt"
 	"log"
-	"path"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/mesosphere/kudo-kafka-operator/images/kafka/kafka-connectors-setup/pkgs/utils"
 )
 
 // Connector Struct
 type Connector struct {
-	Resources []string    `json:"resources,omitempty" yaml:"resources,omitempty"`
-	Config    interface{} `json:"config,omitempty" yaml:"config,omitempty"`
+	Resources []utils.Resource `json:"resources,omitempty" yaml:"resources,omitempty"`
+	Config    interface{}      `json:"config,omitempty" yaml:"config,omitempty"`
 }
 
 // ConfigFile Struct
 type ConfigFile struct {
 	Connectors map[string]Connector `json:"connectors,omitempty" yaml:"connectors,omitempty"`
-	Resources  []string             `json:"resources,omitempty" yaml:"resources,omitempty"`
+	Resources  []utils.Resource     `json:"resources,omitempty" yaml:"resources,omitempty"`
 }
 
 // ConfigurationSetup Struct
 type ConfigurationSetup struct {
 	Utils      utils.Utils
 	ConfigFile *ConfigFile
+	// ReadyTimeout is the maximum time to wait for kafka-connect to be ready before registering the connectors
+	ReadyTimeout time.Duration
+	// Retry retries the registrations failing while kafka-connect rebalances or fails
+	Retry utils.Backoff
+	// Resolver resolves the placeholders of the connector configs, they are sent verbatim when nil
+	Resolver *Resolver
 }
 
-// RegisterConnectors Registers Connectors to endpoint
-func (c *ConfigurationSetup) RegisterConnectors(endpoint string) {
-	for name, connector := range c.ConfigFile.Connectors {
+// ConnectorConfig returns the config of the connector name.
+// Config is either the config itself or the {"name": ..., "config": {...}} payload of POST /connectors.
+func (c Connector) ConnectorConfig(name string) (map[string]string, error) {
+	config, err := toStringMap(c.Config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config of connector %s: %v", name, err)
+	}
+	inner, ok := config["config"]
+	if !ok {
+		return stringValues(config)
+	}
+	if payloadName, ok := config["name"]; ok && fmt.Sprint(payloadName) != name {
+		return nil, fmt.Errorf("connector %s is named %v in its config", name, payloadName)
+	}
+	innerConfig, err := toStringMap(inner)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config of connector %s: %v", name, err)
+	}
+	return stringValues(innerConfig)
+}
+
+// connectorConfig returns the config of the connector name with its placeholders resolved
+func (c *ConfigurationSetup) connectorConfig(name string) (map[string]string, error) {
+	config, err := c.ConfigFile.Connectors[name].ConnectorConfig(name)
+	if err != nil || c.Resolver == nil {
+		return config, err
+	}
+	return c.Resolver.Resolve(config)
+}
+
+// ConnectorNames returns the names of the connectors in a stable order
+func (c *ConfigFile) ConnectorNames() []string {
+	var names []string
+	for name := range c.Connectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RegisterConnectors Registers Connectors to endpoint once it is ready and all of them are valid, a failed registration does not stop the others
+func (c *ConfigurationSetup) RegisterConnectors(endpoint string) error {
+	if err := c.Utils.WaitForReady(endpoint, c.ReadyTimeout); err != nil {
+		return err
+	}
+	if err := c.validate(endpoint); err != nil {
+		return err
+	}
+	var failed []string
+	for _, name := range c.ConfigFile.ConnectorNames() {
 		log.Printf("Registering connector: %s\n", name)
-		err := c.Utils.RegisterConnector(endpoint, connector.Config)
-		if err != nil {
-			log.Fatalf("Error in registering connector: %v", err)
+		if err := c.registerConnector(endpoint, name); err != nil {
+			log.Printf("Error in registering connector %s: %v", name, err)
+			failed = append(failed, name)
 		}
 	}
+	if len(failed) > 0 {
+		return fmt.Errorf("could not register the connectors %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func (c *ConfigurationSetup) registerConnector(endpoint, name string) error {
+	config, err := c.connectorConfig(name)
+	if err != nil {
+		return err
+	}
+	var status utils.RegistrationStatus
+	err = c.Retry.Retry(fmt.Sprintf("registering connector %s", name), func() error {
+		status, err = c.Utils.RegisterConnector(endpoint, name, config)
+		return c.Resolver.RedactError(err)
+	}, utils.IsRetryable)
+	if err != nil {
+		return err
+	}
+	log.Printf("Connector %s %s\n", name, status)
+	return nil
 }
 
-// DownloadConnectorResources Download Connector Resources
-func (c *ConfigurationSetup) DownloadConnectorResources(downloadDirectory string) {
-	for name, connector := range c.ConfigFile.Connectors {
+// DownloadConnectorResources installs the resources of every connector into its own directory of pluginPath
+func (c *ConfigurationSetup) DownloadConnectorResources(pluginPath string) {
+	for _, name := range c.ConfigFile.ConnectorNames() {
+		resources := c.ConfigFile.Connectors[name].Resources
+		if len(resources) == 0 {
+			continue
+		}
 		log.Printf("Parsing connector: %s", name)
-		for _, resource := range connector.Resources {
-			log.Printf("Downloading file: %s\n", resource)
-			filename, err := c.Utils.DownloadFile(downloadDirectory, resource)
-			if err != nil {
-				log.Fatalf("Error in downloading resource %s: %v", resource, err)
-			}
-			log.Printf("Extracting file: %s\n", resource)
-			err = c.Utils.ExtractFile(path.Join(downloadDirectory, filename), downloadDirectory)
-			if err != nil {
-				log.Fatalf("Error in extracting file: %v", err)
-			}
+		if err := c.InstallPlugin(pluginPath, name, resources); err != nil {
+			log.Fatalf("Error in installing the resources of connector %s: %v", name, err)
 		}
 	}
 }
 
-// DownloadResources Download Resources
-func (c *ConfigurationSetup) DownloadResources(downloadDirectory string) {
+// DownloadResources installs every resource shared by the connectors into its own directory of pluginPath
+func (c *ConfigurationSetup) DownloadResources(pluginPath string) {
 	for _, resource := range c.ConfigFile.Resources {
-		log.Printf("Downloading file: %s\n", resource)
-		filename, err := c.Utils.DownloadFile(downloadDirectory, resource)
+		filename, err := resource.Name()
 		if err != nil {
-			log.Fatalf("Error in downloading resource %s: %v", resource, err)
+			log.Fatalf("Error in installing resource %s: %v", resource, err)
 		}
-		log.Printf("Extracting file: %s\n", resource)
-		err = c.Utils.ExtractFile(path.Join(downloadDirectory, filename), downloadDirectory)
-		if err != nil {
-			log.Fatalf("Error in extracting file: %v", err)
+		if err := c.InstallPlugin(pluginPath, pluginDirectoryName(filename), []utils.Resource{resource}); err != nil {
+			log.Fatalf("Error in installing resource %s: %v", resource, err)
+		}
+	}
+}
+
+// toStringMap converts the maps decoded from YAML or JSON into a map keyed by strings
+func toStringMap(value interface{}) (map[string]interface{}, error) {
+	switch m := value.(type) {
+	case map[string]interface{}:
+		return m, nil
+	case map[interface{}]interface{}:
+		converted := map[string]interface{}{}
+		for key, value := range m {
+			converted[fmt.Sprint(key)] = value
+		}
+		return converted, nil
+	case nil:
+		return nil, fmt.Errorf("the config is empty")
+	default:
+		return nil, fmt.Errorf("expected a map, got %T", value)
+	}
+}
+
+// stringValues converts the scalar values of a config into the strings kafka-connect expects
+func stringValues(config map[string]interface{}) (map[string]string, error) {
+	values := map[string]string{}
+	for key, value := range config {
+		switch value.(type) {
+		case map[string]interface{}, map[interface{}]interface{}, []interface{}:
+			return nil, fmt.Errorf("the value of %s is not a scalar", key)
+		case nil:
+			values[key] = ""
+		default:
+			values[key] = fmt.Sprint(value)
 		}
 	}
+	return values, nil
 }
@@ -2,15 +2,26 @@ package config
 
 import (
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/mesosphere/kudo-kafka-operator/images/kafka/kafka-connectors-setup/pkgs/mocks"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka/kafka-connectors-setup/pkgs/utils"
 
 	"github.com/golang/mock/gomock"
 	. "github.com/onsi/ginkgo"
 	"github.com/onsi/ginkgo/reporters"
 	. "github.com/onsi/gomega"
 	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testclient "k8s.io/client-go/kubernetes/fake"
 )
 
 var _ = Describe("[Kafka KafkaService]", func() {
@@ -23,9 +34,243 @@ var _ = Describe("[Kafka KafkaService]", func() {
 
 	Context("Kafka Connectors Configuration", func() {
 		It("Tests sample configuration", func() {
-			configuration.RegisterConnectors("http://www.sample.endpoint")
-			configuration.DownloadConnectorResources("/tmp")
-			configuration.DownloadResources("/tmp")
+			Expect(configuration.RegisterConnectors("http://www.sample.endpoint")).To(BeNil())
+			pluginPath, err := ioutil.TempDir("", "plugins")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(pluginPath)
+			configuration.DownloadConnectorResources(pluginPath)
+			configuration.DownloadResources(pluginPath)
+			Expect(listFiles(pluginPath)).To(Equal([]string{"resource0/resource0.jar", "sample-connector-1/resource1.jar", "sample-connector-1/resource2.jar"}))
+		})
+		It("Replaces the plugin directories once all their resources are extracted", func() {
+			pluginPath, err := ioutil.TempDir("", "plugins")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(pluginPath)
+			Expect(os.Mkdir(path.Join(pluginPath, "sample-connector-1"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(path.Join(pluginPath, "sample-connector-1", "old.jar"), nil, 0644)).To(Succeed())
+
+			mockUtils.EXPECT().DownloadFile(gomock.Any(), utils.Resource{URL: "http://foo.bar/missing.zip"}).Return("", fmt.Errorf("404 Not Found"))
+			err = configuration.InstallPlugin(pluginPath, "sample-connector-1", []utils.Resource{
+				{URL: "http://foo.bar/resource1.zip"},
+				{URL: "http://foo.bar/missing.zip"},
+			})
+			Expect(err).To(MatchError("could not download resource http://foo.bar/missing.zip: 404 Not Found"))
+			Expect(listFiles(pluginPath)).To(Equal([]string{"sample-connector-1/old.jar"}))
+
+			Expect(configuration.InstallPlugin(pluginPath, "sample-connector-1", []utils.Resource{{URL: "http://foo.bar/resource1.zip"}})).To(Succeed())
+			Expect(listFiles(pluginPath)).To(Equal([]string{"sample-connector-1/resource1.jar"}))
+			Expect(configuration.InstallPlugin(pluginPath, "../escape", nil)).To(MatchError(`invalid plugin directory name "../escape"`))
+			Expect(pluginDirectoryName("confluentinc-kafka-connect-jdbc-10.0.1.tar.gz")).To(Equal("confluentinc-kafka-connect-jdbc-10.0.1"))
+		})
+		It("Restores the plugin directory when it could not be replaced", func() {
+			pluginPath, err := ioutil.TempDir("", "plugins")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(pluginPath)
+			target := path.Join(pluginPath, "sample-connector-1")
+			Expect(os.Mkdir(target, 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(path.Join(target, "old.jar"), nil, 0644)).To(Succeed())
+
+			Expect(replaceDirectory(path.Join(pluginPath, ".missing"), target)).NotTo(Succeed())
+			Expect(listFiles(pluginPath)).To(Equal([]string{"sample-connector-1/old.jar"}))
+		})
+		It("Retries the registrations while kafka-connect rebalances", func() {
+			mockUtils = mocks.NewMockUtils(mockCtrl)
+			configuration.Utils = mockUtils
+			mockUtils.EXPECT().ValidateConnector("http://www.sample.endpoint", gomock.Any(), gomock.Any()).Return(nil, nil).AnyTimes()
+			mockUtils.EXPECT().WaitForReady("http://www.sample.endpoint", time.Minute).Return(nil)
+			gomock.InOrder(
+				mockUtils.EXPECT().RegisterConnector("http://www.sample.endpoint", "sample-connector-1", gomock.Any()).
+					Return(utils.RegistrationStatus(""), &utils.ConnectError{StatusCode: http.StatusConflict, Status: "409 Conflict"}),
+				mockUtils.EXPECT().RegisterConnector("http://www.sample.endpoint", "sample-connector-1", gomock.Any()).
+					Return(utils.RegistrationStatus(""), &utils.ConnectError{StatusCode: http.StatusServiceUnavailable, Status: "503 Service Unavailable"}),
+				mockUtils.EXPECT().RegisterConnector("http://www.sample.endpoint", "sample-connector-1", gomock.Any()).
+					Return(utils.CONNECTOR_CREATED, nil),
+			)
+			Expect(configuration.RegisterConnectors("http://www.sample.endpoint")).To(BeNil())
+		})
+		It("Reports the connectors which could not be registered", func() {
+			mockUtils = mocks.NewMockUtils(mockCtrl)
+			configuration.Utils = mockUtils
+			mockUtils.EXPECT().ValidateConnector("http://www.sample.endpoint", gomock.Any(), gomock.Any()).Return(nil, nil).AnyTimes()
+			mockUtils.EXPECT().WaitForReady("http://www.sample.endpoint", time.Minute).Return(nil)
+			mockUtils.EXPECT().RegisterConnector("http://www.sample.endpoint", "sample-connector-1", gomock.Any()).
+				Return(utils.RegistrationStatus(""), &utils.ConnectError{StatusCode: http.StatusBadRequest, Status: "400 Bad Request"})
+			Expect(configuration.RegisterConnectors("http://www.sample.endpoint")).To(MatchError("could not register the connectors sample-connector-1"))
+		})
+		It("Refuses to register invalid connectors", func() {
+			mockUtils = mocks.NewMockUtils(mockCtrl)
+			configuration.Utils = mockUtils
+			configuration.ConfigFile.Connectors["broken"] = Connector{Config: "connector.class=foo.bar"}
+			mockUtils.EXPECT().WaitForReady("http://www.sample.endpoint", time.Minute).Return(nil)
+			mockUtils.EXPECT().ValidateConnector("http://www.sample.endpoint", gomock.Any(), gomock.Any()).Return(map[string][]string{
+				"topics":    {"Must configure one of topics or topics.regex"},
+				"tasks.max": {"Invalid value 0", "Value must be at least 1"},
+			}, nil)
+
+			report, err := configuration.ValidateConnectors("http://www.sample.endpoint")
+			Expect(err).To(BeNil())
+			Expect(report.String()).To(Equal("broken:\n  config: invalid config of connector broken: expected a map, got string\n" +
+				"sample-connector-1:\n  tasks.max: Invalid value 0\n  tasks.max: Value must be at least 1\n  topics: Must configure one of topics or topics.regex\n"))
+
+			mockUtils.EXPECT().ValidateConnector("http://www.sample.endpoint", gomock.Any(), gomock.Any()).Return(nil, nil)
+			Expect(configuration.RegisterConnectors("http://www.sample.endpoint")).To(MatchError("invalid connectors broken"))
+		})
+		It("Syncs the connectors with the configuration file", func() {
+			mockUtils = mocks.NewMockUtils(mockCtrl)
+			configuration.Utils = mockUtils
+			mockUtils.EXPECT().ValidateConnector("http://www.sample.endpoint", gomock.Any(), gomock.Any()).Return(nil, nil).AnyTimes()
+			configuration.ConfigFile.Connectors["team-a-new"] = Connector{Config: map[string]interface{}{"connector.class": "foo.bar"}}
+			mockUtils.EXPECT().WaitForReady("http://www.sample.endpoint", time.Minute).Return(nil).Times(2)
+			mockUtils.EXPECT().ListConnectors("http://www.sample.endpoint").Return([]string{"other", "sample-connector-1", "team-a-old"}, nil).Times(2)
+			mockUtils.EXPECT().GetConnectorConfig("http://www.sample.endpoint", "sample-connector-1").Return(map[string]string{
+				"name":            "sample-connector-1",
+				"connector.class": "foo.bar",
+				"tasks.max":       "2",
+				"topics":          "sample_connector_1_topic",
+			}, nil).Times(2)
+			mockUtils.EXPECT().GetConnectorConfig("http://www.sample.endpoint", "team-a-new").Return(nil, nil).Times(2)
+
+			options := SyncOptions{Prune: true, PrunePrefix: "team-a-", DryRun: true}
+			plan, err := configuration.Sync("http://www.sample.endpoint", options)
+			Expect(err).To(BeNil())
+			Expect(plan.String()).To(Equal("update    sample-connector-1\ncreate    team-a-new\ndelete    team-a-old\n"))
+
+			mockUtils.EXPECT().RegisterConnector("http://www.sample.endpoint", "sample-connector-1", gomock.Any()).Return(utils.CONNECTOR_UPDATED, nil)
+			mockUtils.EXPECT().RegisterConnector("http://www.sample.endpoint", "team-a-new", map[string]string{"connector.class": "foo.bar"}).Return(utils.CONNECTOR_CREATED, nil)
+			mockUtils.EXPECT().DeleteConnector("http://www.sample.endpoint", "team-a-old").Return(nil)
+			options.DryRun = false
+			_, err = configuration.Sync("http://www.sample.endpoint", options)
+			Expect(err).To(BeNil())
+		})
+		It("Restarts only the failed connectors and tasks", func() {
+			mockUtils = mocks.NewMockUtils(mockCtrl)
+			configuration.Utils = mockUtils
+			status := &utils.ConnectorStatus{
+				Name:      "sample-connector-1",
+				Connector: utils.ConnectorState{State: utils.STATE_RUNNING, WorkerID: "10.0.0.1:8083"},
+				Tasks: []utils.TaskState{
+					{ID: 0, State: utils.STATE_RUNNING, WorkerID: "10.0.0.1:8083"},
+					{ID: 1, State: utils.STATE_FAILED, WorkerID: "10.0.0.2:8083",
+						Trace: "org.apache.kafka.connect.errors.ConnectException: topic sample does not exist\n\tat org.apache.kafka.connect..."},
+				},
+			}
+			mockUtils.EXPECT().ListConnectors("http://www.sample.endpoint").Return([]string{"sample-connector-1"}, nil)
+			mockUtils.EXPECT().GetConnectorStatus("http://www.sample.endpoint", "sample-connector-1").Return(status, nil).Times(2)
+			mockUtils.EXPECT().RestartTask("http://www.sample.endpoint", "sample-connector-1", 1).Return(nil)
+			Expect(configuration.RestartConnectors("http://www.sample.endpoint", nil, true, true)).To(Succeed())
+			Expect(configuration.RestartConnectors("http://www.sample.endpoint", nil, false, true)).To(MatchError("no connector given"))
+
+			statuses, err := configuration.ConnectorStatuses("http://www.sample.endpoint", []string{"sample-connector-1"})
+			Expect(err).To(BeNil())
+			Expect(FormatStatusTable(statuses)).To(Equal(
+				"CONNECTOR           TASK  STATE    WORKER         TRACE\n" +
+					"sample-connector-1  -     RUNNING  10.0.0.1:8083  \n" +
+					"sample-connector-1  0     RUNNING  10.0.0.1:8083  \n" +
+					"sample-connector-1  1     FAILED   10.0.0.2:8083  org.apache.kafka.connect.errors.ConnectException: topic sample does not exist\n"))
+		})
+		It("Exports the running connectors with their sensitive values masked", func() {
+			mockUtils = mocks.NewMockUtils(mockCtrl)
+			configuration.Utils = mockUtils
+			mockUtils.EXPECT().ExportConnectors("http://www.sample.endpoint").Return(map[string]map[string]string{
+				"jdbc": {"connector.class": "JdbcSinkConnector", "connection.password": "hunter2", "key.converter": "StringConverter"},
+			}, nil)
+			exported, err := configuration.Export("http://www.sample.endpoint", ExportOptions{Mask: true})
+			Expect(err).To(BeNil())
+
+			b, err := yaml.Marshal(exported)
+			Expect(err).To(BeNil())
+			imported := &ConfigFile{}
+			Expect(yaml.Unmarshal(b, imported)).To(Succeed())
+			config, err := imported.Connectors["jdbc"].ConnectorConfig("jdbc")
+			Expect(err).To(BeNil())
+			Expect(config).To(Equal(map[string]string{
+				"connector.class":     "JdbcSinkConnector",
+				"connection.password": MASKED_VALUE,
+				"key.converter":       "StringConverter",
+			}))
+		})
+		It("Resolves the placeholders of the configs and hides their values", func() {
+			mockUtils = mocks.NewMockUtils(mockCtrl)
+			configuration.Utils = mockUtils
+			file, err := ioutil.TempFile("", "connector-*.properties")
+			Expect(err).To(BeNil())
+			defer os.Remove(file.Name())
+			fmt.Fprint(file, "# credentials\nuser = sample-user\n")
+			file.Close()
+			os.Setenv("SAMPLE_TOPIC", "sample_topic")
+			defer os.Unsetenv("SAMPLE_TOPIC")
+			configuration.Resolver = &Resolver{Client: testclient.NewSimpleClientset(&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "kafka", Name: "jdbc"},
+				Data:       map[string][]byte{"password": []byte("hunter22")},
+			})}
+			configuration.ConfigFile.Connectors = map[string]Connector{"jdbc": {Config: map[interface{}]interface{}{
+				"connector.class":     "JdbcSinkConnector",
+				"topics":              "${env:SAMPLE_TOPIC}",
+				"connection.user":     fmt.Sprintf("${file:%s:user}", file.Name()),
+				"connection.password": "${secret:kafka/jdbc/password}",
+				"transforms.route":    "${vault:path}",
+			}}}
+
+			mockUtils.EXPECT().ValidateConnector("http://www.sample.endpoint", "jdbc", gomock.Any()).
+				Return(map[string][]string{"connection.password": {"Invalid value hunter22 for configuration connection.password"}}, nil)
+			report, err := configuration.ValidateConnectors("http://www.sample.endpoint")
+			Expect(err).To(BeNil())
+			Expect(report.String()).To(Equal("jdbc:\n  connection.password: Invalid value [hidden] for configuration connection.password\n"))
+
+			mockUtils.EXPECT().RegisterConnector("http://www.sample.endpoint", "jdbc", map[string]string{
+				"connector.class":     "JdbcSinkConnector",
+				"topics":              "sample_topic",
+				"connection.user":     "sample-user",
+				"connection.password": "hunter22",
+				"transforms.route":    "${vault:path}",
+			}).Return(utils.RegistrationStatus(""), &utils.ConnectError{StatusCode: http.StatusBadRequest, Message: "Invalid value hunter22"})
+			Expect(configuration.registerConnector("http://www.sample.endpoint", "jdbc")).To(MatchError(ContainSubstring("Invalid value [hidden]")))
+
+			configuration.ConfigFile.Connectors["jdbc"].Config.(map[interface{}]interface{})["connection.password"] = "${secret:kafka/jdbc/missing}"
+			_, err = configuration.connectorConfig("jdbc")
+			Expect(err).To(MatchError("could not resolve ${secret:kafka/jdbc/missing} of connection.password: no key missing in the secret kafka/jdbc"))
+		})
+		It("Hides the resolved values whatever their length", func() {
+			os.Setenv("SAMPLE_PIN", "42")
+			defer os.Unsetenv("SAMPLE_PIN")
+			os.Setenv("SAMPLE_EMPTY", "")
+			defer os.Unsetenv("SAMPLE_EMPTY")
+			resolver := &Resolver{Client: testclient.NewSimpleClientset(&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "kafka", Name: "jdbc"},
+				Data:       map[string][]byte{"password": []byte("4242")},
+			})}
+			_, err := resolver.Resolve(map[string]string{
+				"pin":      "${env:SAMPLE_PIN}",
+				"empty":    "${env:SAMPLE_EMPTY}",
+				"password": "${secret:kafka/jdbc/password}",
+			})
+			Expect(err).To(BeNil())
+			Expect(resolver.Redact("Invalid values 42 and 4242")).To(Equal("Invalid values [hidden] and [hidden]"))
+		})
+		It("Reads the connector config alone or as a connector payload", func() {
+			connectors := &ConfigFile{}
+			err := yaml.Unmarshal([]byte(`
+connectors:
+  flat:
+    config:
+      connector.class: foo.bar
+      tasks.max: 2
+      errors.tolerance: all
+  renamed:
+    config:
+      name: other
+      config:
+        connector.class: foo.bar
+`), connectors)
+			Expect(err).To(BeNil())
+			Expect(connectors.ConnectorNames()).To(Equal([]string{"flat", "renamed"}))
+
+			config, err := connectors.Connectors["flat"].ConnectorConfig("flat")
+			Expect(err).To(BeNil())
+			Expect(config).To(Equal(map[string]string{"connector.class": "foo.bar", "tasks.max": "2", "errors.tolerance": "all"}))
+
+			_, err = connectors.Connectors["renamed"].ConnectorConfig("renamed")
+			Expect(err).To(MatchError(ContainSubstring("connector renamed is named other")))
 		})
 	})
 
@@ -38,7 +283,7 @@ var _ = Describe("[Kafka KafkaService]", func() {
 				"sample-connector-1": {
 					"resources": [
 						"http://foo.bar/resource1.zip",
-						"http://foo.bar/resource2.zip"
+						{"url": "http://foo.bar/resource2.zip", "sha256": "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"}
 					],
 					"config": {
 						"name": "sample-connector-1",
@@ -55,19 +300,31 @@ var _ = Describe("[Kafka KafkaService]", func() {
 			]
 		}`
 		configuration = &ConfigurationSetup{
-			Utils:      mockUtils,
-			ConfigFile: &ConfigFile{},
+			Utils:        mockUtils,
+			ConfigFile:   &ConfigFile{},
+			ReadyTimeout: time.Minute,
+			Retry:        utils.Backoff{Initial: time.Millisecond, Max: time.Millisecond, Timeout: time.Second},
 		}
 		err := yaml.Unmarshal([]byte(sampleConfig), configuration.ConfigFile)
 		Expect(err).To(BeNil())
 
-		mockUtils.EXPECT().RegisterConnector("http://www.sample.endpoint", configuration.ConfigFile.Connectors["sample-connector-1"].Config).Return(nil).AnyTimes()
-		mockUtils.EXPECT().DownloadFile("/tmp", "http://foo.bar/resource1.zip").Return("resource1.zip", nil).AnyTimes()
-		mockUtils.EXPECT().ExtractFile("/tmp/resource1.zip", "/tmp").Return(nil).AnyTimes()
-		mockUtils.EXPECT().DownloadFile("/tmp", "http://foo.bar/resource2.zip").Return("resource2.zip", nil).AnyTimes()
-		mockUtils.EXPECT().ExtractFile("/tmp/resource2.zip", "/tmp").Return(nil).AnyTimes()
-		mockUtils.EXPECT().DownloadFile("/tmp", "http://foo.bar/resource0.zip").Return("resource0.zip", nil).AnyTimes()
-		mockUtils.EXPECT().ExtractFile("/tmp/resource0.zip", "/tmp").Return(nil).AnyTimes()
+		mockUtils.EXPECT().WaitForReady("http://www.sample.endpoint", time.Minute).Return(nil).AnyTimes()
+		mockUtils.EXPECT().ValidateConnector("http://www.sample.endpoint", gomock.Any(), gomock.Any()).Return(nil, nil).AnyTimes()
+		mockUtils.EXPECT().RegisterConnector("http://www.sample.endpoint", "sample-connector-1", map[string]string{
+			"connector.class": "foo.bar",
+			"tasks.max":       "1",
+			"topics":          "sample_connector_1_topic",
+		}).Return(utils.CONNECTOR_CREATED, nil).AnyTimes()
+		mockUtils.EXPECT().DownloadFile(gomock.Any(), utils.Resource{URL: "http://foo.bar/resource1.zip"}).Return("resource1.zip", nil).AnyTimes()
+		mockUtils.EXPECT().DownloadFile(gomock.Any(), utils.Resource{
+			URL:    "http://foo.bar/resource2.zip",
+			SHA256: "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+		}).Return("resource2.zip", nil).AnyTimes()
+		mockUtils.EXPECT().DownloadFile(gomock.Any(), utils.Resource{URL: "http://foo.bar/resource0.zip"}).Return("resource0.zip", nil).AnyTimes()
+		// every archive holds a jar named after it
+		mockUtils.EXPECT().ExtractFile(gomock.Any(), gomock.Any()).DoAndReturn(func(archive, destination string) error {
+			return ioutil.WriteFile(path.Join(destination, strings.TrimSuffix(path.Base(archive), ".zip")+".jar"), nil, 0644)
+		}).AnyTimes()
 	})
 
 	AfterEach(func() {
@@ -75,6 +332,19 @@ var _ = Describe("[Kafka KafkaService]", func() {
 	})
 })
 
+// listFiles returns the files of directory, with their path relative to it
+func listFiles(directory string) []string {
+	var files []string
+	Expect(filepath.Walk(directory, func(file string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			relative, _ := filepath.Rel(directory, file)
+			files = append(files, relative)
+		}
+		return err
+	})).To(Succeed())
+	return files
+}
+
 func TestConfig(t *testing.T) {
 	RegisterFailHandler(Fail)
 	junitReporter := reporters.NewJUnitReporter(fmt.Sprintf("%s-junit.xml", "kafka-connectors-setup"))
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"regexp"
+
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka/kafka-connectors-setup/pkgs/utils"
+)
+
+const (
+	MASKED_VALUE = "********"
+	// DEFAULT_SENSITIVE_KEYS matches the config keys usually holding credentials
+	DEFAULT_SENSITIVE_KEYS = `(?i)(password|passwd|secret|token|credential|api[._-]?key|access[._-]?key|private[._-]?key|jaas\.config)`
+)
+
+// ExportOptions Struct
+type ExportOptions struct {
+	// Mask replaces the values of the sensitive keys with MASKED_VALUE
+	Mask bool
+	// SensitiveKeys matches the sensitive keys, DEFAULT_SENSITIVE_KEYS when nil
+	SensitiveKeys *regexp.Regexp
+}
+
+// Export reads the connectors running in kafka-connect into a configuration file.
+// The resources of the connectors are unknown to kafka-connect and are not exported.
+func (c *ConfigurationSetup) Export(endpoint string, options ExportOptions) (*ConfigFile, error) {
+	sensitiveKeys := options.SensitiveKeys
+	if sensitiveKeys == nil {
+		sensitiveKeys = regexp.MustCompile(DEFAULT_SENSITIVE_KEYS)
+	}
+	var configs map[string]map[string]string
+	err := c.Retry.Retry("exporting the connectors", func() error {
+		var err error
+		configs, err = c.Utils.ExportConnectors(endpoint)
+		return err
+	}, utils.IsRetryable)
+	if err != nil {
+		return nil, err
+	}
+
+	configFile := &ConfigFile{Connectors: map[string]Connector{}}
+	for name, config := range configs {
+		exported := map[string]interface{}{}
+		for key, value := range config {
+			if options.Mask && sensitiveKeys.MatchString(key) {
+				value = MASKED_VALUE
+			}
+			exported[key] = value
+		}
+		configFile.Connectors[name] = Connector{Config: exported}
+	}
+	return configFile, nil
+}
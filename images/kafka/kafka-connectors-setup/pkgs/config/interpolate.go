@@ -0,0 +1,177 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka/kafka-connectors-setup/pkgs/utils"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	REDACTED_VALUE = "[hidden]"
+)
+
+// placeholderPattern matches ${env:VAR}, ${file:/path[:key]} and ${secret:namespace/name/key},
+// the placeholders of other providers are left to the config providers of kafka-connect
+var placeholderPattern = regexp.MustCompile(`\$\{(env|file|secret):([^}]*)\}`)
+
+// Resolver resolves the placeholders of the connector configs and remembers the values it resolved to redact them
+type Resolver struct {
+	// Client reads the kubernetes secrets, it is created with NewClient on the first ${secret:...} placeholder when nil
+	Client    kubernetes.Interface
+	NewClient func() (kubernetes.Interface, error)
+
+	resolved map[string]bool
+}
+
+// Resolve returns config with its placeholders replaced by their values.
+// The errors only name the placeholders, never a resolved value.
+func (r *Resolver) Resolve(config map[string]string) (map[string]string, error) {
+	resolvedConfig := map[string]string{}
+	for key, value := range config {
+		var failed error
+		resolvedConfig[key] = placeholderPattern.ReplaceAllStringFunc(value, func(placeholder string) string {
+			if failed != nil {
+				return placeholder
+			}
+			match := placeholderPattern.FindStringSubmatch(placeholder)
+			resolved, err := r.resolve(match[1], match[2])
+			if err != nil {
+				failed = fmt.Errorf("could not resolve %s of %s: %v", placeholder, key, err)
+				return placeholder
+			}
+			r.remember(resolved)
+			return resolved
+		})
+		if failed != nil {
+			return nil, failed
+		}
+	}
+	return resolvedConfig, nil
+}
+
+func (r *Resolver) resolve(provider, reference string) (string, error) {
+	switch provider {
+	case "env":
+		value, ok := os.LookupEnv(reference)
+		if !ok {
+			return "", fmt.Errorf("the environment variable is not set")
+		}
+		return value, nil
+	case "file":
+		return resolveFile(reference)
+	default:
+		return r.resolveSecret(reference)
+	}
+}
+
+// resolveFile reads key from the properties file path, or the whole file when the reference has no key
+func resolveFile(reference string) (string, error) {
+	path, key := reference, ""
+	if i := strings.LastIndex(reference, ":"); i > 0 {
+		path, key = reference[:i], reference[i+1:]
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if len(key) == 0 {
+		return strings.TrimSpace(string(b)), nil
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
+			continue
+		}
+		separator := strings.IndexAny(line, "=:")
+		if separator < 0 {
+			continue
+		}
+		if strings.TrimSpace(line[:separator]) == key {
+			return strings.TrimSpace(line[separator+1:]), nil
+		}
+	}
+	return "", fmt.Errorf("no key %s in %s", key, path)
+}
+
+func (r *Resolver) resolveSecret(reference string) (string, error) {
+	parts := strings.Split(reference, "/")
+	if len(parts) != 3 || len(parts[0]) == 0 || len(parts[1]) == 0 || len(parts[2]) == 0 {
+		return "", fmt.Errorf("expected namespace/name/key")
+	}
+	if r.Client == nil {
+		if r.NewClient == nil {
+			return "", fmt.Errorf("no kubernetes client")
+		}
+		client, err := r.NewClient()
+		if err != nil {
+			return "", fmt.Errorf("could not create the kubernetes client: %v", err)
+		}
+		r.Client = client
+	}
+	secret, err := r.Client.CoreV1().Secrets(parts[0]).Get(parts[1], metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	value, ok := secret.Data[parts[2]]
+	if !ok {
+		return "", fmt.Errorf("no key %s in the secret %s/%s", parts[2], parts[0], parts[1])
+	}
+	return string(value), nil
+}
+
+func (r *Resolver) remember(value string) {
+	if len(value) == 0 {
+		return
+	}
+	if r.resolved == nil {
+		r.resolved = map[string]bool{}
+	}
+	r.resolved[value] = true
+}
+
+// Redact replaces the resolved values found in message with REDACTED_VALUE, whatever their length.
+// The longest values are replaced first so a shorter value never leaves a part of a longer one visible.
+func (r *Resolver) Redact(message string) string {
+	if r == nil {
+		return message
+	}
+	values := make([]string, 0, len(r.resolved))
+	for value := range r.resolved {
+		values = append(values, value)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if len(values[i]) != len(values[j]) {
+			return len(values[i]) > len(values[j])
+		}
+		return values[i] < values[j]
+	})
+	for _, value := range values {
+		message = strings.Replace(message, value, REDACTED_VALUE, -1)
+	}
+	return message
+}
+
+// RedactError redacts the message of err, kafka-connect errors keep their type to still be retried
+func (r *Resolver) RedactError(err error) error {
+	if r == nil || err == nil {
+		return err
+	}
+	if connectErr, ok := err.(*utils.ConnectError); ok {
+		redacted := *connectErr
+		redacted.Message = r.Redact(connectErr.Message)
+		return &redacted
+	}
+	if message := r.Redact(err.Error()); message != err.Error() {
+		return errors.New(message)
+	}
+	return err
+}
@@ -0,0 +1,149 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka/kafka-connectors-setup/pkgs/utils"
+)
+
+// TRACE_EXCERPT_LENGTH is the maximum length of the trace excerpt of a failed connector or task in the status table
+const TRACE_EXCERPT_LENGTH = 120
+
+// ConnectorStatuses returns the status of the connectors, of all the running connectors when no name is given
+func (c *ConfigurationSetup) ConnectorStatuses(endpoint string, names []string) ([]*utils.ConnectorStatus, error) {
+	names, err := c.connectorNames(endpoint, names, true)
+	if err != nil {
+		return nil, err
+	}
+	var statuses []*utils.ConnectorStatus
+	for _, name := range names {
+		var status *utils.ConnectorStatus
+		err := c.Retry.Retry(fmt.Sprintf("getting the status of connector %s", name), func() error {
+			var err error
+			status, err = c.Utils.GetConnectorStatus(endpoint, name)
+			return err
+		}, utils.IsRetryable)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// RestartConnectors restarts the connectors and their tasks, only the failed ones with failedOnly
+func (c *ConfigurationSetup) RestartConnectors(endpoint string, names []string, all, failedOnly bool) error {
+	names, err := c.connectorNames(endpoint, names, all)
+	if err != nil {
+		return err
+	}
+	return c.forEachConnector(names, "restarting", func(name string) error {
+		status, err := c.Utils.GetConnectorStatus(endpoint, name)
+		if err != nil {
+			return err
+		}
+		if !failedOnly || status.Connector.State == utils.STATE_FAILED {
+			if err := c.Utils.RestartConnector(endpoint, name); err != nil {
+				return err
+			}
+			log.Printf("Restarted connector %s", name)
+		}
+		for _, task := range status.Tasks {
+			if failedOnly && task.State != utils.STATE_FAILED {
+				continue
+			}
+			if err := c.Utils.RestartTask(endpoint, name, task.ID); err != nil {
+				return err
+			}
+			log.Printf("Restarted task %d of connector %s", task.ID, name)
+		}
+		return nil
+	})
+}
+
+// PauseConnectors pauses the connectors and their tasks
+func (c *ConfigurationSetup) PauseConnectors(endpoint string, names []string, all bool) error {
+	names, err := c.connectorNames(endpoint, names, all)
+	if err != nil {
+		return err
+	}
+	return c.forEachConnector(names, "pausing", func(name string) error {
+		return c.Utils.PauseConnector(endpoint, name)
+	})
+}
+
+// ResumeConnectors resumes the paused connectors and their tasks
+func (c *ConfigurationSetup) ResumeConnectors(endpoint string, names []string, all bool) error {
+	names, err := c.connectorNames(endpoint, names, all)
+	if err != nil {
+		return err
+	}
+	return c.forEachConnector(names, "resuming", func(name string) error {
+		return c.Utils.ResumeConnector(endpoint, name)
+	})
+}
+
+// connectorNames returns names, or all the running connectors when none is given and all is set
+func (c *ConfigurationSetup) connectorNames(endpoint string, names []string, all bool) ([]string, error) {
+	if len(names) > 0 {
+		return names, nil
+	}
+	if !all {
+		return nil, fmt.Errorf("no connector given")
+	}
+	var running []string
+	err := c.Retry.Retry("listing the connectors", func() error {
+		var err error
+		running, err = c.Utils.ListConnectors(endpoint)
+		return err
+	}, utils.IsRetryable)
+	return running, err
+}
+
+// forEachConnector runs operation on every connector with retries, a failure does not stop the other connectors
+func (c *ConfigurationSetup) forEachConnector(names []string, description string, operation func(name string) error) error {
+	var failed []string
+	for _, name := range names {
+		err := c.Retry.Retry(fmt.Sprintf("%s connector %s", description, name), func() error {
+			return operation(name)
+		}, utils.IsRetryable)
+		if err != nil {
+			log.Printf("Error in %s connector %s: %v", description, name, err)
+			failed = append(failed, name)
+			continue
+		}
+		log.Printf("Done %s connector %s", description, name)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("could not finish %s the connectors %s", description, strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// FormatStatusTable prints a row per connector and per task, with an excerpt of the trace of the failed ones
+func FormatStatusTable(statuses []*utils.ConnectorStatus) string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CONNECTOR\tTASK\tSTATE\tWORKER\tTRACE")
+	for _, status := range statuses {
+		fmt.Fprintf(w, "%s\t-\t%s\t%s\t%s\n", status.Name, status.Connector.State, status.Connector.WorkerID, traceExcerpt(status.Connector.Trace))
+		for _, task := range status.Tasks {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", status.Name, strconv.Itoa(task.ID), task.State, task.WorkerID, traceExcerpt(task.Trace))
+		}
+	}
+	w.Flush()
+	return b.String()
+}
+
+// traceExcerpt returns the first line of a java stack trace, usually the exception and its message
+func traceExcerpt(trace string) string {
+	excerpt := strings.TrimSpace(strings.SplitN(trace, "\n", 2)[0])
+	if len(excerpt) > TRACE_EXCERPT_LENGTH {
+		excerpt = excerpt[:TRACE_EXCERPT_LENGTH-3] + "..."
+	}
+	return excerpt
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka/kafka-connectors-setup/pkgs/utils"
+)
+
+// ARCHIVE_EXTENSIONS are trimmed from the name of a shared resource to name its plugin directory
+var ARCHIVE_EXTENSIONS = []string{
+	".tar.gz", ".tgz", ".tar.bz2", ".tbz2", ".tar.xz", ".txz", ".tar.lz4", ".tlz4", ".tar.sz", ".tsz", ".tar.zst",
+	".tar", ".zip", ".rar",
+}
+
+// InstallPlugin downloads and extracts resources into the directory name of pluginPath.
+// The resources are extracted aside and only replace an existing directory once all of them are extracted,
+// a failure leaves the existing directory untouched.
+func (c *ConfigurationSetup) InstallPlugin(pluginPath, name string, resources []utils.Resource) error {
+	if len(name) == 0 || name == "." || name == ".." || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid plugin directory name %q", name)
+	}
+	if err := os.MkdirAll(pluginPath, 0755); err != nil {
+		return err
+	}
+	// kafka-connect loads every directory of the plugin path, the staging directories are hidden and removed
+	staging, err := ioutil.TempDir(pluginPath, fmt.Sprintf(".%s-", name))
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+	downloads, err := ioutil.TempDir("", "kafka-connectors-setup-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(downloads)
+
+	for _, resource := range resources {
+		log.Printf("Downloading file: %s\n", resource)
+		filename, err := c.Utils.DownloadFile(downloads, resource)
+		if err != nil {
+			return fmt.Errorf("could not download resource %s: %v", resource, err)
+		}
+		log.Printf("Extracting file: %s\n", resource)
+		if err := c.Utils.ExtractFile(filepath.Join(downloads, filename), staging); err != nil {
+			return err
+		}
+	}
+	if err := os.Chmod(staging, 0755); err != nil {
+		return err
+	}
+	return replaceDirectory(staging, filepath.Join(pluginPath, name))
+}
+
+// replaceDirectory renames source to target, an existing target is moved aside first and restored if the rename fails.
+// A directory cannot be renamed over another one, so target is briefly missing between the two renames: a worker
+// scanning the plugin path in that window misses the plugin until its next restart. The previous directory is only
+// removed once source is in place.
+func replaceDirectory(source, target string) error {
+	if _, err := os.Lstat(target); os.IsNotExist(err) {
+		return os.Rename(source, target)
+	}
+	previous := source + "-previous"
+	if err := os.Rename(target, previous); err != nil {
+		return err
+	}
+	if err := os.Rename(source, target); err != nil {
+		if restoreErr := os.Rename(previous, target); restoreErr != nil {
+			log.Printf("Error in restoring %s: %v", target, restoreErr)
+		}
+		return err
+	}
+	log.Printf("Replaced plugin directory %s", target)
+	// the plugin is replaced, a leftover hidden directory is not loaded by kafka-connect
+	if err := os.RemoveAll(previous); err != nil {
+		log.Printf("Error in removing the previous plugin directory %s: %v", previous, err)
+	}
+	return nil
+}
+
+// pluginDirectoryName names the plugin directory of a shared resource after its file
+func pluginDirectoryName(filename string) string {
+	for _, extension := range ARCHIVE_EXTENSIONS {
+		if strings.HasSuffix(strings.ToLower(filename), extension) && len(filename) > len(extension) {
+			return filename[:len(filename)-len(extension)]
+		}
+	}
+	return filename
+}
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka/kafka-connectors-setup/pkgs/utils"
+)
+
+// SyncAction is what a sync does to a connector
+type SyncAction string
+
+const (
+	SYNC_CREATE    SyncAction = "create"
+	SYNC_UPDATE    SyncAction = "update"
+	SYNC_UNCHANGED SyncAction = "unchanged"
+	SYNC_DELETE    SyncAction = "delete"
+)
+
+// SyncOptions Struct
+type SyncOptions struct {
+	// Prune deletes the running connectors missing from the configuration file
+	Prune bool
+	// PrunePrefix restricts the pruned connectors to the ones whose name starts with it
+	PrunePrefix string
+	// DryRun only plans the sync, kafka-connect is not changed
+	DryRun bool
+}
+
+// SyncStep is the action planned for a connector
+type SyncStep struct {
+	Connector string
+	Action    SyncAction
+}
+
+// SyncPlan lists the actions making kafka-connect match the configuration file
+type SyncPlan []SyncStep
+
+func (p SyncPlan) String() string {
+	var b strings.Builder
+	for _, step := range p {
+		fmt.Fprintf(&b, "%-9s %s\n", step.Action, step.Connector)
+	}
+	return b.String()
+}
+
+// Sync makes the connectors of kafka-connect match the configuration file and returns the plan it applied.
+// Nothing is changed when a connector of the file is invalid.
+func (c *ConfigurationSetup) Sync(endpoint string, options SyncOptions) (SyncPlan, error) {
+	if err := c.Utils.WaitForReady(endpoint, c.ReadyTimeout); err != nil {
+		return nil, err
+	}
+	if err := c.validate(endpoint); err != nil {
+		return nil, err
+	}
+	plan, configs, err := c.PlanSync(endpoint, options)
+	if err != nil || options.DryRun {
+		return plan, err
+	}
+
+	var failed []string
+	for _, step := range plan {
+		var err error
+		switch step.Action {
+		case SYNC_CREATE, SYNC_UPDATE:
+			err = c.Retry.Retry(fmt.Sprintf("registering connector %s", step.Connector), func() error {
+				_, err := c.Utils.RegisterConnector(endpoint, step.Connector, configs[step.Connector])
+				return c.Resolver.RedactError(err)
+			}, utils.IsRetryable)
+		case SYNC_DELETE:
+			err = c.Retry.Retry(fmt.Sprintf("deleting connector %s", step.Connector), func() error {
+				return c.Utils.DeleteConnector(endpoint, step.Connector)
+			}, utils.IsRetryable)
+		}
+		if err != nil {
+			log.Printf("Error in syncing connector %s: %v", step.Connector, err)
+			failed = append(failed, step.Connector)
+			continue
+		}
+		log.Printf("Connector %s: %s\n", step.Connector, step.Action)
+	}
+	if len(failed) > 0 {
+		return plan, fmt.Errorf("could not sync the connectors %s", strings.Join(failed, ", "))
+	}
+	return plan, nil
+}
+
+// PlanSync compares the configuration file with the connectors of kafka-connect, it returns the plan and the config of every connector of the file
+func (c *ConfigurationSetup) PlanSync(endpoint string, options SyncOptions) (SyncPlan, map[string]map[string]string, error) {
+	var running []string
+	err := c.Retry.Retry("listing the connectors", func() error {
+		var err error
+		running, err = c.Utils.ListConnectors(endpoint)
+		return err
+	}, utils.IsRetryable)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var plan SyncPlan
+	configs := map[string]map[string]string{}
+	for _, name := range c.ConfigFile.ConnectorNames() {
+		config, err := c.connectorConfig(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		configs[name] = config
+		var current map[string]string
+		err = c.Retry.Retry(fmt.Sprintf("getting the config of connector %s", name), func() error {
+			var err error
+			current, err = c.Utils.GetConnectorConfig(endpoint, name)
+			return err
+		}, utils.IsRetryable)
+		if err != nil {
+			return nil, nil, err
+		}
+		action := SYNC_UPDATE
+		if current == nil {
+			action = SYNC_CREATE
+		} else if utils.SameConfig(current, config) {
+			action = SYNC_UNCHANGED
+		}
+		plan = append(plan, SyncStep{Connector: name, Action: action})
+	}
+	if options.Prune {
+		for _, name := range running {
+			if _, ok := c.ConfigFile.Connectors[name]; !ok && strings.HasPrefix(name, options.PrunePrefix) {
+				plan = append(plan, SyncStep{Connector: name, Action: SYNC_DELETE})
+			}
+		}
+	}
+	return plan, configs, nil
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka/kafka-connectors-setup/pkgs/utils"
+)
+
+// ValidationReport holds the errors of the invalid connectors, keyed by connector then field
+type ValidationReport map[string]map[string][]string
+
+func (r ValidationReport) String() string {
+	var b strings.Builder
+	for _, name := range sortedKeys(r) {
+		fmt.Fprintf(&b, "%s:\n", name)
+		fields := r[name]
+		var names []string
+		for field := range fields {
+			names = append(names, field)
+		}
+		sort.Strings(names)
+		for _, field := range names {
+			for _, message := range fields[field] {
+				fmt.Fprintf(&b, "  %s: %s\n", field, message)
+			}
+		}
+	}
+	return b.String()
+}
+
+// ValidateConnectors validates the config of every connector of the configuration file with its connector plugin
+func (c *ConfigurationSetup) ValidateConnectors(endpoint string) (ValidationReport, error) {
+	report := ValidationReport{}
+	for _, name := range c.ConfigFile.ConnectorNames() {
+		config, err := c.connectorConfig(name)
+		if err != nil {
+			report[name] = map[string][]string{"config": {err.Error()}}
+			continue
+		}
+		var fieldErrors map[string][]string
+		err = c.Retry.Retry(fmt.Sprintf("validating connector %s", name), func() error {
+			var err error
+			fieldErrors, err = c.Utils.ValidateConnector(endpoint, name, config)
+			return c.Resolver.RedactError(err)
+		}, utils.IsRetryable)
+		if err != nil {
+			return nil, err
+		}
+		if len(fieldErrors) > 0 {
+			// kafka-connect quotes the invalid values in its messages
+			for _, messages := range fieldErrors {
+				for i, message := range messages {
+					messages[i] = c.Resolver.Redact(message)
+				}
+			}
+			report[name] = fieldErrors
+		}
+	}
+	return report, nil
+}
+
+// validate fails when a connector of the configuration file is invalid, the errors are logged grouped by connector
+func (c *ConfigurationSetup) validate(endpoint string) error {
+	report, err := c.ValidateConnectors(endpoint)
+	if err != nil {
+		return err
+	}
+	if len(report) > 0 {
+		log.Printf("Invalid connector configs:\n%s", report)
+		return fmt.Errorf("invalid connectors %s", strings.Join(sortedKeys(report), ", "))
+	}
+	return nil
+}
+
+func sortedKeys(report ValidationReport) []string {
+	var names []string
+	for name := range report {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -6,8 +6,10 @@ package mocks
 
 import (
 	reflect "reflect"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
+	utils "github.com/mesosphere/kudo-kafka-operator/images/kafka/kafka-connectors-setup/pkgs/utils"
 )
 
 // MockUtils is a mock of Utils interface
@@ -33,8 +35,22 @@ func (m *MockUtils) EXPECT() *MockUtilsMockRecorder {
 	return m.recorder
 }
 
+// DeleteConnector mocks base method
+func (m *MockUtils) DeleteConnector(arg0, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteConnector", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteConnector indicates an expected call of DeleteConnector
+func (mr *MockUtilsMockRecorder) DeleteConnector(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteConnector", reflect.TypeOf((*MockUtils)(nil).DeleteConnector), arg0, arg1)
+}
+
 // DownloadFile mocks base method
-func (m *MockUtils) DownloadFile(arg0, arg1 string) (string, error) {
+func (m *MockUtils) DownloadFile(arg0 string, arg1 utils.Resource) (string, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "DownloadFile", arg0, arg1)
 	ret0, _ := ret[0].(string)
@@ -48,6 +64,21 @@ func (mr *MockUtilsMockRecorder) DownloadFile(arg0, arg1 interface{}) *gomock.Ca
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DownloadFile", reflect.TypeOf((*MockUtils)(nil).DownloadFile), arg0, arg1)
 }
 
+// ExportConnectors mocks base method
+func (m *MockUtils) ExportConnectors(arg0 string) (map[string]map[string]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ExportConnectors", arg0)
+	ret0, _ := ret[0].(map[string]map[string]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ExportConnectors indicates an expected call of ExportConnectors
+func (mr *MockUtilsMockRecorder) ExportConnectors(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExportConnectors", reflect.TypeOf((*MockUtils)(nil).ExportConnectors), arg0)
+}
+
 // ExtractFile mocks base method
 func (m *MockUtils) ExtractFile(arg0, arg1 string) error {
 	m.ctrl.T.Helper()
@@ -62,16 +93,147 @@ func (mr *MockUtilsMockRecorder) ExtractFile(arg0, arg1 interface{}) *gomock.Cal
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExtractFile", reflect.TypeOf((*MockUtils)(nil).ExtractFile), arg0, arg1)
 }
 
-// RegisterConnector mocks base method
-func (m *MockUtils) RegisterConnector(arg0 string, arg1 interface{}) error {
+// GetConnectorConfig mocks base method
+func (m *MockUtils) GetConnectorConfig(arg0, arg1 string) (map[string]string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "RegisterConnector", arg0, arg1)
+	ret := m.ctrl.Call(m, "GetConnectorConfig", arg0, arg1)
+	ret0, _ := ret[0].(map[string]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetConnectorConfig indicates an expected call of GetConnectorConfig
+func (mr *MockUtilsMockRecorder) GetConnectorConfig(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetConnectorConfig", reflect.TypeOf((*MockUtils)(nil).GetConnectorConfig), arg0, arg1)
+}
+
+// GetConnectorStatus mocks base method
+func (m *MockUtils) GetConnectorStatus(arg0, arg1 string) (*utils.ConnectorStatus, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetConnectorStatus", arg0, arg1)
+	ret0, _ := ret[0].(*utils.ConnectorStatus)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetConnectorStatus indicates an expected call of GetConnectorStatus
+func (mr *MockUtilsMockRecorder) GetConnectorStatus(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetConnectorStatus", reflect.TypeOf((*MockUtils)(nil).GetConnectorStatus), arg0, arg1)
+}
+
+// ListConnectors mocks base method
+func (m *MockUtils) ListConnectors(arg0 string) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListConnectors", arg0)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListConnectors indicates an expected call of ListConnectors
+func (mr *MockUtilsMockRecorder) ListConnectors(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListConnectors", reflect.TypeOf((*MockUtils)(nil).ListConnectors), arg0)
+}
+
+// PauseConnector mocks base method
+func (m *MockUtils) PauseConnector(arg0, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PauseConnector", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
+// PauseConnector indicates an expected call of PauseConnector
+func (mr *MockUtilsMockRecorder) PauseConnector(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PauseConnector", reflect.TypeOf((*MockUtils)(nil).PauseConnector), arg0, arg1)
+}
+
+// RegisterConnector mocks base method
+func (m *MockUtils) RegisterConnector(arg0, arg1 string, arg2 map[string]string) (utils.RegistrationStatus, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RegisterConnector", arg0, arg1, arg2)
+	ret0, _ := ret[0].(utils.RegistrationStatus)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
 // RegisterConnector indicates an expected call of RegisterConnector
-func (mr *MockUtilsMockRecorder) RegisterConnector(arg0, arg1 interface{}) *gomock.Call {
+func (mr *MockUtilsMockRecorder) RegisterConnector(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RegisterConnector", reflect.TypeOf((*MockUtils)(nil).RegisterConnector), arg0, arg1, arg2)
+}
+
+// RestartConnector mocks base method
+func (m *MockUtils) RestartConnector(arg0, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RestartConnector", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RestartConnector indicates an expected call of RestartConnector
+func (mr *MockUtilsMockRecorder) RestartConnector(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RestartConnector", reflect.TypeOf((*MockUtils)(nil).RestartConnector), arg0, arg1)
+}
+
+// RestartTask mocks base method
+func (m *MockUtils) RestartTask(arg0, arg1 string, arg2 int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RestartTask", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RestartTask indicates an expected call of RestartTask
+func (mr *MockUtilsMockRecorder) RestartTask(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RestartTask", reflect.TypeOf((*MockUtils)(nil).RestartTask), arg0, arg1, arg2)
+}
+
+// ResumeConnector mocks base method
+func (m *MockUtils) ResumeConnector(arg0, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResumeConnector", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ResumeConnector indicates an expected call of ResumeConnector
+func (mr *MockUtilsMockRecorder) ResumeConnector(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResumeConnector", reflect.TypeOf((*MockUtils)(nil).ResumeConnector), arg0, arg1)
+}
+
+// ValidateConnector mocks base method
+func (m *MockUtils) ValidateConnector(arg0, arg1 string, arg2 map[string]string) (map[string][]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ValidateConnector", arg0, arg1, arg2)
+	ret0, _ := ret[0].(map[string][]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ValidateConnector indicates an expected call of ValidateConnector
+func (mr *MockUtilsMockRecorder) ValidateConnector(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ValidateConnector", reflect.TypeOf((*MockUtils)(nil).ValidateConnector), arg0, arg1, arg2)
+}
+
+// WaitForReady mocks base method
+func (m *MockUtils) WaitForReady(arg0 string, arg1 time.Duration) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WaitForReady", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// WaitForReady indicates an expected call of WaitForReady
+func (mr *MockUtilsMockRecorder) WaitForReady(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RegisterConnector", reflect.TypeOf((*MockUtils)(nil).RegisterConnector), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WaitForReady", reflect.TypeOf((*MockUtils)(nil).WaitForReady), arg0, arg1)
 }
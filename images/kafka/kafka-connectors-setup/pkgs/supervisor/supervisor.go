@@ -0,0 +1,294 @@
+package supervisor
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka/kafka-connectors-setup/pkgs/utils"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	DEFAULT_CHECK_INTERVAL      = 30 * time.Second
+	DEFAULT_RESTART_BACKOFF     = 30 * time.Second
+	DEFAULT_MAX_RESTART_BACKOFF = 10 * time.Minute
+	DEFAULT_MAX_RESTARTS        = 5
+	DEFAULT_RESTART_WINDOW      = time.Hour
+
+	METRICS_NAMESPACE = "kafka_connectors_setup"
+)
+
+// STATES are the connector and task states exported as metrics
+var STATES = []string{utils.STATE_RUNNING, utils.STATE_PAUSED, utils.STATE_FAILED, utils.STATE_UNASSIGNED}
+
+// Metrics are the prometheus metrics of the supervisor
+type Metrics struct {
+	// ConnectorState is 1 for the current state of a connector and 0 for the others
+	ConnectorState *prometheus.GaugeVec
+	// TaskState is 1 for the current state of a task and 0 for the others
+	TaskState *prometheus.GaugeVec
+	// Restarts counts the restarts of the connectors and of their tasks
+	Restarts *prometheus.CounterVec
+	// RestartBudgetExhausted is 1 when a connector failed too often to be restarted
+	RestartBudgetExhausted *prometheus.GaugeVec
+}
+
+// NewMetrics returns the metrics of the supervisor, they still have to be registered
+func NewMetrics() *Metrics {
+	return &Metrics{
+		ConnectorState: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "connector_state",
+			Help:      "State of the connector, 1 for the current state.",
+		}, []string{"connector", "state"}),
+		TaskState: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "task_state",
+			Help:      "State of the task of a connector, 1 for the current state.",
+		}, []string{"connector", "task", "state"}),
+		Restarts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "restarts_total",
+			Help:      "Number of restarts of a connector or of its tasks by the supervisor.",
+		}, []string{"connector", "target"}),
+		RestartBudgetExhausted: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "restart_budget_exhausted",
+			Help:      "1 when the connector failed too often within the restart window to be restarted.",
+		}, []string{"connector"}),
+	}
+}
+
+// Register registers the metrics with registerer
+func (m *Metrics) Register(registerer prometheus.Registerer) error {
+	for _, collector := range []prometheus.Collector{m.ConnectorState, m.TaskState, m.Restarts, m.RestartBudgetExhausted} {
+		if err := registerer.Register(collector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Supervisor polls the status of the connectors and restarts the failed connectors and tasks
+type Supervisor struct {
+	Utils    utils.Utils
+	Endpoint string
+	// Connectors are the supervised connectors, all the running connectors when empty
+	Connectors []string
+	// RestartBackoff is the delay before restarting a connector failing again, doubled up to MaxRestartBackoff
+	RestartBackoff    time.Duration
+	MaxRestartBackoff time.Duration
+	// MaxRestarts is the number of restarts of a connector allowed within RestartWindow
+	MaxRestarts   int
+	RestartWindow time.Duration
+	Metrics       *Metrics
+
+	now    func() time.Time
+	health map[string]*connectorHealth
+	// tasks are the ids of the tasks whose state is exported, keyed by connector
+	tasks map[string][]int
+}
+
+// connectorHealth tracks the restarts of a connector
+type connectorHealth struct {
+	restarts    []time.Time
+	backoff     time.Duration
+	nextRestart time.Time
+}
+
+// Run checks the connectors every interval until stop is closed
+func (s *Supervisor) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.Check(); err != nil {
+			log.Printf("Error in supervising connectors: %v", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check updates the metrics with the status of the connectors and restarts the failed ones
+func (s *Supervisor) Check() error {
+	if s.health == nil {
+		s.health = map[string]*connectorHealth{}
+	}
+	if s.tasks == nil {
+		s.tasks = map[string][]int{}
+	}
+	names := s.Connectors
+	if len(names) == 0 {
+		var err error
+		if names, err = s.Utils.ListConnectors(s.Endpoint); err != nil {
+			return err
+		}
+	}
+	// a connector whose status can't be fetched keeps its last state
+	var failed []string
+	for _, name := range names {
+		status, err := s.Utils.GetConnectorStatus(s.Endpoint, name)
+		if err != nil {
+			log.Printf("Error in getting the status of connector %s: %v", name, err)
+			failed = append(failed, name)
+			continue
+		}
+		s.updateMetrics(status)
+		if err := s.heal(status); err != nil {
+			log.Printf("Error in restarting connector %s: %v", name, err)
+			failed = append(failed, name)
+		}
+	}
+	s.forget(names)
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("could not supervise the connectors %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// updateMetrics exports the states of status, the tasks which are gone are dropped
+func (s *Supervisor) updateMetrics(status *utils.ConnectorStatus) {
+	current := map[int]bool{}
+	var tasks []int
+	for _, task := range status.Tasks {
+		current[task.ID] = true
+		tasks = append(tasks, task.ID)
+	}
+	for _, state := range STATES {
+		s.Metrics.ConnectorState.WithLabelValues(status.Name, state).Set(boolToFloat(status.Connector.State == state))
+		for _, task := range status.Tasks {
+			s.Metrics.TaskState.WithLabelValues(status.Name, strconv.Itoa(task.ID), state).Set(boolToFloat(task.State == state))
+		}
+	}
+	for _, task := range s.tasks[status.Name] {
+		if !current[task] {
+			s.deleteTaskMetrics(status.Name, task)
+		}
+	}
+	s.tasks[status.Name] = tasks
+}
+
+func (s *Supervisor) deleteTaskMetrics(name string, task int) {
+	for _, state := range STATES {
+		s.Metrics.TaskState.DeleteLabelValues(name, strconv.Itoa(task), state)
+	}
+}
+
+// heal restarts the failed connector and tasks of status, unless the connector is backing off or out of restart budget
+func (s *Supervisor) heal(status *utils.ConnectorStatus) error {
+	name := status.Name
+	health, ok := s.health[name]
+	if !ok {
+		health = &connectorHealth{}
+		s.health[name] = health
+	}
+	var failedTasks []int
+	for _, task := range status.Tasks {
+		if task.State == utils.STATE_FAILED {
+			failedTasks = append(failedTasks, task.ID)
+		}
+	}
+	connectorFailed := status.Connector.State == utils.STATE_FAILED
+	if !connectorFailed && len(failedTasks) == 0 {
+		health.backoff = 0
+		s.Metrics.RestartBudgetExhausted.WithLabelValues(name).Set(0)
+		return nil
+	}
+
+	now := s.clock()
+	if now.Before(health.nextRestart) {
+		log.Printf("Connector %s failed, restarting it in %s", name, health.nextRestart.Sub(now))
+		return nil
+	}
+	recent := health.restarts[:0]
+	for _, restart := range health.restarts {
+		if now.Sub(restart) < s.RestartWindow {
+			recent = append(recent, restart)
+		}
+	}
+	health.restarts = recent
+	if len(health.restarts) >= s.MaxRestarts {
+		s.Metrics.RestartBudgetExhausted.WithLabelValues(name).Set(1)
+		log.Printf("Connector %s failed %d times within %s, not restarting it", name, len(health.restarts), s.RestartWindow)
+		return nil
+	}
+	s.Metrics.RestartBudgetExhausted.WithLabelValues(name).Set(0)
+
+	health.restarts = append(health.restarts, now)
+	health.backoff *= 2
+	if health.backoff == 0 {
+		health.backoff = s.RestartBackoff
+	}
+	if s.MaxRestartBackoff > 0 && health.backoff > s.MaxRestartBackoff {
+		health.backoff = s.MaxRestartBackoff
+	}
+	health.nextRestart = now.Add(health.backoff)
+
+	if connectorFailed {
+		if err := s.Utils.RestartConnector(s.Endpoint, name); err != nil {
+			return err
+		}
+		s.Metrics.Restarts.WithLabelValues(name, "connector").Inc()
+		log.Printf("Restarted connector %s", name)
+	}
+	for _, task := range failedTasks {
+		if err := s.Utils.RestartTask(s.Endpoint, name, task); err != nil {
+			return err
+		}
+		s.Metrics.Restarts.WithLabelValues(name, "task").Inc()
+		log.Printf("Restarted task %d of connector %s", task, name)
+	}
+	return nil
+}
+
+// forget drops the health and the metrics of the connectors which are not in names anymore
+func (s *Supervisor) forget(names []string) {
+	current := map[string]bool{}
+	for _, name := range names {
+		current[name] = true
+	}
+	for name := range s.health {
+		if current[name] {
+			continue
+		}
+		delete(s.health, name)
+		s.Metrics.RestartBudgetExhausted.DeleteLabelValues(name)
+		s.Metrics.Restarts.DeleteLabelValues(name, "connector")
+		s.Metrics.Restarts.DeleteLabelValues(name, "task")
+	}
+	for name, tasks := range s.tasks {
+		if current[name] {
+			continue
+		}
+		delete(s.tasks, name)
+		for _, state := range STATES {
+			s.Metrics.ConnectorState.DeleteLabelValues(name, state)
+		}
+		for _, task := range tasks {
+			s.deleteTaskMetrics(name, task)
+		}
+	}
+}
+
+func (s *Supervisor) clock() time.Time {
+	if s.now == nil {
+		return time.Now()
+	}
+	return s.now()
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
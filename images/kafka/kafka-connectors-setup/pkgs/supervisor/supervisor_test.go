@@ -0,0 +1,185 @@
+package supervisor
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka/kafka-connectors-setup/pkgs/mocks"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka/kafka-connectors-setup/pkgs/utils"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/reporters"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+const endpoint = "http://www.sample.endpoint"
+
+var _ = Describe("[Kafka Connectors Supervisor]", func() {
+
+	var (
+		mockCtrl   *gomock.Controller
+		mockUtils  *mocks.MockUtils
+		supervisor *Supervisor
+		now        time.Time
+	)
+
+	failedStatus := func(name string) *utils.ConnectorStatus {
+		return &utils.ConnectorStatus{
+			Name:      name,
+			Connector: utils.ConnectorState{State: utils.STATE_RUNNING},
+			Tasks: []utils.TaskState{
+				{ID: 0, State: utils.STATE_RUNNING},
+				{ID: 1, State: utils.STATE_FAILED},
+			},
+		}
+	}
+
+	Context("Supervising connectors", func() {
+		It("Exports the states of the connectors and of their tasks", func() {
+			mockUtils.EXPECT().ListConnectors(endpoint).Return([]string{"sink"}, nil)
+			mockUtils.EXPECT().GetConnectorStatus(endpoint, "sink").Return(&utils.ConnectorStatus{
+				Name:      "sink",
+				Connector: utils.ConnectorState{State: utils.STATE_PAUSED},
+				Tasks:     []utils.TaskState{{ID: 0, State: utils.STATE_PAUSED}},
+			}, nil)
+			Expect(supervisor.Check()).To(BeNil())
+			Expect(testutil.ToFloat64(supervisor.Metrics.ConnectorState.WithLabelValues("sink", utils.STATE_PAUSED))).To(Equal(1.0))
+			Expect(testutil.ToFloat64(supervisor.Metrics.ConnectorState.WithLabelValues("sink", utils.STATE_RUNNING))).To(Equal(0.0))
+			Expect(testutil.ToFloat64(supervisor.Metrics.TaskState.WithLabelValues("sink", "0", utils.STATE_PAUSED))).To(Equal(1.0))
+		})
+		It("Restarts the failed tasks with a backoff", func() {
+			supervisor.Connectors = []string{"sink"}
+			mockUtils.EXPECT().GetConnectorStatus(endpoint, "sink").Return(failedStatus("sink"), nil).Times(4)
+			mockUtils.EXPECT().RestartTask(endpoint, "sink", 1).Return(nil).Times(2)
+
+			Expect(supervisor.Check()).To(BeNil())
+			// still backing off
+			now = now.Add(30 * time.Second)
+			Expect(supervisor.Check()).To(BeNil())
+			now = now.Add(31 * time.Second)
+			Expect(supervisor.Check()).To(BeNil())
+			// the backoff doubled
+			now = now.Add(90 * time.Second)
+			Expect(supervisor.Check()).To(BeNil())
+			Expect(testutil.ToFloat64(supervisor.Metrics.Restarts.WithLabelValues("sink", "task"))).To(Equal(2.0))
+		})
+		It("Stops restarting a connector once its restart budget is exhausted", func() {
+			supervisor.Connectors = []string{"source"}
+			supervisor.MaxRestarts = 2
+			mockUtils.EXPECT().GetConnectorStatus(endpoint, "source").Return(&utils.ConnectorStatus{
+				Name:      "source",
+				Connector: utils.ConnectorState{State: utils.STATE_FAILED},
+			}, nil).Times(4)
+			mockUtils.EXPECT().RestartConnector(endpoint, "source").Return(nil).Times(3)
+
+			for i := 0; i < 3; i++ {
+				Expect(supervisor.Check()).To(BeNil())
+				now = now.Add(10 * time.Minute)
+			}
+			Expect(testutil.ToFloat64(supervisor.Metrics.RestartBudgetExhausted.WithLabelValues("source"))).To(Equal(1.0))
+			// the restarts left the window
+			now = now.Add(50 * time.Minute)
+			Expect(supervisor.Check()).To(BeNil())
+			Expect(testutil.ToFloat64(supervisor.Metrics.RestartBudgetExhausted.WithLabelValues("source"))).To(Equal(0.0))
+			Expect(testutil.ToFloat64(supervisor.Metrics.Restarts.WithLabelValues("source", "connector"))).To(Equal(3.0))
+		})
+		It("Clears the exhausted restart budget once the connector recovers", func() {
+			supervisor.Connectors = []string{"source"}
+			supervisor.MaxRestarts = 1
+			gomock.InOrder(
+				mockUtils.EXPECT().GetConnectorStatus(endpoint, "source").Return(failedStatus("source"), nil).Times(2),
+				mockUtils.EXPECT().GetConnectorStatus(endpoint, "source").Return(&utils.ConnectorStatus{
+					Name:      "source",
+					Connector: utils.ConnectorState{State: utils.STATE_RUNNING},
+				}, nil),
+			)
+			mockUtils.EXPECT().RestartTask(endpoint, "source", 1).Return(nil)
+
+			Expect(supervisor.Check()).To(BeNil())
+			now = now.Add(10 * time.Minute)
+			Expect(supervisor.Check()).To(BeNil())
+			Expect(testutil.ToFloat64(supervisor.Metrics.RestartBudgetExhausted.WithLabelValues("source"))).To(Equal(1.0))
+			Expect(supervisor.Check()).To(BeNil())
+			Expect(testutil.ToFloat64(supervisor.Metrics.RestartBudgetExhausted.WithLabelValues("source"))).To(Equal(0.0))
+		})
+		It("Drops the metrics of the removed connectors", func() {
+			gomock.InOrder(
+				mockUtils.EXPECT().ListConnectors(endpoint).Return([]string{"sink", "source"}, nil),
+				mockUtils.EXPECT().ListConnectors(endpoint).Return([]string{"sink"}, nil),
+			)
+			mockUtils.EXPECT().GetConnectorStatus(endpoint, "sink").Return(failedStatus("sink"), nil).Times(2)
+			mockUtils.EXPECT().GetConnectorStatus(endpoint, "source").Return(failedStatus("source"), nil)
+			mockUtils.EXPECT().RestartTask(endpoint, gomock.Any(), 1).Return(nil).Times(2)
+
+			Expect(supervisor.Check()).To(BeNil())
+			Expect(testutil.CollectAndCount(supervisor.Metrics.Restarts)).To(Equal(2))
+			Expect(testutil.CollectAndCount(supervisor.Metrics.RestartBudgetExhausted)).To(Equal(2))
+			Expect(supervisor.Check()).To(BeNil())
+			Expect(testutil.CollectAndCount(supervisor.Metrics.Restarts)).To(Equal(1))
+			Expect(testutil.CollectAndCount(supervisor.Metrics.RestartBudgetExhausted)).To(Equal(1))
+			Expect(testutil.CollectAndCount(supervisor.Metrics.ConnectorState)).To(Equal(len(STATES)))
+			Expect(testutil.CollectAndCount(supervisor.Metrics.TaskState)).To(Equal(2 * len(STATES)))
+			Expect(supervisor.health).NotTo(HaveKey("source"))
+		})
+		It("Keeps the last state of a connector whose status can't be fetched", func() {
+			supervisor.Connectors = []string{"sink"}
+			gomock.InOrder(
+				mockUtils.EXPECT().GetConnectorStatus(endpoint, "sink").Return(&utils.ConnectorStatus{
+					Name:      "sink",
+					Connector: utils.ConnectorState{State: utils.STATE_PAUSED},
+					Tasks:     []utils.TaskState{{ID: 0, State: utils.STATE_PAUSED}, {ID: 1, State: utils.STATE_PAUSED}},
+				}, nil),
+				mockUtils.EXPECT().GetConnectorStatus(endpoint, "sink").Return(nil, &utils.ConnectError{StatusCode: 500, Status: "500 Internal Server Error"}),
+				mockUtils.EXPECT().GetConnectorStatus(endpoint, "sink").Return(&utils.ConnectorStatus{
+					Name:      "sink",
+					Connector: utils.ConnectorState{State: utils.STATE_PAUSED},
+					Tasks:     []utils.TaskState{{ID: 0, State: utils.STATE_PAUSED}},
+				}, nil),
+			)
+			Expect(supervisor.Check()).To(BeNil())
+			Expect(supervisor.Check()).To(HaveOccurred())
+			Expect(testutil.ToFloat64(supervisor.Metrics.ConnectorState.WithLabelValues("sink", utils.STATE_PAUSED))).To(Equal(1.0))
+			Expect(testutil.CollectAndCount(supervisor.Metrics.TaskState)).To(Equal(2 * len(STATES)))
+
+			// the task removed by a rebalance is dropped
+			Expect(supervisor.Check()).To(BeNil())
+			Expect(testutil.CollectAndCount(supervisor.Metrics.TaskState)).To(Equal(len(STATES)))
+		})
+		It("Reports the connectors it could not supervise", func() {
+			supervisor.Connectors = []string{"sink", "source"}
+			mockUtils.EXPECT().GetConnectorStatus(endpoint, "sink").Return(nil, &utils.ConnectError{StatusCode: 404, Status: "404 Not Found"})
+			mockUtils.EXPECT().GetConnectorStatus(endpoint, "source").Return(failedStatus("source"), nil)
+			mockUtils.EXPECT().RestartTask(endpoint, "source", 1).Return(nil)
+			Expect(supervisor.Check()).To(MatchError("could not supervise the connectors sink"))
+		})
+	})
+
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockUtils = mocks.NewMockUtils(mockCtrl)
+		now = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		supervisor = &Supervisor{
+			Utils:             mockUtils,
+			Endpoint:          endpoint,
+			RestartBackoff:    time.Minute,
+			MaxRestartBackoff: 2 * time.Minute,
+			MaxRestarts:       DEFAULT_MAX_RESTARTS,
+			RestartWindow:     DEFAULT_RESTART_WINDOW,
+			Metrics:           NewMetrics(),
+			now:               func() time.Time { return now },
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+})
+
+func TestSupervisor(t *testing.T) {
+	RegisterFailHandler(Fail)
+	junitReporter := reporters.NewJUnitReporter(fmt.Sprintf("%s-junit.xml", "kafka-connectors-setup-supervisor"))
+	RunSpecsWithDefaultAndCustomReporters(t, "KafkaConnectorsSetup Supervisor Suite", []Reporter{junitReporter})
+}
@@ -0,0 +1,193 @@
+package utils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	archiver "github.com/mholt/archiver/v3"
+	"github.com/nwaples/rardecode"
+)
+
+// archiveEntry is a file of an archive whatever its format
+type archiveEntry struct {
+	name string
+	mode os.FileMode
+	// link is the target of a symbolic link, or the name in the archive of the file of a hard link
+	link     string
+	hardLink bool
+}
+
+// ExtractFile extracts the archive archivePath into destination. The entries which would be written outside of
+// destination, directly or through a symbolic link, fail the extraction as well as the links pointing outside of it.
+func (u *UtilsImpl) ExtractFile(archivePath, destination string) error {
+	root, err := filepath.Abs(destination)
+	if err != nil {
+		return err
+	}
+	if root, err = filepath.EvalSymlinks(root); err != nil {
+		return err
+	}
+	var links []string
+	err = archiver.Walk(archivePath, func(f archiver.File) error {
+		entry, err := newArchiveEntry(f)
+		if err != nil {
+			return err
+		}
+		if len(entry.name) == 0 {
+			return nil
+		}
+		to, err := joinWithin(root, entry.name)
+		if err != nil {
+			return err
+		}
+		if to == root {
+			return nil
+		}
+		if err := mkdirWithin(root, filepath.Dir(to)); err != nil {
+			return err
+		}
+		switch {
+		case entry.mode.IsDir():
+			return mkdirWithin(root, to)
+		case entry.mode&os.ModeSymlink != 0:
+			if filepath.IsAbs(entry.link) {
+				return fmt.Errorf("the symbolic link %s points to the absolute path %s", entry.name, entry.link)
+			}
+			links = append(links, to)
+			return os.Symlink(entry.link, to)
+		case entry.hardLink:
+			source, err := joinWithin(root, entry.link)
+			if err != nil {
+				return err
+			}
+			if source, err = resolveWithin(root, source); err != nil {
+				return err
+			}
+			return os.Link(source, to)
+		case entry.mode.IsRegular():
+			return writeNewFile(to, f, entry.mode.Perm())
+		default:
+			return fmt.Errorf("the entry %s has the unsupported mode %s", entry.name, entry.mode)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("could not extract %s: %v", archivePath, err)
+	}
+	// the links are checked once all the entries they may point to are extracted
+	for _, link := range links {
+		if _, err := resolveWithin(root, link); err != nil {
+			return fmt.Errorf("could not extract %s: %v", archivePath, err)
+		}
+	}
+	return nil
+}
+
+func newArchiveEntry(f archiver.File) (archiveEntry, error) {
+	switch header := f.Header.(type) {
+	case *tar.Header:
+		entry := archiveEntry{name: header.Name, mode: f.Mode(), link: header.Linkname}
+		switch header.Typeflag {
+		case tar.TypeLink:
+			entry.hardLink = true
+		case tar.TypeXGlobalHeader:
+			// the pax global header of the tarballs generated by git
+			entry.name = ""
+		}
+		return entry, nil
+	case zip.FileHeader:
+		entry := archiveEntry{name: header.Name, mode: header.Mode()}
+		if entry.mode&os.ModeSymlink != 0 {
+			// the target of a symbolic link is the content of its entry
+			target, err := ioutil.ReadAll(io.LimitReader(f, 4096))
+			if err != nil {
+				return entry, fmt.Errorf("could not read the symbolic link %s: %v", header.Name, err)
+			}
+			entry.link = strings.TrimSpace(string(target))
+		}
+		return entry, nil
+	case *rardecode.FileHeader:
+		return archiveEntry{name: header.Name, mode: header.Mode()}, nil
+	default:
+		return archiveEntry{}, fmt.Errorf("unsupported archive entry %T", f.Header)
+	}
+}
+
+// joinWithin joins name to root, the names leaving root are refused
+func joinWithin(root, name string) (string, error) {
+	to := filepath.Join(root, filepath.FromSlash(name))
+	if !within(root, to) {
+		return "", fmt.Errorf("the entry %s is outside of the destination", name)
+	}
+	return to, nil
+}
+
+// resolveWithin resolves the symbolic links of path, which must stay within root
+func resolveWithin(root, path string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("could not resolve %s: %v", path, err)
+	}
+	if !within(root, resolved) {
+		return "", fmt.Errorf("%s resolves to %s, outside of the destination", path, resolved)
+	}
+	return resolved, nil
+}
+
+// mkdirWithin creates dir and its parents, the symbolic links they go through must point within root
+func mkdirWithin(root, dir string) error {
+	relative, err := filepath.Rel(root, dir)
+	if err != nil {
+		return err
+	}
+	current := root
+	for _, element := range strings.Split(relative, string(filepath.Separator)) {
+		if element == "." {
+			continue
+		}
+		current = filepath.Join(current, element)
+		info, err := os.Lstat(current)
+		switch {
+		case os.IsNotExist(err):
+			if err := os.Mkdir(current, 0755); err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		case info.Mode()&os.ModeSymlink != 0:
+			if current, err = resolveWithin(root, current); err != nil {
+				return err
+			}
+		case !info.IsDir():
+			return fmt.Errorf("%s is not a directory", current)
+		}
+	}
+	return nil
+}
+
+// writeNewFile writes the content of an entry to to, which must not exist yet so that no link is followed
+func writeNewFile(to string, content io.Reader, mode os.FileMode) error {
+	if mode == 0 {
+		mode = 0644
+	}
+	out, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, content); err != nil {
+		return fmt.Errorf("could not write %s: %v", to, err)
+	}
+	return out.Close()
+}
+
+// within tells whether path is root or one of its descendants
+func within(root, path string) bool {
+	relative, err := filepath.Rel(root, path)
+	return err == nil && relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator))
+}
@@ -1,36 +1,112 @@
 package utils
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
-
-	archiver "github.com/mholt/archiver/v3"
+	"strings"
 )
 
-// DownloadFile Download files to directory
-func (u *UtilsImpl) DownloadFile(downloadDirectory, url string) (string, error) {
-	filename := path.Base(url)
-	filepath := path.Join(downloadDirectory, filename)
+// Resource is a file to download, either a plain URL or an object with its checksum
+type Resource struct {
+	URL string `json:"url" yaml:"url"`
+	// SHA256 is the hex encoded checksum of the file, it is not verified when empty
+	SHA256 string `json:"sha256,omitempty" yaml:"sha256,omitempty"`
+	// Filename is the name of the downloaded file, the last element of the URL path when empty
+	Filename string `json:"filename,omitempty" yaml:"filename,omitempty"`
+}
+
+func (r Resource) String() string {
+	return r.URL
+}
+
+// UnmarshalYAML reads a resource from a plain URL or from an object
+func (r *Resource) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var resourceURL string
+	if err := unmarshal(&resourceURL); err == nil {
+		*r = Resource{URL: resourceURL}
+		return nil
+	}
+	type resource Resource
+	var object resource
+	if err := unmarshal(&object); err != nil {
+		return err
+	}
+	if len(object.URL) == 0 {
+		return fmt.Errorf("the resource with sha256 %q and filename %q has no url", object.SHA256, object.Filename)
+	}
+	*r = Resource(object)
+	return nil
+}
+
+// MarshalYAML writes the resources without checksum nor filename as plain URLs
+func (r Resource) MarshalYAML() (interface{}, error) {
+	if len(r.SHA256) == 0 && len(r.Filename) == 0 {
+		return r.URL, nil
+	}
+	type resource Resource
+	return resource(r), nil
+}
 
-	resp, err := http.Get(url)
+// Name returns the name of the downloaded file, it cannot leave the download directory
+func (r Resource) Name() (string, error) {
+	filename := r.Filename
+	if len(filename) == 0 {
+		parsed, err := url.Parse(r.URL)
+		if err != nil {
+			return "", err
+		}
+		filename = path.Base(parsed.Path)
+	}
+	if filename == "." || filename == ".." || filename == "/" || strings.ContainsAny(filename, `/\`) {
+		return "", fmt.Errorf("invalid filename %q for resource %s", filename, r.URL)
+	}
+	return filename, nil
+}
+
+// DownloadFile downloads resource to directory and returns the name of the file.
+// The file only appears in the directory once its checksum is verified.
+func (u *UtilsImpl) DownloadFile(downloadDirectory string, resource Resource) (string, error) {
+	filename, err := resource.Name()
+	if err != nil {
+		return "", err
+	}
+	if len(resource.SHA256) == 0 {
+		log.Printf("No checksum for resource %s, it is not verified", resource.URL)
+	}
+
+	resp, err := http.Get(resource.URL)
 	if err != nil {
 		return filename, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return filename, fmt.Errorf("downloading %s returned %s", resource.URL, resp.Status)
+	}
 
-	out, err := os.Create(filepath)
+	out, err := ioutil.TempFile(downloadDirectory, fmt.Sprintf(".%s-*", filename))
 	if err != nil {
 		return filename, err
 	}
+	defer os.Remove(out.Name())
 	defer out.Close()
 
-	_, err = io.Copy(out, resp.Body)
-	return filename, err
-}
-
-// ExtractFile Extract archives using p7zip
-func (u *UtilsImpl) ExtractFile(filepath, destination string) error {
-	return archiver.Unarchive(filepath, destination)
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(out, hash), resp.Body); err != nil {
+		return filename, fmt.Errorf("could not download %s: %v", resource.URL, err)
+	}
+	if err := out.Close(); err != nil {
+		return filename, err
+	}
+	if checksum := hex.EncodeToString(hash.Sum(nil)); len(resource.SHA256) > 0 && !strings.EqualFold(checksum, resource.SHA256) {
+		return filename, fmt.Errorf("checksum mismatch for %s: expected sha256 %s, got %s", resource.URL, resource.SHA256, checksum)
+	}
+	return filename, os.Rename(out.Name(), path.Join(downloadDirectory, filename))
 }
@@ -3,32 +3,279 @@ package utils
 import (
 	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
 	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
 
 	jsoniter "github.com/json-iterator/go"
 )
 
-// RegisterConnector register connectors to kafka-connect endpoint
-func (u *UtilsImpl) RegisterConnector(endpoint string, data interface{}) error {
-	if data == nil {
-		return fmt.Errorf("register data is empty for endpoint %s", endpoint)
+const (
+	DEFAULT_POLL_INTERVAL = 2 * time.Second
+	// DEFAULT_REQUEST_TIMEOUT bounds a request to a worker which accepted the connection but doesn't answer, e.g. while it
+	// starts or rebalances
+	DEFAULT_REQUEST_TIMEOUT = 30 * time.Second
+	CONNECTOR_CLASS_KEY     = "connector.class"
+)
+
+var connectClient = &http.Client{Timeout: DEFAULT_REQUEST_TIMEOUT}
+
+// RegistrationStatus tells what registering a connector changed
+type RegistrationStatus string
+
+const (
+	CONNECTOR_CREATED   RegistrationStatus = "created"
+	CONNECTOR_UPDATED   RegistrationStatus = "updated"
+	CONNECTOR_UNCHANGED RegistrationStatus = "unchanged"
+)
+
+// ConnectError is a non-2xx response of the kafka-connect REST API
+type ConnectError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Status     string
+	// Message is the error message of kafka-connect, the raw body when it is not a kafka-connect error
+	Message string
+}
+
+func (e *ConnectError) Error() string {
+	return fmt.Sprintf("%s %s returned %s: %s", e.Method, e.URL, e.Status, e.Message)
+}
+
+// RegisterConnector creates the connector name with config, or updates it when its config changed
+func (u *UtilsImpl) RegisterConnector(endpoint, name string, config map[string]string) (RegistrationStatus, error) {
+	if len(config) == 0 {
+		return "", fmt.Errorf("register data is empty for connector %s", name)
 	}
-	payloadBytes, err := jsoniter.Marshal(data)
+	current, err := u.GetConnectorConfig(endpoint, name)
 	if err != nil {
-		return err
+		return "", err
+	}
+	if current != nil && SameConfig(current, config) {
+		return CONNECTOR_UNCHANGED, nil
+	}
+
+	if err := doRequest(http.MethodPut, fmt.Sprintf("%s/config", connectorURL(endpoint, name)), config, nil); err != nil {
+		return "", err
+	}
+	if current == nil {
+		return CONNECTOR_CREATED, nil
 	}
-	body := bytes.NewReader(payloadBytes)
+	return CONNECTOR_UPDATED, nil
+}
 
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/%s", endpoint, "connectors"), body)
+// GetConnectorConfig returns the config of the connector name, nil when it does not exist
+func (u *UtilsImpl) GetConnectorConfig(endpoint, name string) (map[string]string, error) {
+	config := map[string]string{}
+	err := doRequest(http.MethodGet, fmt.Sprintf("%s/config", connectorURL(endpoint, name)), nil, &config)
+	if connectErr, ok := err.(*ConnectError); ok && connectErr.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// ListConnectors returns the names of the connectors running in kafka-connect, sorted
+func (u *UtilsImpl) ListConnectors(endpoint string) ([]string, error) {
+	var names []string
+	if err := doRequest(http.MethodGet, fmt.Sprintf("%s/connectors", strings.TrimSuffix(endpoint, "/")), nil, &names); err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ExportConnectors returns the config of every connector running in kafka-connect keyed by connector, without the name
+// kafka-connect adds to it. The workers ignoring ?expand=info are asked for the config of every connector.
+func (u *UtilsImpl) ExportConnectors(endpoint string) (map[string]map[string]string, error) {
+	var response jsoniter.RawMessage
+	if err := doRequest(http.MethodGet, fmt.Sprintf("%s/connectors?expand=info", strings.TrimSuffix(endpoint, "/")), nil, &response); err != nil {
+		return nil, err
+	}
+	configs := map[string]map[string]string{}
+	var expanded map[string]struct {
+		Info struct {
+			Config map[string]string `json:"config"`
+		} `json:"info"`
+	}
+	if err := jsoniter.Unmarshal(response, &expanded); err == nil {
+		for name, connector := range expanded {
+			configs[name] = withoutName(connector.Info.Config)
+		}
+		return configs, nil
+	}
+	var names []string
+	if err := jsoniter.Unmarshal(response, &names); err != nil {
+		return nil, fmt.Errorf("could not decode the connectors of %s: %v", endpoint, err)
+	}
+	for _, name := range names {
+		config, err := u.GetConnectorConfig(endpoint, name)
+		if err != nil {
+			return nil, err
+		}
+		// the connector was deleted since it was listed
+		if config != nil {
+			configs[name] = withoutName(config)
+		}
+	}
+	return configs, nil
+}
+
+// DeleteConnector deletes the connector name, a connector which does not exist is already deleted
+func (u *UtilsImpl) DeleteConnector(endpoint, name string) error {
+	err := doRequest(http.MethodDelete, connectorURL(endpoint, name), nil, nil)
+	if connectErr, ok := err.(*ConnectError); ok && connectErr.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// ValidateConnector validates the config of the connector name with its connector plugin and returns the errors of every
+// invalid field. Kafka-connect requires the name in the validated config, it is added to it.
+func (u *UtilsImpl) ValidateConnector(endpoint, name string, config map[string]string) (map[string][]string, error) {
+	class, ok := config[CONNECTOR_CLASS_KEY]
+	if !ok || len(class) == 0 {
+		return map[string][]string{CONNECTOR_CLASS_KEY: {"Missing required configuration \"connector.class\""}}, nil
+	}
+	var validation struct {
+		ErrorCount int `json:"error_count"`
+		Configs    []struct {
+			Value struct {
+				Name   string   `json:"name"`
+				Errors []string `json:"errors"`
+			} `json:"value"`
+		} `json:"configs"`
+	}
+	payload := map[string]string{"name": name}
+	for key, value := range config {
+		payload[key] = value
+	}
+	validateURL := fmt.Sprintf("%s/connector-plugins/%s/config/validate", strings.TrimSuffix(endpoint, "/"), url.PathEscape(class))
+	if err := doRequest(http.MethodPut, validateURL, payload, &validation); err != nil {
+		return nil, err
+	}
+	fieldErrors := map[string][]string{}
+	for _, config := range validation.Configs {
+		if len(config.Value.Errors) > 0 {
+			fieldErrors[config.Value.Name] = config.Value.Errors
+		}
+	}
+	return fieldErrors, nil
+}
+
+// WaitForReady polls the root and /connectors endpoints of the worker until it answers both, i.e. it joined its group
+func (u *UtilsImpl) WaitForReady(endpoint string, timeout time.Duration) error {
+	interval := u.PollInterval
+	if interval == 0 {
+		interval = DEFAULT_POLL_INTERVAL
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	deadline := time.Now().Add(timeout)
+	for {
+		err := doRequest(http.MethodGet, endpoint+"/", nil, nil)
+		if err == nil {
+			err = doRequest(http.MethodGet, endpoint+"/connectors", nil, nil)
+		}
+		if err == nil {
+			return nil
+		}
+		if time.Now().Add(interval).After(deadline) {
+			return fmt.Errorf("kafka-connect at %s is not ready after %s: %v", endpoint, timeout, err)
+		}
+		log.Printf("Waiting for kafka-connect at %s: %v", endpoint, err)
+		time.Sleep(interval)
+	}
+}
+
+func connectorURL(endpoint, name string) string {
+	return fmt.Sprintf("%s/connectors/%s", strings.TrimSuffix(endpoint, "/"), url.PathEscape(name))
+}
+
+// SameConfig compares the config of a connector with the desired one, kafka-connect adds the name to the config it returns
+func SameConfig(current, desired map[string]string) bool {
+	for key, value := range current {
+		if desiredValue, ok := desired[key]; (!ok && key != "name") || (ok && desiredValue != value) {
+			return false
+		}
+	}
+	for key := range desired {
+		if _, ok := current[key]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func withoutName(config map[string]string) map[string]string {
+	trimmed := map[string]string{}
+	for key, value := range config {
+		if key != "name" {
+			trimmed[key] = value
+		}
+	}
+	return trimmed
+}
+
+// doRequest sends payload as JSON and decodes the response into result, a non-2xx response is a *ConnectError
+func doRequest(method, requestURL string, payload, result interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		payloadBytes, err := jsoniter.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(payloadBytes)
+	}
+	req, err := http.NewRequest(method, requestURL, body)
 	if err != nil {
 		return err
 	}
-	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := connectClient.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("could not read the response of %s %s: %v", method, requestURL, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return newConnectError(method, requestURL, resp, respBody)
+	}
+	if result != nil && len(respBody) > 0 {
+		if err := jsoniter.Unmarshal(respBody, result); err != nil {
+			return fmt.Errorf("could not decode the response of %s %s: %v", method, requestURL, err)
+		}
+	}
 	return nil
 }
+
+func newConnectError(method, requestURL string, resp *http.Response, body []byte) *ConnectError {
+	connectErr := &ConnectError{
+		Method:     method,
+		URL:        requestURL,
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Message:    strings.TrimSpace(string(body)),
+	}
+	var errorBody struct {
+		Message string `json:"message"`
+	}
+	if err := jsoniter.Unmarshal(body, &errorBody); err == nil && len(errorBody.Message) > 0 {
+		connectErr.Message = errorBody.Message
+	}
+	return connectErr
+}
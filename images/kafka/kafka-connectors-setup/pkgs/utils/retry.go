@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	DEFAULT_RETRY_INITIAL = time.Second
+	DEFAULT_RETRY_MAX     = 30 * time.Second
+	DEFAULT_RETRY_TIMEOUT = 2 * time.Minute
+)
+
+// Backoff retries an operation with an exponential backoff until it succeeds or the timeout elapses
+type Backoff struct {
+	// Initial is the delay before the first retry, doubled on every retry
+	Initial time.Duration
+	// Max caps the delay between two retries
+	Max     time.Duration
+	Timeout time.Duration
+}
+
+// DefaultBackoff returns the backoff used to retry the kafka-connect requests
+func DefaultBackoff() Backoff {
+	return Backoff{
+		Initial: DEFAULT_RETRY_INITIAL,
+		Max:     DEFAULT_RETRY_MAX,
+		Timeout: DEFAULT_RETRY_TIMEOUT,
+	}
+}
+
+// Retry runs operation until it succeeds, the errors which are not retryable are returned right away
+func (b Backoff) Retry(description string, operation func() error, retryable func(error) bool) error {
+	deadline := time.Now().Add(b.Timeout)
+	delay := b.Initial
+	for attempt := 1; ; attempt++ {
+		err := operation()
+		if err == nil || !retryable(err) {
+			return err
+		}
+		if time.Now().Add(delay).After(deadline) {
+			return fmt.Errorf("%s failed after %d attempts: %v", description, attempt, err)
+		}
+		log.Printf("%s failed, retrying in %s: %v", description, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+		if b.Max > 0 && delay > b.Max {
+			delay = b.Max
+		}
+	}
+}
+
+// IsRetryable tells whether a kafka-connect request may succeed later: the worker is rebalancing (409),
+// failing (5xx) or cannot be reached
+func IsRetryable(err error) bool {
+	switch e := err.(type) {
+	case *ConnectError:
+		return e.StatusCode == http.StatusConflict || e.StatusCode >= http.StatusInternalServerError
+	case *url.Error:
+		return true
+	default:
+		return false
+	}
+}
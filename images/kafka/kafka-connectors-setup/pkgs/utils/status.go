@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+)
+
+const (
+	STATE_RUNNING    = "RUNNING"
+	STATE_PAUSED     = "PAUSED"
+	STATE_FAILED     = "FAILED"
+	STATE_UNASSIGNED = "UNASSIGNED"
+)
+
+// ConnectorState is the state of a connector instance as reported by kafka-connect
+type ConnectorState struct {
+	State    string `json:"state"`
+	WorkerID string `json:"worker_id"`
+	Trace    string `json:"trace,omitempty"`
+}
+
+// TaskState is the state of a task of a connector
+type TaskState struct {
+	ID       int    `json:"id"`
+	State    string `json:"state"`
+	WorkerID string `json:"worker_id"`
+	Trace    string `json:"trace,omitempty"`
+}
+
+// ConnectorStatus is the response of GET /connectors/{name}/status
+type ConnectorStatus struct {
+	Name      string         `json:"name"`
+	Type      string         `json:"type,omitempty"`
+	Connector ConnectorState `json:"connector"`
+	Tasks     []TaskState    `json:"tasks"`
+}
+
+// GetConnectorStatus returns the state of the connector name and of its tasks
+func (u *UtilsImpl) GetConnectorStatus(endpoint, name string) (*ConnectorStatus, error) {
+	status := &ConnectorStatus{}
+	if err := doRequest(http.MethodGet, fmt.Sprintf("%s/status", connectorURL(endpoint, name)), nil, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// RestartConnector restarts the connector instance of name, its tasks are left running
+func (u *UtilsImpl) RestartConnector(endpoint, name string) error {
+	return doRequest(http.MethodPost, fmt.Sprintf("%s/restart", connectorURL(endpoint, name)), nil, nil)
+}
+
+// RestartTask restarts the task of the connector name
+func (u *UtilsImpl) RestartTask(endpoint, name string, task int) error {
+	return doRequest(http.MethodPost, fmt.Sprintf("%s/tasks/%d/restart", connectorURL(endpoint, name), task), nil, nil)
+}
+
+// PauseConnector pauses the connector name and its tasks
+func (u *UtilsImpl) PauseConnector(endpoint, name string) error {
+	return doRequest(http.MethodPut, fmt.Sprintf("%s/pause", connectorURL(endpoint, name)), nil, nil)
+}
+
+// ResumeConnector resumes the paused connector name and its tasks
+func (u *UtilsImpl) ResumeConnector(endpoint, name string) error {
+	return doRequest(http.MethodPut, fmt.Sprintf("%s/resume", connectorURL(endpoint, name)), nil, nil)
+}
@@ -1,13 +1,29 @@
 package utils
 
+import "time"
+
 //go:generate mockgen -destination=../mocks/utils_mock.go -package=mocks github.com/mesosphere/kudo-kafka-operator/images/kafka/kafka-connectors-setup/pkgs/utils Utils
 
 // Utils interface
 type Utils interface {
-	DownloadFile(downloadDirectory, url string) (string, error)
+	DownloadFile(downloadDirectory string, resource Resource) (string, error)
 	ExtractFile(filepath, destination string) error
-	RegisterConnector(endpoint string, data interface{}) error
+	RegisterConnector(endpoint, name string, config map[string]string) (RegistrationStatus, error)
+	GetConnectorConfig(endpoint, name string) (map[string]string, error)
+	ListConnectors(endpoint string) ([]string, error)
+	ExportConnectors(endpoint string) (map[string]map[string]string, error)
+	DeleteConnector(endpoint, name string) error
+	ValidateConnector(endpoint, name string, config map[string]string) (map[string][]string, error)
+	GetConnectorStatus(endpoint, name string) (*ConnectorStatus, error)
+	RestartConnector(endpoint, name string) error
+	RestartTask(endpoint, name string, task int) error
+	PauseConnector(endpoint, name string) error
+	ResumeConnector(endpoint, name string) error
+	WaitForReady(endpoint string, timeout time.Duration) error
 }
 
 // UtilsImpl struct
-type UtilsImpl struct{}
+type UtilsImpl struct {
+	// PollInterval is the interval between two checks of the kafka-connect readiness, DEFAULT_POLL_INTERVAL when not set
+	PollInterval time.Duration
+}
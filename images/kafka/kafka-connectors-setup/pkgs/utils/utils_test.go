@@ -0,0 +1,386 @@
+package utils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/reporters"
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v2"
+)
+
+var _ = Describe("[Kafka Connectors Utils]", func() {
+
+	var (
+		server     *httptest.Server
+		connectors map[string]map[string]string
+		// legacyWorker ignores ?expand=info like the workers before kafka 2.3
+		legacyWorker bool
+		requests     []string
+		utils        *UtilsImpl
+	)
+
+	Context("Connector registration", func() {
+		It("creates, updates and leaves the connectors unchanged", func() {
+			config := map[string]string{"connector.class": "foo.bar", "tasks.max": "1"}
+			status, err := utils.RegisterConnector(server.URL, "sample", config)
+			Expect(err).To(BeNil())
+			Expect(status).To(Equal(CONNECTOR_CREATED))
+			Expect(connectors["sample"]).To(HaveKeyWithValue("tasks.max", "1"))
+
+			status, err = utils.RegisterConnector(server.URL, "sample", config)
+			Expect(err).To(BeNil())
+			Expect(status).To(Equal(CONNECTOR_UNCHANGED))
+
+			status, err = utils.RegisterConnector(server.URL, "sample", map[string]string{"connector.class": "foo.bar", "tasks.max": "2"})
+			Expect(err).To(BeNil())
+			Expect(status).To(Equal(CONNECTOR_UPDATED))
+			Expect(connectors["sample"]).To(HaveKeyWithValue("tasks.max", "2"))
+			Expect(requests).To(Equal([]string{
+				"GET /connectors/sample/config", "PUT /connectors/sample/config",
+				"GET /connectors/sample/config",
+				"GET /connectors/sample/config", "PUT /connectors/sample/config",
+			}))
+		})
+		It("lists and deletes the connectors", func() {
+			connectors["b"] = map[string]string{"name": "b"}
+			connectors["a"] = map[string]string{"name": "a"}
+			names, err := utils.ListConnectors(server.URL)
+			Expect(err).To(BeNil())
+			Expect(names).To(Equal([]string{"a", "b"}))
+			Expect(utils.DeleteConnector(server.URL, "a")).To(Succeed())
+			Expect(utils.DeleteConnector(server.URL, "missing")).To(Succeed())
+			Expect(connectors).NotTo(HaveKey("a"))
+		})
+		It("exports the connectors with or without ?expand=info", func() {
+			connectors["a"] = map[string]string{"name": "a", "connector.class": "foo.bar"}
+			connectors["b"] = map[string]string{"name": "b", "connector.class": "foo.baz"}
+			expected := map[string]map[string]string{"a": {"connector.class": "foo.bar"}, "b": {"connector.class": "foo.baz"}}
+			configs, err := utils.ExportConnectors(server.URL)
+			Expect(err).To(BeNil())
+			Expect(configs).To(Equal(expected))
+			Expect(requests).To(Equal([]string{"GET /connectors"}))
+
+			legacyWorker = true
+			requests = nil
+			configs, err = utils.ExportConnectors(server.URL)
+			Expect(err).To(BeNil())
+			Expect(configs).To(Equal(expected))
+			Expect(requests).To(ConsistOf("GET /connectors", "GET /connectors/a/config", "GET /connectors/b/config"))
+		})
+		It("validates the connectors with their plugin", func() {
+			fieldErrors, err := utils.ValidateConnector(server.URL, "sink", map[string]string{"connector.class": "FileStreamSink"})
+			Expect(err).To(BeNil())
+			// the server reports a missing name, the name of the connector is sent with its config
+			Expect(fieldErrors).To(Equal(map[string][]string{"topics": {"Must configure one of topics or topics.regex"}}))
+			Expect(requests).To(Equal([]string{"PUT /connector-plugins/FileStreamSink/config/validate"}))
+
+			fieldErrors, err = utils.ValidateConnector(server.URL, "sink", map[string]string{"tasks.max": "1"})
+			Expect(err).To(BeNil())
+			Expect(fieldErrors).To(HaveKey("connector.class"))
+		})
+		It("operates the connectors", func() {
+			connectors["sample"] = map[string]string{"name": "sample"}
+			status, err := utils.GetConnectorStatus(server.URL, "sample")
+			Expect(err).To(BeNil())
+			Expect(status.Tasks).To(Equal([]TaskState{{ID: 0, State: STATE_FAILED, WorkerID: "10.0.0.1:8083", Trace: "boom"}}))
+			Expect(utils.RestartConnector(server.URL, "sample")).To(Succeed())
+			Expect(utils.RestartTask(server.URL, "sample", 0)).To(Succeed())
+			Expect(utils.PauseConnector(server.URL, "sample")).To(Succeed())
+			Expect(utils.ResumeConnector(server.URL, "sample")).To(Succeed())
+			Expect(requests).To(Equal([]string{
+				"GET /connectors/sample/status", "POST /connectors/sample/restart", "POST /connectors/sample/tasks/0/restart",
+				"PUT /connectors/sample/pause", "PUT /connectors/sample/resume",
+			}))
+		})
+		It("reports the error of kafka-connect", func() {
+			_, err := utils.RegisterConnector(server.URL, "invalid", map[string]string{"connector.class": "missing"})
+			Expect(err).To(MatchError(ContainSubstring("400 Bad Request: Connector config is invalid")))
+			Expect(err.(*ConnectError).StatusCode).To(Equal(http.StatusBadRequest))
+		})
+	})
+
+	Context("Resources", func() {
+		It("downloads the resources and verifies their checksum", func() {
+			directory, err := ioutil.TempDir("", "resources")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(directory)
+
+			// sha256 of "foo"
+			checksum := "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+			filename, err := utils.DownloadFile(directory, Resource{URL: server.URL + "/files/plugin.zip?version=1", SHA256: strings.ToUpper(checksum)})
+			Expect(err).To(BeNil())
+			Expect(filename).To(Equal("plugin.zip"))
+			Expect(ioutil.ReadFile(path.Join(directory, "plugin.zip"))).To(Equal([]byte("foo")))
+
+			_, err = utils.DownloadFile(directory, Resource{URL: server.URL + "/files/plugin.zip", SHA256: "0000", Filename: "other.zip"})
+			Expect(err).To(MatchError(ContainSubstring("checksum mismatch for " + server.URL + "/files/plugin.zip: expected sha256 0000, got " + checksum)))
+			_, err = utils.DownloadFile(directory, Resource{URL: server.URL + "/missing.zip"})
+			Expect(err).To(MatchError(ContainSubstring("returned 404 Not Found")))
+			_, err = utils.DownloadFile(directory, Resource{URL: server.URL + "/files/plugin.zip", Filename: "../plugin.zip"})
+			Expect(err).To(MatchError(ContainSubstring("invalid filename")))
+
+			files, err := ioutil.ReadDir(directory)
+			Expect(err).To(BeNil())
+			Expect(files).To(HaveLen(1))
+		})
+		It("extracts the archives within their destination only", func() {
+			directory, err := ioutil.TempDir("", "extract")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(directory)
+			destination := path.Join(directory, "plugin")
+			Expect(os.Mkdir(destination, 0755)).To(Succeed())
+
+			writeTar(path.Join(directory, "plugin.tar"),
+				&tar.Header{Name: "lib/", Typeflag: tar.TypeDir, Mode: 0755},
+				&tar.Header{Name: "lib/connector.jar", Typeflag: tar.TypeReg, Mode: 0644},
+				&tar.Header{Name: "current", Typeflag: tar.TypeSymlink, Linkname: "lib"},
+				&tar.Header{Name: "current/dependency.jar", Typeflag: tar.TypeReg, Mode: 0644},
+				&tar.Header{Name: "lib/copy.jar", Typeflag: tar.TypeLink, Linkname: "lib/connector.jar"},
+			)
+			Expect(utils.ExtractFile(path.Join(directory, "plugin.tar"), destination)).To(Succeed())
+			Expect(ioutil.ReadFile(path.Join(destination, "lib", "dependency.jar"))).To(Equal([]byte("current/dependency.jar")))
+			Expect(ioutil.ReadFile(path.Join(destination, "lib", "copy.jar"))).To(Equal([]byte("lib/connector.jar")))
+
+			zipFile, err := os.Create(path.Join(directory, "slip.zip"))
+			Expect(err).To(BeNil())
+			zipWriter := zip.NewWriter(zipFile)
+			w, err := zipWriter.Create("../evil.jar")
+			Expect(err).To(BeNil())
+			fmt.Fprint(w, "evil")
+			Expect(zipWriter.Close()).To(Succeed())
+			zipFile.Close()
+			Expect(utils.ExtractFile(path.Join(directory, "slip.zip"), destination)).To(MatchError(ContainSubstring("the entry ../evil.jar is outside of the destination")))
+
+			escapes := map[string][]*tar.Header{
+				"absolute.tar": {{Name: "etc", Typeflag: tar.TypeSymlink, Linkname: "/etc"}},
+				"parent.tar": {
+					{Name: "up", Typeflag: tar.TypeSymlink, Linkname: ".."},
+					{Name: "up/evil.jar", Typeflag: tar.TypeReg, Mode: 0644},
+				},
+				"nested.tar": {
+					{Name: "a/b/", Typeflag: tar.TypeDir, Mode: 0755},
+					{Name: "a/b/up", Typeflag: tar.TypeSymlink, Linkname: ".."},
+					{Name: "a/b/up/up", Typeflag: tar.TypeSymlink, Linkname: "../.."},
+				},
+				"hardlink.tar": {{Name: "passwd", Typeflag: tar.TypeLink, Linkname: "../../../../../../etc/passwd"}},
+			}
+			for name, headers := range escapes {
+				writeTar(path.Join(directory, name), headers...)
+				target, err := ioutil.TempDir(directory, "escape")
+				Expect(err).To(BeNil())
+				Expect(utils.ExtractFile(path.Join(directory, name), target)).NotTo(Succeed(), name)
+			}
+			Expect(path.Join(directory, "evil.jar")).NotTo(BeAnExistingFile())
+		})
+		It("reads the resources from plain urls or objects", func() {
+			var resources []Resource
+			Expect(yaml.Unmarshal([]byte("- http://foo.bar/a.zip\n- url: http://foo.bar/b\n  sha256: abc\n  filename: b.zip\n"), &resources)).To(Succeed())
+			Expect(resources).To(Equal([]Resource{{URL: "http://foo.bar/a.zip"}, {URL: "http://foo.bar/b", SHA256: "abc", Filename: "b.zip"}}))
+			b, err := yaml.Marshal(resources)
+			Expect(err).To(BeNil())
+			Expect(string(b)).To(Equal("- http://foo.bar/a.zip\n- url: http://foo.bar/b\n  sha256: abc\n  filename: b.zip\n"))
+
+			var invalid []Resource
+			Expect(yaml.Unmarshal([]byte("- sha256: abc\n"), &invalid)).To(MatchError(ContainSubstring("has no url")))
+		})
+	})
+
+	Context("Readiness", func() {
+		It("waits until the worker answers", func() {
+			unavailable := 2
+			ready := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests = append(requests, fmt.Sprintf("%s %s", r.Method, r.URL.Path))
+				if r.URL.Path == "/connectors" && unavailable > 0 {
+					unavailable--
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				fmt.Fprint(w, `{"version":"2.5.0"}`)
+			}))
+			defer ready.Close()
+			Expect(utils.WaitForReady(ready.URL, time.Second)).To(Succeed())
+			Expect(requests).To(HaveLen(6))
+
+			unavailable = 100
+			Expect(utils.WaitForReady(ready.URL, 50*time.Millisecond)).To(MatchError(ContainSubstring("is not ready after 50ms")))
+		})
+		It("gives up on a worker which doesn't answer", func() {
+			hanging := make(chan struct{})
+			silent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				<-hanging
+			}))
+			defer silent.Close()
+			defer close(hanging)
+			connectClient.Timeout = 10 * time.Millisecond
+			defer func() { connectClient.Timeout = DEFAULT_REQUEST_TIMEOUT }()
+
+			err := utils.WaitForReady(silent.URL, 50*time.Millisecond)
+			Expect(err).To(MatchError(ContainSubstring("is not ready after 50ms")))
+			Expect(err).To(MatchError(ContainSubstring("Client.Timeout exceeded")))
+		})
+	})
+
+	Context("Retry", func() {
+		It("only retries the rebalances, server errors and unreachable workers", func() {
+			backoff := Backoff{Initial: time.Millisecond, Max: 2 * time.Millisecond, Timeout: time.Second}
+			attempts := 0
+			err := backoff.Retry("registering", func() error {
+				attempts++
+				return &ConnectError{StatusCode: http.StatusBadRequest}
+			}, IsRetryable)
+			Expect(err).To(HaveOccurred())
+			Expect(attempts).To(Equal(1))
+
+			Expect(IsRetryable(&ConnectError{StatusCode: http.StatusConflict})).To(BeTrue())
+			Expect(IsRetryable(&ConnectError{StatusCode: http.StatusBadGateway})).To(BeTrue())
+			_, err = utils.RegisterConnector("http://127.0.0.1:1", "sample", map[string]string{"connector.class": "foo.bar"})
+			Expect(IsRetryable(err)).To(BeTrue())
+
+			backoff.Timeout = 20 * time.Millisecond
+			err = backoff.Retry("registering", func() error {
+				return &ConnectError{StatusCode: http.StatusConflict, Status: "409 Conflict", Message: "rebalance in progress"}
+			}, IsRetryable)
+			Expect(err).To(MatchError(ContainSubstring("rebalance in progress")))
+		})
+	})
+
+	BeforeEach(func() {
+		connectors = map[string]map[string]string{}
+		legacyWorker = false
+		requests = nil
+		utils = &UtilsImpl{PollInterval: time.Millisecond}
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, fmt.Sprintf("%s %s", r.Method, r.URL.Path))
+			name := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/connectors/"), "/config")
+			switch r.Method {
+			case http.MethodGet:
+				if strings.HasPrefix(r.URL.Path, "/files/") {
+					fmt.Fprint(w, "foo")
+					return
+				}
+				if r.URL.Path == "/missing.zip" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				if strings.HasSuffix(r.URL.Path, "/status") {
+					fmt.Fprint(w, `{"name":"sample","connector":{"state":"RUNNING","worker_id":"10.0.0.1:8083"},`+
+						`"tasks":[{"id":0,"state":"FAILED","worker_id":"10.0.0.1:8083","trace":"boom"}],"type":"sink"}`)
+					return
+				}
+				if r.URL.Path == "/connectors" && r.URL.Query().Get("expand") == "info" && !legacyWorker {
+					expanded := map[string]interface{}{}
+					for name, config := range connectors {
+						expanded[name] = map[string]interface{}{"info": map[string]interface{}{"name": name, "config": config, "tasks": []interface{}{}, "type": "sink"}}
+					}
+					body, _ := jsoniter.Marshal(expanded)
+					w.Write(body)
+					return
+				}
+				if r.URL.Path == "/connectors" {
+					var names []string
+					for name := range connectors {
+						names = append(names, name)
+					}
+					body, _ := jsoniter.Marshal(names)
+					w.Write(body)
+					return
+				}
+				config, ok := connectors[name]
+				if !ok {
+					w.WriteHeader(http.StatusNotFound)
+					fmt.Fprintf(w, `{"error_code":404,"message":"Connector %s not found"}`, name)
+					return
+				}
+				body, _ := jsoniter.Marshal(config)
+				w.Write(body)
+			case http.MethodPost:
+				w.WriteHeader(http.StatusNoContent)
+			case http.MethodPut:
+				if strings.HasSuffix(r.URL.Path, "/pause") || strings.HasSuffix(r.URL.Path, "/resume") {
+					w.WriteHeader(http.StatusAccepted)
+					return
+				}
+				if strings.HasPrefix(r.URL.Path, "/connector-plugins/") {
+					body, _ := ioutil.ReadAll(r.Body)
+					config := map[string]string{}
+					Expect(jsoniter.Unmarshal(body, &config)).To(Succeed())
+					// kafka-connect requires the name in the config it validates
+					if len(config["name"]) == 0 {
+						fmt.Fprint(w, `{"name":"FileStreamSink","error_count":1,"configs":[`+
+							`{"definition":{"name":"name"},"value":{"name":"name","value":null,"errors":["Missing required configuration \"name\" which has no default value."]}}]}`)
+						return
+					}
+					fmt.Fprint(w, `{"name":"FileStreamSink","error_count":1,"configs":[`+
+						`{"definition":{"name":"file"},"value":{"name":"file","value":null,"errors":[]}},`+
+						`{"definition":{"name":"topics"},"value":{"name":"topics","value":null,"errors":["Must configure one of topics or topics.regex"]}}]}`)
+					return
+				}
+				if name == "invalid" {
+					w.WriteHeader(http.StatusBadRequest)
+					fmt.Fprint(w, `{"error_code":400,"message":"Connector config is invalid"}`)
+					return
+				}
+				body, _ := ioutil.ReadAll(r.Body)
+				config := map[string]string{}
+				Expect(jsoniter.Unmarshal(body, &config)).To(Succeed())
+				_, exists := connectors[name]
+				// kafka-connect adds the name to the config of the connector
+				config["name"] = name
+				connectors[name] = config
+				if exists {
+					w.WriteHeader(http.StatusOK)
+				} else {
+					w.WriteHeader(http.StatusCreated)
+				}
+				w.Write(body)
+			case http.MethodDelete:
+				if _, ok := connectors[name]; !ok {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				delete(connectors, name)
+				w.WriteHeader(http.StatusNoContent)
+			}
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+})
+
+// writeTar writes an archive of headers, the content of the regular files is their name
+func writeTar(archive string, headers ...*tar.Header) {
+	file, err := os.Create(archive)
+	Expect(err).To(BeNil())
+	defer file.Close()
+	w := tar.NewWriter(file)
+	for _, header := range headers {
+		if header.Typeflag == tar.TypeReg {
+			header.Size = int64(len(header.Name))
+		}
+		Expect(w.WriteHeader(header)).To(Succeed())
+		if header.Typeflag == tar.TypeReg {
+			fmt.Fprint(w, header.Name)
+		}
+	}
+	Expect(w.Close()).To(Succeed())
+}
+
+func TestUtils(t *testing.T) {
+	RegisterFailHandler(Fail)
+	junitReporter := reporters.NewJUnitReporter(fmt.Sprintf("%s-junit.xml", "kafka-connectors-setup-utils"))
+	RunSpecsWithDefaultAndCustomReporters(t, "KafkaConnectorsSetup Utils Suite", []Reporter{junitReporter})
+}
@@ -9,12 +9,20 @@ replace k8s.io/apimachinery => k8s.io/apimachinery v0.0.0-20191004115801-a2eda9f
 replace k8s.io/client-go => k8s.io/client-go v0.0.0-20191016111102-bec269661e48
 
 require (
+	github.com/Shopify/sarama v1.38.1
 	github.com/avast/retry-go v2.4.3+incompatible
-	github.com/golang/mock v1.2.0
+	github.com/golang/mock v1.4.4
+	github.com/jcmturner/gokrb5/v8 v8.4.3
 	github.com/onsi/ginkgo v1.8.0
 	github.com/onsi/gomega v1.5.0
-	github.com/sirupsen/logrus v1.4.2
+	github.com/pavel-v-chernykh/keystore-go/v4 v4.1.0
+	github.com/prometheus/client_golang v1.13.0
+	github.com/prometheus/common v0.37.0
+	github.com/sirupsen/logrus v1.6.0
+	github.com/stretchr/testify v1.9.0 // indirect
+	gopkg.in/alecthomas/kingpin.v2 v2.2.6
 	k8s.io/api v0.0.0-20191016110408-35e52d86657a
 	k8s.io/apimachinery v0.0.0-20191004115801-a2eda9f80ab8
 	k8s.io/client-go v0.0.0-00010101000000-000000000000
+	software.sslmate.com/src/go-pkcs12 v0.0.0-20210415151418-c5206de65a78
 )
@@ -1,31 +1,664 @@
 package main
 
 import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
 	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/client"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/config"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/csr"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/disk"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/dynamic"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/events"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/kafka"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/kerberos"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/keystore"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/kraft"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/metrics"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/migration"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/replacement"
 	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/service"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/storage"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/throttle"
+	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"k8s.io/client-go/kubernetes"
 )
 
 const (
-	KAFKA_HOME = "/opt/kafka"
+	KAFKA_HOME        = "/opt/kafka"
+	KAFKA_CONFIG_PATH = KAFKA_HOME + "/config"
+)
+
+var (
+	app              = kingpin.New("kafka-utils", "A helper to bootstrap and operate the kafka brokers.")
+	certDirFlag      = app.Flag("cert-dir", "Directory of the mounted TLS secret.").Default(keystore.DEFAULT_CERT_DIR).String()
+	keystoreDirFlag  = app.Flag("keystore-dir", "Directory where the keystore and truststore are written.").Default(keystore.DEFAULT_KEYSTORE_DIR).String()
+	keystoreTypeFlag = app.Flag("keystore-type", "Type of the keystore and truststore.").Default(keystore.PKCS12).Enum(keystore.PKCS12, keystore.JKS)
+	bootstrapFlag    = app.Flag("bootstrap-server", "Comma separated list of brokers to connect to.").Envar("KAFKA_BOOTSTRAP_SERVERS").Default("localhost:9093").String()
+	protocolFlag     = app.Flag("security-protocol", "Security protocol used to connect to the brokers.").Envar("KAFKA_SECURITY_PROTOCOL").Default(kafka.PLAINTEXT).Enum(kafka.PLAINTEXT, kafka.SSL, kafka.SASL_PLAINTEXT, kafka.SASL_SSL)
+	kafkaVersionFlag = app.Flag("kafka-version", "Version of the kafka protocol used to connect to the brokers.").Envar("KAFKA_VERSION").Default("2.5.0").String()
+	_                = app.Command("bootstrap", "Bootstraps the broker configuration.").Default()
+	keystores        = app.Command("keystores", "Builds the broker keystore and truststore from the mounted TLS secret.")
+	watchFlag        = keystores.Flag("watch", "Keep watching the certificate files and rebuild the stores when they change.").Bool()
+	intervalFlag     = keystores.Flag("interval", "Interval between two checks of the certificate files.").Default("30s").Duration()
+	reloadFlag       = keystores.Flag("reload", "Make the running broker reload its keystore when the certificate changes.").Default("true").Bool()
+	listenersFlag    = keystores.Flag("listener", "Name of a listener whose keystore is reloaded.").Default("INTERNAL").Strings()
+	certificate      = app.Command("certificate", "Requests a certificate for the broker through the kubernetes CSR API and writes it into the output dir.")
+	serviceFlag      = certificate.Flag("headless-service", "Name of the headless service of the broker StatefulSet.").Envar("KAFKA_HEADLESS_SERVICE").Default("kafka-svc").String()
+	domainFlag       = certificate.Flag("cluster-domain", "Kubernetes cluster domain.").Default(csr.DEFAULT_CLUSTER_DOMAIN).String()
+	outputDirFlag    = certificate.Flag("output-dir", "Writable directory, e.g. an emptyDir, the key and the signed certificate are written into, the --cert-dir of the keystores.").Default(csr.DEFAULT_CERT_DIR).String()
+	caFileFlag       = certificate.Flag("ca-file", "CA of the CSR signer, added to the truststore.").Default(csr.SERVICE_ACCOUNT_CA).String()
+	timeoutFlag      = certificate.Flag("timeout", "Maximum time to wait for the certificate signing request to be approved.").Default("5m").Duration()
+	certMonitor      = app.Command("cert-monitor", "Exports the expiry time of the keystore and truststore certificates and warns before they expire.")
+	metricsFlag      = certMonitor.Flag("metrics-address", "Address the prometheus metrics are served on.").Default(metrics.DEFAULT_METRICS_ADDRESS).String()
+	warnDaysFlag     = certMonitor.Flag("warn-days", "Days before expiry at which a warning event is recorded.").Default("30", "7").Ints()
+	checkFlag        = certMonitor.Flag("interval", "Interval between two checks of the certificates.").Default("1h").Duration()
+	migrate          = app.Command("migrate", "Migrates the cluster from ZooKeeper to KRaft one phase at a time.")
+	statefulSetFlag  = migrate.Flag("statefulset", "Name of the broker StatefulSet.").Envar("KAFKA_STATEFULSET").Required().String()
+	controllersFlag  = migrate.Flag("controller-replicas", "Number of KRaft controllers.").Default(strconv.Itoa(migration.DEFAULT_CONTROLLER_REPLICAS)).Int32()
+	idOffsetFlag     = migrate.Flag("controller-id-offset", "Offset of the KRaft controller node ids, they must not clash with the broker ids.").Default(strconv.Itoa(migration.DEFAULT_CONTROLLER_ID_OFFSET)).Int32()
+	controllerPort   = migrate.Flag("controller-port", "Port of the KRaft controller listener.").Default(kraft.DEFAULT_CONTROLLER_PORT).String()
+	zookeeperFlag    = migrate.Flag("zookeeper-uri", "ZooKeeper connection string of the cluster.").Envar(migration.ZOOKEEPER_URI_ENV).String()
+	migrationDomain  = migrate.Flag("cluster-domain", "Kubernetes cluster domain.").Default(kraft.DEFAULT_CLUSTER_DOMAIN).String()
+	metricsPortFlag  = migrate.Flag("metrics-port", "Port of the prometheus endpoint of the controllers.").Envar("METRICS_PORT").Default("9094").String()
+	migrateTimeout   = migrate.Flag("timeout", "Maximum time to wait for a phase to roll out.").Default("30m").Duration()
+	replacementCmd   = app.Command("broker-replacement", "Reports the catch-up of a broker replaced with empty log dirs and clears its replication throttles once in sync.")
+	catchUpFlag      = replacementCmd.Flag("interval", "Interval between two checks of the broker ISR membership.").Default("30s").Duration()
+	keepRunningFlag  = replacementCmd.Flag("keep-running", "Keep running once the broker is in sync, to run as a sidecar.").Bool()
+	throttleCmd      = app.Command("throttle", "Manages the replication throttles of the brokers and topics.")
+	brokersFlag      = throttleCmd.Flag("broker", "Id of a broker, all the brokers when none is given.").Int32List()
+	topicsFlag       = throttleCmd.Flag("topic", "Name of a topic.").Strings()
+	throttleSet      = throttleCmd.Command("set", "Sets the replication rates of the brokers and the throttled replicas of the topics.")
+	leaderRateFlag   = throttleSet.Flag("leader-rate", "Replication rate of the leaders, e.g. 50MiB.").Bytes()
+	followerRateFlag = throttleSet.Flag("follower-rate", "Replication rate of the followers, e.g. 50MiB.").Bytes()
+	leaderReplicas   = throttleSet.Flag("leader-replicas", "Throttled 'partition:broker' leader replicas of the topics, or '*'.").Default(throttle.ALL_REPLICAS).Strings()
+	followerReplicas = throttleSet.Flag("follower-replicas", "Throttled 'partition:broker' follower replicas of the topics, or '*'.").Default(throttle.ALL_REPLICAS).Strings()
+	throttleDescribe = throttleCmd.Command("describe", "Shows the replication throttles, of all the brokers and topics when none is given.")
+	throttleClear    = throttleCmd.Command("clear", "Removes the replication throttles, all of them when no broker or topic is given.")
+	logDirsCmd       = app.Command("log-dirs", "Manages the replicas of the log dirs of the brokers.")
+	logDirsDescribe  = logDirsCmd.Command("describe", "Shows the log dirs of the brokers.")
+	logDirsBrokers   = logDirsDescribe.Flag("broker", "Id of a broker, all the brokers when none is given.").Int32List()
+	logDirsMove      = logDirsCmd.Command("move", "Moves replicas of a broker into another of its log dirs.")
+	moveBrokerFlag   = logDirsMove.Flag("broker", "Id of the broker.").Required().Int32()
+	moveDirFlag      = logDirsMove.Flag("dir", "Log dir the replicas are moved into.").Required().String()
+	moveTopicFlag    = logDirsMove.Flag("topic", "Topic of the replicas.").Required().String()
+	movePartitions   = logDirsMove.Flag("partition", "Partition of the replicas, all the partitions of the broker when none is given.").Int32List()
+	moveTimeoutFlag  = logDirsMove.Flag("timeout", "Maximum time to wait for the replicas to be moved.").Default("1h").Duration()
+	diskMonitor      = app.Command("disk-monitor", "Exports the disk usage of the log dirs and protects the broker from running out of disk.")
+	diskMetricsFlag  = diskMonitor.Flag("metrics-address", "Address the prometheus metrics are served on.").Default(disk.DEFAULT_METRICS_ADDRESS).String()
+	diskIntervalFlag = diskMonitor.Flag("interval", "Interval between two checks of the disk usage.").Default("1m").Duration()
+	warningRatioFlag = diskMonitor.Flag("warning-ratio", "Used fraction of a log dir filesystem at which a warning event is recorded.").Default("0.8").Float64()
+	criticalRatio    = diskMonitor.Flag("critical-ratio", "Used fraction of a log dir filesystem at which the retention of the allowed topics is lowered.").Default("0.9").Float64()
+	protectTopics    = diskMonitor.Flag("lower-retention-topic", "Topic whose retention may be lowered when the disk usage is critical, none by default.").Strings()
+	loweredRetention = diskMonitor.Flag("lowered-retention", "Retention set on the allowed topics when the disk usage is critical.").Default("1h").Duration()
+	diskRun          = diskMonitor.Command("run", "Runs the disk usage monitor.").Default()
+	diskRestore      = diskMonitor.Command("restore", "Restores the retention of every topic lowered by the disk usage monitors.")
+	dynamicConfig    = app.Command("dynamic-config", "Applies the dynamic broker configs of a ConfigMap without restarting the brokers.")
+	configMapFlag    = dynamicConfig.Flag("configmap", "Name of the ConfigMap holding cluster.properties and broker-<id>.properties.").Envar(dynamic.DYNAMIC_CONFIG_CONFIGMAP_ENV).Required().String()
+	dynamicRun       = dynamicConfig.Command("run", "Reconciles the ConfigMap whenever it changes.").Default()
+	dynamicInterval  = dynamicRun.Flag("interval", "Interval between two checks of the ConfigMap.").Default("30s").Duration()
+	dynamicOnce      = dynamicRun.Flag("once", "Reconcile the ConfigMap once and exit.").Bool()
+	dynamicStatus    = dynamicConfig.Command("status", "Shows which configs were applied live and which require a restart.")
+	renderConfig     = app.Command("render-config", "Merges the broker config sources into server.properties and validates it against the known configs of the kafka version.")
+	baseConfigFlag   = renderConfig.Flag("base-config", "Broker config mounted from the ConfigMap, overridden by the KAFKA_CFG_ variables and the broker overrides.").Default(config.DEFAULT_BASE_CONFIG_PATH).String()
+	renderOutputFlag = renderConfig.Flag("output", "File the rendered config is written into.").Default(filepath.Join(KAFKA_CONFIG_PATH, config.SERVER_PROPERTIES_FILE)).String()
+	renderCheckFlag  = renderConfig.Flag("check", "Only validate the config and show where every value comes from, nothing is written.").Bool()
+	allowPrefixFlag  = renderConfig.Flag("allow-prefix", "Prefix of keys not checked against the known configs, e.g. of a metrics reporter.").Strings()
+	migrateStatus    = migrate.Command("status", "Shows the current phase of the migration.")
+	migrateAdvance   = migrate.Command("advance", "Rolls the cluster into the next phase of the migration, a failed phase is retried.")
+	migrateRollback  = migrate.Command("rollback", "Rolls the cluster back into the previous phase, until the migration is finalized.")
 )
 
 func main() {
 	log.Infoln("Running kafka-utils...")
+	parsed := kingpin.MustParse(app.Parse(os.Args[1:]))
 
 	k8sClient, err := client.GetKubernetesClient()
 	if err != nil {
 		log.Fatalf("Error initializing client: %+v", err)
 	}
+	env := &service.EnvironmentImpl{}
+	keystoreService := &keystore.KeystoreService{
+		Client:      k8sClient,
+		Env:         env,
+		CertDir:     *certDirFlag,
+		KeystoreDir: *keystoreDirFlag,
+		StoreType:   *keystoreTypeFlag,
+		ConfigPath:  filepath.Join(KAFKA_CONFIG_PATH, config.SERVER_PROPERTIES_FILE),
+	}
+
+	switch parsed {
+	case certificate.FullCommand():
+		csrService := &csr.CSRService{
+			Client:          k8sClient,
+			Env:             env,
+			HeadlessService: *serviceFlag,
+			ClusterDomain:   *domainFlag,
+			ExternalDNSPath: filepath.Join(KAFKA_HOME, service.EXTERNAL_DNS),
+			CAPath:          *caFileFlag,
+			PollInterval:    5 * time.Second,
+			Timeout:         *timeoutFlag,
+		}
+		if err := csrService.RequestCertificate(*outputDirFlag); err != nil {
+			log.Fatalf("could not get a certificate for the broker: %v", err)
+		}
+	case keystores.FullCommand():
+		if _, err := keystoreService.BuildStores(); err != nil {
+			log.Fatalf("could not build the keystores: %v", err)
+		}
+		if *watchFlag {
+			watcher := &keystore.Watcher{
+				Dir:      *certDirFlag,
+				Interval: *intervalFlag,
+				OnChange: func() error {
+					_, err := keystoreService.BuildStores()
+					return err
+				},
+			}
+			if *reloadFlag {
+				watcher.OnChange = newRotator(k8sClient, env, keystoreService).Rotate
+			}
+			watcher.Run(make(chan struct{}))
+		}
+	case migrateStatus.FullCommand(), migrateAdvance.FullCommand(), migrateRollback.FullCommand():
+		runMigration(parsed, k8sClient, env)
+	case throttleSet.FullCommand(), throttleDescribe.FullCommand(), throttleClear.FullCommand():
+		runThrottle(parsed, env)
+	case logDirsDescribe.FullCommand(), logDirsMove.FullCommand():
+		runLogDirs(parsed, env)
+	case diskRun.FullCommand(), diskRestore.FullCommand():
+		runDiskMonitor(parsed, k8sClient, env)
+	case dynamicRun.FullCommand(), dynamicStatus.FullCommand():
+		runDynamicConfig(parsed, k8sClient, env)
+	case renderConfig.FullCommand():
+		runRenderConfig(env)
+	case replacementCmd.FullCommand():
+		if err := newReplacer(k8sClient, env, strings.Split(*bootstrapFlag, ",")).WaitForCatchUp(make(chan struct{})); err != nil {
+			log.Fatalf("could not complete the broker replacement: %v", err)
+		}
+		if *keepRunningFlag {
+			select {}
+		}
+	case certMonitor.FullCommand():
+		var thresholds []time.Duration
+		for _, days := range *warnDaysFlag {
+			thresholds = append(thresholds, time.Duration(days)*24*time.Hour)
+		}
+		monitor := keystore.NewExpiryMonitor(keystoreService, &events.Recorder{
+			Client: k8sClient,
+			Env:    env,
+		}, thresholds)
+		prometheus.MustRegister(monitor.Expiry)
+		metrics.StartServer(*metricsFlag)
+		monitor.Run(*checkFlag, make(chan struct{}))
+	default:
+		runBootstrap(k8sClient, env)
+	}
+}
+
+func runBootstrap(k8sClient kubernetes.Interface, env service.Environment) {
 	kafkaService := service.KafkaService{
 		Client: k8sClient,
-		Env:    &service.EnvironmentImpl{},
+		Env:    env,
 	}
 	log.Infoln("Running kafka-utils...")
-	err = kafkaService.WriteIngressToPath(KAFKA_HOME)
+	err := kafkaService.WriteIngressToPath(KAFKA_HOME)
 	if err != nil {
 		log.Errorf("could not run the kafka utils bootstrap: %v", err)
 	} else {
 		log.Infoln("Finished the kafka-utils bootstrap.")
 	}
+
+	serverProperties := filepath.Join(KAFKA_CONFIG_PATH, config.SERVER_PROPERTIES_FILE)
+	logDirsPath, err := storage.WriteLogDirsConfigToPath(KAFKA_CONFIG_PATH, storage.LogDirsFromEnv())
+	if err != nil {
+		log.Fatalf("could not render the log dirs configuration: %v", err)
+	}
+	if err := config.MergeFileIntoPath(logDirsPath, serverProperties); err != nil {
+		log.Fatalf("could not merge the log dirs configuration: %v", err)
+	}
+
+	// the keystores merge their properties themselves when they are built after the bootstrap
+	sslPropertiesPath := filepath.Join(*keystoreDirFlag, keystore.SSL_PROPERTIES_FILE)
+	if _, err := os.Stat(sslPropertiesPath); err == nil {
+		if err := config.MergeFileIntoPath(sslPropertiesPath, serverProperties); err != nil {
+			log.Fatalf("could not merge the ssl configuration: %v", err)
+		}
+	}
+
+	if kerberos.IsEnabled() {
+		log.Infoln("Rendering the kerberos configuration...")
+		kerberosConfig := kerberos.NewConfigFromEnv(KAFKA_HOME, env.GetHostName())
+		if err := kerberosConfig.WriteConfigToPath(KAFKA_CONFIG_PATH); err != nil {
+			log.Fatalf("refusing to start the broker, invalid kerberos configuration: %v", err)
+		}
+	}
+
+	if storage.IsGuardEnabled() {
+		if err := checkIdentity(k8sClient, env); err != nil {
+			log.Fatalf("refusing to start the broker: %v", err)
+		}
+	}
+
+	if replacement.IsEnabled() {
+		// the local broker is not started yet, the cluster is reached through its bootstrap address
+		if servers := replacement.BootstrapServersFromEnv(); len(servers) == 0 {
+			log.Warnf("skipping the broker replacement throttling, %s is not set to the bootstrap address of the cluster", replacement.REPLACEMENT_BOOTSTRAP_ENV)
+		} else if err := newReplacer(k8sClient, env, servers).Prepare(); err != nil {
+			log.Errorf("could not throttle the replication of the replaced broker: %v", err)
+		}
+	}
+
+	if kraft.IsEnabled() {
+		log.Infoln("Bootstrapping the KRaft node...")
+		kraftConfig, err := kraft.NewConfigFromEnv(env.GetHostName())
+		if err != nil {
+			log.Fatalf("invalid KRaft configuration: %v", err)
+		}
+		kraftService := &kraft.KRaftService{
+			Client:    k8sClient,
+			Env:       env,
+			Config:    kraftConfig,
+			KafkaHome: KAFKA_HOME,
+		}
+		if err := kraftService.Bootstrap(KAFKA_CONFIG_PATH); err != nil {
+			log.Fatalf("could not bootstrap the KRaft node: %v", err)
+		}
+	}
+
+	phase, migrating, err := migration.PhaseFromEnv()
+	if err != nil {
+		log.Fatalf("invalid migration configuration: %v", err)
+	}
+	if migrating {
+		log.Infof("Rendering the configuration of the migration phase %s...", phase)
+		kraftConfig, err := kraft.NewConfigFromEnv(env.GetHostName())
+		if err != nil {
+			log.Fatalf("invalid KRaft configuration: %v", err)
+		}
+		kraftService := &kraft.KRaftService{
+			Client: k8sClient,
+			Env:    env,
+			Config: kraftConfig,
+		}
+		if err := migration.WriteConfigToPath(KAFKA_CONFIG_PATH, phase, kraftService, os.Getenv(migration.ZOOKEEPER_URI_ENV)); err != nil {
+			log.Fatalf("could not render the migration configuration: %v", err)
+		}
+		if err := migration.MergeConfigIntoPath(KAFKA_CONFIG_PATH, phase, kraftService); err != nil {
+			log.Fatalf("could not merge the migration configuration: %v", err)
+		}
+	}
+
+	// the overrides are merged last so they win over every block merged by the bootstrap
+	overrides := &config.Overrides{
+		Env: env,
+		Dir: config.OverridesDirFromEnv(),
+	}
+	if _, err := overrides.MergeIntoPath(serverProperties); err != nil {
+		log.Fatalf("could not merge the overrides of the broker: %v", err)
+	}
+}
+
+func checkIdentity(k8sClient kubernetes.Interface, env service.Environment) error {
+	hostname := env.GetHostName()
+	statefulSet, err := service.GetStatefulSetName(hostname)
+	if err != nil {
+		return err
+	}
+	nodeID, err := service.GetOrdinal(hostname)
+	if err != nil {
+		return err
+	}
+	if kraft.IsEnabled() {
+		kraftConfig, err := kraft.NewConfigFromEnv(hostname)
+		if err != nil {
+			return err
+		}
+		if nodeID, err = kraftConfig.NodeID(hostname); err != nil {
+			return err
+		}
+	}
+	guard := &storage.IdentityGuard{
+		Client:      k8sClient,
+		Env:         env,
+		StatefulSet: statefulSet,
+		SecretName:  storage.ClusterIDSecretName(statefulSet),
+		NodeID:      nodeID,
+		LogDirs:     storage.LogDirsFromEnv(),
+	}
+	return guard.Check()
+}
+
+func newReplacer(k8sClient kubernetes.Interface, env service.Environment, bootstrapServers []string) *replacement.Replacer {
+	brokerID, err := service.GetOrdinal(env.GetHostName())
+	if err != nil {
+		log.Fatalf("could not detect the broker id: %v", err)
+	}
+	rate := int64(replacement.DEFAULT_THROTTLE_RATE)
+	if value := os.Getenv(replacement.REPLACEMENT_RATE_ENV); len(value) > 0 {
+		if rate, err = strconv.ParseInt(value, 10, 64); err != nil {
+			log.Fatalf("invalid %s '%s': %v", replacement.REPLACEMENT_RATE_ENV, value, err)
+		}
+	}
+	kafkaConfig := newKafkaConfig(env)
+	kafkaConfig.BootstrapServers = bootstrapServers
+	return &replacement.Replacer{
+		NewAdmin: func() (sarama.ClusterAdmin, error) {
+			return kafka.NewClusterAdmin(kafkaConfig)
+		},
+		Events: &events.Recorder{
+			Client: k8sClient,
+			Env:    env,
+		},
+		BrokerID: brokerID,
+		LogDirs:  storage.LogDirsFromEnv(),
+		Rate:     rate,
+		Interval: *catchUpFlag,
+	}
+}
+
+func runMigration(command string, k8sClient kubernetes.Interface, env service.Environment) {
+	kafkaConfig := newKafkaConfig(env)
+	migrator := &migration.Migrator{
+		Client: k8sClient,
+		Env:    env,
+		Events: &events.Recorder{
+			Client: k8sClient,
+			Env:    env,
+		},
+		StatefulSet:        *statefulSetFlag,
+		ControllerReplicas: *controllersFlag,
+		ControllerIDOffset: *idOffsetFlag,
+		ControllerPort:     *controllerPort,
+		ZookeeperURI:       *zookeeperFlag,
+		ClusterID: func() (string, error) {
+			return kafka.ClusterID(kafkaConfig)
+		},
+		PollInterval: 10 * time.Second,
+		Timeout:      *migrateTimeout,
+	}
+	migrator.MigrationState = migration.NewMetricsMigrationState(&http.Client{Timeout: 10 * time.Second},
+		migrator.ControllerMetricsURLs(*migrationDomain, *metricsPortFlag))
+
+	var state *migration.State
+	var err error
+	switch command {
+	case migrateAdvance.FullCommand():
+		state, err = migrator.Advance()
+	case migrateRollback.FullCommand():
+		state, err = migrator.Rollback()
+	default:
+		state, err = migrator.LoadState()
+	}
+	if state != nil {
+		log.Infof("migration %s", state)
+	}
+	if err != nil {
+		log.Fatalf("migration failed: %v", err)
+	}
+}
+
+func runThrottle(command string, env service.Environment) {
+	admin, err := kafka.NewClusterAdmin(newKafkaConfig(env))
+	if err != nil {
+		log.Fatalf("could not connect to the brokers: %v", err)
+	}
+	defer admin.Close()
+	throttler := &throttle.Throttler{Admin: admin}
+
+	switch command {
+	case throttleSet.FullCommand():
+		err = setThrottles(throttler)
+	case throttleClear.FullCommand():
+		err = throttler.Clear(*brokersFlag, *topicsFlag)
+	default:
+		var report *throttle.Report
+		if report, err = throttler.Describe(*brokersFlag, *topicsFlag); err == nil {
+			fmt.Print(report)
+		}
+	}
+	if err != nil {
+		log.Fatalf("could not manage the replication throttles: %v", err)
+	}
+}
+
+func runDiskMonitor(command string, k8sClient kubernetes.Interface, env service.Environment) {
+	brokerID, err := service.GetOrdinal(env.GetHostName())
+	if err != nil {
+		log.Fatalf("could not detect the broker id: %v", err)
+	}
+	statefulSet, err := service.GetStatefulSetName(env.GetHostName())
+	if err != nil {
+		log.Fatalf("could not detect the broker StatefulSet: %v", err)
+	}
+	kafkaConfig := newKafkaConfig(env)
+	recorder := &events.Recorder{
+		Client: k8sClient,
+		Env:    env,
+	}
+	guard := &disk.RetentionGuard{
+		Client:    k8sClient,
+		Env:       env,
+		Events:    recorder,
+		ConfigMap: disk.RetentionConfigMapName(statefulSet),
+		BrokerID:  brokerID,
+		Topics:    splitList(*protectTopics),
+		Retention: *loweredRetention,
+	}
+
+	if command == diskRestore.FullCommand() {
+		admin, err := kafka.NewClusterAdmin(kafkaConfig)
+		if err != nil {
+			log.Fatalf("could not connect to the brokers: %v", err)
+		}
+		defer admin.Close()
+		if err := guard.Restore(admin, true); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+
+	monitor := disk.NewMonitor(func() (sarama.ClusterAdmin, error) {
+		return kafka.NewClusterAdmin(kafkaConfig)
+	}, recorder, brokerID, storage.LogDirsFromEnv())
+	monitor.WarningRatio = *warningRatioFlag
+	monitor.CriticalRatio = *criticalRatio
+	// the retention of topics which are not allowed explicitly is never touched
+	if len(guard.Topics) > 0 {
+		monitor.Retention = guard
+	}
+	prometheus.MustRegister(monitor.Collectors()...)
+	metrics.StartServer(*diskMetricsFlag)
+	monitor.Run(*diskIntervalFlag, make(chan struct{}))
+}
+
+func runDynamicConfig(command string, k8sClient kubernetes.Interface, env service.Environment) {
+	kafkaConfig := newKafkaConfig(env)
+	reconciler := &dynamic.Reconciler{
+		Client: k8sClient,
+		Env:    env,
+		Events: &events.Recorder{
+			Client: k8sClient,
+			Env:    env,
+		},
+		NewAdmin: func() (sarama.ClusterAdmin, error) {
+			return kafka.NewClusterAdmin(kafkaConfig)
+		},
+		ConfigMap: *configMapFlag,
+	}
+
+	switch {
+	case command == dynamicStatus.FullCommand():
+		status, err := reconciler.LoadStatus()
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		fmt.Print(status)
+	case *dynamicOnce:
+		status, err := reconciler.Reconcile()
+		fmt.Print(status)
+		if err != nil {
+			log.Fatalf("could not reconcile the dynamic configs: %v", err)
+		}
+	default:
+		reconciler.Run(*dynamicInterval, make(chan struct{}))
+	}
+}
+
+func runRenderConfig(env service.Environment) {
+	catalog, err := config.NewCatalog(*kafkaVersionFlag)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	renderer := &config.Renderer{
+		Catalog:  catalog,
+		BasePath: *baseConfigFlag,
+		Environ:  os.Environ(),
+		Overrides: &config.Overrides{
+			Env: env,
+			Dir: config.OverridesDirFromEnv(),
+		},
+		Fragments: []string{
+			filepath.Join(KAFKA_CONFIG_PATH, storage.LOG_DIRS_PROPERTIES_FILE),
+			filepath.Join(*keystoreDirFlag, keystore.SSL_PROPERTIES_FILE),
+			filepath.Join(KAFKA_CONFIG_PATH, kraft.KRAFT_PROPERTIES_FILE),
+			filepath.Join(KAFKA_CONFIG_PATH, migration.MIGRATION_PROPERTIES_FILE),
+		},
+		ExternalDir:     KAFKA_HOME,
+		AllowedPrefixes: *allowPrefixFlag,
+	}
+	phase, migrating, err := migration.PhaseFromEnv()
+	if err != nil {
+		log.Fatalf("invalid migration configuration: %v", err)
+	}
+	if migrating {
+		kraftConfig, err := kraft.NewConfigFromEnv(env.GetHostName())
+		if err != nil {
+			log.Fatalf("invalid KRaft configuration: %v", err)
+		}
+		renderer.Removed = migration.RemovedKeys(phase, kraftConfig)
+	}
+	rendered, err := renderer.Render()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if *renderCheckFlag {
+		fmt.Print(rendered)
+		return
+	}
+	if err := rendered.Properties.WriteToPath(*renderOutputFlag); err != nil {
+		log.Fatalf("could not write %s: %v", *renderOutputFlag, err)
+	}
+	log.Infof("rendered the broker config of kafka %s into %s", catalog.Version, *renderOutputFlag)
+}
+
+func runLogDirs(command string, env service.Environment) {
+	kafkaConfig := newKafkaConfig(env)
+	admin, err := kafka.NewClusterAdmin(kafkaConfig)
+	if err != nil {
+		log.Fatalf("could not connect to the brokers: %v", err)
+	}
+	defer admin.Close()
+	mover := &storage.ReplicaMover{
+		Admin: admin,
+		AlterReplicaLogDirs: func(brokerID int32, dir string, replicas map[string][]int32) error {
+			return kafka.AlterReplicaLogDirs(kafkaConfig, brokerID, dir, replicas)
+		},
+		PollInterval: 10 * time.Second,
+		Timeout:      *moveTimeoutFlag,
+	}
+
+	if command == logDirsMove.FullCommand() {
+		if err := mover.Move(*moveBrokerFlag, *moveDirFlag, *moveTopicFlag, *movePartitions); err != nil {
+			log.Fatalf("could not move the replicas: %v", err)
+		}
+		return
+	}
+	logDirs, err := mover.Describe(*logDirsBrokers)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	fmt.Print(storage.FormatLogDirs(logDirs))
+}
+
+func setThrottles(throttler *throttle.Throttler) error {
+	leaderRate, followerRate := int64(*leaderRateFlag), int64(*followerRateFlag)
+	if leaderRate <= 0 && followerRate <= 0 && len(*topicsFlag) == 0 {
+		return fmt.Errorf("nothing to throttle, give a rate or a topic")
+	}
+	if leaderRate > 0 || followerRate > 0 {
+		brokerIDs := *brokersFlag
+		if len(brokerIDs) == 0 {
+			brokers, _, err := throttler.Admin.DescribeCluster()
+			if err != nil {
+				return fmt.Errorf("could not list the brokers: %v", err)
+			}
+			for _, broker := range brokers {
+				brokerIDs = append(brokerIDs, broker.ID())
+			}
+		}
+		for _, brokerID := range brokerIDs {
+			if err := throttler.SetBrokerRates(brokerID, leaderRate, followerRate); err != nil {
+				return err
+			}
+		}
+	}
+	for _, topic := range *topicsFlag {
+		if err := throttler.SetTopicReplicas(topic, splitList(*leaderReplicas), splitList(*followerReplicas)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitList accepts both repeated flags and comma separated values
+func splitList(values []string) []string {
+	var list []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); len(item) > 0 {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+func newKafkaConfig(env service.Environment) *kafka.Config {
+	return &kafka.Config{
+		BootstrapServers:   strings.Split(*bootstrapFlag, ","),
+		SecurityProtocol:   *protocolFlag,
+		KafkaVersion:       *kafkaVersionFlag,
+		CertDir:            *certDirFlag,
+		Kerberos:           kerberos.NewConfigFromEnv(KAFKA_HOME, env.GetHostName()),
+		KerberosConfigPath: filepath.Join(KAFKA_CONFIG_PATH, kerberos.KRB5_CONF_FILE),
+	}
+}
+
+func newRotator(k8sClient kubernetes.Interface, env service.Environment, keystoreService *keystore.KeystoreService) *keystore.Rotator {
+	brokerID, err := service.GetOrdinal(env.GetHostName())
+	if err != nil {
+		log.Fatalf("could not detect the broker id: %v", err)
+	}
+	kafkaConfig := newKafkaConfig(env)
+	return &keystore.Rotator{
+		Keystore: keystoreService,
+		Events: &events.Recorder{
+			Client: k8sClient,
+			Env:    env,
+		},
+		NewAdmin: func() (sarama.ClusterAdmin, error) {
+			return kafka.NewClusterAdmin(kafkaConfig)
+		},
+		BrokerID:  brokerID,
+		Listeners: *listenersFlag,
+	}
 }
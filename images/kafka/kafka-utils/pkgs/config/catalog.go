@@ -0,0 +1,426 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ConfigType is the type of the value of a broker config
+type ConfigType string
+
+const (
+	TYPE_BOOLEAN  ConfigType = "boolean"
+	TYPE_SHORT    ConfigType = "short"
+	TYPE_INT      ConfigType = "int"
+	TYPE_LONG     ConfigType = "long"
+	TYPE_DOUBLE   ConfigType = "double"
+	TYPE_STRING   ConfigType = "string"
+	TYPE_LIST     ConfigType = "list"
+	TYPE_CLASS    ConfigType = "class"
+	TYPE_PASSWORD ConfigType = "password"
+
+	// MIN_CATALOG_VERSION is the oldest kafka version the catalog describes
+	MIN_CATALOG_VERSION  = "2.5.0"
+	LISTENER_NAME_PREFIX = "listener.name."
+)
+
+// ConfigDef describes a broker config of the catalog
+type ConfigDef struct {
+	Type ConfigType
+	// Since is the first kafka version knowing the config, empty for the configs of MIN_CATALOG_VERSION
+	Since string
+	// Until is the first kafka version which removed the config, empty when it was not removed
+	Until string
+}
+
+var className = regexp.MustCompile(`^[A-Za-z_$][\w$]*(\.[A-Za-z_$][\w$]*)*$`)
+
+// brokerConfigs are the configs a broker reads from server.properties
+var brokerConfigs = map[string]ConfigDef{
+	"advertised.host.name":                                        {Type: TYPE_STRING, Until: "3.0.0"},
+	"advertised.listeners":                                        {Type: TYPE_STRING},
+	"advertised.port":                                             {Type: TYPE_INT, Until: "3.0.0"},
+	"alter.config.policy.class.name":                              {Type: TYPE_CLASS},
+	"alter.log.dirs.replication.quota.window.num":                 {Type: TYPE_INT},
+	"alter.log.dirs.replication.quota.window.size.seconds":        {Type: TYPE_INT},
+	"authorizer.class.name":                                       {Type: TYPE_STRING},
+	"auto.create.topics.enable":                                   {Type: TYPE_BOOLEAN},
+	"auto.leader.rebalance.enable":                                {Type: TYPE_BOOLEAN},
+	"background.threads":                                          {Type: TYPE_INT},
+	"broker.id":                                                   {Type: TYPE_INT},
+	"broker.id.generation.enable":                                 {Type: TYPE_BOOLEAN},
+	"broker.rack":                                                 {Type: TYPE_STRING},
+	"client.quota.callback.class":                                 {Type: TYPE_CLASS},
+	"compression.type":                                            {Type: TYPE_STRING},
+	"connection.failed.authentication.delay.ms":                   {Type: TYPE_INT},
+	"connections.max.idle.ms":                                     {Type: TYPE_LONG},
+	"connections.max.reauth.ms":                                   {Type: TYPE_LONG},
+	"control.plane.listener.name":                                 {Type: TYPE_STRING},
+	"controlled.shutdown.enable":                                  {Type: TYPE_BOOLEAN},
+	"controlled.shutdown.max.retries":                             {Type: TYPE_INT},
+	"controlled.shutdown.retry.backoff.ms":                        {Type: TYPE_LONG},
+	"controller.listener.names":                                   {Type: TYPE_STRING, Since: "2.8.0"},
+	"controller.quorum.append.linger.ms":                          {Type: TYPE_INT, Since: "2.8.0"},
+	"controller.quorum.election.backoff.max.ms":                   {Type: TYPE_INT, Since: "2.8.0"},
+	"controller.quorum.election.timeout.ms":                       {Type: TYPE_INT, Since: "2.8.0"},
+	"controller.quorum.fetch.timeout.ms":                          {Type: TYPE_INT, Since: "2.8.0"},
+	"controller.quorum.request.timeout.ms":                        {Type: TYPE_INT, Since: "2.8.0"},
+	"controller.quorum.retry.backoff.ms":                          {Type: TYPE_INT, Since: "2.8.0"},
+	"controller.quorum.voters":                                    {Type: TYPE_LIST, Since: "2.8.0"},
+	"controller.socket.timeout.ms":                                {Type: TYPE_INT},
+	"create.topic.policy.class.name":                              {Type: TYPE_CLASS},
+	"default.replication.factor":                                  {Type: TYPE_INT},
+	"delegation.token.expiry.check.interval.ms":                   {Type: TYPE_LONG},
+	"delegation.token.expiry.time.ms":                             {Type: TYPE_LONG},
+	"delegation.token.master.key":                                 {Type: TYPE_PASSWORD},
+	"delegation.token.max.lifetime.ms":                            {Type: TYPE_LONG},
+	"delete.records.purgatory.purge.interval.requests":            {Type: TYPE_INT},
+	"delete.topic.enable":                                         {Type: TYPE_BOOLEAN},
+	"fetch.max.bytes":                                             {Type: TYPE_INT},
+	"fetch.purgatory.purge.interval.requests":                     {Type: TYPE_INT},
+	"group.initial.rebalance.delay.ms":                            {Type: TYPE_INT},
+	"group.max.session.timeout.ms":                                {Type: TYPE_INT},
+	"group.max.size":                                              {Type: TYPE_INT},
+	"group.min.session.timeout.ms":                                {Type: TYPE_INT},
+	"host.name":                                                   {Type: TYPE_STRING, Until: "3.0.0"},
+	"initial.broker.registration.timeout.ms":                      {Type: TYPE_INT, Since: "2.8.0"},
+	"inter.broker.listener.name":                                  {Type: TYPE_STRING},
+	"inter.broker.protocol.version":                               {Type: TYPE_STRING},
+	"kafka.metrics.polling.interval.secs":                         {Type: TYPE_INT},
+	"kafka.metrics.reporters":                                     {Type: TYPE_LIST},
+	"leader.imbalance.check.interval.seconds":                     {Type: TYPE_LONG},
+	"leader.imbalance.per.broker.percentage":                      {Type: TYPE_INT},
+	"listener.security.protocol.map":                              {Type: TYPE_STRING},
+	"listeners":                                                   {Type: TYPE_STRING},
+	"log.cleaner.backoff.ms":                                      {Type: TYPE_LONG},
+	"log.cleaner.dedupe.buffer.size":                              {Type: TYPE_LONG},
+	"log.cleaner.delete.retention.ms":                             {Type: TYPE_LONG},
+	"log.cleaner.enable":                                          {Type: TYPE_BOOLEAN},
+	"log.cleaner.io.buffer.load.factor":                           {Type: TYPE_DOUBLE},
+	"log.cleaner.io.buffer.size":                                  {Type: TYPE_INT},
+	"log.cleaner.io.max.bytes.per.second":                         {Type: TYPE_DOUBLE},
+	"log.cleaner.max.compaction.lag.ms":                           {Type: TYPE_LONG},
+	"log.cleaner.min.cleanable.ratio":                             {Type: TYPE_DOUBLE},
+	"log.cleaner.min.compaction.lag.ms":                           {Type: TYPE_LONG},
+	"log.cleaner.threads":                                         {Type: TYPE_INT},
+	"log.cleanup.policy":                                          {Type: TYPE_LIST},
+	"log.dir":                                                     {Type: TYPE_STRING},
+	"log.dirs":                                                    {Type: TYPE_STRING},
+	"log.flush.interval.messages":                                 {Type: TYPE_LONG},
+	"log.flush.interval.ms":                                       {Type: TYPE_LONG},
+	"log.flush.offset.checkpoint.interval.ms":                     {Type: TYPE_INT},
+	"log.flush.scheduler.interval.ms":                             {Type: TYPE_LONG},
+	"log.flush.start.offset.checkpoint.interval.ms":               {Type: TYPE_INT},
+	"log.index.interval.bytes":                                    {Type: TYPE_INT},
+	"log.index.size.max.bytes":                                    {Type: TYPE_INT},
+	"log.message.downconversion.enable":                           {Type: TYPE_BOOLEAN},
+	"log.message.format.version":                                  {Type: TYPE_STRING},
+	"log.message.timestamp.difference.max.ms":                     {Type: TYPE_LONG},
+	"log.message.timestamp.type":                                  {Type: TYPE_STRING},
+	"log.preallocate":                                             {Type: TYPE_BOOLEAN},
+	"log.retention.bytes":                                         {Type: TYPE_LONG},
+	"log.retention.check.interval.ms":                             {Type: TYPE_LONG},
+	"log.retention.hours":                                         {Type: TYPE_INT},
+	"log.retention.minutes":                                       {Type: TYPE_INT},
+	"log.retention.ms":                                            {Type: TYPE_LONG},
+	"log.roll.hours":                                              {Type: TYPE_INT},
+	"log.roll.jitter.hours":                                       {Type: TYPE_INT},
+	"log.roll.jitter.ms":                                          {Type: TYPE_LONG},
+	"log.roll.ms":                                                 {Type: TYPE_LONG},
+	"log.segment.bytes":                                           {Type: TYPE_INT},
+	"log.segment.delete.delay.ms":                                 {Type: TYPE_LONG},
+	"max.connections":                                             {Type: TYPE_INT},
+	"max.connections.per.ip":                                      {Type: TYPE_INT},
+	"max.connections.per.ip.overrides":                            {Type: TYPE_STRING},
+	"max.incremental.fetch.session.cache.slots":                   {Type: TYPE_INT},
+	"message.max.bytes":                                           {Type: TYPE_INT},
+	"metadata.log.dir":                                            {Type: TYPE_STRING, Since: "2.8.0"},
+	"metric.reporters":                                            {Type: TYPE_LIST},
+	"metrics.num.samples":                                         {Type: TYPE_INT},
+	"metrics.recording.level":                                     {Type: TYPE_STRING},
+	"metrics.sample.window.ms":                                    {Type: TYPE_LONG},
+	"min.insync.replicas":                                         {Type: TYPE_INT},
+	"node.id":                                                     {Type: TYPE_INT, Since: "2.8.0"},
+	"num.io.threads":                                              {Type: TYPE_INT},
+	"num.network.threads":                                         {Type: TYPE_INT},
+	"num.partitions":                                              {Type: TYPE_INT},
+	"num.recovery.threads.per.data.dir":                           {Type: TYPE_INT},
+	"num.replica.alter.log.dirs.threads":                          {Type: TYPE_INT},
+	"num.replica.fetchers":                                        {Type: TYPE_INT},
+	"offset.metadata.max.bytes":                                   {Type: TYPE_INT},
+	"offsets.commit.required.acks":                                {Type: TYPE_SHORT},
+	"offsets.commit.timeout.ms":                                   {Type: TYPE_INT},
+	"offsets.load.buffer.size":                                    {Type: TYPE_INT},
+	"offsets.retention.check.interval.ms":                         {Type: TYPE_LONG},
+	"offsets.retention.minutes":                                   {Type: TYPE_INT},
+	"offsets.topic.compression.codec":                             {Type: TYPE_INT},
+	"offsets.topic.num.partitions":                                {Type: TYPE_INT},
+	"offsets.topic.replication.factor":                            {Type: TYPE_SHORT},
+	"offsets.topic.segment.bytes":                                 {Type: TYPE_INT},
+	"password.encoder.cipher.algorithm":                           {Type: TYPE_STRING},
+	"password.encoder.iterations":                                 {Type: TYPE_INT},
+	"password.encoder.key.length":                                 {Type: TYPE_INT},
+	"password.encoder.keyfactory.algorithm":                       {Type: TYPE_STRING},
+	"password.encoder.old.secret":                                 {Type: TYPE_PASSWORD},
+	"password.encoder.secret":                                     {Type: TYPE_PASSWORD},
+	"port":                                                        {Type: TYPE_INT, Until: "3.0.0"},
+	"principal.builder.class":                                     {Type: TYPE_CLASS},
+	"process.roles":                                               {Type: TYPE_LIST, Since: "2.8.0"},
+	"producer.purgatory.purge.interval.requests":                  {Type: TYPE_INT},
+	"queued.max.request.bytes":                                    {Type: TYPE_LONG},
+	"queued.max.requests":                                         {Type: TYPE_INT},
+	"quota.consumer.default":                                      {Type: TYPE_LONG, Until: "3.0.0"},
+	"quota.producer.default":                                      {Type: TYPE_LONG, Until: "3.0.0"},
+	"quota.window.num":                                            {Type: TYPE_INT},
+	"quota.window.size.seconds":                                   {Type: TYPE_INT},
+	"replica.fetch.backoff.ms":                                    {Type: TYPE_INT},
+	"replica.fetch.max.bytes":                                     {Type: TYPE_INT},
+	"replica.fetch.min.bytes":                                     {Type: TYPE_INT},
+	"replica.fetch.response.max.bytes":                            {Type: TYPE_INT},
+	"replica.fetch.wait.max.ms":                                   {Type: TYPE_INT},
+	"replica.high.watermark.checkpoint.interval.ms":               {Type: TYPE_LONG},
+	"replica.lag.time.max.ms":                                     {Type: TYPE_LONG},
+	"replica.selector.class":                                      {Type: TYPE_STRING},
+	"replica.socket.receive.buffer.bytes":                         {Type: TYPE_INT},
+	"replica.socket.timeout.ms":                                   {Type: TYPE_INT},
+	"replication.quota.window.num":                                {Type: TYPE_INT},
+	"replication.quota.window.size.seconds":                       {Type: TYPE_INT},
+	"request.timeout.ms":                                          {Type: TYPE_INT},
+	"reserved.broker.max.id":                                      {Type: TYPE_INT},
+	"sasl.client.callback.handler.class":                          {Type: TYPE_CLASS},
+	"sasl.enabled.mechanisms":                                     {Type: TYPE_LIST},
+	"sasl.jaas.config":                                            {Type: TYPE_PASSWORD},
+	"sasl.kerberos.kinit.cmd":                                     {Type: TYPE_STRING},
+	"sasl.kerberos.min.time.before.relogin":                       {Type: TYPE_LONG},
+	"sasl.kerberos.principal.to.local.rules":                      {Type: TYPE_LIST},
+	"sasl.kerberos.service.name":                                  {Type: TYPE_STRING},
+	"sasl.kerberos.ticket.renew.jitter":                           {Type: TYPE_DOUBLE},
+	"sasl.kerberos.ticket.renew.window.factor":                    {Type: TYPE_DOUBLE},
+	"sasl.login.callback.handler.class":                           {Type: TYPE_CLASS},
+	"sasl.login.class":                                            {Type: TYPE_CLASS},
+	"sasl.login.refresh.buffer.seconds":                           {Type: TYPE_SHORT},
+	"sasl.login.refresh.min.period.seconds":                       {Type: TYPE_SHORT},
+	"sasl.login.refresh.window.factor":                            {Type: TYPE_DOUBLE},
+	"sasl.login.refresh.window.jitter":                            {Type: TYPE_DOUBLE},
+	"sasl.mechanism.inter.broker.protocol":                        {Type: TYPE_STRING},
+	"sasl.server.callback.handler.class":                          {Type: TYPE_CLASS},
+	"security.inter.broker.protocol":                              {Type: TYPE_STRING},
+	"security.providers":                                          {Type: TYPE_STRING},
+	"socket.connection.setup.timeout.max.ms":                      {Type: TYPE_LONG, Since: "2.7.0"},
+	"socket.connection.setup.timeout.ms":                          {Type: TYPE_LONG, Since: "2.7.0"},
+	"socket.receive.buffer.bytes":                                 {Type: TYPE_INT},
+	"socket.request.max.bytes":                                    {Type: TYPE_INT},
+	"socket.send.buffer.bytes":                                    {Type: TYPE_INT},
+	"ssl.cipher.suites":                                           {Type: TYPE_LIST},
+	"ssl.client.auth":                                             {Type: TYPE_STRING},
+	"ssl.enabled.protocols":                                       {Type: TYPE_LIST},
+	"ssl.endpoint.identification.algorithm":                       {Type: TYPE_STRING},
+	"ssl.engine.factory.class":                                    {Type: TYPE_CLASS, Since: "2.6.0"},
+	"ssl.key.password":                                            {Type: TYPE_PASSWORD},
+	"ssl.keymanager.algorithm":                                    {Type: TYPE_STRING},
+	"ssl.keystore.certificate.chain":                              {Type: TYPE_PASSWORD, Since: "2.7.0"},
+	"ssl.keystore.key":                                            {Type: TYPE_PASSWORD, Since: "2.7.0"},
+	"ssl.keystore.location":                                       {Type: TYPE_STRING},
+	"ssl.keystore.password":                                       {Type: TYPE_PASSWORD},
+	"ssl.keystore.type":                                           {Type: TYPE_STRING},
+	"ssl.principal.mapping.rules":                                 {Type: TYPE_STRING},
+	"ssl.protocol":                                                {Type: TYPE_STRING},
+	"ssl.provider":                                                {Type: TYPE_STRING},
+	"ssl.secure.random.implementation":                            {Type: TYPE_STRING},
+	"ssl.trustmanager.algorithm":                                  {Type: TYPE_STRING},
+	"ssl.truststore.certificates":                                 {Type: TYPE_PASSWORD, Since: "2.7.0"},
+	"ssl.truststore.location":                                     {Type: TYPE_STRING},
+	"ssl.truststore.password":                                     {Type: TYPE_PASSWORD},
+	"ssl.truststore.type":                                         {Type: TYPE_STRING},
+	"transaction.abort.timed.out.transaction.cleanup.interval.ms": {Type: TYPE_INT},
+	"transaction.max.timeout.ms":                                  {Type: TYPE_INT},
+	"transaction.remove.expired.transaction.cleanup.interval.ms":  {Type: TYPE_INT},
+	"transaction.state.log.load.buffer.size":                      {Type: TYPE_INT},
+	"transaction.state.log.min.isr":                               {Type: TYPE_INT},
+	"transaction.state.log.num.partitions":                        {Type: TYPE_INT},
+	"transaction.state.log.replication.factor":                    {Type: TYPE_SHORT},
+	"transaction.state.log.segment.bytes":                         {Type: TYPE_INT},
+	"transactional.id.expiration.ms":                              {Type: TYPE_INT},
+	"unclean.leader.election.enable":                              {Type: TYPE_BOOLEAN},
+	"zookeeper.clientCnxnSocket":                                  {Type: TYPE_STRING, Until: "4.0.0"},
+	"zookeeper.connect":                                           {Type: TYPE_STRING, Until: "4.0.0"},
+	"zookeeper.connection.timeout.ms":                             {Type: TYPE_INT, Until: "4.0.0"},
+	"zookeeper.max.in.flight.requests":                            {Type: TYPE_INT, Until: "4.0.0"},
+	"zookeeper.metadata.migration.enable":                         {Type: TYPE_BOOLEAN, Since: "3.4.0", Until: "4.0.0"},
+	"zookeeper.session.timeout.ms":                                {Type: TYPE_INT, Until: "4.0.0"},
+	"zookeeper.set.acl":                                           {Type: TYPE_BOOLEAN, Until: "4.0.0"},
+	"zookeeper.ssl.cipher.suites":                                 {Type: TYPE_LIST, Until: "4.0.0"},
+	"zookeeper.ssl.client.enable":                                 {Type: TYPE_BOOLEAN, Until: "4.0.0"},
+	"zookeeper.ssl.crl.enable":                                    {Type: TYPE_BOOLEAN, Until: "4.0.0"},
+	"zookeeper.ssl.enabled.protocols":                             {Type: TYPE_LIST, Until: "4.0.0"},
+	"zookeeper.ssl.endpoint.identification.algorithm":             {Type: TYPE_STRING, Until: "4.0.0"},
+	"zookeeper.ssl.keystore.location":                             {Type: TYPE_STRING, Until: "4.0.0"},
+	"zookeeper.ssl.keystore.password":                             {Type: TYPE_PASSWORD, Until: "4.0.0"},
+	"zookeeper.ssl.keystore.type":                                 {Type: TYPE_STRING, Until: "4.0.0"},
+	"zookeeper.ssl.ocsp.enable":                                   {Type: TYPE_BOOLEAN, Until: "4.0.0"},
+	"zookeeper.ssl.protocol":                                      {Type: TYPE_STRING, Until: "4.0.0"},
+	"zookeeper.ssl.truststore.location":                           {Type: TYPE_STRING, Until: "4.0.0"},
+	"zookeeper.ssl.truststore.password":                           {Type: TYPE_PASSWORD, Until: "4.0.0"},
+	"zookeeper.ssl.truststore.type":                               {Type: TYPE_STRING, Until: "4.0.0"},
+	"zookeeper.sync.time.ms":                                      {Type: TYPE_INT, Until: "4.0.0"},
+}
+
+// Catalog holds the broker configs known to a kafka version
+type Catalog struct {
+	Version string
+	version []int
+}
+
+// NewCatalog returns the catalog of the broker configs of the kafka version
+func NewCatalog(version string) (*Catalog, error) {
+	parsed, err := parseVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	minimum, _ := parseVersion(MIN_CATALOG_VERSION)
+	if compareVersions(parsed, minimum) < 0 {
+		return nil, fmt.Errorf("no broker config catalog for kafka %s, the oldest known version is %s", version, MIN_CATALOG_VERSION)
+	}
+	return &Catalog{Version: version, version: parsed}, nil
+}
+
+// Lookup returns the definition of key, the per listener configs are looked up without their listener.name.<listener>. prefix
+func (c *Catalog) Lookup(key string) (ConfigDef, error) {
+	name := key
+	if strings.HasPrefix(key, LISTENER_NAME_PREFIX) {
+		// listener.name.<listener>.<config> or listener.name.<listener>.<sasl mechanism>.<config>
+		parts := strings.SplitN(strings.TrimPrefix(key, LISTENER_NAME_PREFIX), ".", 2)
+		if len(parts) != 2 {
+			return ConfigDef{}, fmt.Errorf("%s does not name a listener config", key)
+		}
+		name = parts[1]
+		if _, ok := brokerConfigs[name]; !ok {
+			if mechanism := strings.SplitN(name, ".", 2); len(mechanism) == 2 {
+				name = mechanism[1]
+			}
+		}
+	}
+	def, ok := brokerConfigs[name]
+	if !ok {
+		if suggestion := c.suggest(name); len(suggestion) > 0 {
+			return ConfigDef{}, fmt.Errorf("unknown config %s for kafka %s, did you mean %s?", key, c.Version, suggestion)
+		}
+		return ConfigDef{}, fmt.Errorf("unknown config %s for kafka %s", key, c.Version)
+	}
+	if len(def.Since) > 0 && c.before(def.Since) {
+		return ConfigDef{}, fmt.Errorf("%s requires kafka %s or later, the broker runs kafka %s", key, def.Since, c.Version)
+	}
+	if len(def.Until) > 0 && !c.before(def.Until) {
+		return ConfigDef{}, fmt.Errorf("%s was removed in kafka %s, the broker runs kafka %s", key, def.Until, c.Version)
+	}
+	return def, nil
+}
+
+// Check returns an error when value does not match the type of the config
+func (d ConfigDef) Check(key, value string) error {
+	var err error
+	switch d.Type {
+	case TYPE_BOOLEAN:
+		if lower := strings.ToLower(value); lower != "true" && lower != "false" {
+			err = fmt.Errorf("not true or false")
+		}
+	case TYPE_SHORT:
+		_, err = strconv.ParseInt(value, 10, 16)
+	case TYPE_INT:
+		_, err = strconv.ParseInt(value, 10, 32)
+	case TYPE_LONG:
+		_, err = strconv.ParseInt(value, 10, 64)
+	case TYPE_DOUBLE:
+		_, err = strconv.ParseFloat(value, 64)
+	case TYPE_CLASS:
+		if len(value) > 0 && !className.MatchString(value) {
+			err = fmt.Errorf("not a class name")
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%s=%s is not a valid %s", key, value, d.Type)
+	}
+	return nil
+}
+
+func (c *Catalog) before(version string) bool {
+	parsed, err := parseVersion(version)
+	if err != nil {
+		return false
+	}
+	return compareVersions(c.version, parsed) < 0
+}
+
+// suggest returns the known config closest to an unknown key, empty when none is close enough
+func (c *Catalog) suggest(key string) string {
+	best, bestDistance := "", 3
+	for name := range brokerConfigs {
+		if distance := levenshtein(strings.ToLower(key), strings.ToLower(name)); distance < bestDistance || (distance == bestDistance && len(best) > 0 && name < best) {
+			best, bestDistance = name, distance
+		}
+	}
+	return best
+}
+
+func parseVersion(version string) ([]int, error) {
+	var parsed []int
+	for _, part := range strings.Split(version, ".") {
+		number, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid kafka version %s", version)
+		}
+		parsed = append(parsed, number)
+	}
+	return parsed, nil
+}
+
+func compareVersions(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func levenshtein(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+func min(values ...int) int {
+	minimum := values[0]
+	for _, value := range values[1:] {
+		if value < minimum {
+			minimum = value
+		}
+	}
+	return minimum
+}
@@ -0,0 +1,321 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/mocks"
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/reporters"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("[Kafka Config]", func() {
+
+	var (
+		mockCtrl  *gomock.Controller
+		mockEnv   *mocks.MockEnvironment
+		dir       string
+		overrides *Overrides
+	)
+
+	writeFile := func(path, content string) {
+		Expect(ioutil.WriteFile(path, []byte(content), 0644)).To(BeNil())
+	}
+
+	Context("Properties", func() {
+		It("keeps the order of the properties", func() {
+			properties, err := ParseProperties(strings.NewReader("# broker\nbroker.id=0\n\nnum.network.threads = 3\n! comment\nlog.dirs:/var/lib/kafka/data\nbroker.id=1\n"))
+			Expect(err).To(BeNil())
+			Expect(properties.Keys()).To(Equal([]string{"broker.id", "num.network.threads", "log.dirs"}))
+			value, _ := properties.Get("broker.id")
+			Expect(value).To(Equal("1"))
+
+			b := &bytes.Buffer{}
+			_, err = properties.WriteTo(b)
+			Expect(err).To(BeNil())
+			Expect(b.String()).To(Equal("broker.id=1\nnum.network.threads=3\nlog.dirs=/var/lib/kafka/data\n"))
+		})
+		It("reads the java properties syntax", func() {
+			properties, err := ParseProperties(strings.NewReader("listener.name.sasl_ssl.plain.sasl.jaas.config = \\\n" +
+				"    org.apache.kafka.common.security.plain.PlainLoginModule required \\\n" +
+				"    username=\"admin\";\n" +
+				"ssl.keystore.location /etc/tls/keystore.jks\n" +
+				"log.dirs=C:\\\\kafka\n" +
+				"metric\\=reporter\\ name=\\u00e9t\\u00e9\\n\n" +
+				"empty=\n"))
+			Expect(err).To(BeNil())
+			Expect(properties.Keys()).To(Equal([]string{"listener.name.sasl_ssl.plain.sasl.jaas.config", "ssl.keystore.location", "log.dirs", "metric=reporter name", "empty"}))
+			value, _ := properties.Get("listener.name.sasl_ssl.plain.sasl.jaas.config")
+			Expect(value).To(Equal("org.apache.kafka.common.security.plain.PlainLoginModule required username=\"admin\";"))
+			value, _ = properties.Get("ssl.keystore.location")
+			Expect(value).To(Equal("/etc/tls/keystore.jks"))
+			value, _ = properties.Get("log.dirs")
+			Expect(value).To(Equal(`C:\kafka`))
+			value, _ = properties.Get("metric=reporter name")
+			Expect(value).To(Equal("été\n"))
+
+			b := &bytes.Buffer{}
+			_, err = properties.WriteTo(b)
+			Expect(err).To(BeNil())
+			written, err := ParseProperties(b)
+			Expect(err).To(BeNil())
+			Expect(written).To(Equal(properties))
+		})
+		It("merges properties in a block replaced on every run", func() {
+			path := filepath.Join(dir, SERVER_PROPERTIES_FILE)
+			writeFile(path, "# rendered\nlog.dirs=/tmp/kafka-logs\nnum.io.threads=8")
+			properties := NewProperties()
+			properties.Set("log.dirs", "/var/lib/kafka/data")
+			Expect(properties.MergeIntoPath(path, "log-dirs.properties")).To(Succeed())
+			properties.Set("log.dirs", "/var/lib/kafka/data-0,/var/lib/kafka/data-1")
+			Expect(properties.MergeIntoPath(path, "log-dirs.properties")).To(Succeed())
+
+			content, err := ioutil.ReadFile(path)
+			Expect(err).To(BeNil())
+			Expect(string(content)).To(Equal("# rendered\nlog.dirs=/tmp/kafka-logs\nnum.io.threads=8\n" +
+				"# BEGIN kafka-utils log-dirs.properties\nlog.dirs=/var/lib/kafka/data-0,/var/lib/kafka/data-1\n# END kafka-utils log-dirs.properties\n"))
+			merged, err := ReadProperties(path)
+			Expect(err).To(BeNil())
+			value, _ := merged.Get("log.dirs")
+			Expect(value).To(Equal("/var/lib/kafka/data-0,/var/lib/kafka/data-1"))
+		})
+		It("removes properties from the file until the block stops removing them", func() {
+			path := filepath.Join(dir, SERVER_PROPERTIES_FILE)
+			base := "broker.id=1\nzookeeper.connect=zk-0:2181,\\\n  zk-1:2181\nnum.io.threads=8\n"
+			writeFile(path, base)
+			properties := NewProperties()
+			properties.Set("process.roles", "broker")
+			Expect(properties.MergeIntoPath(path, "migration.properties", "broker.id", "zookeeper.connect")).To(Succeed())
+
+			content, err := ioutil.ReadFile(path)
+			Expect(err).To(BeNil())
+			Expect(string(content)).To(Equal("#kafka-utils migration.properties removed# broker.id=1\n" +
+				"#kafka-utils migration.properties removed# zookeeper.connect=zk-0:2181,\\\n" +
+				"#kafka-utils migration.properties removed#   zk-1:2181\nnum.io.threads=8\n" +
+				"# BEGIN kafka-utils migration.properties\nprocess.roles=broker\n# END kafka-utils migration.properties\n"))
+			merged, err := ReadProperties(path)
+			Expect(err).To(BeNil())
+			Expect(merged.Keys()).To(Equal([]string{"num.io.threads", "process.roles"}))
+
+			Expect(NewProperties().MergeIntoPath(path, "migration.properties")).To(Succeed())
+			content, err = ioutil.ReadFile(path)
+			Expect(err).To(BeNil())
+			Expect(string(content)).To(Equal(base))
+		})
+		It("refuses lines without value separator", func() {
+			_, err := ParseProperties(strings.NewReader("broker.id=0\nnum.network.threads\n"))
+			Expect(err).To(MatchError(ContainSubstring("line 2")))
+		})
+	})
+
+	Context("Broker overrides", func() {
+		It("merges the overrides of the broker into the config", func() {
+			path := filepath.Join(dir, SERVER_PROPERTIES_FILE)
+			writeFile(path, "# rendered\nnum.replica.fetchers=1\nnum.io.threads=8\n")
+			writeFile(filepath.Join(overrides.Dir, "broker-2.properties"), "num.replica.fetchers=4\nnum.io.threads=8\nbroker.rack=zone-b\n")
+			writeFile(filepath.Join(overrides.Dir, "broker-1.properties"), "num.replica.fetchers=2\n")
+
+			merged, err := overrides.MergeIntoPath(path)
+			Expect(err).To(BeNil())
+			Expect(merged).To(Equal([]Override{
+				{Key: "num.replica.fetchers", Previous: "1", Value: "4"},
+				{Key: "num.io.threads", Previous: "8", Value: "8"},
+				{Key: "broker.rack", Value: "zone-b", Added: true},
+			}))
+			Expect(merged[0].String()).To(Equal("num.replica.fetchers=4 (was 1)"))
+			Expect(merged[1].String()).To(Equal("num.io.threads=8 (unchanged)"))
+			Expect(merged[2].String()).To(Equal("broker.rack=zone-b (added)"))
+
+			content, err := ioutil.ReadFile(path)
+			Expect(err).To(BeNil())
+			Expect(string(content)).To(Equal("# rendered\nnum.replica.fetchers=1\nnum.io.threads=8\n" +
+				"# BEGIN kafka-utils broker overrides\nnum.replica.fetchers=4\nnum.io.threads=8\nbroker.rack=zone-b\n# END kafka-utils broker overrides\n"))
+
+			// the overrides of a previous run are not mistaken for the config of the broker
+			merged, err = overrides.MergeIntoPath(path)
+			Expect(err).To(BeNil())
+			Expect(merged[0].String()).To(Equal("num.replica.fetchers=4 (was 1)"))
+			again, err := ioutil.ReadFile(path)
+			Expect(err).To(BeNil())
+			Expect(again).To(Equal(content))
+
+			// the blocks merged after the overrides don't win over them
+			properties := NewProperties()
+			properties.Set("num.replica.fetchers", "2")
+			Expect(properties.MergeIntoPath(path, "ssl.properties")).To(Succeed())
+			config, err := ReadProperties(path)
+			Expect(err).To(BeNil())
+			value, _ := config.Get("num.replica.fetchers")
+			Expect(value).To(Equal("4"))
+		})
+		It("leaves the config of a broker without overrides untouched", func() {
+			path := filepath.Join(dir, SERVER_PROPERTIES_FILE)
+			writeFile(path, "# rendered\nnum.replica.fetchers=1\n")
+			merged, err := overrides.MergeIntoPath(path)
+			Expect(err).To(BeNil())
+			Expect(merged).To(BeEmpty())
+			content, err := ioutil.ReadFile(path)
+			Expect(err).To(BeNil())
+			Expect(string(content)).To(Equal("# rendered\nnum.replica.fetchers=1\n"))
+		})
+		It("drops the block of the removed overrides", func() {
+			path := filepath.Join(dir, SERVER_PROPERTIES_FILE)
+			writeFile(path, "# rendered\nnum.replica.fetchers=1\n")
+			writeFile(filepath.Join(overrides.Dir, "broker-2.properties"), "num.replica.fetchers=4\n")
+			_, err := overrides.MergeIntoPath(path)
+			Expect(err).To(BeNil())
+
+			Expect(os.Remove(filepath.Join(overrides.Dir, "broker-2.properties"))).To(Succeed())
+			merged, err := overrides.MergeIntoPath(path)
+			Expect(err).To(BeNil())
+			Expect(merged).To(BeEmpty())
+			content, err := ioutil.ReadFile(path)
+			Expect(err).To(BeNil())
+			Expect(string(content)).To(Equal("# rendered\nnum.replica.fetchers=1\n"))
+		})
+		It("reports a malformed override file", func() {
+			writeFile(filepath.Join(overrides.Dir, "broker-2.properties"), "num.replica.fetchers\n")
+			path := filepath.Join(dir, SERVER_PROPERTIES_FILE)
+			writeFile(path, "num.replica.fetchers=1\n")
+			_, err := overrides.MergeIntoPath(path)
+			Expect(err).To(MatchError(ContainSubstring("broker-2.properties")))
+		})
+	})
+
+	Context("Render", func() {
+		var renderer *Renderer
+
+		BeforeEach(func() {
+			catalog, err := NewCatalog("2.5.0")
+			Expect(err).To(BeNil())
+			renderer = &Renderer{
+				Catalog:     catalog,
+				BasePath:    filepath.Join(dir, SERVER_PROPERTIES_FILE),
+				Overrides:   overrides,
+				Fragments:   []string{filepath.Join(dir, "log-dirs.properties"), filepath.Join(dir, "kraft.properties")},
+				ExternalDir: dir,
+			}
+		})
+
+		It("merges the sources in their order of precedence", func() {
+			writeFile(renderer.BasePath, "broker.id=2\nnum.io.threads=8\nlisteners=INTERNAL://0.0.0.0:9093\nssl.keystore.password=secret\n")
+			renderer.Environ = []string{"PATH=/bin", "KAFKA_CFG_NUM_IO_THREADS=16", "KAFKA_CFG_ZOOKEEPER_CLIENTCNXNSOCKET=org.apache.zookeeper.ClientCnxnSocketNetty"}
+			writeFile(filepath.Join(overrides.Dir, "broker-2.properties"), "num.io.threads=4\n")
+			writeFile(filepath.Join(dir, "log-dirs.properties"), "log.dirs=/var/lib/kafka/data\n")
+			writeFile(filepath.Join(dir, "external.listeners"), "EXTERNAL_INGRESS://0.0.0.0:30902\n")
+
+			rendered, err := renderer.Render()
+			Expect(err).To(BeNil())
+			b := &bytes.Buffer{}
+			_, err = rendered.Properties.WriteTo(b)
+			Expect(err).To(BeNil())
+			Expect(b.String()).To(Equal("broker.id=2\nnum.io.threads=4\nlisteners=INTERNAL://0.0.0.0:9093,EXTERNAL_INGRESS://0.0.0.0:30902\n" +
+				"ssl.keystore.password=secret\nzookeeper.clientCnxnSocket=org.apache.zookeeper.ClientCnxnSocketNetty\nlog.dirs=/var/lib/kafka/data\n"))
+			Expect(rendered.Origins["num.io.threads"]).To(Equal("broker overrides"))
+			Expect(rendered.Origins["zookeeper.clientCnxnSocket"]).To(Equal("environment"))
+			Expect(rendered.String()).To(ContainSubstring("ssl.keystore.password=[hidden] ("))
+		})
+		It("reports every unknown, invalid and conflicting setting", func() {
+			writeFile(renderer.BasePath, "broker.id=two\nlog.retension.hours=24\nlog.dir=/tmp/kafka-logs\nmetrics.reporter.endpoint=localhost\n")
+			renderer.Environ = []string{"KAFKA_CFG_LOG_DIRS=/data"}
+			writeFile(filepath.Join(dir, "log-dirs.properties"), "log.dirs=/var/lib/kafka/data\n")
+			renderer.AllowedPrefixes = []string{"metrics.reporter."}
+
+			_, err := renderer.Render()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("broker.id=two is not a valid int"))
+			Expect(err.Error()).To(ContainSubstring("unknown config log.retension.hours for kafka 2.5.0, did you mean log.retention.hours?"))
+			Expect(err.Error()).To(ContainSubstring("log.dirs=/data from environment conflicts with log.dirs=/var/lib/kafka/data managed by kafka-utils"))
+			Expect(err.Error()).To(ContainSubstring("log.dir from " + renderer.BasePath + " is ignored by the broker because log.dirs is set"))
+			Expect(err.Error()).NotTo(ContainSubstring("metrics.reporter"))
+		})
+		It("extends the listeners of the base config with the ones of the KRaft node", func() {
+			writeFile(renderer.BasePath, "listeners=INTERNAL://0.0.0.0:9093\nlistener.security.protocol.map=INTERNAL:SSL\n"+
+				"inter.broker.listener.name=INTERNAL\nlog.dirs=/tmp/kafka-logs\nssl.client.auth=required\n")
+			writeFile(filepath.Join(dir, "log-dirs.properties"), "log.dirs=/var/lib/kafka/data\n")
+			writeFile(filepath.Join(dir, "kraft.properties"), "process.roles=broker,controller\nnode.id=2\n"+
+				"controller.quorum.voters=0@kafka-kafka-0.kafka-svc.default.svc.cluster.local:9097\ncontroller.listener.names=CONTROLLER\n"+
+				"log.dirs=/var/lib/kafka/data\nlisteners=INTERNAL://0.0.0.0:9093,CONTROLLER://0.0.0.0:9097\n"+
+				"listener.security.protocol.map=INTERNAL:SSL,CONTROLLER:PLAINTEXT\n")
+			writeFile(filepath.Join(dir, "external.listeners"), "EXTERNAL_INGRESS://0.0.0.0:30902\n")
+			var err error
+			renderer.Catalog, err = NewCatalog("3.5.1")
+			Expect(err).To(BeNil())
+
+			rendered, err := renderer.Render()
+			Expect(err).To(BeNil())
+			listeners, _ := rendered.Properties.Get("listeners")
+			Expect(listeners).To(Equal("INTERNAL://0.0.0.0:9093,CONTROLLER://0.0.0.0:9097,EXTERNAL_INGRESS://0.0.0.0:30902"))
+			protocolMap, _ := rendered.Properties.Get("listener.security.protocol.map")
+			Expect(protocolMap).To(Equal("INTERNAL:SSL,CONTROLLER:PLAINTEXT"))
+			logDirs, _ := rendered.Properties.Get("log.dirs")
+			Expect(logDirs).To(Equal("/var/lib/kafka/data"))
+			Expect(rendered.Origins["listener.security.protocol.map"]).To(Equal(renderer.BasePath + " and " + filepath.Join(dir, "kraft.properties")))
+		})
+		It("drops the keys removed by the fragments", func() {
+			writeFile(renderer.BasePath, "broker.id=2\nzookeeper.connect=zk-0:2181\nnum.io.threads=8\n")
+			writeFile(filepath.Join(dir, "kraft.properties"), "process.roles=broker\nnode.id=2\n")
+			renderer.Removed = []string{"broker.id", "zookeeper.connect"}
+			var err error
+			renderer.Catalog, err = NewCatalog("3.5.1")
+			Expect(err).To(BeNil())
+
+			rendered, err := renderer.Render()
+			Expect(err).To(BeNil())
+			Expect(rendered.Properties.Keys()).To(Equal([]string{"num.io.threads", "process.roles", "node.id"}))
+		})
+		It("checks the configs against the kafka version", func() {
+			writeFile(renderer.BasePath, "process.roles=broker\nport=9092\n")
+			_, err := renderer.Render()
+			Expect(err).To(MatchError(ContainSubstring("process.roles requires kafka 2.8.0 or later")))
+
+			renderer.Catalog, err = NewCatalog("3.5.1")
+			Expect(err).To(BeNil())
+			_, err = renderer.Render()
+			Expect(err).To(MatchError(ContainSubstring("port was removed in kafka 3.0.0")))
+			Expect(err).NotTo(MatchError(ContainSubstring("process.roles")))
+
+			_, err = NewCatalog("2.4.1")
+			Expect(err).To(HaveOccurred())
+		})
+		It("checks the per listener configs", func() {
+			writeFile(renderer.BasePath, "listener.name.internal.ssl.keystore.location=/ks\nlistener.name.external.plain.sasl.jaas.config=secret\nlistener.name.internal.ssl.keystore.typo=x\n")
+			_, err := renderer.Render()
+			Expect(err).To(MatchError(ContainSubstring("unknown config listener.name.internal.ssl.keystore.typo")))
+			Expect(err).NotTo(MatchError(ContainSubstring("sasl.jaas.config")))
+		})
+	})
+
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockEnv = mocks.NewMockEnvironment(mockCtrl)
+		mockEnv.EXPECT().GetHostName().Return("kafka-kafka-2").AnyTimes()
+
+		var err error
+		dir, err = ioutil.TempDir("/tmp", "kafka-config-test")
+		Expect(err).To(BeNil())
+		Expect(os.Mkdir(filepath.Join(dir, "overrides"), 0755)).To(BeNil())
+		overrides = &Overrides{
+			Env: mockEnv,
+			Dir: filepath.Join(dir, "overrides"),
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+		os.RemoveAll(dir)
+	})
+})
+
+func TestConfig(t *testing.T) {
+	RegisterFailHandler(Fail)
+	junitReporter := reporters.NewJUnitReporter(fmt.Sprintf("%s-junit.xml", "kafka-utils-config"))
+	RunSpecsWithDefaultAndCustomReporters(t, "KafkaUtils Config Suite", []Reporter{junitReporter})
+}
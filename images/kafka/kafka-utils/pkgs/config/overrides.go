@@ -0,0 +1,110 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/service"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	SERVER_PROPERTIES_FILE         = "server.properties"
+	BROKER_OVERRIDES_PATH_ENV      = "BROKER_OVERRIDES_PATH"
+	DEFAULT_BROKER_OVERRIDES_PATH  = "/broker-overrides"
+	BROKER_OVERRIDES_FILE_TEMPLATE = "broker-%d.properties"
+	OVERRIDES_BLOCK                = "broker overrides"
+)
+
+// Override is the change a broker override made to a property
+type Override struct {
+	Key      string
+	Previous string
+	Value    string
+	// Added is set when the property was not in the config before
+	Added bool
+}
+
+func (o Override) String() string {
+	if o.Added {
+		return fmt.Sprintf("%s=%s (added)", o.Key, o.Value)
+	}
+	if o.Previous == o.Value {
+		return fmt.Sprintf("%s=%s (unchanged)", o.Key, o.Value)
+	}
+	return fmt.Sprintf("%s=%s (was %s)", o.Key, o.Value, o.Previous)
+}
+
+// Overrides holds the properties of individual brokers, in a broker-<ordinal>.properties file per broker
+type Overrides struct {
+	Env service.Environment
+	Dir string
+}
+
+// OverridesDirFromEnv returns the directory of the broker overrides
+func OverridesDirFromEnv() string {
+	if dir := os.Getenv(BROKER_OVERRIDES_PATH_ENV); len(dir) > 0 {
+		return dir
+	}
+	return DEFAULT_BROKER_OVERRIDES_PATH
+}
+
+// Load returns the overrides of the broker, nil when it has none
+func (o *Overrides) Load() (*Properties, error) {
+	brokerID, err := service.GetOrdinal(o.Env.GetHostName())
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(o.Dir, fmt.Sprintf(BROKER_OVERRIDES_FILE_TEMPLATE, brokerID))
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		log.Infof("no override found for broker %d in %s", brokerID, o.Dir)
+		return nil, nil
+	}
+	return ReadProperties(path)
+}
+
+func (o *Overrides) merge(properties, overrides *Properties) []Override {
+	var merged []Override
+	for _, key := range overrides.Keys() {
+		value, _ := overrides.Get(key)
+		previous, exists := properties.Get(key)
+		override := Override{Key: key, Previous: previous, Value: value, Added: !exists}
+		properties.Set(key, value)
+		log.Infof("broker %s override: %s", o.Env.GetHostName(), override)
+		merged = append(merged, override)
+	}
+	return merged
+}
+
+// MergeIntoPath applies the overrides of the broker on the properties file at path, they are appended in a block
+// which replaces the one of a previous run and the rest of the file is kept as is. The block is dropped once the
+// broker has no override anymore.
+func (o *Overrides) MergeIntoPath(path string) ([]Override, error) {
+	overrides, err := o.Load()
+	if err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %v", path, err)
+	}
+	if overrides == nil {
+		if !bytes.Equal(withoutBlock(content, OVERRIDES_BLOCK), content) {
+			return nil, NewProperties().MergeIntoPath(path, OVERRIDES_BLOCK)
+		}
+		return nil, nil
+	}
+	properties, err := ParseProperties(bytes.NewReader(withoutBlock(content, OVERRIDES_BLOCK)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid properties file %s: %v", path, err)
+	}
+	merged := o.merge(properties, overrides)
+	if err := overrides.MergeIntoPath(path, OVERRIDES_BLOCK); err != nil {
+		return nil, err
+	}
+	log.Infof("merged %d overrides into %s", len(merged), path)
+	return merged, nil
+}
@@ -0,0 +1,419 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	PROPERTIES_WHITESPACE = " \t\f"
+	BLOCK_BEGIN_TEMPLATE  = "# BEGIN kafka-utils %s"
+	BLOCK_END_TEMPLATE    = "# END kafka-utils %s"
+	// REMOVED_TEMPLATE prefixes the lines of the properties a block removed from the file
+	REMOVED_TEMPLATE = "#kafka-utils %s removed# "
+)
+
+// Properties is a set of broker properties which keeps the order they were read in
+type Properties struct {
+	keys   []string
+	values map[string]string
+}
+
+// NewProperties returns an empty set of properties
+func NewProperties() *Properties {
+	return &Properties{values: map[string]string{}}
+}
+
+// ReadProperties reads a properties file, comments and blank lines are dropped
+func ReadProperties(path string) (*Properties, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %v", path, err)
+	}
+	defer file.Close()
+	properties, err := ParseProperties(file)
+	if err != nil {
+		return nil, fmt.Errorf("invalid properties file %s: %v", path, err)
+	}
+	return properties, nil
+}
+
+// ParseProperties reads the java properties format: 'key=value', 'key:value' and 'key value' lines, lines starting
+// with '#' or '!' are comments and a line ending with an odd number of backslashes continues on the next one
+func ParseProperties(r io.Reader) (*Properties, error) {
+	properties := NewProperties()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var logical strings.Builder
+	line, start, continued := 0, 0, false
+	for scanner.Scan() {
+		line++
+		text := strings.TrimLeft(scanner.Text(), PROPERTIES_WHITESPACE)
+		if !continued {
+			if len(text) == 0 || text[0] == '#' || text[0] == '!' {
+				continue
+			}
+			start = line
+		}
+		if continued = continues(text); continued {
+			logical.WriteString(text[:len(text)-1])
+			continue
+		}
+		logical.WriteString(text)
+		if err := properties.parseProperty(logical.String(), start); err != nil {
+			return nil, err
+		}
+		logical.Reset()
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if continued {
+		if err := properties.parseProperty(logical.String(), start); err != nil {
+			return nil, err
+		}
+	}
+	return properties, nil
+}
+
+// parseProperty sets the property of a logical line
+func (p *Properties) parseProperty(text string, line int) error {
+	end := keyEnd(text)
+	value := strings.TrimLeft(text[end:], PROPERTIES_WHITESPACE)
+	if len(value) > 0 && (value[0] == '=' || value[0] == ':') {
+		value = strings.TrimLeft(value[1:], PROPERTIES_WHITESPACE)
+	}
+	if end == 0 || end == len(text) {
+		return fmt.Errorf("line %d is not a 'key=value' property: %s", line, text)
+	}
+	key, err := unescape(text[:end])
+	if err != nil {
+		return fmt.Errorf("line %d: %v", line, err)
+	}
+	if value, err = unescape(value); err != nil {
+		return fmt.Errorf("line %d: %v", line, err)
+	}
+	p.Set(key, value)
+	return nil
+}
+
+// keyEnd returns the end of the key of a logical line, at the first unescaped separator or whitespace
+func keyEnd(text string) int {
+	end, escaped := 0, false
+	for ; end < len(text); end++ {
+		if escaped {
+			escaped = false
+			continue
+		}
+		if text[end] == '\\' {
+			escaped = true
+		} else if strings.IndexByte("=:"+PROPERTIES_WHITESPACE, text[end]) >= 0 {
+			break
+		}
+	}
+	return end
+}
+
+// continues tells whether a line ends with an unescaped backslash
+func continues(line string) bool {
+	backslashes := 0
+	for i := len(line) - 1; i >= 0 && line[i] == '\\'; i-- {
+		backslashes++
+	}
+	return backslashes%2 == 1
+}
+
+// unescape decodes the \t, \n, \r, \f and \uXXXX escapes, any other escaped character stands for itself
+func unescape(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'f':
+			b.WriteByte('\f')
+		case 'u':
+			r, err := decodeUnicode(s[i+1:])
+			if err != nil {
+				return "", err
+			}
+			i += 4
+			// the characters out of the basic plane are escaped as a surrogate pair
+			if utf16.IsSurrogate(r) && strings.HasPrefix(s[i+1:], `\u`) {
+				if low, err := decodeUnicode(s[i+3:]); err == nil {
+					if pair := utf16.DecodeRune(r, low); pair != unicode.ReplacementChar {
+						r = pair
+						i += 6
+					}
+				}
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), nil
+}
+
+func decodeUnicode(s string) (rune, error) {
+	if len(s) < 4 {
+		return 0, fmt.Errorf("malformed \\uxxxx escape: \\u%s", s)
+	}
+	code, err := strconv.ParseUint(s[:4], 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("malformed \\uxxxx escape: \\u%s", s[:4])
+	}
+	return rune(code), nil
+}
+
+// escape escapes a key or a value so that ParseProperties, and java, read it back as is
+func escape(s string, key bool) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch {
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\f':
+			b.WriteString(`\f`)
+		case r == ' ' && (key || i == 0), key && strings.ContainsRune("=:#!", r):
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20 || r > 0x7e:
+			// java reads properties files as ISO-8859-1
+			for _, unit := range utf16.Encode([]rune{r}) {
+				fmt.Fprintf(&b, `\u%04x`, unit)
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Get returns the value of a property
+func (p *Properties) Get(key string) (string, bool) {
+	value, ok := p.values[key]
+	return value, ok
+}
+
+// Set sets a property, a new property is added after the existing ones
+func (p *Properties) Set(key, value string) {
+	if _, ok := p.values[key]; !ok {
+		p.keys = append(p.keys, key)
+	}
+	p.values[key] = value
+}
+
+// Remove removes a property
+func (p *Properties) Remove(key string) {
+	if _, ok := p.values[key]; !ok {
+		return
+	}
+	delete(p.values, key)
+	for i, existing := range p.keys {
+		if existing == key {
+			p.keys = append(p.keys[:i], p.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// Keys returns the keys of the properties in order
+func (p *Properties) Keys() []string {
+	return append([]string{}, p.keys...)
+}
+
+// Len returns the number of properties
+func (p *Properties) Len() int {
+	return len(p.keys)
+}
+
+// WriteTo writes the properties as 'key=value' lines, escaped as in java properties files
+func (p *Properties) WriteTo(w io.Writer) (int64, error) {
+	writer := bufio.NewWriter(w)
+	var written int64
+	for _, key := range p.keys {
+		n, err := fmt.Fprintf(writer, "%s=%s\n", escape(key, true), escape(p.values[key], false))
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, writer.Flush()
+}
+
+// WriteToPath replaces the file at path with the properties
+func (p *Properties) WriteToPath(path string) error {
+	var b bytes.Buffer
+	if _, err := p.WriteTo(&b); err != nil {
+		return err
+	}
+	return replaceFile(path, b.Bytes())
+}
+
+// MergeIntoPath writes the properties at the end of the properties file at path, in a block delimited by comments
+// naming it. The block replaces the one of the same name written by a previous run, the rest of the file is kept as is.
+// The properties of the block win over the ones set before it, the last value of a key is the one java reads.
+// The removed keys are commented out of the rest of the file, a later merge of the block restores the ones it no
+// longer removes.
+func (p *Properties) MergeIntoPath(path, name string, removed ...string) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not read %s: %v", path, err)
+	}
+	content = withoutKeys(withoutBlock(content, name), name, removed)
+	// the broker overrides stay last so they win over every other block
+	var overrides []byte
+	if name != OVERRIDES_BLOCK {
+		begin := []byte(fmt.Sprintf(BLOCK_BEGIN_TEMPLATE+"\n", OVERRIDES_BLOCK))
+		if i := bytes.Index(content, begin); i >= 0 && (i == 0 || content[i-1] == '\n') {
+			content, overrides = content[:i], append([]byte{}, content[i:]...)
+		}
+	}
+	b := bytes.NewBuffer(content)
+	if b.Len() > 0 && !bytes.HasSuffix(b.Bytes(), []byte("\n")) {
+		b.WriteString("\n")
+	}
+	if p.Len() > 0 {
+		fmt.Fprintf(b, BLOCK_BEGIN_TEMPLATE+"\n", name)
+		if _, err := p.WriteTo(b); err != nil {
+			return err
+		}
+		fmt.Fprintf(b, BLOCK_END_TEMPLATE+"\n", name)
+	}
+	b.Write(overrides)
+	if err := replaceFile(path, b.Bytes()); err != nil {
+		return err
+	}
+	if len(removed) > 0 {
+		log.Infof("merged %d properties of %s into %s without %s", p.Len(), name, path, strings.Join(removed, ","))
+	} else {
+		log.Infof("merged %d properties of %s into %s", p.Len(), name, path)
+	}
+	return nil
+}
+
+// withoutBlock returns content without the lines of the block named name, the properties it removed are restored
+func withoutBlock(content []byte, name string) []byte {
+	begin, end := fmt.Sprintf(BLOCK_BEGIN_TEMPLATE, name), fmt.Sprintf(BLOCK_END_TEMPLATE, name)
+	removedPrefix := fmt.Sprintf(REMOVED_TEMPLATE, name)
+	var b bytes.Buffer
+	inBlock := false
+	for _, line := range strings.SplitAfter(string(content), "\n") {
+		switch strings.TrimSpace(line) {
+		case begin:
+			inBlock = true
+		case end:
+			inBlock = false
+		default:
+			if !inBlock {
+				b.WriteString(strings.TrimPrefix(line, removedPrefix))
+			}
+		}
+	}
+	return b.Bytes()
+}
+
+// withoutKeys comments out the properties of content with one of keys, every line of a continued property is prefixed
+// with the REMOVED_TEMPLATE of the block named name
+func withoutKeys(content []byte, name string, keys []string) []byte {
+	if len(keys) == 0 {
+		return content
+	}
+	removed := map[string]bool{}
+	for _, key := range keys {
+		removed[key] = true
+	}
+	prefix := fmt.Sprintf(REMOVED_TEMPLATE, name)
+	var b bytes.Buffer
+	removing, continued := false, false
+	for _, line := range strings.SplitAfter(string(content), "\n") {
+		if len(line) == 0 {
+			continue
+		}
+		text := strings.TrimLeft(strings.TrimRight(line, "\r\n"), PROPERTIES_WHITESPACE)
+		if !continued {
+			if len(text) == 0 || text[0] == '#' || text[0] == '!' {
+				b.WriteString(line)
+				continue
+			}
+			key, err := unescape(text[:keyEnd(text)])
+			removing = err == nil && removed[key]
+		}
+		continued = continues(text)
+		if removing {
+			b.WriteString(prefix)
+		}
+		b.WriteString(line)
+	}
+	return b.Bytes()
+}
+
+// ReadPropertiesWithoutBlock reads the properties file at path as it was before the block named name was merged into it,
+// the properties are empty when the file does not exist
+func ReadPropertiesWithoutBlock(path, name string) (*Properties, error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return NewProperties(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %v", path, err)
+	}
+	properties, err := ParseProperties(bytes.NewReader(withoutBlock(content, name)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid properties file %s: %v", path, err)
+	}
+	return properties, nil
+}
+
+// MergeFileIntoPath merges the properties file fragmentPath into the properties file at path, in a block named after it.
+// The removed keys are commented out of the rest of the file.
+func MergeFileIntoPath(fragmentPath, path string, removed ...string) error {
+	properties, err := ReadProperties(fragmentPath)
+	if err != nil {
+		return err
+	}
+	return properties.MergeIntoPath(path, filepath.Base(fragmentPath), removed...)
+}
+
+// replaceFile replaces the file at path at once, so the broker never reads a partial config
+func replaceFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("could not write %s: %v", tmp, err)
+	}
+	return os.Rename(tmp, path)
+}
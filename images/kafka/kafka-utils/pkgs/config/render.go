@@ -0,0 +1,334 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DEFAULT_BASE_CONFIG_PATH = "/config/" + SERVER_PROPERTIES_FILE
+	ENV_CONFIG_PREFIX        = "KAFKA_CFG_"
+	HIDDEN_VALUE             = "[hidden]"
+)
+
+// externalFragments maps the external listener fragments written by kafka-utils to the list property they extend
+var externalFragments = [][2]string{
+	{"external.listeners", "listeners"},
+	{"external.advertised.listeners", "advertised.listeners"},
+	{"external.listener.security.protocol.map", "listener.security.protocol.map"},
+}
+
+// listConfigs are the managed properties listing the listeners, a fragment extends their value instead of replacing it
+var listConfigs = map[string]bool{
+	"listeners":                      true,
+	"advertised.listeners":           true,
+	"listener.security.protocol.map": true,
+}
+
+// shadowedConfigs maps deprecated configs to the configs which silently replace them when both are set
+var shadowedConfigs = map[string]string{
+	"log.dir":              "log.dirs",
+	"port":                 "listeners",
+	"host.name":            "listeners",
+	"advertised.port":      "advertised.listeners",
+	"advertised.host.name": "advertised.listeners",
+}
+
+// Renderer assembles server.properties from its sources, in increasing precedence:
+// the base config mounted from the ConfigMap, the KAFKA_CFG_ environment variables and the broker overrides.
+// The fragments written by kafka-utils are managed and win over the base config, the environment or the broker overrides
+// setting one of their keys to another value is a conflict. The listener lists are extended by the fragments instead.
+type Renderer struct {
+	Catalog   *Catalog
+	BasePath  string
+	Environ   []string
+	Overrides *Overrides
+	// Fragments are the properties files written by kafka-utils, the missing ones are skipped
+	Fragments []string
+	// Removed are the keys the fragments remove from the config, e.g. the ZooKeeper configs of a broker in KRaft mode
+	Removed []string
+	// ExternalDir holds the external listener fragments of the ingress
+	ExternalDir string
+	// AllowedPrefixes are the prefixes of keys which are not checked against the catalog, e.g. of a metrics reporter
+	AllowedPrefixes []string
+}
+
+// Rendered is the merged broker config
+type Rendered struct {
+	Properties *Properties
+	// Origins maps every key to the source its value comes from
+	Origins map[string]string
+
+	catalog *Catalog
+}
+
+// Render merges the sources and validates the result, every invalid or conflicting setting is reported at once
+func (r *Renderer) Render() (*Rendered, error) {
+	rendered := &Rendered{Properties: NewProperties(), Origins: map[string]string{}, catalog: r.Catalog}
+	var problems []string
+
+	base, err := ReadProperties(r.BasePath)
+	if err != nil {
+		return nil, err
+	}
+	rendered.merge(base, r.BasePath)
+
+	environment, envProblems := r.environmentProperties()
+	problems = append(problems, envProblems...)
+	rendered.merge(environment, "environment")
+
+	if r.Overrides != nil {
+		overrides, err := r.Overrides.Load()
+		if err != nil {
+			return nil, err
+		}
+		if overrides != nil {
+			rendered.merge(overrides, "broker overrides")
+		}
+	}
+
+	for _, path := range r.Fragments {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+		fragment, err := ReadProperties(path)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, rendered.mergeManaged(fragment, path, r.BasePath)...)
+	}
+	for _, key := range r.Removed {
+		rendered.Properties.Remove(key)
+		delete(rendered.Origins, key)
+	}
+	if len(r.ExternalDir) > 0 {
+		if err := rendered.appendExternal(r.ExternalDir); err != nil {
+			return nil, err
+		}
+	}
+
+	problems = append(problems, rendered.validate(r.AllowedPrefixes)...)
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid broker config:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+	return rendered, nil
+}
+
+// environmentProperties maps the KAFKA_CFG_ variables to properties, KAFKA_CFG_LOG_RETENTION_HOURS is log.retention.hours.
+// A double underscore stands for an underscore and the keys are matched with the catalog regardless of their case.
+func (r *Renderer) environmentProperties() (*Properties, []string) {
+	properties := NewProperties()
+	var problems []string
+	variables := map[string]string{}
+	environ := append([]string{}, r.Environ...)
+	sort.Strings(environ)
+	for _, variable := range environ {
+		parts := strings.SplitN(variable, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], ENV_CONFIG_PREFIX) {
+			continue
+		}
+		name := strings.TrimPrefix(parts[0], ENV_CONFIG_PREFIX)
+		key := strings.ToLower(strings.Replace(strings.Replace(strings.Replace(name, "__", "\x00", -1), "_", ".", -1), "\x00", "_", -1))
+		for known := range brokerConfigs {
+			if strings.EqualFold(known, key) {
+				key = known
+				break
+			}
+		}
+		if previous, ok := variables[key]; ok {
+			if value, _ := properties.Get(key); value != parts[1] {
+				problems = append(problems, fmt.Sprintf("%s and %s both set %s to different values", previous, parts[0], key))
+			}
+			continue
+		}
+		variables[key] = parts[0]
+		properties.Set(key, parts[1])
+	}
+	return properties, problems
+}
+
+func (r *Rendered) merge(properties *Properties, source string) {
+	for _, key := range properties.Keys() {
+		value, _ := properties.Get(key)
+		if previous, ok := r.Properties.Get(key); ok && previous != value {
+			log.Infof("%s from %s overrides the value of %s", r.display(key, value), source, r.Origins[key])
+		}
+		r.Properties.Set(key, value)
+		r.Origins[key] = source
+	}
+}
+
+// mergeManaged merges a fragment written by kafka-utils, only the scalar keys set explicitly by the environment or the
+// broker overrides can conflict with it
+func (r *Rendered) mergeManaged(fragment *Properties, path, basePath string) []string {
+	var problems []string
+	for _, key := range fragment.Keys() {
+		value, _ := fragment.Get(key)
+		previous, ok := r.Properties.Get(key)
+		switch {
+		case ok && listConfigs[key]:
+			if extended := extendList(previous, value); extended != previous {
+				r.Properties.Set(key, extended)
+				r.Origins[key] = fmt.Sprintf("%s and %s", r.Origins[key], path)
+			}
+			continue
+		case ok && previous != value && r.Origins[key] != basePath:
+			problems = append(problems, fmt.Sprintf("%s from %s conflicts with %s managed by kafka-utils in %s",
+				r.display(key, previous), r.Origins[key], r.display(key, value), path))
+			continue
+		case ok && previous != value:
+			log.Infof("%s managed by kafka-utils in %s overrides the value of %s", r.display(key, value), path, r.Origins[key])
+		}
+		r.Properties.Set(key, value)
+		r.Origins[key] = path
+	}
+	return problems
+}
+
+// extendList adds the entries of extension to a list of listeners or of listener protocols,
+// an entry of extension replaces the entry of list for the same listener name
+func extendList(list, extension string) string {
+	var entries []string
+	if len(list) > 0 {
+		entries = strings.Split(list, ",")
+	}
+	for _, entry := range strings.Split(extension, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+		name := strings.SplitN(entry, ":", 2)[0]
+		replaced := false
+		for i, existing := range entries {
+			if strings.SplitN(strings.TrimSpace(existing), ":", 2)[0] == name {
+				entries[i], replaced = entry, true
+			}
+		}
+		if !replaced {
+			entries = append(entries, entry)
+		}
+	}
+	return strings.Join(entries, ",")
+}
+
+// appendExternal extends the listener properties with the external listener of the ingress, when the broker is exposed
+func (r *Rendered) appendExternal(dir string) error {
+	for _, fragment := range externalFragments {
+		path := filepath.Join(dir, fragment[0])
+		content, err := readFragment(path)
+		if err != nil {
+			return err
+		}
+		if len(content) == 0 {
+			continue
+		}
+		value, _ := r.Properties.Get(fragment[1])
+		var items []string
+		if len(value) > 0 {
+			items = strings.Split(value, ",")
+		}
+		if contains(items, content) {
+			continue
+		}
+		r.Properties.Set(fragment[1], strings.Join(append(items, content), ","))
+		if origin, ok := r.Origins[fragment[1]]; ok {
+			r.Origins[fragment[1]] = fmt.Sprintf("%s and %s", origin, path)
+		} else {
+			r.Origins[fragment[1]] = path
+		}
+	}
+	return nil
+}
+
+func (r *Rendered) validate(allowedPrefixes []string) []string {
+	var problems []string
+	for _, key := range r.Properties.Keys() {
+		if hasPrefix(key, allowedPrefixes) {
+			continue
+		}
+		value, _ := r.Properties.Get(key)
+		def, err := r.catalog.Lookup(key)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%v (set by %s)", err, r.Origins[key]))
+			continue
+		}
+		if err := def.Check(key, value); err != nil {
+			problems = append(problems, fmt.Sprintf("%v (set by %s)", err, r.Origins[key]))
+		}
+	}
+	var shadowed []string
+	for key := range shadowedConfigs {
+		shadowed = append(shadowed, key)
+	}
+	sort.Strings(shadowed)
+	for _, key := range shadowed {
+		replacement := shadowedConfigs[key]
+		if _, ok := r.Properties.Get(key); !ok {
+			continue
+		}
+		if _, ok := r.Properties.Get(replacement); ok {
+			problems = append(problems, fmt.Sprintf("%s from %s is ignored by the broker because %s is set by %s",
+				key, r.Origins[key], replacement, r.Origins[replacement]))
+		}
+	}
+	brokerID, hasBrokerID := r.Properties.Get("broker.id")
+	nodeID, hasNodeID := r.Properties.Get("node.id")
+	if hasBrokerID && hasNodeID && brokerID != nodeID {
+		problems = append(problems, fmt.Sprintf("broker.id=%s from %s conflicts with node.id=%s from %s",
+			brokerID, r.Origins["broker.id"], nodeID, r.Origins["node.id"]))
+	}
+	return problems
+}
+
+// display returns key=value, with the value hidden for passwords
+func (r *Rendered) display(key, value string) string {
+	if def, err := r.catalog.Lookup(key); err == nil && def.Type == TYPE_PASSWORD {
+		value = HIDDEN_VALUE
+	}
+	return fmt.Sprintf("%s=%s", key, value)
+}
+
+// String lists the merged config with the source of every value, the passwords are hidden
+func (r *Rendered) String() string {
+	var b strings.Builder
+	for _, key := range r.Properties.Keys() {
+		value, _ := r.Properties.Get(key)
+		fmt.Fprintf(&b, "%s (%s)\n", r.display(key, value), r.Origins[key])
+	}
+	return b.String()
+}
+
+func readFragment(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not read %s: %v", path, err)
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+func hasPrefix(key string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,200 @@
+package csr
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/avast/retry-go"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/keystore"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/service"
+	log "github.com/sirupsen/logrus"
+	certificates "k8s.io/api/certificates/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	DEFAULT_CLUSTER_DOMAIN = "cluster.local"
+	SERVICE_ACCOUNT_CA     = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+	// DEFAULT_CERT_DIR is a writable emptyDir, unlike the read-only mount of a TLS secret
+	DEFAULT_CERT_DIR = "/home/kafka/certs"
+)
+
+// DeniedError is returned when the certificate signing request has been denied
+type DeniedError struct {
+	Name    string
+	Reason  string
+	Message string
+}
+
+func (e *DeniedError) Error() string {
+	return fmt.Sprintf("certificate signing request %s denied: %s %s", e.Name, e.Reason, e.Message)
+}
+
+// CSRService issues the broker certificate through the kubernetes CertificateSigningRequest API
+type CSRService struct {
+	Client kubernetes.Interface
+	Env    service.Environment
+	// HeadlessService is the name of the service governing the broker StatefulSet
+	HeadlessService string
+	ClusterDomain   string
+	// ExternalDNSPath is the file written by the bootstrap with the external address of the broker
+	ExternalDNSPath string
+	// CAPath is copied next to the signed certificate so the truststore trusts the CSR signer
+	CAPath       string
+	PollInterval time.Duration
+	Timeout      time.Duration
+}
+
+// SubjectAlternativeNames returns the internal names of the broker and the external address discovered by the bootstrap
+func (c *CSRService) SubjectAlternativeNames() ([]string, []net.IP) {
+	hostname := c.Env.GetHostName()
+	namespace := c.Env.GetNamespace()
+	dnsNames := []string{
+		fmt.Sprintf("%s.%s.%s.svc.%s", hostname, c.HeadlessService, namespace, c.ClusterDomain),
+		fmt.Sprintf("%s.%s.%s.svc", hostname, c.HeadlessService, namespace),
+		fmt.Sprintf("%s.%s", hostname, c.HeadlessService),
+		hostname,
+	}
+	var ipAddresses []net.IP
+
+	externalDNS, err := ioutil.ReadFile(c.ExternalDNSPath)
+	if err != nil && !os.IsNotExist(err) {
+		log.Errorf("could not read the external DNS from %s: %v", c.ExternalDNSPath, err)
+	}
+	// the bootstrap writes an address per line, one per ingress of the external service
+	seen := map[string]bool{}
+	for _, address := range strings.Fields(string(externalDNS)) {
+		if seen[address] {
+			continue
+		}
+		seen[address] = true
+		if ip := net.ParseIP(address); ip != nil {
+			ipAddresses = append(ipAddresses, ip)
+		} else {
+			dnsNames = append(dnsNames, address)
+		}
+	}
+	return dnsNames, ipAddresses
+}
+
+func (c *CSRService) requestName() string {
+	return fmt.Sprintf("%s-%s", c.Env.GetNamespace(), c.Env.GetHostName())
+}
+
+// RequestCertificate generates a key, waits for the signed certificate and writes them as tls.key and tls.crt into dir,
+// which must be writable: DEFAULT_CERT_DIR rather than the mount of a TLS secret
+func (c *CSRService) RequestCertificate(dir string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("could not generate the private key: %v", err)
+	}
+	dnsNames, ipAddresses := c.SubjectAlternativeNames()
+	request, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{
+			CommonName: dnsNames[0],
+		},
+		DNSNames:    dnsNames,
+		IPAddresses: ipAddresses,
+	}, key)
+	if err != nil {
+		return fmt.Errorf("could not create the certificate request: %v", err)
+	}
+
+	name := c.requestName()
+	csrs := c.Client.CertificatesV1beta1().CertificateSigningRequests()
+	// a previous request can't be reused as its key is lost
+	if err := csrs.Delete(name, &metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("could not delete the previous certificate signing request %s: %v", name, err)
+	}
+	_, err = csrs.Create(&certificates.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Spec: certificates.CertificateSigningRequestSpec{
+			Request: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: request}),
+			Usages: []certificates.KeyUsage{
+				certificates.UsageDigitalSignature,
+				certificates.UsageKeyEncipherment,
+				certificates.UsageServerAuth,
+				certificates.UsageClientAuth,
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("could not create the certificate signing request %s: %v", name, err)
+	}
+	log.Infof("created the certificate signing request %s for %s", name, strings.Join(dnsNames, ","))
+
+	certPEM, err := c.waitForCertificate(name)
+	if err != nil {
+		return err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	// a watcher seeing the new key before the new certificate fails to build the stores and retries
+	if err := keystore.WriteFileAtomically(filepath.Join(dir, keystore.TLS_KEY_FILE), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	if err := keystore.WriteFileAtomically(filepath.Join(dir, keystore.TLS_CERT_FILE), certPEM, 0644); err != nil {
+		return err
+	}
+	if caPEM, err := ioutil.ReadFile(c.CAPath); err == nil {
+		if err := keystore.WriteFileAtomically(filepath.Join(dir, keystore.CA_CERT_FILE), caPEM, 0644); err != nil {
+			return err
+		}
+	} else {
+		log.Infof("no CA found in %s: %v", c.CAPath, err)
+	}
+	log.Infof("wrote the signed certificate into %s", dir)
+	return nil
+}
+
+func (c *CSRService) waitForCertificate(name string) ([]byte, error) {
+	var certPEM []byte
+	err := retry.Do(func() error {
+		csr, err := c.Client.CertificatesV1beta1().CertificateSigningRequests().Get(name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		for _, condition := range csr.Status.Conditions {
+			if condition.Type == certificates.CertificateDenied {
+				return &DeniedError{Name: name, Reason: condition.Reason, Message: condition.Message}
+			}
+		}
+		if len(csr.Status.Certificate) == 0 {
+			log.Infof("the certificate signing request %s is still pending...", name)
+			return fmt.Errorf("certificate signing request %s is pending", name)
+		}
+		certPEM = csr.Status.Certificate
+		return nil
+	},
+		retry.Attempts(uint(c.Timeout/c.PollInterval)+1),
+		retry.Delay(c.PollInterval),
+		retry.DelayType(retry.FixedDelay),
+		retry.LastErrorOnly(true),
+		retry.RetryIf(func(err error) bool {
+			_, denied := err.(*DeniedError)
+			return !denied
+		}),
+	)
+	return certPEM, err
+}
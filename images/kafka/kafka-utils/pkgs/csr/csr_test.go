@@ -0,0 +1,175 @@
+package csr
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/keystore"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/mocks"
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/reporters"
+	. "github.com/onsi/gomega"
+	certificates "k8s.io/api/certificates/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime"
+	testclient "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var _ = Describe("[Kafka CSR]", func() {
+
+	var (
+		mockCtrl   *gomock.Controller
+		mockEnv    *mocks.MockEnvironment
+		dir        string
+		client     *testclient.Clientset
+		csrService *CSRService
+	)
+
+	// signer approves and signs every created request like a kubernetes signer would
+	signer := func(denied bool) k8stesting.ReactionFunc {
+		return func(action k8stesting.Action) (bool, runtime.Object, error) {
+			request := action.(k8stesting.CreateAction).GetObject().(*certificates.CertificateSigningRequest)
+			if denied {
+				request.Status.Conditions = []certificates.CertificateSigningRequestCondition{
+					{Type: certificates.CertificateDenied, Reason: "NotAllowed"},
+				}
+				return false, nil, nil
+			}
+			block, _ := pem.Decode(request.Spec.Request)
+			certRequest, err := x509.ParseCertificateRequest(block.Bytes)
+			Expect(err).To(BeNil())
+			caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			Expect(err).To(BeNil())
+			ca := &x509.Certificate{
+				SerialNumber:          big.NewInt(1),
+				Subject:               pkix.Name{CommonName: "kubernetes"},
+				NotBefore:             time.Now(),
+				NotAfter:              time.Now().Add(time.Hour),
+				IsCA:                  true,
+				BasicConstraintsValid: true,
+			}
+			certDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+				SerialNumber: big.NewInt(2),
+				Subject:      certRequest.Subject,
+				DNSNames:     certRequest.DNSNames,
+				IPAddresses:  certRequest.IPAddresses,
+				NotBefore:    time.Now(),
+				NotAfter:     time.Now().Add(time.Hour),
+			}, ca, certRequest.PublicKey, caKey)
+			Expect(err).To(BeNil())
+			request.Status.Conditions = []certificates.CertificateSigningRequestCondition{
+				{Type: certificates.CertificateApproved},
+			}
+			request.Status.Certificate = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+			return false, nil, nil
+		}
+	}
+
+	Context("Subject alternative names", func() {
+		It("contains the internal names of the broker", func() {
+			dnsNames, ipAddresses := csrService.SubjectAlternativeNames()
+			Expect(dnsNames).To(Equal([]string{
+				"kafka-kafka-0.kafka-svc.default.svc.cluster.local",
+				"kafka-kafka-0.kafka-svc.default.svc",
+				"kafka-kafka-0.kafka-svc",
+				"kafka-kafka-0",
+			}))
+			Expect(ipAddresses).To(BeEmpty())
+		})
+		It("contains the external DNS discovered by the bootstrap", func() {
+			Expect(ioutil.WriteFile(csrService.ExternalDNSPath, []byte("aws.kafka.dns-kafka-kafka-0"), 0644)).To(BeNil())
+			dnsNames, _ := csrService.SubjectAlternativeNames()
+			Expect(dnsNames).To(ContainElement("aws.kafka.dns-kafka-kafka-0"))
+		})
+		It("contains the external IP discovered by the bootstrap", func() {
+			Expect(ioutil.WriteFile(csrService.ExternalDNSPath, []byte("30.0.0.1"), 0644)).To(BeNil())
+			_, ipAddresses := csrService.SubjectAlternativeNames()
+			Expect(ipAddresses).To(HaveLen(1))
+			Expect(ipAddresses[0].String()).To(Equal("30.0.0.1"))
+		})
+		It("contains every address of the external service", func() {
+			Expect(ioutil.WriteFile(csrService.ExternalDNSPath, []byte("aws.kafka.dns-kafka-kafka-0\n30.0.0.1\n30.0.0.2\n30.0.0.1"), 0644)).To(BeNil())
+			dnsNames, ipAddresses := csrService.SubjectAlternativeNames()
+			Expect(dnsNames).To(ContainElement("aws.kafka.dns-kafka-kafka-0"))
+			Expect(ipAddresses).To(HaveLen(2))
+			Expect(ipAddresses[1].String()).To(Equal("30.0.0.2"))
+		})
+	})
+
+	Context("Certificate signing request", func() {
+		It("writes the signed certificate and its key", func() {
+			client.PrependReactor("create", "certificatesigningrequests", signer(false))
+			Expect(ioutil.WriteFile(csrService.ExternalDNSPath, []byte("30.0.0.1"), 0644)).To(BeNil())
+			certDir := filepath.Join(dir, "certs")
+			Expect(csrService.RequestCertificate(certDir)).To(BeNil())
+
+			bundle, err := keystore.LoadCertificateBundle(certDir)
+			Expect(err).To(BeNil())
+			Expect(bundle.Certificate().DNSNames).To(ContainElement("kafka-kafka-0.kafka-svc.default.svc.cluster.local"))
+			Expect(bundle.Certificate().IPAddresses[0].String()).To(Equal("30.0.0.1"))
+			Expect(filepath.Join(certDir, keystore.CA_CERT_FILE)).To(BeAnExistingFile())
+			info, err := os.Stat(filepath.Join(certDir, keystore.TLS_KEY_FILE))
+			Expect(err).To(BeNil())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+			files, err := ioutil.ReadDir(certDir)
+			Expect(err).To(BeNil())
+			Expect(files).To(HaveLen(3))
+		})
+		It("fails when the request is denied", func() {
+			client.PrependReactor("create", "certificatesigningrequests", signer(true))
+			err := csrService.RequestCertificate(filepath.Join(dir, "certs"))
+			Expect(err).To(BeAssignableToTypeOf(&DeniedError{}))
+		})
+		It("times out when the request is never approved", func() {
+			err := csrService.RequestCertificate(filepath.Join(dir, "certs"))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("pending"))
+		})
+	})
+
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockEnv = mocks.NewMockEnvironment(mockCtrl)
+		mockEnv.EXPECT().GetNamespace().Return("default").AnyTimes()
+		mockEnv.EXPECT().GetHostName().Return("kafka-kafka-0").AnyTimes()
+
+		var err error
+		dir, err = ioutil.TempDir("/tmp", "kafka-csr-test")
+		Expect(err).To(BeNil())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "ca.crt"), []byte("ca"), 0644)).To(BeNil())
+		client = testclient.NewSimpleClientset()
+		csrService = &CSRService{
+			Client:          client,
+			Env:             mockEnv,
+			HeadlessService: "kafka-svc",
+			ClusterDomain:   DEFAULT_CLUSTER_DOMAIN,
+			ExternalDNSPath: filepath.Join(dir, "external.dns"),
+			CAPath:          filepath.Join(dir, "ca.crt"),
+			PollInterval:    10 * time.Millisecond,
+			Timeout:         50 * time.Millisecond,
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+		os.RemoveAll(dir)
+	})
+})
+
+func TestCSR(t *testing.T) {
+	RegisterFailHandler(Fail)
+	junitReporter := reporters.NewJUnitReporter(fmt.Sprintf("%s-junit.xml", "kafka-utils-csr"))
+	RunSpecsWithDefaultAndCustomReporters(t, "KafkaUtils CSR Suite", []Reporter{junitReporter})
+}
@@ -0,0 +1,217 @@
+package disk
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/golang/mock/gomock"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/events"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/mocks"
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/reporters"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testclient "k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Describe("[Kafka Disk Usage]", func() {
+
+	var (
+		mockCtrl  *gomock.Controller
+		mockEnv   *mocks.MockEnvironment
+		mockAdmin *mocks.MockClusterAdmin
+		client    *testclient.Clientset
+		usages    map[string]Usage
+		monitor   *Monitor
+		guard     *RetentionGuard
+	)
+
+	reasons := func() []string {
+		eventList, err := client.CoreV1().Events("default").List(metav1.ListOptions{})
+		Expect(err).To(BeNil())
+		var reasons []string
+		for _, event := range eventList.Items {
+			reasons = append(reasons, event.Reason)
+		}
+		return reasons
+	}
+
+	expectLogDirs := func() {
+		mockAdmin.EXPECT().DescribeLogDirs([]int32{1}).Return(map[int32][]sarama.DescribeLogDirsResponseDirMetadata{
+			1: {
+				{Path: "/var/lib/kafka/data", Topics: []sarama.DescribeLogDirsResponseTopic{
+					{Topic: "logs", Partitions: []sarama.DescribeLogDirsResponsePartition{{PartitionID: 0, Size: 300}}},
+					{Topic: "orders", Partitions: []sarama.DescribeLogDirsResponsePartition{{PartitionID: 0, Size: 500}}},
+				}},
+				{Path: "/var/lib/kafka/data-1", Topics: []sarama.DescribeLogDirsResponseTopic{
+					{Topic: "metrics", Partitions: []sarama.DescribeLogDirsResponsePartition{{PartitionID: 0, Size: 200}, {PartitionID: 1, Size: 200}}},
+				}},
+			},
+		}, nil)
+		mockAdmin.EXPECT().Close().Return(nil)
+	}
+
+	expectRetention := func(topic string, entries ...sarama.ConfigEntry) {
+		mockAdmin.EXPECT().DescribeConfig(sarama.ConfigResource{
+			Type:        sarama.TopicResource,
+			Name:        topic,
+			ConfigNames: []string{RETENTION_MS_KEY},
+		}).Return(entries, nil)
+	}
+
+	expectAlter := func(topic string, entry sarama.IncrementalAlterConfigsEntry) {
+		mockAdmin.EXPECT().IncrementalAlterConfig(sarama.TopicResource, topic, map[string]sarama.IncrementalAlterConfigsEntry{
+			RETENTION_MS_KEY: entry,
+		}, false).Return(nil)
+	}
+
+	Context("Usage", func() {
+		It("exports the usage of the log dirs", func() {
+			expectLogDirs()
+			Expect(monitor.Check()).To(Equal(LEVEL_NORMAL))
+			Expect(testutil.ToFloat64(monitor.Capacity.WithLabelValues("/var/lib/kafka/data"))).To(Equal(float64(1000)))
+			Expect(testutil.ToFloat64(monitor.Available.WithLabelValues("/var/lib/kafka/data-1"))).To(Equal(float64(600)))
+			Expect(testutil.ToFloat64(monitor.Replicas.WithLabelValues("/var/lib/kafka/data"))).To(Equal(float64(800)))
+			Expect(testutil.ToFloat64(monitor.Replicas.WithLabelValues("/var/lib/kafka/data-1"))).To(Equal(float64(400)))
+			Expect(reasons()).To(BeEmpty())
+		})
+		It("exports the filesystem usage while the broker is down", func() {
+			monitor.NewAdmin = func() (sarama.ClusterAdmin, error) {
+				return nil, fmt.Errorf("connection refused")
+			}
+			usages["/var/lib/kafka/data"] = Usage{Capacity: 1000, Available: 0}
+			Expect(monitor.Check()).To(Equal(LEVEL_CRITICAL))
+			Expect(testutil.ToFloat64(monitor.Available.WithLabelValues("/var/lib/kafka/data"))).To(Equal(float64(0)))
+		})
+		It("records an event when the usage level changes", func() {
+			expectLogDirs()
+			usages["/var/lib/kafka/data-1"] = Usage{Capacity: 1000, Available: 150}
+			Expect(monitor.Check()).To(Equal(LEVEL_HIGH))
+			expectLogDirs()
+			Expect(monitor.Check()).To(Equal(LEVEL_HIGH))
+			Expect(reasons()).To(Equal([]string{DISK_USAGE_HIGH_REASON}))
+
+			expectLogDirs()
+			usages["/var/lib/kafka/data-1"] = Usage{Capacity: 1000, Available: 600}
+			Expect(monitor.Check()).To(Equal(LEVEL_NORMAL))
+			Expect(reasons()).To(Equal([]string{DISK_USAGE_HIGH_REASON, DISK_USAGE_NORMAL_REASON}))
+		})
+	})
+
+	Context("Retention", func() {
+		BeforeEach(func() {
+			monitor.Retention = guard
+		})
+
+		It("lowers the retention of the largest allowed topic and restores it", func() {
+			usages["/var/lib/kafka/data"] = Usage{Capacity: 1000, Available: 50}
+			expectLogDirs()
+			// orders is the largest topic but it is not allowed
+			expectRetention("metrics", sarama.ConfigEntry{Name: RETENTION_MS_KEY, Value: "604800000", Default: true, Source: sarama.SourceDefault})
+			lowered := "3600000"
+			expectAlter("metrics", sarama.IncrementalAlterConfigsEntry{Operation: sarama.IncrementalAlterConfigsOperationSet, Value: &lowered})
+			Expect(monitor.Check()).To(Equal(LEVEL_CRITICAL))
+
+			records, err := guard.Load()
+			Expect(err).To(BeNil())
+			Expect(records).To(HaveKey("metrics"))
+			Expect(records["metrics"].Original).To(BeEmpty())
+			Expect(records["metrics"].BrokerID).To(Equal(int32(1)))
+
+			// the next largest topic is lowered while the usage stays critical
+			expectLogDirs()
+			expectRetention("logs", sarama.ConfigEntry{Name: RETENTION_MS_KEY, Value: "604800000", Source: sarama.SourceTopic})
+			expectAlter("logs", sarama.IncrementalAlterConfigsEntry{Operation: sarama.IncrementalAlterConfigsOperationSet, Value: &lowered})
+			Expect(monitor.Check()).To(Equal(LEVEL_CRITICAL))
+			records, err = guard.Load()
+			Expect(err).To(BeNil())
+			Expect(records["logs"].Original).To(Equal("604800000"))
+
+			// the high usage keeps the retention lowered
+			usages["/var/lib/kafka/data"] = Usage{Capacity: 1000, Available: 150}
+			expectLogDirs()
+			Expect(monitor.Check()).To(Equal(LEVEL_HIGH))
+
+			usages["/var/lib/kafka/data"] = Usage{Capacity: 1000, Available: 500}
+			expectLogDirs()
+			original := "604800000"
+			expectAlter("logs", sarama.IncrementalAlterConfigsEntry{Operation: sarama.IncrementalAlterConfigsOperationSet, Value: &original})
+			expectAlter("metrics", sarama.IncrementalAlterConfigsEntry{Operation: sarama.IncrementalAlterConfigsOperationDelete})
+			Expect(monitor.Check()).To(Equal(LEVEL_NORMAL))
+
+			records, err = guard.Load()
+			Expect(err).To(BeNil())
+			Expect(records).To(BeEmpty())
+			Expect(reasons()).To(Equal([]string{
+				DISK_USAGE_CRITICAL_REASON, RETENTION_LOWERED_REASON, RETENTION_LOWERED_REASON, DISK_USAGE_HIGH_REASON,
+				DISK_USAGE_NORMAL_REASON, RETENTION_RESTORED_REASON, RETENTION_RESTORED_REASON,
+			}))
+		})
+		It("skips the topics whose retention is already low", func() {
+			expectRetention("logs", sarama.ConfigEntry{Name: RETENTION_MS_KEY, Value: "60000", Source: sarama.SourceTopic})
+			expectRetention("metrics")
+			lowered := "3600000"
+			expectAlter("metrics", sarama.IncrementalAlterConfigsEntry{Operation: sarama.IncrementalAlterConfigsOperationSet, Value: &lowered})
+			Expect(guard.Lower(mockAdmin, map[string]int64{"logs": 300, "metrics": 200, "orders": 500})).To(BeNil())
+		})
+		It("only restores the topics lowered by the broker unless asked to", func() {
+			other := &RetentionGuard{Client: client, Env: mockEnv, Events: guard.Events, ConfigMap: guard.ConfigMap, BrokerID: 2,
+				Topics: []string{"metrics"}, Retention: time.Hour}
+			lowered := "3600000"
+			expectRetention("metrics")
+			expectAlter("metrics", sarama.IncrementalAlterConfigsEntry{Operation: sarama.IncrementalAlterConfigsOperationSet, Value: &lowered})
+			Expect(other.Lower(mockAdmin, map[string]int64{"metrics": 1})).To(BeNil())
+
+			Expect(guard.Restore(mockAdmin, false)).To(BeNil())
+			expectAlter("metrics", sarama.IncrementalAlterConfigsEntry{Operation: sarama.IncrementalAlterConfigsOperationDelete})
+			Expect(guard.Restore(mockAdmin, true)).To(BeNil())
+		})
+	})
+
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockEnv = mocks.NewMockEnvironment(mockCtrl)
+		mockEnv.EXPECT().GetNamespace().Return("default").AnyTimes()
+		mockEnv.EXPECT().GetHostName().Return("kafka-kafka-1").AnyTimes()
+		mockEnv.EXPECT().GetNodeName().Return("kubelet-0").AnyTimes()
+		mockAdmin = mocks.NewMockClusterAdmin(mockCtrl)
+		client = testclient.NewSimpleClientset()
+
+		usages = map[string]Usage{
+			"/var/lib/kafka/data":   {Capacity: 1000, Available: 500},
+			"/var/lib/kafka/data-1": {Capacity: 1000, Available: 600},
+		}
+		recorder := &events.Recorder{
+			Client: client,
+			Env:    mockEnv,
+		}
+		monitor = NewMonitor(func() (sarama.ClusterAdmin, error) {
+			return mockAdmin, nil
+		}, recorder, 1, []string{"/var/lib/kafka/data", "/var/lib/kafka/data-1"})
+		monitor.Statfs = func(path string) (Usage, error) {
+			return usages[path], nil
+		}
+		guard = &RetentionGuard{
+			Client:    client,
+			Env:       mockEnv,
+			Events:    recorder,
+			ConfigMap: RetentionConfigMapName("kafka-kafka"),
+			BrokerID:  1,
+			Topics:    []string{"logs", "metrics", "payments"},
+			Retention: time.Hour,
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+})
+
+func TestDisk(t *testing.T) {
+	RegisterFailHandler(Fail)
+	junitReporter := reporters.NewJUnitReporter(fmt.Sprintf("%s-junit.xml", "kafka-utils-disk"))
+	RunSpecsWithDefaultAndCustomReporters(t, "KafkaUtils Disk Usage Suite", []Reporter{junitReporter})
+}
@@ -0,0 +1,179 @@
+package disk
+
+import (
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/events"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	DEFAULT_METRICS_ADDRESS    = ":9099"
+	DEFAULT_WARNING_RATIO      = 0.8
+	DEFAULT_CRITICAL_RATIO     = 0.9
+	DISK_USAGE_HIGH_REASON     = "DiskUsageHigh"
+	DISK_USAGE_CRITICAL_REASON = "DiskUsageCritical"
+	DISK_USAGE_NORMAL_REASON   = "DiskUsageNormal"
+)
+
+// Level is the severity of the disk usage of a broker
+type Level int
+
+const (
+	LEVEL_NORMAL Level = iota
+	LEVEL_HIGH
+	LEVEL_CRITICAL
+)
+
+// Monitor exports the usage of the log dirs of a broker and protects it from running out of disk
+type Monitor struct {
+	NewAdmin func() (sarama.ClusterAdmin, error)
+	Events   *events.Recorder
+	BrokerID int32
+	LogDirs  []string
+	Statfs   func(path string) (Usage, error)
+	// WarningRatio and CriticalRatio are the used fractions of a log dir filesystem at which events are recorded
+	WarningRatio  float64
+	CriticalRatio float64
+	// Retention lowers the retention of topics when the usage is critical, nil disables it
+	Retention *RetentionGuard
+
+	Capacity  *prometheus.GaugeVec
+	Available *prometheus.GaugeVec
+	Replicas  *prometheus.GaugeVec
+
+	level Level
+}
+
+// NewMonitor creates the monitor and its gauges, the gauges still need to be registered
+func NewMonitor(newAdmin func() (sarama.ClusterAdmin, error), recorder *events.Recorder, brokerID int32, logDirs []string) *Monitor {
+	return &Monitor{
+		NewAdmin:      newAdmin,
+		Events:        recorder,
+		BrokerID:      brokerID,
+		LogDirs:       logDirs,
+		Statfs:        Statfs,
+		WarningRatio:  DEFAULT_WARNING_RATIO,
+		CriticalRatio: DEFAULT_CRITICAL_RATIO,
+		Capacity: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "kafka_broker_log_dir_capacity_bytes",
+			Help: "Size of the filesystem holding the log dir in bytes.",
+		}, []string{"dir"}),
+		Available: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "kafka_broker_log_dir_available_bytes",
+			Help: "Space left on the filesystem holding the log dir in bytes.",
+		}, []string{"dir"}),
+		Replicas: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "kafka_broker_log_dir_replicas_bytes",
+			Help: "Size of the replicas stored in the log dir in bytes, as reported by the broker.",
+		}, []string{"dir"}),
+	}
+}
+
+// Collectors returns the gauges of the monitor
+func (m *Monitor) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.Capacity, m.Available, m.Replicas}
+}
+
+// Run checks the disk usage every interval until stop is closed
+func (m *Monitor) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		m.Check()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check refreshes the gauges, records an event when the usage level changes and runs the protective actions.
+// The filesystem usage is exported even when the broker is down, which is when it matters most.
+func (m *Monitor) Check() Level {
+	var worstDir string
+	var worst float64
+	for _, logDir := range m.LogDirs {
+		usage, err := m.Statfs(logDir)
+		if err != nil {
+			log.Errorf("%v", err)
+			continue
+		}
+		m.Capacity.WithLabelValues(logDir).Set(float64(usage.Capacity))
+		m.Available.WithLabelValues(logDir).Set(float64(usage.Available))
+		if ratio := usage.Ratio(); ratio >= worst {
+			worstDir, worst = logDir, ratio
+		}
+	}
+
+	var admin sarama.ClusterAdmin
+	topicSizes := map[string]int64{}
+	if m.NewAdmin != nil {
+		var err error
+		if admin, err = m.NewAdmin(); err != nil {
+			log.Warnf("could not connect to the brokers, the replica sizes are not updated: %v", err)
+		} else {
+			defer admin.Close()
+			topicSizes = m.describeReplicas(admin)
+		}
+	}
+
+	level := LEVEL_NORMAL
+	if worst >= m.CriticalRatio {
+		level = LEVEL_CRITICAL
+	} else if worst >= m.WarningRatio {
+		level = LEVEL_HIGH
+	}
+	if level != m.level {
+		switch level {
+		case LEVEL_CRITICAL:
+			m.Events.Eventf(v1.EventTypeWarning, DISK_USAGE_CRITICAL_REASON, "log dir %s is %.0f%% full", worstDir, worst*100)
+		case LEVEL_HIGH:
+			m.Events.Eventf(v1.EventTypeWarning, DISK_USAGE_HIGH_REASON, "log dir %s is %.0f%% full", worstDir, worst*100)
+		default:
+			m.Events.Eventf(v1.EventTypeNormal, DISK_USAGE_NORMAL_REASON, "the log dirs are below %.0f%% full", m.WarningRatio*100)
+		}
+		m.level = level
+	}
+
+	if m.Retention == nil || admin == nil {
+		return level
+	}
+	// the retention stays lowered while the usage is high, so it doesn't flap around the critical ratio
+	switch level {
+	case LEVEL_CRITICAL:
+		if err := m.Retention.Lower(admin, topicSizes); err != nil {
+			log.Errorf("could not lower the retention: %v", err)
+		}
+	case LEVEL_NORMAL:
+		if err := m.Retention.Restore(admin, false); err != nil {
+			log.Errorf("could not restore the retention: %v", err)
+		}
+	}
+	return level
+}
+
+// describeReplicas exports the size of the replicas per log dir and returns the size of every topic on the broker
+func (m *Monitor) describeReplicas(admin sarama.ClusterAdmin) map[string]int64 {
+	topicSizes := map[string]int64{}
+	logDirs, err := admin.DescribeLogDirs([]int32{m.BrokerID})
+	if err != nil {
+		log.Warnf("could not describe the log dirs of broker %d: %v", m.BrokerID, err)
+		return topicSizes
+	}
+	for _, logDir := range logDirs[m.BrokerID] {
+		var size int64
+		for _, topic := range logDir.Topics {
+			for _, partition := range topic.Partitions {
+				size += partition.Size
+				topicSizes[topic.Topic] += partition.Size
+			}
+		}
+		m.Replicas.WithLabelValues(logDir.Path).Set(float64(size))
+	}
+	return topicSizes
+}
@@ -0,0 +1,215 @@
+package disk
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/events"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/service"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	RETENTION_MS_KEY          = "retention.ms"
+	RETENTION_LOWERED_REASON  = "RetentionLowered"
+	RETENTION_RESTORED_REASON = "RetentionRestored"
+)
+
+// LoweredRetention records the retention of a topic before it was lowered, an empty Original is the default retention
+type LoweredRetention struct {
+	BrokerID int32     `json:"broker"`
+	Original string    `json:"original"`
+	Lowered  string    `json:"lowered"`
+	Time     time.Time `json:"time"`
+}
+
+// RetentionGuard lowers the retention of the largest allowed topics and restores it once the disk usage is back to normal.
+// The original retentions are kept in a ConfigMap so every change can be reverted, even after a restart.
+type RetentionGuard struct {
+	Client    kubernetes.Interface
+	Env       service.Environment
+	Events    *events.Recorder
+	ConfigMap string
+	BrokerID  int32
+	// Topics is the allow-list of the topics whose retention may be lowered
+	Topics    []string
+	Retention time.Duration
+}
+
+// RetentionConfigMapName returns the name of the ConfigMap holding the lowered retentions of the brokers of statefulSet
+func RetentionConfigMapName(statefulSet string) string {
+	return fmt.Sprintf("%s-lowered-retention", statefulSet)
+}
+
+// Lower lowers the retention of the largest allowed topic not lowered yet, one topic per call
+func (g *RetentionGuard) Lower(admin sarama.ClusterAdmin, topicSizes map[string]int64) error {
+	lowered, err := g.Load()
+	if err != nil {
+		return err
+	}
+	var candidates []string
+	for _, topic := range g.Topics {
+		if _, ok := lowered[topic]; !ok && topicSizes[topic] > 0 {
+			candidates = append(candidates, topic)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return topicSizes[candidates[i]] > topicSizes[candidates[j]] })
+
+	retention := strconv.FormatInt(int64(g.Retention/time.Millisecond), 10)
+	for _, topic := range candidates {
+		original, err := g.describeRetention(admin, topic)
+		if err != nil {
+			return err
+		}
+		if len(original) > 0 {
+			if value, err := strconv.ParseInt(original, 10, 64); err == nil && value >= 0 && value <= int64(g.Retention/time.Millisecond) {
+				log.Infof("the retention of topic %s is already %sms", topic, original)
+				continue
+			}
+		}
+		// the original retention is saved first, so a failure never loses it
+		record := LoweredRetention{BrokerID: g.BrokerID, Original: original, Lowered: retention, Time: time.Now().UTC()}
+		if err := g.save(topic, &record); err != nil {
+			return err
+		}
+		if err := admin.IncrementalAlterConfig(sarama.TopicResource, topic, map[string]sarama.IncrementalAlterConfigsEntry{
+			RETENTION_MS_KEY: {Operation: sarama.IncrementalAlterConfigsOperationSet, Value: &retention},
+		}, false); err != nil {
+			return fmt.Errorf("could not lower the retention of topic %s: %v", topic, err)
+		}
+		log.Infof("lowered the retention of topic %s from %s to %sms, %d bytes on broker %d",
+			topic, describeOriginal(original), retention, topicSizes[topic], g.BrokerID)
+		g.Events.Eventf(v1.EventTypeWarning, RETENTION_LOWERED_REASON, "lowered the retention of topic %s from %s to %sms to free disk space",
+			topic, describeOriginal(original), retention)
+		return nil
+	}
+	log.Infof("no allowed topic left to lower the retention of")
+	return nil
+}
+
+// Restore reverts the retention of the topics lowered by the broker, of all the lowered topics when all is set
+func (g *RetentionGuard) Restore(admin sarama.ClusterAdmin, all bool) error {
+	lowered, err := g.Load()
+	if err != nil {
+		return err
+	}
+	var topics []string
+	for topic, record := range lowered {
+		if all || record.BrokerID == g.BrokerID {
+			topics = append(topics, topic)
+		}
+	}
+	sort.Strings(topics)
+	for _, topic := range topics {
+		record := lowered[topic]
+		entry := sarama.IncrementalAlterConfigsEntry{Operation: sarama.IncrementalAlterConfigsOperationDelete}
+		if len(record.Original) > 0 {
+			original := record.Original
+			entry = sarama.IncrementalAlterConfigsEntry{Operation: sarama.IncrementalAlterConfigsOperationSet, Value: &original}
+		}
+		if err := admin.IncrementalAlterConfig(sarama.TopicResource, topic, map[string]sarama.IncrementalAlterConfigsEntry{
+			RETENTION_MS_KEY: entry,
+		}, false); err != nil {
+			return fmt.Errorf("could not restore the retention of topic %s: %v", topic, err)
+		}
+		if err := g.save(topic, nil); err != nil {
+			return err
+		}
+		log.Infof("restored the retention of topic %s to %s", topic, describeOriginal(record.Original))
+		g.Events.Eventf(v1.EventTypeNormal, RETENTION_RESTORED_REASON, "restored the retention of topic %s to %s", topic, describeOriginal(record.Original))
+	}
+	return nil
+}
+
+// Load returns the lowered retentions per topic
+func (g *RetentionGuard) Load() (map[string]*LoweredRetention, error) {
+	lowered := map[string]*LoweredRetention{}
+	configMap, err := g.Client.CoreV1().ConfigMaps(g.Env.GetNamespace()).Get(g.ConfigMap, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return lowered, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get the ConfigMap %s: %v", g.ConfigMap, err)
+	}
+	for topic, data := range configMap.Data {
+		record := &LoweredRetention{}
+		if err := json.Unmarshal([]byte(data), record); err != nil {
+			return nil, fmt.Errorf("invalid lowered retention of topic %s in the ConfigMap %s: %v", topic, g.ConfigMap, err)
+		}
+		lowered[topic] = record
+	}
+	return lowered, nil
+}
+
+// save records the lowered retention of a topic, a nil record removes it
+func (g *RetentionGuard) save(topic string, record *LoweredRetention) error {
+	configMaps := g.Client.CoreV1().ConfigMaps(g.Env.GetNamespace())
+	configMap, err := configMaps.Get(g.ConfigMap, metav1.GetOptions{})
+	found := err == nil
+	if errors.IsNotFound(err) {
+		configMap = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      g.ConfigMap,
+				Namespace: g.Env.GetNamespace(),
+			},
+		}
+	} else if err != nil {
+		return fmt.Errorf("could not get the ConfigMap %s: %v", g.ConfigMap, err)
+	}
+	if configMap.Data == nil {
+		configMap.Data = map[string]string{}
+	}
+	if record == nil {
+		delete(configMap.Data, topic)
+	} else {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		configMap.Data[topic] = string(data)
+	}
+
+	if found {
+		_, err = configMaps.Update(configMap)
+	} else {
+		_, err = configMaps.Create(configMap)
+	}
+	if err != nil {
+		return fmt.Errorf("could not save the lowered retention of topic %s in the ConfigMap %s: %v", topic, g.ConfigMap, err)
+	}
+	return nil
+}
+
+// describeRetention returns the retention set on a topic, empty when it uses the default one
+func (g *RetentionGuard) describeRetention(admin sarama.ClusterAdmin, topic string) (string, error) {
+	entries, err := admin.DescribeConfig(sarama.ConfigResource{
+		Type:        sarama.TopicResource,
+		Name:        topic,
+		ConfigNames: []string{RETENTION_MS_KEY},
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not describe the configuration of topic %s: %v", topic, err)
+	}
+	for _, entry := range entries {
+		// the brokers before 1.1 report no source, only whether the value is a default
+		if entry.Name == RETENTION_MS_KEY && !entry.Default && (entry.Source == sarama.SourceTopic || entry.Source == sarama.SourceUnknown) {
+			return entry.Value, nil
+		}
+	}
+	return "", nil
+}
+
+func describeOriginal(original string) string {
+	if len(original) == 0 {
+		return "the default"
+	}
+	return original + "ms"
+}
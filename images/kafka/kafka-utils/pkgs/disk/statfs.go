@@ -0,0 +1,32 @@
+package disk
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// Usage is the usage of the filesystem holding a log dir
+type Usage struct {
+	Capacity  uint64
+	Available uint64
+}
+
+// Ratio returns the used fraction of the filesystem
+func (u Usage) Ratio() float64 {
+	if u.Capacity == 0 {
+		return 0
+	}
+	return float64(u.Capacity-u.Available) / float64(u.Capacity)
+}
+
+// Statfs returns the usage of the filesystem holding path, the blocks reserved to root are not counted as available
+func Statfs(path string) (Usage, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return Usage{}, fmt.Errorf("could not stat the filesystem of %s: %v", path, err)
+	}
+	return Usage{
+		Capacity:  uint64(stat.Blocks) * uint64(stat.Bsize),
+		Available: uint64(stat.Bavail) * uint64(stat.Bsize),
+	}, nil
+}
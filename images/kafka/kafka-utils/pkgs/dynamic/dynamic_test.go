@@ -0,0 +1,285 @@
+package dynamic
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/golang/mock/gomock"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/events"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/mocks"
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/reporters"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testclient "k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Describe("[Kafka Dynamic Config]", func() {
+
+	var (
+		mockCtrl   *gomock.Controller
+		mockEnv    *mocks.MockEnvironment
+		mockAdmin  *mocks.MockClusterAdmin
+		client     *testclient.Clientset
+		reconciler *Reconciler
+	)
+
+	brokerConfigs := []sarama.ConfigEntry{
+		{Name: "log.retention.hours", Value: "168"},
+		{Name: "log.cleaner.threads", Value: "1"},
+		{Name: "broker.rack", Value: "", ReadOnly: true},
+		{Name: "listener.name.internal.ssl.keystore.location", Value: ""},
+	}
+
+	setConfigMap := func(data map[string]string) {
+		configMap := &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "kafka-dynamic-config", Namespace: "default"},
+			Data:       data,
+		}
+		if _, err := client.CoreV1().ConfigMaps("default").Update(configMap); err != nil {
+			_, err = client.CoreV1().ConfigMaps("default").Create(configMap)
+			Expect(err).To(BeNil())
+		}
+	}
+
+	expectCluster := func() {
+		mockAdmin.EXPECT().DescribeCluster().Return([]*sarama.Broker{sarama.NewBroker("kafka-kafka-0:9093")}, int32(0), nil)
+		mockAdmin.EXPECT().Close().Return(nil)
+	}
+
+	expectDescribe := func(brokerID string) {
+		mockAdmin.EXPECT().DescribeConfig(sarama.ConfigResource{Type: sarama.BrokerResource, Name: brokerID}).Return(brokerConfigs, nil)
+	}
+
+	set := func(value string) sarama.IncrementalAlterConfigsEntry {
+		return sarama.IncrementalAlterConfigsEntry{Operation: sarama.IncrementalAlterConfigsOperationSet, Value: &value}
+	}
+
+	reasons := func() []string {
+		eventList, err := client.CoreV1().Events("default").List(metav1.ListOptions{})
+		Expect(err).To(BeNil())
+		var reasons []string
+		for _, event := range eventList.Items {
+			reasons = append(reasons, event.Reason)
+		}
+		return reasons
+	}
+
+	Context("Reconciliation", func() {
+		It("applies the dynamic configs live and records the ones requiring a restart", func() {
+			setConfigMap(map[string]string{
+				CLUSTER_PROPERTIES_KEY: "log.retention.hours=24\nbroker.rack=a\n",
+				"broker-0.properties":  "log.cleaner.threads=2\n",
+			})
+			expectCluster()
+			// the brokers created from an address have the id -1
+			expectDescribe("-1")
+			expectDescribe("0")
+			mockAdmin.EXPECT().IncrementalAlterConfig(sarama.BrokerResource, "", map[string]sarama.IncrementalAlterConfigsEntry{
+				"log.retention.hours": set("24"),
+			}, false).Return(nil)
+			mockAdmin.EXPECT().IncrementalAlterConfig(sarama.BrokerResource, "0", map[string]sarama.IncrementalAlterConfigsEntry{
+				"log.cleaner.threads": set("2"),
+			}, false).Return(nil)
+
+			status, err := reconciler.Reconcile()
+			Expect(err).To(BeNil())
+			Expect(status.String()).To(Equal("broker-0: applied [log.cleaner.threads], pending restart []\n" +
+				"cluster: applied [log.retention.hours], pending restart [broker.rack]\n"))
+			Expect(reasons()).To(ConsistOf(DYNAMIC_CONFIG_APPLIED_REASON, DYNAMIC_CONFIG_APPLIED_REASON, DYNAMIC_CONFIG_RESTART_REASON))
+
+			loaded, err := reconciler.LoadStatus()
+			Expect(err).To(BeNil())
+			Expect(loaded).To(Equal(status))
+
+			// nothing changed, the brokers still run without the config pending a restart
+			expectCluster()
+			expectDescribe("-1")
+			status, err = reconciler.Reconcile()
+			Expect(err).To(BeNil())
+			Expect(status).To(BeNil())
+		})
+		It("clears the configs pending a restart once the brokers run with them", func() {
+			setConfigMap(map[string]string{
+				CLUSTER_PROPERTIES_KEY: "broker.rack=a\n",
+			})
+			expectCluster()
+			expectDescribe("-1")
+			status, err := reconciler.Reconcile()
+			Expect(err).To(BeNil())
+			Expect(status[CLUSTER_RESOURCE].PendingRestart).To(Equal([]string{"broker.rack"}))
+
+			expectCluster()
+			mockAdmin.EXPECT().DescribeConfig(sarama.ConfigResource{Type: sarama.BrokerResource, Name: "-1"}).Return([]sarama.ConfigEntry{
+				{Name: "broker.rack", Value: "a", ReadOnly: true},
+			}, nil)
+			status, err = reconciler.Reconcile()
+			Expect(err).To(BeNil())
+			Expect(status).To(BeEmpty())
+			loaded, err := reconciler.LoadStatus()
+			Expect(err).To(BeNil())
+			Expect(loaded).To(BeEmpty())
+
+			// nothing is pending anymore
+			status, err = reconciler.Reconcile()
+			Expect(err).To(BeNil())
+			Expect(status).To(BeNil())
+		})
+		It("reports the unknown configs as failed", func() {
+			setConfigMap(map[string]string{
+				CLUSTER_PROPERTIES_KEY: "log.retention.hour=24\nlog.cleaner.threads=2\nlistener.name.internal.ssl.keystore.type=PKCS12\n",
+			})
+			expectCluster()
+			expectDescribe("-1")
+			mockAdmin.EXPECT().IncrementalAlterConfig(sarama.BrokerResource, "", map[string]sarama.IncrementalAlterConfigsEntry{
+				"log.cleaner.threads":                      set("2"),
+				"listener.name.internal.ssl.keystore.type": set("PKCS12"),
+			}, false).Return(nil)
+			status, err := reconciler.Reconcile()
+			Expect(err).To(HaveOccurred())
+			Expect(status[CLUSTER_RESOURCE].Applied).To(Equal([]string{"listener.name.internal.ssl.keystore.type", "log.cleaner.threads"}))
+			Expect(status[CLUSTER_RESOURCE].PendingRestart).To(BeEmpty())
+			Expect(status[CLUSTER_RESOURCE].Failed).To(Equal("unknown configs log.retention.hour"))
+			Expect(reasons()).To(ConsistOf(DYNAMIC_CONFIG_FAILED_REASON, DYNAMIC_CONFIG_APPLIED_REASON))
+
+			// the retry fails the same way, it is not reported again
+			expectCluster()
+			expectDescribe("-1")
+			mockAdmin.EXPECT().IncrementalAlterConfig(sarama.BrokerResource, "", gomock.Any(), false).Return(nil)
+			_, err = reconciler.Reconcile()
+			Expect(err).To(HaveOccurred())
+			Expect(reasons()).To(ConsistOf(DYNAMIC_CONFIG_FAILED_REASON, DYNAMIC_CONFIG_APPLIED_REASON, DYNAMIC_CONFIG_APPLIED_REASON))
+		})
+		It("reconciles again when another broker saved its status", func() {
+			setConfigMap(map[string]string{
+				CLUSTER_PROPERTIES_KEY: "log.cleaner.threads=2\n",
+			})
+			expectCluster()
+			expectDescribe("-1")
+			mockAdmin.EXPECT().IncrementalAlterConfig(sarama.BrokerResource, "", gomock.Any(), false).Return(nil)
+			_, err := reconciler.Reconcile()
+			Expect(err).To(BeNil())
+
+			setConfigMap(map[string]string{
+				CLUSTER_PROPERTIES_KEY: "log.cleaner.threads=3\n",
+			})
+			expectCluster()
+			expectDescribe("-1")
+			mockAdmin.EXPECT().IncrementalAlterConfig(sarama.BrokerResource, "", gomock.Any(), false).DoAndReturn(
+				func(sarama.ConfigResourceType, string, map[string]sarama.IncrementalAlterConfigsEntry, bool) error {
+					// another broker saves its status while this one reconciles
+					other, err := client.CoreV1().ConfigMaps("default").Get(StatusConfigMapName("kafka-dynamic-config"), metav1.GetOptions{})
+					Expect(err).To(BeNil())
+					other.ResourceVersion = "2"
+					_, err = client.CoreV1().ConfigMaps("default").Update(other)
+					Expect(err).To(BeNil())
+					return nil
+				})
+			_, err = reconciler.Reconcile()
+			Expect(err).To(MatchError(ContainSubstring("was updated by another broker")))
+
+			expectCluster()
+			expectDescribe("-1")
+			mockAdmin.EXPECT().IncrementalAlterConfig(sarama.BrokerResource, "", map[string]sarama.IncrementalAlterConfigsEntry{
+				"log.cleaner.threads": set("3"),
+			}, false).Return(nil)
+			status, err := reconciler.Reconcile()
+			Expect(err).To(BeNil())
+			Expect(status[CLUSTER_RESOURCE].Applied).To(Equal([]string{"log.cleaner.threads"}))
+		})
+		It("reverts the configs removed from the ConfigMap", func() {
+			setConfigMap(map[string]string{
+				CLUSTER_PROPERTIES_KEY: "log.retention.hours=24\nlog.cleaner.threads=2\n",
+			})
+			expectCluster()
+			expectDescribe("-1")
+			mockAdmin.EXPECT().IncrementalAlterConfig(sarama.BrokerResource, "", gomock.Any(), false).Return(nil)
+			_, err := reconciler.Reconcile()
+			Expect(err).To(BeNil())
+
+			setConfigMap(map[string]string{
+				CLUSTER_PROPERTIES_KEY: "log.retention.hours=48\n",
+			})
+			expectCluster()
+			expectDescribe("-1")
+			mockAdmin.EXPECT().IncrementalAlterConfig(sarama.BrokerResource, "", map[string]sarama.IncrementalAlterConfigsEntry{
+				"log.retention.hours": set("48"),
+				"log.cleaner.threads": {Operation: sarama.IncrementalAlterConfigsOperationDelete},
+			}, false).Return(nil)
+			status, err := reconciler.Reconcile()
+			Expect(err).To(BeNil())
+			Expect(status[CLUSTER_RESOURCE].Applied).To(Equal([]string{"log.retention.hours"}))
+
+			Expect(client.CoreV1().ConfigMaps("default").Delete("kafka-dynamic-config", &metav1.DeleteOptions{})).To(Succeed())
+			expectCluster()
+			expectDescribe("-1")
+			mockAdmin.EXPECT().IncrementalAlterConfig(sarama.BrokerResource, "", map[string]sarama.IncrementalAlterConfigsEntry{
+				"log.retention.hours": {Operation: sarama.IncrementalAlterConfigsOperationDelete},
+			}, false).Return(nil)
+			status, err = reconciler.Reconcile()
+			Expect(err).To(BeNil())
+			Expect(status).To(BeEmpty())
+		})
+		It("retries the resources which failed", func() {
+			setConfigMap(map[string]string{
+				"broker-2.properties": "log.cleaner.threads=2\n",
+			})
+			expectCluster()
+			mockAdmin.EXPECT().DescribeConfig(sarama.ConfigResource{Type: sarama.BrokerResource, Name: "2"}).Return(nil, fmt.Errorf("broker down"))
+			status, err := reconciler.Reconcile()
+			Expect(err).To(HaveOccurred())
+			Expect(status["broker-2"].Failed).To(ContainSubstring("broker down"))
+			Expect(reasons()).To(Equal([]string{DYNAMIC_CONFIG_FAILED_REASON}))
+
+			expectCluster()
+			expectDescribe("2")
+			mockAdmin.EXPECT().IncrementalAlterConfig(sarama.BrokerResource, "2", map[string]sarama.IncrementalAlterConfigsEntry{
+				"log.cleaner.threads": set("2"),
+			}, false).Return(nil)
+			status, err = reconciler.Reconcile()
+			Expect(err).To(BeNil())
+			Expect(status["broker-2"].Failed).To(BeEmpty())
+		})
+		It("refuses malformed properties", func() {
+			setConfigMap(map[string]string{
+				CLUSTER_PROPERTIES_KEY: "log.retention.hours\n",
+			})
+			_, err := reconciler.Reconcile()
+			Expect(err).To(MatchError(ContainSubstring(CLUSTER_PROPERTIES_KEY)))
+		})
+	})
+
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockEnv = mocks.NewMockEnvironment(mockCtrl)
+		mockEnv.EXPECT().GetNamespace().Return("default").AnyTimes()
+		mockEnv.EXPECT().GetHostName().Return("kafka-kafka-0").AnyTimes()
+		mockEnv.EXPECT().GetNodeName().Return("kubelet-0").AnyTimes()
+		mockAdmin = mocks.NewMockClusterAdmin(mockCtrl)
+		client = testclient.NewSimpleClientset()
+		reconciler = &Reconciler{
+			Client: client,
+			Env:    mockEnv,
+			Events: &events.Recorder{
+				Client: client,
+				Env:    mockEnv,
+			},
+			NewAdmin: func() (sarama.ClusterAdmin, error) {
+				return mockAdmin, nil
+			},
+			ConfigMap: "kafka-dynamic-config",
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+})
+
+func TestDynamic(t *testing.T) {
+	RegisterFailHandler(Fail)
+	junitReporter := reporters.NewJUnitReporter(fmt.Sprintf("%s-junit.xml", "kafka-utils-dynamic"))
+	RunSpecsWithDefaultAndCustomReporters(t, "KafkaUtils Dynamic Config Suite", []Reporter{junitReporter})
+}
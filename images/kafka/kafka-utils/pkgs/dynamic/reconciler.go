@@ -0,0 +1,368 @@
+package dynamic
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/config"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/events"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/service"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	CLUSTER_RESOURCE               = "cluster"
+	CLUSTER_PROPERTIES_KEY         = "cluster.properties"
+	DYNAMIC_CONFIG_CONFIGMAP_ENV   = "DYNAMIC_CONFIG_CONFIGMAP"
+	DYNAMIC_CONFIG_APPLIED_REASON  = "DynamicConfigApplied"
+	DYNAMIC_CONFIG_RESTART_REASON  = "DynamicConfigRequiresRestart"
+	DYNAMIC_CONFIG_FAILED_REASON   = "DynamicConfigFailed"
+	BROKER_PROPERTIES_KEY_TEMPLATE = "broker-%d.properties"
+	BROKER_RESOURCE_TEMPLATE       = "broker-%d"
+	LISTENER_CONFIG_PREFIX         = "listener.name."
+)
+
+var brokerPropertiesKey = regexp.MustCompile(`^broker-(\d+)\.properties$`)
+
+// Reconciler applies the dynamic broker configs of a ConfigMap with IncrementalAlterConfigs.
+// The ConfigMap holds the cluster-wide defaults in cluster.properties and the configs of a broker in broker-<id>.properties.
+type Reconciler struct {
+	Client    kubernetes.Interface
+	Env       service.Environment
+	Events    *events.Recorder
+	NewAdmin  func() (sarama.ClusterAdmin, error)
+	ConfigMap string
+
+	// reconciled is the content of the ConfigMap last reconciled without failure
+	reconciled map[string]string
+	// pending is set while configs of the last reconciliation are pending a restart
+	pending bool
+	// statusVersion is the resourceVersion of the status ConfigMap last loaded, the status is only saved over that version
+	// since every broker runs a reconciler
+	statusVersion string
+}
+
+// Run reconciles the ConfigMap every interval when its content changed or the last reconciliation failed
+func (r *Reconciler) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := r.Reconcile(); err != nil {
+			log.Errorf("could not reconcile the dynamic configs: %v", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reconcile applies the configs which changed since the last reconciliation, the keys removed from the ConfigMap are reverted.
+// While configs are pending a restart, the brokers are checked for the desired values. It returns nil when there was nothing to do.
+func (r *Reconciler) Reconcile() (Status, error) {
+	data, err := r.loadConfigMap()
+	if err != nil {
+		return nil, err
+	}
+	desired, err := parseConfigMap(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ConfigMap %s: %v", r.ConfigMap, err)
+	}
+	if r.reconciled != nil && reflect.DeepEqual(data, r.reconciled) {
+		if !r.pending {
+			return nil, nil
+		}
+		return r.refreshPending(desired)
+	}
+	previous, err := r.LoadStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	admin, anyBroker, err := r.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer admin.Close()
+
+	var resources []string
+	for resource := range desired {
+		resources = append(resources, resource)
+	}
+	for resource := range previous {
+		if _, ok := desired[resource]; !ok {
+			resources = append(resources, resource)
+		}
+	}
+	sort.Strings(resources)
+
+	status := Status{}
+	failed := false
+	for _, resource := range resources {
+		resourceStatus := r.reconcileResource(admin, anyBroker, resource, desired[resource], previous[resource])
+		if len(resourceStatus.Failed) > 0 {
+			failed = true
+		}
+		// a resource without any config left is dropped from the status
+		if len(resourceStatus.Applied) > 0 || len(resourceStatus.PendingRestart) > 0 || len(resourceStatus.Failed) > 0 {
+			status[resource] = resourceStatus
+		}
+	}
+	if err := r.saveStatus(status); err != nil {
+		return nil, err
+	}
+	r.pending = status.hasPendingRestart()
+	if failed {
+		// the reconciliation is retried on the next run
+		r.reconciled = nil
+		return status, fmt.Errorf("some dynamic configs could not be applied, see the ConfigMap %s", StatusConfigMapName(r.ConfigMap))
+	}
+	r.reconciled = data
+	return status, nil
+}
+
+// refreshPending drops the configs pending a restart from the status once the brokers report their desired value.
+// It returns nil when none was dropped.
+func (r *Reconciler) refreshPending(desired map[string]map[string]string) (Status, error) {
+	status, err := r.LoadStatus()
+	if err != nil {
+		return nil, err
+	}
+	admin, anyBroker, err := r.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer admin.Close()
+
+	changed := false
+	for _, resource := range status.resources() {
+		resourceStatus := status[resource]
+		if len(resourceStatus.PendingRestart) == 0 {
+			continue
+		}
+		_, describedBroker, err := resourceName(resource, anyBroker)
+		if err != nil {
+			return nil, err
+		}
+		configs, err := describeConfigs(admin, describedBroker)
+		if err != nil {
+			log.Warnf("could not check the configs %s of %s pending a restart: %v", strings.Join(resourceStatus.PendingRestart, ","), resource, err)
+			continue
+		}
+		var pending []string
+		for _, key := range resourceStatus.PendingRestart {
+			if !hasValue(configs, key, desired[resource][key]) {
+				pending = append(pending, key)
+			}
+		}
+		if len(pending) < len(resourceStatus.PendingRestart) {
+			log.Infof("the brokers restarted with the configs of %s, still pending a restart: %s", resource, strings.Join(pending, ","))
+			resourceStatus.PendingRestart = pending
+			changed = true
+		}
+	}
+	r.pending = status.hasPendingRestart()
+	if !changed {
+		return nil, nil
+	}
+	for resource, resourceStatus := range status {
+		if len(resourceStatus.Applied) == 0 && len(resourceStatus.PendingRestart) == 0 && len(resourceStatus.Failed) == 0 {
+			delete(status, resource)
+		}
+	}
+	if err := r.saveStatus(status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// connect returns an admin client and the id of a broker of the cluster
+func (r *Reconciler) connect() (sarama.ClusterAdmin, int32, error) {
+	admin, err := r.NewAdmin()
+	if err != nil {
+		return nil, 0, err
+	}
+	brokers, _, err := admin.DescribeCluster()
+	if err != nil {
+		admin.Close()
+		return nil, 0, fmt.Errorf("could not list the brokers: %v", err)
+	}
+	if len(brokers) == 0 {
+		admin.Close()
+		return nil, 0, fmt.Errorf("no broker is available")
+	}
+	return admin, brokers[0].ID(), nil
+}
+
+func (r *Reconciler) reconcileResource(admin sarama.ClusterAdmin, anyBroker int32, resource string, desired map[string]string, previous *ResourceStatus) *ResourceStatus {
+	status := &ResourceStatus{}
+	if previous == nil {
+		previous = &ResourceStatus{}
+	}
+	name, describedBroker, err := resourceName(resource, anyBroker)
+	if err != nil {
+		status.Failed = err.Error()
+		return status
+	}
+	configs, err := describeConfigs(admin, describedBroker)
+	if err != nil {
+		// the keys stay applied, so they are reverted once the broker is back
+		status.Applied = previous.Applied
+		status.Failed = err.Error()
+		// a failure is only reported when it changed, the resource is retried on every run
+		if status.Failed != previous.Failed {
+			r.Events.Eventf(v1.EventTypeWarning, DYNAMIC_CONFIG_FAILED_REASON, "could not reconcile the configs of %s: %v", resource, err)
+		}
+		return status
+	}
+
+	entries := map[string]sarama.IncrementalAlterConfigsEntry{}
+	var unknown []string
+	for _, key := range sortedKeys(desired) {
+		value := desired[key]
+		entry, known := configs[key]
+		switch {
+		// the listener configs are only described once set, the broker validates them when they are altered
+		case !known && !strings.HasPrefix(key, LISTENER_CONFIG_PREFIX):
+			unknown = append(unknown, key)
+			continue
+		// configs which are not dynamic only apply once the broker restarts, unless it already runs with them
+		case known && entry.ReadOnly:
+			if !hasValue(configs, key, value) {
+				status.PendingRestart = append(status.PendingRestart, key)
+			}
+			continue
+		}
+		entries[key] = sarama.IncrementalAlterConfigsEntry{Operation: sarama.IncrementalAlterConfigsOperationSet, Value: &value}
+		status.Applied = append(status.Applied, key)
+	}
+	if len(unknown) > 0 {
+		status.Failed = fmt.Sprintf("unknown configs %s", strings.Join(unknown, ","))
+		if status.Failed != previous.Failed {
+			r.Events.Eventf(v1.EventTypeWarning, DYNAMIC_CONFIG_FAILED_REASON, "the configs %s of %s are unknown to the brokers", strings.Join(unknown, ","), resource)
+		}
+	}
+	var removed []string
+	for _, key := range previous.Applied {
+		if _, ok := entries[key]; !ok {
+			entries[key] = sarama.IncrementalAlterConfigsEntry{Operation: sarama.IncrementalAlterConfigsOperationDelete}
+			removed = append(removed, key)
+		}
+	}
+
+	if len(entries) > 0 {
+		if err := admin.IncrementalAlterConfig(sarama.BrokerResource, name, entries, false); err != nil {
+			status.Applied = previous.Applied
+			status.Failed = fmt.Sprintf("could not alter the configs: %v", err)
+			if status.Failed != previous.Failed {
+				r.Events.Eventf(v1.EventTypeWarning, DYNAMIC_CONFIG_FAILED_REASON, "could not reconcile the configs of %s: %v", resource, err)
+			}
+			return status
+		}
+		log.Infof("applied the configs %s of %s live, reverted %s", strings.Join(status.Applied, ","), resource, strings.Join(removed, ","))
+		r.Events.Eventf(v1.EventTypeNormal, DYNAMIC_CONFIG_APPLIED_REASON, "applied %d configs of %s live, reverted %d",
+			len(status.Applied), resource, len(removed))
+	}
+	if len(status.PendingRestart) > 0 && !reflect.DeepEqual(status.PendingRestart, previous.PendingRestart) {
+		log.Warnf("the configs %s of %s cannot be updated dynamically, they apply once the brokers restart", strings.Join(status.PendingRestart, ","), resource)
+		r.Events.Eventf(v1.EventTypeWarning, DYNAMIC_CONFIG_RESTART_REASON, "the configs %s of %s require a restart of the brokers",
+			strings.Join(status.PendingRestart, ","), resource)
+	}
+	return status
+}
+
+func (r *Reconciler) loadConfigMap() (map[string]string, error) {
+	configMap, err := r.Client.CoreV1().ConfigMaps(r.Env.GetNamespace()).Get(r.ConfigMap, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		// a deleted ConfigMap reverts every config applied from it
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get the ConfigMap %s: %v", r.ConfigMap, err)
+	}
+	if configMap.Data == nil {
+		return map[string]string{}, nil
+	}
+	return configMap.Data, nil
+}
+
+// parseConfigMap returns the desired configs keyed by 'cluster' or 'broker-<id>'
+func parseConfigMap(data map[string]string) (map[string]map[string]string, error) {
+	desired := map[string]map[string]string{}
+	for key, content := range data {
+		var resource string
+		if key == CLUSTER_PROPERTIES_KEY {
+			resource = CLUSTER_RESOURCE
+		} else if match := brokerPropertiesKey.FindStringSubmatch(key); match != nil {
+			brokerID, _ := strconv.Atoi(match[1])
+			resource = fmt.Sprintf(BROKER_RESOURCE_TEMPLATE, brokerID)
+		} else {
+			log.Warnf("ignoring the key %s, expected %s or %s", key, CLUSTER_PROPERTIES_KEY, fmt.Sprintf(BROKER_PROPERTIES_KEY_TEMPLATE, 0))
+			continue
+		}
+		properties, err := config.ParseProperties(strings.NewReader(content))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+		desired[resource] = map[string]string{}
+		for _, name := range properties.Keys() {
+			desired[resource][name], _ = properties.Get(name)
+		}
+	}
+	return desired, nil
+}
+
+// resourceName returns the config resource name of the cluster or a broker, and the broker whose configs describe it
+func resourceName(resource string, anyBroker int32) (string, int32, error) {
+	if resource == CLUSTER_RESOURCE {
+		// the cluster-wide defaults are the broker resource without name
+		return "", anyBroker, nil
+	}
+	var brokerID int32
+	if _, err := fmt.Sscanf(resource, BROKER_RESOURCE_TEMPLATE, &brokerID); err != nil {
+		return "", 0, fmt.Errorf("invalid resource %s", resource)
+	}
+	return strconv.Itoa(int(brokerID)), brokerID, nil
+}
+
+// describeConfigs returns the configs known to a broker keyed by name
+func describeConfigs(admin sarama.ClusterAdmin, brokerID int32) (map[string]sarama.ConfigEntry, error) {
+	entries, err := admin.DescribeConfig(sarama.ConfigResource{
+		Type: sarama.BrokerResource,
+		Name: strconv.Itoa(int(brokerID)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not describe the configs of broker %d: %v", brokerID, err)
+	}
+	configs := map[string]sarama.ConfigEntry{}
+	for _, entry := range entries {
+		configs[entry.Name] = entry
+	}
+	return configs, nil
+}
+
+// hasValue tells whether the broker reports value for key, the value of a sensitive config is never reported
+func hasValue(configs map[string]sarama.ConfigEntry, key, value string) bool {
+	entry, ok := configs[key]
+	return ok && !entry.Sensitive && entry.Value == value
+}
+
+func sortedKeys(properties map[string]string) []string {
+	var keys []string
+	for key := range properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,156 @@
+package dynamic
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	APPLIED_SUFFIX         = ".applied"
+	PENDING_RESTART_SUFFIX = ".pending-restart"
+	FAILED_SUFFIX          = ".failed"
+	UPDATED_KEY            = "updated"
+)
+
+// ResourceStatus records how the configs of the cluster or of a broker were reconciled
+type ResourceStatus struct {
+	// Applied are the keys applied live
+	Applied []string
+	// PendingRestart are the keys which cannot be updated dynamically, they only apply once the brokers restart
+	PendingRestart []string
+	// Failed is the error of the last reconciliation of the resource
+	Failed string
+}
+
+// Status is the reconciliation status keyed by 'cluster' or 'broker-<id>'
+type Status map[string]*ResourceStatus
+
+// StatusConfigMapName returns the name of the ConfigMap holding the reconciliation status of configMap
+func StatusConfigMapName(configMap string) string {
+	return fmt.Sprintf("%s-status", configMap)
+}
+
+// resources returns the resources of the status sorted by name
+func (s Status) resources() []string {
+	var resources []string
+	for resource := range s {
+		resources = append(resources, resource)
+	}
+	sort.Strings(resources)
+	return resources
+}
+
+// hasPendingRestart tells whether configs of any resource are pending a restart
+func (s Status) hasPendingRestart() bool {
+	for _, status := range s {
+		if len(status.PendingRestart) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (s Status) String() string {
+	var b strings.Builder
+	for _, resource := range s.resources() {
+		status := s[resource]
+		fmt.Fprintf(&b, "%s: applied [%s], pending restart [%s]", resource,
+			strings.Join(status.Applied, ","), strings.Join(status.PendingRestart, ","))
+		if len(status.Failed) > 0 {
+			fmt.Fprintf(&b, ", failed: %s", status.Failed)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// LoadStatus returns the status of the last reconciliation, empty when there was none
+func (r *Reconciler) LoadStatus() (Status, error) {
+	status := Status{}
+	name := StatusConfigMapName(r.ConfigMap)
+	configMap, err := r.Client.CoreV1().ConfigMaps(r.Env.GetNamespace()).Get(name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		r.statusVersion = ""
+		return status, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get the ConfigMap %s: %v", name, err)
+	}
+	r.statusVersion = configMap.ResourceVersion
+	for key, value := range configMap.Data {
+		for _, suffix := range []string{APPLIED_SUFFIX, PENDING_RESTART_SUFFIX, FAILED_SUFFIX} {
+			if !strings.HasSuffix(key, suffix) {
+				continue
+			}
+			resource := strings.TrimSuffix(key, suffix)
+			if status[resource] == nil {
+				status[resource] = &ResourceStatus{}
+			}
+			switch suffix {
+			case APPLIED_SUFFIX:
+				status[resource].Applied = splitKeys(value)
+			case PENDING_RESTART_SUFFIX:
+				status[resource].PendingRestart = splitKeys(value)
+			default:
+				status[resource].Failed = value
+			}
+		}
+	}
+	return status, nil
+}
+
+// saveStatus saves the status over the version last loaded. It fails when another broker saved its status in between,
+// the configs are then reconciled again from the status of the other broker.
+func (r *Reconciler) saveStatus(status Status) error {
+	name := StatusConfigMapName(r.ConfigMap)
+	data := map[string]string{
+		UPDATED_KEY: time.Now().UTC().Format(time.RFC3339),
+	}
+	for resource, resourceStatus := range status {
+		data[resource+APPLIED_SUFFIX] = strings.Join(resourceStatus.Applied, ",")
+		data[resource+PENDING_RESTART_SUFFIX] = strings.Join(resourceStatus.PendingRestart, ",")
+		if len(resourceStatus.Failed) > 0 {
+			data[resource+FAILED_SUFFIX] = resourceStatus.Failed
+		}
+	}
+	configMaps := r.Client.CoreV1().ConfigMaps(r.Env.GetNamespace())
+	configMap, err := configMaps.Get(name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		configMap, err = configMaps.Create(&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: r.Env.GetNamespace(),
+			},
+			Data: data,
+		})
+	} else if err == nil {
+		if configMap.ResourceVersion != r.statusVersion {
+			err = errors.NewConflict(v1.Resource("configmaps"), name, fmt.Errorf("the status changed since it was loaded"))
+		} else {
+			configMap.Data = data
+			// the update carries the version read, it is refused when another broker saved its status since
+			configMap, err = configMaps.Update(configMap)
+		}
+	}
+	if errors.IsConflict(err) || errors.IsAlreadyExists(err) {
+		return fmt.Errorf("the ConfigMap %s was updated by another broker, the configs are reconciled again", name)
+	}
+	if err != nil {
+		return fmt.Errorf("could not save the reconciliation status in the ConfigMap %s: %v", name, err)
+	}
+	r.statusVersion = configMap.ResourceVersion
+	return nil
+}
+
+func splitKeys(value string) []string {
+	if len(value) == 0 {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
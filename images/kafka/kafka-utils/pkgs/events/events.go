@@ -0,0 +1,62 @@
+package events
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/service"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	COMPONENT = "kafka-utils"
+)
+
+// Recorder records kubernetes events on the broker pod
+type Recorder struct {
+	Client kubernetes.Interface
+	Env    service.Environment
+}
+
+// Eventf records an event of eventType on the broker pod, failures are logged but never returned
+func (r *Recorder) Eventf(eventType, reason, messageFmt string, args ...interface{}) {
+	message := fmt.Sprintf(messageFmt, args...)
+	namespace := r.Env.GetNamespace()
+	podName := r.Env.GetHostName()
+	involvedObject := v1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Namespace:  namespace,
+		Name:       podName,
+	}
+	if pod, err := r.Client.CoreV1().Pods(namespace).Get(podName, metav1.GetOptions{}); err == nil {
+		involvedObject.UID = pod.UID
+	}
+
+	now := metav1.NewTime(time.Now())
+	_, err := r.Client.CoreV1().Events(namespace).Create(&v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", podName, now.UnixNano()),
+			Namespace: namespace,
+		},
+		InvolvedObject: involvedObject,
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source: v1.EventSource{
+			Component: COMPONENT,
+			Host:      r.Env.GetNodeName(),
+		},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	})
+	if err != nil {
+		log.Errorf("could not record the event %s '%s': %v", reason, message, err)
+		return
+	}
+	log.Infof("recorded the %s event %s: %s", eventType, reason, message)
+}
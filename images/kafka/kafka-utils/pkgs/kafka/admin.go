@@ -0,0 +1,141 @@
+package kafka
+
+//go:generate mockgen -destination=../mocks/cluster_admin_mock.go -package=mocks github.com/Shopify/sarama ClusterAdmin
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/kerberos"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/keystore"
+)
+
+const (
+	PLAINTEXT      = "PLAINTEXT"
+	SSL            = "SSL"
+	SASL_PLAINTEXT = "SASL_PLAINTEXT"
+	SASL_SSL       = "SASL_SSL"
+	CLIENT_ID      = "kafka-utils"
+)
+
+// Config describes how kafka-utils connects to the brokers
+type Config struct {
+	BootstrapServers []string
+	SecurityProtocol string
+	KafkaVersion     string
+	// CertDir holds the PEM files used when the security protocol uses SSL
+	CertDir string
+	// Kerberos and KerberosConfigPath are used when the security protocol uses SASL
+	Kerberos           *kerberos.Config
+	KerberosConfigPath string
+}
+
+// NewClusterAdmin connects an admin client to the brokers
+func NewClusterAdmin(c *Config) (sarama.ClusterAdmin, error) {
+	conf, err := c.saramaConfig()
+	if err != nil {
+		return nil, err
+	}
+	admin, err := sarama.NewClusterAdmin(c.BootstrapServers, conf)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to the brokers %s: %v", strings.Join(c.BootstrapServers, ","), err)
+	}
+	return admin, nil
+}
+
+// ClusterID returns the id of the cluster the brokers belong to
+func ClusterID(c *Config) (string, error) {
+	conf, err := c.saramaConfig()
+	if err != nil {
+		return "", err
+	}
+	client, err := sarama.NewClient(c.BootstrapServers, conf)
+	if err != nil {
+		return "", fmt.Errorf("could not connect to the brokers %s: %v", strings.Join(c.BootstrapServers, ","), err)
+	}
+	defer client.Close()
+	controller, err := client.Controller()
+	if err != nil {
+		return "", fmt.Errorf("could not find the controller: %v", err)
+	}
+	// the cluster id is part of the metadata since version 2
+	metadata, err := controller.GetMetadata(&sarama.MetadataRequest{Version: 2})
+	if err != nil {
+		return "", fmt.Errorf("could not get the cluster metadata: %v", err)
+	}
+	if metadata.ClusterID == nil || len(*metadata.ClusterID) == 0 {
+		return "", fmt.Errorf("the brokers did not report a cluster id")
+	}
+	return *metadata.ClusterID, nil
+}
+
+func (c *Config) saramaConfig() (*sarama.Config, error) {
+	conf := sarama.NewConfig()
+	conf.ClientID = CLIENT_ID
+	version, err := sarama.ParseKafkaVersion(c.KafkaVersion)
+	if err != nil {
+		return nil, err
+	}
+	conf.Version = version
+
+	switch c.SecurityProtocol {
+	case PLAINTEXT:
+	case SSL:
+		if err := c.configureTLS(conf); err != nil {
+			return nil, err
+		}
+	case SASL_PLAINTEXT:
+		c.configureKerberos(conf)
+	case SASL_SSL:
+		if err := c.configureTLS(conf); err != nil {
+			return nil, err
+		}
+		c.configureKerberos(conf)
+	default:
+		return nil, fmt.Errorf("unsupported security protocol '%s'", c.SecurityProtocol)
+	}
+	return conf, conf.Validate()
+}
+
+func (c *Config) configureTLS(conf *sarama.Config) error {
+	bundle, err := keystore.LoadCertificateBundle(c.CertDir)
+	if err != nil {
+		return err
+	}
+	rootCAs := x509.NewCertPool()
+	for _, ca := range bundle.CAs {
+		rootCAs.AddCert(ca)
+	}
+	var chain [][]byte
+	for _, cert := range bundle.Chain {
+		chain = append(chain, cert.Raw)
+	}
+	conf.Net.TLS.Enable = true
+	conf.Net.TLS.Config = &tls.Config{
+		RootCAs: rootCAs,
+		Certificates: []tls.Certificate{
+			{
+				Certificate: chain,
+				PrivateKey:  bundle.PrivateKey,
+				Leaf:        bundle.Certificate(),
+			},
+		},
+	}
+	return nil
+}
+
+func (c *Config) configureKerberos(conf *sarama.Config) {
+	conf.Net.SASL.Enable = true
+	conf.Net.SASL.Mechanism = sarama.SASLTypeGSSAPI
+	conf.Net.SASL.GSSAPI = sarama.GSSAPIConfig{
+		AuthType:           sarama.KRB5_KEYTAB_AUTH,
+		KeyTabPath:         c.Kerberos.KeytabPath,
+		KerberosConfigPath: c.KerberosConfigPath,
+		ServiceName:        c.Kerberos.ServiceName(),
+		Username:           c.Kerberos.Username(),
+		Realm:              c.Kerberos.Realm,
+	}
+}
@@ -0,0 +1,99 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/reporters"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("[Kafka Admin]", func() {
+
+	var (
+		client, server net.Conn
+		received       chan []byte
+	)
+
+	// serve answers the next request with a response holding the given partition errors of the topic orders
+	serve := func(correlationID int32, errorCodes map[int32]sarama.KError) {
+		go func() {
+			defer GinkgoRecover()
+			var size int32
+			Expect(binary.Read(server, binary.BigEndian, &size)).To(Succeed())
+			request := make([]byte, size)
+			_, err := io.ReadFull(server, request)
+			Expect(err).To(BeNil())
+			received <- request
+
+			body := &bytes.Buffer{}
+			putInt32(body, correlationID)
+			putInt32(body, 0)
+			putInt32(body, 1)
+			putString(body, "orders")
+			putInt32(body, int32(len(errorCodes)))
+			for partition, code := range errorCodes {
+				putInt32(body, partition)
+				putInt16(body, int16(code))
+			}
+			response := &bytes.Buffer{}
+			putInt32(response, int32(body.Len()))
+			body.WriteTo(response)
+			_, err = response.WriteTo(server)
+			Expect(err).To(BeNil())
+		}()
+	}
+
+	Context("AlterReplicaLogDirs", func() {
+		It("writes the request on the wire", func() {
+			serve(1, map[int32]sarama.KError{0: sarama.ErrNoError})
+			Expect(alterReplicaLogDirs(client, "/var/lib/kafka/data-1", map[string][]int32{"orders": {0}})).To(Succeed())
+
+			expected := &bytes.Buffer{}
+			putInt16(expected, ALTER_REPLICA_LOG_DIRS_KEY)
+			putInt16(expected, ALTER_REPLICA_LOG_DIRS_VERSION)
+			putInt32(expected, 1)
+			putString(expected, CLIENT_ID)
+			putInt32(expected, 1)
+			putString(expected, "/var/lib/kafka/data-1")
+			putInt32(expected, 1)
+			putString(expected, "orders")
+			putInt32(expected, 1)
+			putInt32(expected, 0)
+			Expect(<-received).To(Equal(expected.Bytes()))
+		})
+		It("reports the partitions which could not be moved", func() {
+			serve(1, map[int32]sarama.KError{3: sarama.ErrKafkaStorageError})
+			err := alterReplicaLogDirs(client, "/var/lib/kafka/data-1", map[string][]int32{"orders": {3}})
+			Expect(err).To(MatchError(ContainSubstring("orders-3")))
+			<-received
+		})
+		It("refuses a response to another request", func() {
+			serve(7, nil)
+			Expect(alterReplicaLogDirs(client, "/var/lib/kafka/data-1", map[string][]int32{"orders": {0}})).To(MatchError(ContainSubstring("correlation id")))
+			<-received
+		})
+	})
+
+	BeforeEach(func() {
+		client, server = net.Pipe()
+		received = make(chan []byte, 1)
+	})
+
+	AfterEach(func() {
+		client.Close()
+		server.Close()
+	})
+})
+
+func TestKafka(t *testing.T) {
+	RegisterFailHandler(Fail)
+	junitReporter := reporters.NewJUnitReporter(fmt.Sprintf("%s-junit.xml", "kafka-utils-kafka"))
+	RunSpecsWithDefaultAndCustomReporters(t, "KafkaUtils Kafka Suite", []Reporter{junitReporter})
+}
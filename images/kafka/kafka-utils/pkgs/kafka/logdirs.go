@@ -0,0 +1,169 @@
+package kafka
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+const (
+	// sarama has no client for AlterReplicaLogDirs, the request is written on the wire directly
+	ALTER_REPLICA_LOG_DIRS_KEY     int16 = 34
+	ALTER_REPLICA_LOG_DIRS_VERSION int16 = 1
+)
+
+// AlterReplicaLogDirs asks a broker to move its replicas of the given topic partitions into one of its log dirs.
+// The move happens in the background, DescribeLogDirs reports a temporary replica until it completes.
+func AlterReplicaLogDirs(c *Config, brokerID int32, dir string, replicas map[string][]int32) error {
+	conf, err := c.saramaConfig()
+	if err != nil {
+		return err
+	}
+	if conf.Net.SASL.Enable {
+		return fmt.Errorf("moving replicas between log dirs is not supported over %s, use a PLAINTEXT or SSL listener", c.SecurityProtocol)
+	}
+	client, err := sarama.NewClient(c.BootstrapServers, conf)
+	if err != nil {
+		return fmt.Errorf("could not connect to the brokers %s: %v", strings.Join(c.BootstrapServers, ","), err)
+	}
+	defer client.Close()
+	broker, err := client.Broker(brokerID)
+	if err != nil {
+		return fmt.Errorf("could not find broker %d: %v", brokerID, err)
+	}
+
+	dialer := &net.Dialer{Timeout: conf.Net.DialTimeout}
+	var conn net.Conn
+	if conf.Net.TLS.Enable {
+		conn, err = tls.DialWithDialer(dialer, "tcp", broker.Addr(), conf.Net.TLS.Config)
+	} else {
+		conn, err = dialer.Dial("tcp", broker.Addr())
+	}
+	if err != nil {
+		return fmt.Errorf("could not connect to broker %d at %s: %v", brokerID, broker.Addr(), err)
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(conf.Net.WriteTimeout + conf.Net.ReadTimeout)); err != nil {
+		return err
+	}
+	return alterReplicaLogDirs(conn, dir, replicas)
+}
+
+func alterReplicaLogDirs(conn io.ReadWriter, dir string, replicas map[string][]int32) error {
+	const correlationID = 1
+	body := &bytes.Buffer{}
+	putInt16(body, ALTER_REPLICA_LOG_DIRS_KEY)
+	putInt16(body, ALTER_REPLICA_LOG_DIRS_VERSION)
+	putInt32(body, correlationID)
+	putString(body, CLIENT_ID)
+	// one log dir holding every replica
+	putInt32(body, 1)
+	putString(body, dir)
+	var topics []string
+	for topic := range replicas {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	putInt32(body, int32(len(topics)))
+	for _, topic := range topics {
+		putString(body, topic)
+		putInt32(body, int32(len(replicas[topic])))
+		for _, partition := range replicas[topic] {
+			putInt32(body, partition)
+		}
+	}
+
+	request := &bytes.Buffer{}
+	putInt32(request, int32(body.Len()))
+	body.WriteTo(request)
+	if _, err := request.WriteTo(conn); err != nil {
+		return fmt.Errorf("could not send the AlterReplicaLogDirs request: %v", err)
+	}
+
+	var size int32
+	if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+		return fmt.Errorf("could not read the AlterReplicaLogDirs response: %v", err)
+	}
+	response := make([]byte, size)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return fmt.Errorf("could not read the AlterReplicaLogDirs response: %v", err)
+	}
+	return decodeAlterReplicaLogDirsResponse(bytes.NewReader(response), correlationID)
+}
+
+func decodeAlterReplicaLogDirsResponse(r io.Reader, correlationID int32) error {
+	var header struct {
+		CorrelationID int32
+		ThrottleTime  int32
+		Topics        int32
+	}
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return fmt.Errorf("malformed AlterReplicaLogDirs response: %v", err)
+	}
+	if header.CorrelationID != correlationID {
+		return fmt.Errorf("malformed AlterReplicaLogDirs response, correlation id %d instead of %d", header.CorrelationID, correlationID)
+	}
+	var failures []string
+	for i := int32(0); i < header.Topics; i++ {
+		topic, err := readString(r)
+		if err != nil {
+			return fmt.Errorf("malformed AlterReplicaLogDirs response: %v", err)
+		}
+		var partitions int32
+		if err := binary.Read(r, binary.BigEndian, &partitions); err != nil {
+			return fmt.Errorf("malformed AlterReplicaLogDirs response: %v", err)
+		}
+		for j := int32(0); j < partitions; j++ {
+			var result struct {
+				Partition int32
+				ErrorCode int16
+			}
+			if err := binary.Read(r, binary.BigEndian, &result); err != nil {
+				return fmt.Errorf("malformed AlterReplicaLogDirs response: %v", err)
+			}
+			if result.ErrorCode != int16(sarama.ErrNoError) {
+				failures = append(failures, fmt.Sprintf("%s-%d: %v", topic, result.Partition, sarama.KError(result.ErrorCode)))
+			}
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("could not move the replicas %s", strings.Join(failures, ", "))
+	}
+	return nil
+}
+
+func putInt16(b *bytes.Buffer, value int16) {
+	binary.Write(b, binary.BigEndian, value)
+}
+
+func putInt32(b *bytes.Buffer, value int32) {
+	binary.Write(b, binary.BigEndian, value)
+}
+
+func putString(b *bytes.Buffer, value string) {
+	putInt16(b, int16(len(value)))
+	b.WriteString(value)
+}
+
+func readString(r io.Reader) (string, error) {
+	var length int16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return "", err
+	}
+	if length < 0 {
+		return "", nil
+	}
+	value := make([]byte, length)
+	if _, err := io.ReadFull(r, value); err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
@@ -0,0 +1,202 @@
+package kerberos
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	KRB5_CONF_FILE         = "krb5.conf"
+	SERVER_JAAS_CONF_FILE  = "kafka_server_jaas.conf"
+	CLIENT_JAAS_CONF_FILE  = "kafka_client_jaas.conf"
+	HOST_PLACEHOLDER       = "_HOST"
+	DEFAULT_REALM          = "LOCAL"
+	DEFAULT_PRIMARY        = "kafka"
+	DEFAULT_KDC_PORT       = "88"
+	DEFAULT_KEYTAB_FILE    = "kafka.keytab"
+	KERBEROS_ENABLED_ENV   = "KERBEROS_ENABLED"
+	KERBEROS_REALM_ENV     = "KERBEROS_REALM"
+	KERBEROS_KDC_HOST_ENV  = "KERBEROS_KDC_HOSTNAME"
+	KERBEROS_KDC_PORT_ENV  = "KERBEROS_KDC_PORT"
+	KERBEROS_KDCS_ENV      = "KERBEROS_KDC_ADDRESSES"
+	KERBEROS_PRIMARY_ENV   = "KERBEROS_PRIMARY"
+	KERBEROS_PRINCIPAL_ENV = "KERBEROS_PRINCIPAL"
+	KERBEROS_KEYTAB_ENV    = "KERBEROS_KEYTAB_PATH"
+)
+
+var krb5ConfTemplate = template.Must(template.New(KRB5_CONF_FILE).Parse(`[libdefaults]
+  default_realm = {{ .Realm }}
+  dns_lookup_realm = false
+  dns_lookup_kdc = false
+  udp_preference_limit = 1
+
+[realms]
+  {{ .Realm }} = {
+{{- range .KDCs }}
+    kdc = {{ . }}
+{{- end }}
+  }
+`))
+
+var jaasTemplate = template.Must(template.New("jaas").Parse(`{{ .Section }} {
+  com.sun.security.auth.module.Krb5LoginModule required
+  useKeyTab=true
+  storeKey=true
+  useTicketCache=false
+  keyTab="{{ .KeytabPath }}"
+  principal="{{ .Principal }}";
+};
+`))
+
+type jaasSection struct {
+	*Config
+	Section string
+}
+
+// Config holds the structured inputs used to render the kerberos configuration of a broker
+type Config struct {
+	Realm string
+	// KDCs is the list of KDC addresses in host:port form
+	KDCs []string
+	// PrincipalPattern is the broker principal, '_HOST' is replaced by the FQDN of the broker
+	PrincipalPattern string
+	KeytabPath       string
+	FQDN             string
+}
+
+// IsEnabled returns true when kerberos is enabled for the broker
+func IsEnabled() bool {
+	return strings.EqualFold(os.Getenv(KERBEROS_ENABLED_ENV), "true")
+}
+
+// NewConfigFromEnv builds the kerberos configuration from the broker environment variables
+func NewConfigFromEnv(kafkaHome, hostname string) *Config {
+	realm := getEnvOrDefault(KERBEROS_REALM_ENV, DEFAULT_REALM)
+	primary := getEnvOrDefault(KERBEROS_PRIMARY_ENV, DEFAULT_PRIMARY)
+
+	var kdcs []string
+	if addresses := os.Getenv(KERBEROS_KDCS_ENV); len(addresses) > 0 {
+		for _, address := range strings.Split(addresses, ",") {
+			if address = strings.TrimSpace(address); len(address) > 0 {
+				kdcs = append(kdcs, address)
+			}
+		}
+	} else if kdcHost := os.Getenv(KERBEROS_KDC_HOST_ENV); len(kdcHost) > 0 {
+		kdcs = append(kdcs, net.JoinHostPort(kdcHost, getEnvOrDefault(KERBEROS_KDC_PORT_ENV, DEFAULT_KDC_PORT)))
+	}
+
+	return &Config{
+		Realm:            realm,
+		KDCs:             kdcs,
+		PrincipalPattern: getEnvOrDefault(KERBEROS_PRINCIPAL_ENV, fmt.Sprintf("%s/%s@%s", primary, HOST_PLACEHOLDER, realm)),
+		KeytabPath:       getEnvOrDefault(KERBEROS_KEYTAB_ENV, filepath.Join(kafkaHome, DEFAULT_KEYTAB_FILE)),
+		FQDN:             lookupFQDN(hostname),
+	}
+}
+
+// Principal returns the broker principal with the FQDN substituted and the realm appended if missing
+func (c *Config) Principal() string {
+	principal := strings.Replace(c.PrincipalPattern, HOST_PLACEHOLDER, strings.ToLower(c.FQDN), -1)
+	if !strings.Contains(principal, "@") {
+		principal = fmt.Sprintf("%s@%s", principal, c.Realm)
+	}
+	return principal
+}
+
+// Username returns the broker principal without its realm
+func (c *Config) Username() string {
+	return strings.SplitN(c.Principal(), "@", 2)[0]
+}
+
+// ServiceName returns the primary of the broker principal
+func (c *Config) ServiceName() string {
+	return strings.SplitN(c.Username(), "/", 2)[0]
+}
+
+// Validate checks the configuration is complete and the keytab contains the broker principal
+func (c *Config) Validate() error {
+	if len(c.Realm) == 0 {
+		return fmt.Errorf("kerberos realm is not set")
+	}
+	if len(c.KDCs) == 0 {
+		return fmt.Errorf("no KDC address configured, set %s or %s", KERBEROS_KDCS_ENV, KERBEROS_KDC_HOST_ENV)
+	}
+	if strings.Contains(c.PrincipalPattern, HOST_PLACEHOLDER) && len(c.FQDN) == 0 {
+		return fmt.Errorf("principal pattern '%s' requires the broker FQDN", c.PrincipalPattern)
+	}
+	return c.validateKeytab()
+}
+
+func (c *Config) validateKeytab() error {
+	kt, err := keytab.Load(c.KeytabPath)
+	if err != nil {
+		return fmt.Errorf("could not load the keytab '%s': %v", c.KeytabPath, err)
+	}
+	principal := c.Principal()
+	for _, entry := range kt.Entries {
+		if entry.Principal.String() == principal {
+			log.Infof("found principal %s in keytab %s", principal, c.KeytabPath)
+			return nil
+		}
+	}
+	return fmt.Errorf("keytab '%s' does not contain the principal '%s'", c.KeytabPath, principal)
+}
+
+// WriteConfigToPath validates the configuration and writes the krb5.conf and the JAAS files into path
+func (c *Config) WriteConfigToPath(path string) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	if err := writeTemplate(krb5ConfTemplate, c, filepath.Join(path, KRB5_CONF_FILE)); err != nil {
+		return err
+	}
+	if err := writeTemplate(jaasTemplate, jaasSection{c, "KafkaServer"}, filepath.Join(path, SERVER_JAAS_CONF_FILE)); err != nil {
+		return err
+	}
+	return writeTemplate(jaasTemplate, jaasSection{c, "KafkaClient"}, filepath.Join(path, CLIENT_JAAS_CONF_FILE))
+}
+
+func writeTemplate(tmpl *template.Template, data interface{}, path string) error {
+	file, err := os.OpenFile(path, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Errorf("failed creating file '%s': %s", path, err)
+		return err
+	}
+	defer file.Close()
+	dataWriter := bufio.NewWriter(file)
+	if err := tmpl.Execute(dataWriter, data); err != nil {
+		return fmt.Errorf("could not render '%s': %v", path, err)
+	}
+	if err := dataWriter.Flush(); err != nil {
+		return err
+	}
+	log.Infof("created the %s file", path)
+	return nil
+}
+
+func lookupFQDN(hostname string) string {
+	if len(hostname) == 0 {
+		return ""
+	}
+	cname, err := net.LookupCNAME(hostname)
+	if err != nil || len(cname) == 0 {
+		log.Infof("could not resolve the FQDN of %s, using the hostname: %v", hostname, err)
+		return hostname
+	}
+	return strings.TrimSuffix(cname, ".")
+}
+
+func getEnvOrDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); len(value) > 0 {
+		return value
+	}
+	return defaultValue
+}
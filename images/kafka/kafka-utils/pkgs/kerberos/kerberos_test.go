@@ -0,0 +1,134 @@
+package kerberos
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/reporters"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("[Kafka Kerberos]", func() {
+
+	var (
+		dir    string
+		config *Config
+	)
+
+	Context("Principal", func() {
+		It("substitutes the broker FQDN", func() {
+			Expect(config.Principal()).To(Equal("kafka/kafka-kafka-0.kafka-svc.default.svc.cluster.local@LOCAL"))
+		})
+		It("appends the realm when missing", func() {
+			config.PrincipalPattern = "kafka/_HOST"
+			config.Realm = "EXAMPLE.COM"
+			Expect(config.Principal()).To(Equal("kafka/kafka-kafka-0.kafka-svc.default.svc.cluster.local@EXAMPLE.COM"))
+		})
+	})
+
+	Context("Configuration rendering", func() {
+		It("renders krb5.conf and the JAAS files", func() {
+			writeKeytab(config.KeytabPath, "kafka/kafka-kafka-0.kafka-svc.default.svc.cluster.local", "LOCAL")
+			Expect(config.WriteConfigToPath(dir)).To(BeNil())
+
+			Expect(readFileAsString(filepath.Join(dir, KRB5_CONF_FILE))).To(Equal(`[libdefaults]
+  default_realm = LOCAL
+  dns_lookup_realm = false
+  dns_lookup_kdc = false
+  udp_preference_limit = 1
+
+[realms]
+  LOCAL = {
+    kdc = kdc-service:2500
+    kdc = kdc-backup:88
+  }
+`))
+			Expect(readFileAsString(filepath.Join(dir, SERVER_JAAS_CONF_FILE))).To(Equal(fmt.Sprintf(`KafkaServer {
+  com.sun.security.auth.module.Krb5LoginModule required
+  useKeyTab=true
+  storeKey=true
+  useTicketCache=false
+  keyTab="%s"
+  principal="kafka/kafka-kafka-0.kafka-svc.default.svc.cluster.local@LOCAL";
+};
+`, config.KeytabPath)))
+			Expect(readFileAsString(filepath.Join(dir, CLIENT_JAAS_CONF_FILE))).To(HavePrefix("KafkaClient {"))
+		})
+		It("refuses a keytab without the broker principal", func() {
+			writeKeytab(config.KeytabPath, "kafka/kafka-kafka-1.kafka-svc.default.svc.cluster.local", "LOCAL")
+			err := config.WriteConfigToPath(dir)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("does not contain the principal"))
+			Expect(filepath.Join(dir, SERVER_JAAS_CONF_FILE)).NotTo(BeAnExistingFile())
+		})
+		It("refuses a missing keytab", func() {
+			Expect(config.WriteConfigToPath(dir)).To(HaveOccurred())
+		})
+		It("refuses a configuration without KDC", func() {
+			writeKeytab(config.KeytabPath, "kafka/kafka-kafka-0.kafka-svc.default.svc.cluster.local", "LOCAL")
+			config.KDCs = nil
+			Expect(config.WriteConfigToPath(dir)).To(HaveOccurred())
+		})
+	})
+
+	Context("Environment", func() {
+		It("reads the KDC address from the operator parameters", func() {
+			os.Setenv(KERBEROS_KDC_HOST_ENV, "kdc-service")
+			os.Setenv(KERBEROS_KDC_PORT_ENV, "2500")
+			defer os.Unsetenv(KERBEROS_KDC_HOST_ENV)
+			defer os.Unsetenv(KERBEROS_KDC_PORT_ENV)
+
+			envConfig := NewConfigFromEnv("/opt/kafka", "")
+			Expect(envConfig.KDCs).To(Equal([]string{"kdc-service:2500"}))
+			Expect(envConfig.Realm).To(Equal(DEFAULT_REALM))
+			Expect(envConfig.PrincipalPattern).To(Equal("kafka/_HOST@LOCAL"))
+			Expect(envConfig.KeytabPath).To(Equal("/opt/kafka/kafka.keytab"))
+		})
+	})
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("/tmp", "kafka-kerberos-test")
+		Expect(err).To(BeNil())
+		config = &Config{
+			Realm:            "LOCAL",
+			KDCs:             []string{"kdc-service:2500", "kdc-backup:88"},
+			PrincipalPattern: "kafka/_HOST@LOCAL",
+			KeytabPath:       filepath.Join(dir, DEFAULT_KEYTAB_FILE),
+			FQDN:             "kafka-kafka-0.kafka-svc.default.svc.cluster.local",
+		}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+})
+
+func writeKeytab(path, principal, realm string) {
+	kt := keytab.New()
+	Expect(kt.AddEntry(principal, realm, "password", time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96)).To(BeNil())
+	b, err := kt.Marshal()
+	Expect(err).To(BeNil())
+	Expect(ioutil.WriteFile(path, b, 0600)).To(BeNil())
+}
+
+func readFileAsString(path string) string {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return string(content)
+}
+
+func TestKerberos(t *testing.T) {
+	RegisterFailHandler(Fail)
+	junitReporter := reporters.NewJUnitReporter(fmt.Sprintf("%s-junit.xml", "kafka-utils-kerberos"))
+	RunSpecsWithDefaultAndCustomReporters(t, "KafkaUtils Kerberos Suite", []Reporter{junitReporter})
+}
@@ -0,0 +1,156 @@
+package keystore
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	TLS_CERT_FILE = "tls.crt"
+	TLS_KEY_FILE  = "tls.key"
+	CA_CERT_FILE  = "ca.crt"
+)
+
+// CertificateBundle holds the broker key pair and the certificates it should trust
+type CertificateBundle struct {
+	PrivateKey crypto.PrivateKey
+	// Chain starts with the broker certificate followed by its intermediates
+	Chain []*x509.Certificate
+	CAs   []*x509.Certificate
+}
+
+// Certificate returns the leaf certificate of the broker
+func (b *CertificateBundle) Certificate() *x509.Certificate {
+	return b.Chain[0]
+}
+
+// LoadCertificateBundle reads the tls.crt, tls.key and optional ca.crt of a mounted kubernetes TLS secret
+func LoadCertificateBundle(dir string) (*CertificateBundle, error) {
+	certPEM, err := ioutil.ReadFile(filepath.Join(dir, TLS_CERT_FILE))
+	if err != nil {
+		return nil, fmt.Errorf("could not read the certificate: %v", err)
+	}
+	chain, err := parseCertificates(certPEM)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse '%s': %v", TLS_CERT_FILE, err)
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no certificate found in '%s'", filepath.Join(dir, TLS_CERT_FILE))
+	}
+
+	keyPEM, err := ioutil.ReadFile(filepath.Join(dir, TLS_KEY_FILE))
+	if err != nil {
+		return nil, fmt.Errorf("could not read the private key: %v", err)
+	}
+	privateKey, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse '%s': %v", TLS_KEY_FILE, err)
+	}
+
+	if err := checkKeyPair(privateKey, chain[0]); err != nil {
+		return nil, err
+	}
+
+	bundle := &CertificateBundle{
+		PrivateKey: privateKey,
+		Chain:      chain,
+	}
+
+	caPEM, err := ioutil.ReadFile(filepath.Join(dir, CA_CERT_FILE))
+	switch {
+	case err == nil:
+		if bundle.CAs, err = parseCertificates(caPEM); err != nil {
+			return nil, fmt.Errorf("could not parse '%s': %v", CA_CERT_FILE, err)
+		}
+	case os.IsNotExist(err):
+	default:
+		return nil, fmt.Errorf("could not read the CA certificate: %v", err)
+	}
+	if len(bundle.CAs) == 0 {
+		// without a CA bundle trust the top of the chain, which is the certificate itself when self-signed
+		bundle.CAs = []*x509.Certificate{chain[len(chain)-1]}
+	}
+	return bundle, nil
+}
+
+// Checksum returns a digest of the certificate files found in dir, missing files are skipped
+func Checksum(dir string) (string, error) {
+	hash := sha256.New()
+	for _, name := range []string{TLS_CERT_FILE, TLS_KEY_FILE, CA_CERT_FILE} {
+		content, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		hash.Write([]byte(name))
+		hash.Write(content)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// checkKeyPair fails when the private key is not the one of the certificate, e.g. when the files come from two secrets
+func checkKeyPair(privateKey crypto.PrivateKey, cert *x509.Certificate) error {
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("unsupported private key type %T", privateKey)
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return fmt.Errorf("could not encode the public key of '%s': %v", TLS_KEY_FILE, err)
+	}
+	certificateKey, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return fmt.Errorf("could not encode the public key of '%s': %v", TLS_CERT_FILE, err)
+	}
+	if !bytes.Equal(publicKey, certificateKey) {
+		return fmt.Errorf("the private key of '%s' does not match the certificate %s of '%s'", TLS_KEY_FILE, cert.Subject, TLS_CERT_FILE)
+	}
+	return nil
+}
+
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs, nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+}
+
+func parsePrivateKey(data []byte) (crypto.PrivateKey, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no private key found")
+		}
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			return x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			return x509.ParseECPrivateKey(block.Bytes)
+		case "PRIVATE KEY":
+			return x509.ParsePKCS8PrivateKey(block.Bytes)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package keystore
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/events"
+	jks "github.com/pavel-v-chernykh/keystore-go/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+const (
+	KEYSTORE                    = "keystore"
+	TRUSTSTORE                  = "truststore"
+	CERTIFICATE_EXPIRING_REASON = "CertificateExpiring"
+	CERTIFICATE_EXPIRED_REASON  = "CertificateExpired"
+)
+
+// StoredCertificate is a certificate found in the broker keystore or truststore
+type StoredCertificate struct {
+	Store       string
+	Alias       string
+	Certificate *x509.Certificate
+}
+
+func (s StoredCertificate) key() string {
+	return fmt.Sprintf("%s/%s/%s", s.Store, s.Alias, s.Certificate.SerialNumber.Text(16))
+}
+
+// ExpiryMonitor exports the expiry time of the stored certificates and warns before they expire
+type ExpiryMonitor struct {
+	Keystore *KeystoreService
+	Events   *events.Recorder
+	// Thresholds are the remaining validity periods at which a warning event is recorded
+	Thresholds []time.Duration
+	Expiry     *prometheus.GaugeVec
+
+	// warned holds the smallest threshold already reported per certificate
+	warned map[string]time.Duration
+}
+
+// NewExpiryMonitor creates the monitor and its expiry gauge, the gauge still needs to be registered
+func NewExpiryMonitor(keystoreService *KeystoreService, recorder *events.Recorder, thresholds []time.Duration) *ExpiryMonitor {
+	return &ExpiryMonitor{
+		Keystore:   keystoreService,
+		Events:     recorder,
+		Thresholds: thresholds,
+		Expiry: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "kafka_broker_certificate_expiry_timestamp_seconds",
+			Help: "Expiry time of the certificates in the broker keystore and truststore in seconds since epoch.",
+		}, []string{"store", "alias", "subject", "serial"}),
+		warned: map[string]time.Duration{},
+	}
+}
+
+// Run checks the certificates every interval until stop is closed
+func (m *ExpiryMonitor) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := m.Check(); err != nil {
+			log.Errorf("could not check the certificates expiry: %v", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check refreshes the expiry gauge and records the warning events of the certificates crossing a threshold
+func (m *ExpiryMonitor) Check() error {
+	certs, err := m.Keystore.ReadCertificates()
+	if err != nil {
+		return err
+	}
+	m.Expiry.Reset()
+	for _, stored := range certs {
+		cert := stored.Certificate
+		m.Expiry.WithLabelValues(stored.Store, stored.Alias, cert.Subject.String(), cert.SerialNumber.Text(16)).Set(float64(cert.NotAfter.Unix()))
+		m.warn(stored)
+	}
+	return nil
+}
+
+func (m *ExpiryMonitor) warn(stored StoredCertificate) {
+	cert := stored.Certificate
+	remaining := time.Until(cert.NotAfter)
+	if remaining <= 0 {
+		if _, found := m.warned[stored.key()]; !found || m.warned[stored.key()] > 0 {
+			m.Events.Eventf(v1.EventTypeWarning, CERTIFICATE_EXPIRED_REASON, "certificate %s with serial %s in the %s expired on %s",
+				cert.Subject.String(), cert.SerialNumber.Text(16), stored.Store, cert.NotAfter.Format(time.RFC3339))
+			m.warned[stored.key()] = 0
+		}
+		return
+	}
+
+	var crossed time.Duration
+	for _, threshold := range m.Thresholds {
+		if remaining <= threshold && (crossed == 0 || threshold < crossed) {
+			crossed = threshold
+		}
+	}
+	if crossed == 0 {
+		return
+	}
+	if warned, found := m.warned[stored.key()]; found && warned <= crossed {
+		return
+	}
+	m.Events.Eventf(v1.EventTypeWarning, CERTIFICATE_EXPIRING_REASON, "certificate %s with serial %s in the %s expires on %s, in less than %d days",
+		cert.Subject.String(), cert.SerialNumber.Text(16), stored.Store, cert.NotAfter.Format(time.RFC3339), int(crossed.Hours()/24))
+	m.warned[stored.key()] = crossed
+}
+
+// ReadCertificates returns every certificate of the broker keystore and truststore
+func (k *KeystoreService) ReadCertificates() ([]StoredCertificate, error) {
+	passwords, err := k.getPasswords()
+	if err != nil {
+		return nil, err
+	}
+	keystoreData, err := ioutil.ReadFile(k.KeystorePath())
+	if err != nil {
+		return nil, err
+	}
+	truststoreData, err := ioutil.ReadFile(k.TruststorePath())
+	if err != nil {
+		return nil, err
+	}
+
+	if k.StoreType == JKS {
+		keystoreCerts, err := readJKS(KEYSTORE, keystoreData, passwords.Keystore)
+		if err != nil {
+			return nil, err
+		}
+		truststoreCerts, err := readJKS(TRUSTSTORE, truststoreData, passwords.Truststore)
+		if err != nil {
+			return nil, err
+		}
+		return append(keystoreCerts, truststoreCerts...), nil
+	}
+
+	_, cert, caCerts, err := pkcs12.DecodeChain(keystoreData, passwords.Keystore)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the keystore %s: %v", k.KeystorePath(), err)
+	}
+	certs := []StoredCertificate{{Store: KEYSTORE, Alias: KEY_ALIAS, Certificate: cert}}
+	for i, caCert := range caCerts {
+		certs = append(certs, StoredCertificate{Store: KEYSTORE, Alias: fmt.Sprintf("%s-chain-%d", KEY_ALIAS, i+1), Certificate: caCert})
+	}
+	trusted, err := pkcs12.DecodeTrustStore(truststoreData, passwords.Truststore)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the truststore %s: %v", k.TruststorePath(), err)
+	}
+	for i, trustedCert := range trusted {
+		certs = append(certs, StoredCertificate{Store: TRUSTSTORE, Alias: fmt.Sprintf("ca-%d", i), Certificate: trustedCert})
+	}
+	return certs, nil
+}
+
+func readJKS(store string, data []byte, password string) ([]StoredCertificate, error) {
+	ks := jks.New()
+	if err := ks.Load(bytes.NewReader(data), []byte(password)); err != nil {
+		return nil, fmt.Errorf("could not read the %s: %v", store, err)
+	}
+	var certs []StoredCertificate
+	for _, alias := range ks.Aliases() {
+		var chain []jks.Certificate
+		if ks.IsPrivateKeyEntry(alias) {
+			entry, err := ks.GetPrivateKeyEntry(alias, []byte(password))
+			if err != nil {
+				return nil, err
+			}
+			chain = entry.CertificateChain
+		} else {
+			entry, err := ks.GetTrustedCertificateEntry(alias)
+			if err != nil {
+				return nil, err
+			}
+			chain = []jks.Certificate{entry.Certificate}
+		}
+		for i, raw := range chain {
+			cert, err := x509.ParseCertificate(raw.Content)
+			if err != nil {
+				return nil, fmt.Errorf("could not parse the certificate %s of the %s: %v", alias, store, err)
+			}
+			certAlias := alias
+			if i > 0 {
+				certAlias = fmt.Sprintf("%s-chain-%d", alias, i)
+			}
+			certs = append(certs, StoredCertificate{Store: store, Alias: certAlias, Certificate: cert})
+		}
+	}
+	return certs, nil
+}
@@ -0,0 +1,198 @@
+package keystore
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/config"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/service"
+	jks "github.com/pavel-v-chernykh/keystore-go/v4"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+const (
+	PKCS12               = "PKCS12"
+	JKS                  = "JKS"
+	DEFAULT_CERT_DIR     = "/etc/tls/certs"
+	DEFAULT_KEYSTORE_DIR = "/home/kafka/tls"
+	KEYSTORE_FILE        = "kafka.server.keystore"
+	TRUSTSTORE_FILE      = "kafka.server.truststore"
+	SSL_PROPERTIES_FILE  = "ssl.properties"
+	KEY_ALIAS            = "kafka"
+)
+
+// KeystoreService builds the broker keystore and truststore from a mounted kubernetes TLS secret
+type KeystoreService struct {
+	Client      kubernetes.Interface
+	Env         service.Environment
+	CertDir     string
+	KeystoreDir string
+	StoreType   string
+	// ConfigPath is the broker config the ssl properties are merged into, when it exists
+	ConfigPath string
+}
+
+// KeystorePath returns the location of the broker keystore
+func (k *KeystoreService) KeystorePath() string {
+	return filepath.Join(k.KeystoreDir, KEYSTORE_FILE+k.fileExtension())
+}
+
+// TruststorePath returns the location of the broker truststore
+func (k *KeystoreService) TruststorePath() string {
+	return filepath.Join(k.KeystoreDir, TRUSTSTORE_FILE+k.fileExtension())
+}
+
+func (k *KeystoreService) fileExtension() string {
+	if k.StoreType == JKS {
+		return ".jks"
+	}
+	return ".p12"
+}
+
+// BuildStores converts the PEM files of CertDir into a keystore, a truststore and the matching ssl properties
+func (k *KeystoreService) BuildStores() (*CertificateBundle, error) {
+	if k.StoreType != PKCS12 && k.StoreType != JKS {
+		return nil, fmt.Errorf("unsupported keystore type '%s'", k.StoreType)
+	}
+	bundle, err := LoadCertificateBundle(k.CertDir)
+	if err != nil {
+		return nil, err
+	}
+	passwords, err := k.getOrCreatePasswords()
+	if err != nil {
+		return nil, err
+	}
+
+	var keystoreData, truststoreData []byte
+	if k.StoreType == JKS {
+		keystoreData, truststoreData, err = encodeJKS(bundle, passwords)
+	} else {
+		keystoreData, truststoreData, err = encodePKCS12(bundle, passwords)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(k.KeystoreDir, 0700); err != nil {
+		return nil, err
+	}
+	if err := WriteFileAtomically(k.KeystorePath(), keystoreData, 0600); err != nil {
+		return nil, err
+	}
+	if err := WriteFileAtomically(k.TruststorePath(), truststoreData, 0600); err != nil {
+		return nil, err
+	}
+	sslPropertiesPath := filepath.Join(k.KeystoreDir, SSL_PROPERTIES_FILE)
+	if err := WriteFileAtomically(sslPropertiesPath, k.sslProperties(passwords), 0600); err != nil {
+		return nil, err
+	}
+	// the bootstrap merges the file when it runs after the stores are built
+	if _, err := os.Stat(k.ConfigPath); len(k.ConfigPath) > 0 && err == nil {
+		if err := config.MergeFileIntoPath(sslPropertiesPath, k.ConfigPath); err != nil {
+			return nil, err
+		}
+	}
+	log.Infof("built the %s keystore %s for certificate serial %s expiring on %s",
+		k.StoreType, k.KeystorePath(), bundle.Certificate().SerialNumber.Text(16), bundle.Certificate().NotAfter.Format(time.RFC3339))
+	return bundle, nil
+}
+
+func (k *KeystoreService) sslProperties(passwords *Passwords) []byte {
+	properties := []string{
+		fmt.Sprintf("ssl.keystore.type=%s", k.StoreType),
+		fmt.Sprintf("ssl.keystore.location=%s", k.KeystorePath()),
+		fmt.Sprintf("ssl.keystore.password=%s", passwords.Keystore),
+		fmt.Sprintf("ssl.key.password=%s", passwords.Keystore),
+		fmt.Sprintf("ssl.truststore.type=%s", k.StoreType),
+		fmt.Sprintf("ssl.truststore.location=%s", k.TruststorePath()),
+		fmt.Sprintf("ssl.truststore.password=%s", passwords.Truststore),
+	}
+	return []byte(strings.Join(properties, "\n") + "\n")
+}
+
+func encodePKCS12(bundle *CertificateBundle, passwords *Passwords) ([]byte, []byte, error) {
+	keystoreData, err := pkcs12.Encode(rand.Reader, bundle.PrivateKey, bundle.Certificate(), bundle.Chain[1:], passwords.Keystore)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not encode the PKCS12 keystore: %v", err)
+	}
+	truststoreData, err := pkcs12.EncodeTrustStore(rand.Reader, bundle.CAs, passwords.Truststore)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not encode the PKCS12 truststore: %v", err)
+	}
+	return keystoreData, truststoreData, nil
+}
+
+func encodeJKS(bundle *CertificateBundle, passwords *Passwords) ([]byte, []byte, error) {
+	privateKey, err := x509.MarshalPKCS8PrivateKey(bundle.PrivateKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not encode the private key: %v", err)
+	}
+	now := time.Now()
+
+	var chain []jks.Certificate
+	for _, cert := range bundle.Chain {
+		chain = append(chain, jks.Certificate{Type: "X509", Content: cert.Raw})
+	}
+	ks := jks.New()
+	err = ks.SetPrivateKeyEntry(KEY_ALIAS, jks.PrivateKeyEntry{
+		CreationTime:     now,
+		PrivateKey:       privateKey,
+		CertificateChain: chain,
+	}, []byte(passwords.Keystore))
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not add the private key to the JKS keystore: %v", err)
+	}
+	var keystoreData bytes.Buffer
+	if err := ks.Store(&keystoreData, []byte(passwords.Keystore)); err != nil {
+		return nil, nil, fmt.Errorf("could not encode the JKS keystore: %v", err)
+	}
+
+	ts := jks.New()
+	for i, cert := range bundle.CAs {
+		err := ts.SetTrustedCertificateEntry(fmt.Sprintf("ca-%d", i), jks.TrustedCertificateEntry{
+			CreationTime: now,
+			Certificate:  jks.Certificate{Type: "X509", Content: cert.Raw},
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not add a CA certificate to the JKS truststore: %v", err)
+		}
+	}
+	var truststoreData bytes.Buffer
+	if err := ts.Store(&truststoreData, []byte(passwords.Truststore)); err != nil {
+		return nil, nil, fmt.Errorf("could not encode the JKS truststore: %v", err)
+	}
+	return keystoreData.Bytes(), truststoreData.Bytes(), nil
+}
+
+// WriteFileAtomically replaces path so readers never observe a partially written store or certificate
+func WriteFileAtomically(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	log.Infof("created the %s file", path)
+	return nil
+}
@@ -0,0 +1,399 @@
+package keystore
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/golang/mock/gomock"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/events"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/mocks"
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/reporters"
+	. "github.com/onsi/gomega"
+	jks "github.com/pavel-v-chernykh/keystore-go/v4"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testclient "k8s.io/client-go/kubernetes/fake"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+var _ = Describe("[Kafka Keystore]", func() {
+
+	var (
+		mockCtrl        *gomock.Controller
+		mockEnv         *mocks.MockEnvironment
+		dir             string
+		keystoreService *KeystoreService
+	)
+
+	Context("PKCS12 stores", func() {
+		It("builds the keystore and the truststore from the TLS secret", func() {
+			serial := writeCertificate(keystoreService.CertDir)
+			bundle, err := keystoreService.BuildStores()
+			Expect(err).To(BeNil())
+			Expect(bundle.Certificate().SerialNumber.Int64()).To(Equal(serial))
+
+			secret, err := keystoreService.Client.CoreV1().Secrets("default").Get("kafka-kafka-0-keystore-passwords", metav1.GetOptions{})
+			Expect(err).To(BeNil())
+			password := string(secret.Data[KEYSTORE_PASSWORD_KEY])
+			Expect(password).To(HaveLen(2 * PASSWORD_BYTES))
+
+			keystoreData, err := ioutil.ReadFile(keystoreService.KeystorePath())
+			Expect(err).To(BeNil())
+			_, cert, err := pkcs12.Decode(keystoreData, password)
+			Expect(err).To(BeNil())
+			Expect(cert.SerialNumber.Int64()).To(Equal(serial))
+
+			truststoreData, err := ioutil.ReadFile(keystoreService.TruststorePath())
+			Expect(err).To(BeNil())
+			trusted, err := pkcs12.DecodeTrustStore(truststoreData, string(secret.Data[TRUSTSTORE_PASSWORD_KEY]))
+			Expect(err).To(BeNil())
+			Expect(trusted).To(HaveLen(1))
+
+			properties, err := ioutil.ReadFile(filepath.Join(keystoreService.KeystoreDir, SSL_PROPERTIES_FILE))
+			Expect(err).To(BeNil())
+			Expect(string(properties)).To(ContainSubstring(fmt.Sprintf("ssl.keystore.password=%s\n", password)))
+			Expect(string(properties)).To(ContainSubstring("ssl.keystore.type=PKCS12\n"))
+		})
+		It("reuses the passwords stored in the secret", func() {
+			writeCertificate(keystoreService.CertDir)
+			_, err := keystoreService.BuildStores()
+			Expect(err).To(BeNil())
+			first, err := keystoreService.getOrCreatePasswords()
+			Expect(err).To(BeNil())
+
+			writeCertificate(keystoreService.CertDir)
+			_, err = keystoreService.BuildStores()
+			Expect(err).To(BeNil())
+			second, err := keystoreService.getOrCreatePasswords()
+			Expect(err).To(BeNil())
+			Expect(second).To(Equal(first))
+		})
+		It("owns the secret of the passwords by the StatefulSet", func() {
+			_, err := keystoreService.getOrCreatePasswords()
+			Expect(err).To(BeNil())
+			secret, err := keystoreService.Client.CoreV1().Secrets("default").Get("kafka-kafka-0-keystore-passwords", metav1.GetOptions{})
+			Expect(err).To(BeNil())
+			Expect(secret.OwnerReferences).To(HaveLen(1))
+			Expect(secret.OwnerReferences[0].Name).To(Equal("kafka-kafka"))
+			Expect(secret.OwnerReferences[0].UID).To(BeEquivalentTo("current"))
+
+			// a secret created without owner is adopted
+			secret.OwnerReferences = nil
+			_, err = keystoreService.Client.CoreV1().Secrets("default").Update(secret)
+			Expect(err).To(BeNil())
+			_, err = keystoreService.getOrCreatePasswords()
+			Expect(err).To(BeNil())
+			secret, err = keystoreService.Client.CoreV1().Secrets("default").Get("kafka-kafka-0-keystore-passwords", metav1.GetOptions{})
+			Expect(err).To(BeNil())
+			Expect(secret.OwnerReferences).To(HaveLen(1))
+		})
+		It("fails without certificate", func() {
+			_, err := keystoreService.BuildStores()
+			Expect(err).To(HaveOccurred())
+		})
+		It("refuses a private key which does not match the certificate", func() {
+			other := filepath.Join(dir, "other")
+			Expect(os.Mkdir(other, 0700)).To(BeNil())
+			writeCertificate(other)
+			writeCertificate(keystoreService.CertDir)
+			Expect(os.Rename(filepath.Join(other, TLS_KEY_FILE), filepath.Join(keystoreService.CertDir, TLS_KEY_FILE))).To(BeNil())
+
+			_, err := keystoreService.BuildStores()
+			Expect(err).To(MatchError(ContainSubstring("does not match the certificate")))
+			_, err = os.Stat(keystoreService.KeystorePath())
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+		It("merges the ssl properties into the broker config", func() {
+			keystoreService.ConfigPath = filepath.Join(dir, "server.properties")
+			Expect(ioutil.WriteFile(keystoreService.ConfigPath, []byte("# broker\nbroker.id=0\n"), 0644)).To(BeNil())
+			writeCertificate(keystoreService.CertDir)
+			_, err := keystoreService.BuildStores()
+			Expect(err).To(BeNil())
+
+			content, err := ioutil.ReadFile(keystoreService.ConfigPath)
+			Expect(err).To(BeNil())
+			Expect(string(content)).To(HavePrefix("# broker\nbroker.id=0\n# BEGIN kafka-utils ssl.properties\n"))
+			Expect(string(content)).To(ContainSubstring(fmt.Sprintf("ssl.keystore.location=%s\n", keystoreService.KeystorePath())))
+		})
+	})
+
+	Context("JKS stores", func() {
+		It("builds the keystore and the truststore from the TLS secret", func() {
+			keystoreService.StoreType = JKS
+			writeCertificate(keystoreService.CertDir)
+			_, err := keystoreService.BuildStores()
+			Expect(err).To(BeNil())
+			Expect(keystoreService.KeystorePath()).To(HaveSuffix(".jks"))
+			passwords, err := keystoreService.getOrCreatePasswords()
+			Expect(err).To(BeNil())
+
+			keystoreData, err := ioutil.ReadFile(keystoreService.KeystorePath())
+			Expect(err).To(BeNil())
+			ks := jks.New()
+			Expect(ks.Load(bytes.NewReader(keystoreData), []byte(passwords.Keystore))).To(BeNil())
+			Expect(ks.IsPrivateKeyEntry(KEY_ALIAS)).To(BeTrue())
+
+			truststoreData, err := ioutil.ReadFile(keystoreService.TruststorePath())
+			Expect(err).To(BeNil())
+			ts := jks.New()
+			Expect(ts.Load(bytes.NewReader(truststoreData), []byte(passwords.Truststore))).To(BeNil())
+			Expect(ts.Aliases()).To(HaveLen(1))
+		})
+	})
+
+	Context("Certificate watcher", func() {
+		It("rebuilds when the certificate files change", func() {
+			writeCertificate(keystoreService.CertDir)
+			changes := 0
+			watcher := &Watcher{
+				Dir:      keystoreService.CertDir,
+				Interval: time.Second,
+				OnChange: func() error {
+					changes++
+					return nil
+				},
+			}
+			watcher.checksum, _ = Checksum(keystoreService.CertDir)
+			watcher.poll()
+			Expect(changes).To(Equal(0))
+
+			writeCertificate(keystoreService.CertDir)
+			watcher.poll()
+			Expect(changes).To(Equal(1))
+			watcher.poll()
+			Expect(changes).To(Equal(1))
+		})
+		It("retries a failed rebuild", func() {
+			writeCertificate(keystoreService.CertDir)
+			changes := 0
+			watcher := &Watcher{
+				Dir:      keystoreService.CertDir,
+				Interval: time.Second,
+				OnChange: func() error {
+					changes++
+					return fmt.Errorf("rebuild failed")
+				},
+			}
+			watcher.poll()
+			watcher.poll()
+			Expect(changes).To(Equal(2))
+		})
+	})
+
+	Context("Certificate rotation", func() {
+		var (
+			mockAdmin *mocks.MockClusterAdmin
+			rotator   *Rotator
+		)
+
+		BeforeEach(func() {
+			mockAdmin = mocks.NewMockClusterAdmin(mockCtrl)
+			mockEnv.EXPECT().GetNodeName().Return("kubelet-0").AnyTimes()
+			rotator = &Rotator{
+				Keystore: keystoreService,
+				Events: &events.Recorder{
+					Client: keystoreService.Client,
+					Env:    mockEnv,
+				},
+				NewAdmin: func() (sarama.ClusterAdmin, error) {
+					return mockAdmin, nil
+				},
+				BrokerID:  0,
+				Listeners: []string{"INTERNAL", "EXTERNAL_INGRESS"},
+			}
+		})
+
+		It("reloads the keystore of every listener and records an event", func() {
+			serial := writeCertificate(keystoreService.CertDir)
+			location := keystoreService.KeystorePath()
+			mockAdmin.EXPECT().IncrementalAlterConfig(sarama.BrokerResource, "0", map[string]sarama.IncrementalAlterConfigsEntry{
+				"listener.name.internal.ssl.keystore.location":         {Operation: sarama.IncrementalAlterConfigsOperationSet, Value: &location},
+				"listener.name.external_ingress.ssl.keystore.location": {Operation: sarama.IncrementalAlterConfigsOperationSet, Value: &location},
+			}, false).Return(nil)
+			mockAdmin.EXPECT().Close().Return(nil)
+
+			Expect(rotator.Rotate()).To(BeNil())
+
+			eventList, err := keystoreService.Client.CoreV1().Events("default").List(metav1.ListOptions{})
+			Expect(err).To(BeNil())
+			Expect(eventList.Items).To(HaveLen(1))
+			Expect(eventList.Items[0].Reason).To(Equal(CERTIFICATE_ROTATED_REASON))
+			Expect(eventList.Items[0].InvolvedObject.Name).To(Equal("kafka-kafka-0"))
+			Expect(eventList.Items[0].Message).To(ContainSubstring(fmt.Sprintf("serial %x", serial)))
+		})
+		It("records a warning when the broker rejects the update", func() {
+			writeCertificate(keystoreService.CertDir)
+			mockAdmin.EXPECT().IncrementalAlterConfig(sarama.BrokerResource, "0", gomock.Any(), false).Return(fmt.Errorf("invalid config"))
+			mockAdmin.EXPECT().Close().Return(nil)
+
+			Expect(rotator.Rotate()).To(HaveOccurred())
+
+			eventList, err := keystoreService.Client.CoreV1().Events("default").List(metav1.ListOptions{})
+			Expect(err).To(BeNil())
+			Expect(eventList.Items).To(HaveLen(1))
+			Expect(eventList.Items[0].Type).To(Equal(v1.EventTypeWarning))
+			Expect(eventList.Items[0].Reason).To(Equal(ROTATION_FAILED_REASON))
+		})
+	})
+
+	Context("Certificate expiry", func() {
+		var monitor *ExpiryMonitor
+
+		BeforeEach(func() {
+			mockEnv.EXPECT().GetNodeName().Return("kubelet-0").AnyTimes()
+			monitor = NewExpiryMonitor(keystoreService, &events.Recorder{
+				Client: keystoreService.Client,
+				Env:    mockEnv,
+			}, []time.Duration{30 * 24 * time.Hour, 7 * 24 * time.Hour})
+		})
+
+		warnings := func() []v1.Event {
+			eventList, err := keystoreService.Client.CoreV1().Events("default").List(metav1.ListOptions{})
+			Expect(err).To(BeNil())
+			return eventList.Items
+		}
+
+		It("reads the certificates of the PKCS12 stores", func() {
+			serial := writeCertificate(keystoreService.CertDir)
+			_, err := keystoreService.BuildStores()
+			Expect(err).To(BeNil())
+			certs, err := keystoreService.ReadCertificates()
+			Expect(err).To(BeNil())
+			Expect(certs).To(HaveLen(2))
+			Expect(certs[0].Store).To(Equal(KEYSTORE))
+			Expect(certs[0].Alias).To(Equal(KEY_ALIAS))
+			Expect(certs[0].Certificate.SerialNumber.Int64()).To(Equal(serial))
+			Expect(certs[1].Store).To(Equal(TRUSTSTORE))
+		})
+		It("reads the certificates of the JKS stores", func() {
+			keystoreService.StoreType = JKS
+			serial := writeCertificate(keystoreService.CertDir)
+			_, err := keystoreService.BuildStores()
+			Expect(err).To(BeNil())
+			certs, err := keystoreService.ReadCertificates()
+			Expect(err).To(BeNil())
+			Expect(certs).To(HaveLen(2))
+			Expect(certs[0].Alias).To(Equal(KEY_ALIAS))
+			Expect(certs[0].Certificate.SerialNumber.Int64()).To(Equal(serial))
+			Expect(certs[1].Store).To(Equal(TRUSTSTORE))
+		})
+		It("exports the expiry time of every certificate", func() {
+			serial := writeCertificateValidFor(keystoreService.CertDir, 90*24*time.Hour)
+			_, err := keystoreService.BuildStores()
+			Expect(err).To(BeNil())
+			Expect(monitor.Check()).To(BeNil())
+
+			bundle, err := LoadCertificateBundle(keystoreService.CertDir)
+			Expect(err).To(BeNil())
+			gauge := monitor.Expiry.WithLabelValues(KEYSTORE, KEY_ALIAS, "CN=Kafka", fmt.Sprintf("%x", serial))
+			Expect(testutil.ToFloat64(gauge)).To(Equal(float64(bundle.Certificate().NotAfter.Unix())))
+			Expect(testutil.CollectAndCount(monitor.Expiry)).To(Equal(2))
+			Expect(warnings()).To(BeEmpty())
+		})
+		It("warns once per crossed threshold", func() {
+			writeCertificateValidFor(keystoreService.CertDir, 20*24*time.Hour)
+			_, err := keystoreService.BuildStores()
+			Expect(err).To(BeNil())
+			Expect(monitor.Check()).To(BeNil())
+			Expect(monitor.Check()).To(BeNil())
+
+			// the keystore and the truststore hold the same self-signed certificate
+			recorded := warnings()
+			Expect(recorded).To(HaveLen(2))
+			Expect(recorded[0].Type).To(Equal(v1.EventTypeWarning))
+			Expect(recorded[0].Reason).To(Equal(CERTIFICATE_EXPIRING_REASON))
+			Expect(recorded[0].Message).To(ContainSubstring("in less than 30 days"))
+
+			monitor.Thresholds = append(monitor.Thresholds, 21*24*time.Hour)
+			Expect(monitor.Check()).To(BeNil())
+			Expect(warnings()).To(HaveLen(4))
+		})
+		It("warns about expired certificates", func() {
+			writeCertificateValidFor(keystoreService.CertDir, -time.Minute)
+			_, err := keystoreService.BuildStores()
+			Expect(err).To(BeNil())
+			Expect(monitor.Check()).To(BeNil())
+			Expect(monitor.Check()).To(BeNil())
+
+			recorded := warnings()
+			Expect(recorded).To(HaveLen(2))
+			Expect(recorded[0].Reason).To(Equal(CERTIFICATE_EXPIRED_REASON))
+		})
+	})
+
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockEnv = mocks.NewMockEnvironment(mockCtrl)
+		mockEnv.EXPECT().GetNamespace().Return("default").AnyTimes()
+		mockEnv.EXPECT().GetHostName().Return("kafka-kafka-0").AnyTimes()
+
+		var err error
+		dir, err = ioutil.TempDir("/tmp", "kafka-keystore-test")
+		Expect(err).To(BeNil())
+		Expect(os.Mkdir(filepath.Join(dir, "certs"), 0700)).To(BeNil())
+		keystoreService = &KeystoreService{
+			Client: testclient.NewSimpleClientset(&appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{Name: "kafka-kafka", Namespace: "default", UID: "current"},
+			}),
+			Env:         mockEnv,
+			CertDir:     filepath.Join(dir, "certs"),
+			KeystoreDir: filepath.Join(dir, "tls"),
+			StoreType:   PKCS12,
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+		os.RemoveAll(dir)
+	})
+})
+
+var serialNumber int64
+
+// writeCertificate writes a new self-signed tls.crt and tls.key into dir and returns its serial number
+func writeCertificate(dir string) int64 {
+	return writeCertificateValidFor(dir, 24*time.Hour)
+}
+
+// writeCertificateValidFor writes a new self-signed certificate expiring after validity
+func writeCertificateValidFor(dir string, validity time.Duration) int64 {
+	serialNumber++
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).To(BeNil())
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serialNumber),
+		Subject:      pkix.Name{CommonName: "Kafka"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(validity),
+		DNSNames:     []string{"kafka-kafka-0.kafka-svc.default.svc.cluster.local"},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).To(BeNil())
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	Expect(err).To(BeNil())
+	Expect(ioutil.WriteFile(filepath.Join(dir, TLS_CERT_FILE), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0600)).To(BeNil())
+	Expect(ioutil.WriteFile(filepath.Join(dir, TLS_KEY_FILE), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)).To(BeNil())
+	return serialNumber
+}
+
+func TestKeystore(t *testing.T) {
+	RegisterFailHandler(Fail)
+	junitReporter := reporters.NewJUnitReporter(fmt.Sprintf("%s-junit.xml", "kafka-utils-keystore"))
+	RunSpecsWithDefaultAndCustomReporters(t, "KafkaUtils Keystore Suite", []Reporter{junitReporter})
+}
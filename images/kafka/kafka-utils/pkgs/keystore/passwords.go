@@ -0,0 +1,122 @@
+package keystore
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/service"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	KEYSTORE_PASSWORD_KEY   = "keystore.password"
+	TRUSTSTORE_PASSWORD_KEY = "truststore.password"
+	PASSWORD_BYTES          = 24
+)
+
+// Passwords protecting the broker keystore and truststore
+type Passwords struct {
+	Keystore   string
+	Truststore string
+}
+
+func (k *KeystoreService) passwordSecretName() string {
+	return fmt.Sprintf("%s-keystore-passwords", k.Env.GetHostName())
+}
+
+// getPasswords reads the store passwords from the broker secret
+func (k *KeystoreService) getPasswords() (*Passwords, error) {
+	name := k.passwordSecretName()
+	secret, err := k.Client.CoreV1().Secrets(k.Env.GetNamespace()).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not get the secret %s: %v", name, err)
+	}
+	return passwordsFromSecret(secret)
+}
+
+// getOrCreatePasswords reads the store passwords from the broker secret, generating them on first use.
+// The secret is owned by the StatefulSet of the broker so it is deleted with it.
+func (k *KeystoreService) getOrCreatePasswords() (*Passwords, error) {
+	secrets := k.Client.CoreV1().Secrets(k.Env.GetNamespace())
+	name := k.passwordSecretName()
+	owner, err := k.owner()
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := secrets.Get(name, metav1.GetOptions{})
+	if err == nil {
+		// the secrets created before they had an owner are adopted
+		if !service.IsOwnedBy(secret, owner) {
+			secret.OwnerReferences = []metav1.OwnerReference{owner}
+			if _, err := secrets.Update(secret); err != nil {
+				log.Warnf("could not adopt the secret %s in the StatefulSet %s: %v", name, owner.Name, err)
+			}
+		}
+		return passwordsFromSecret(secret)
+	}
+	if !errors.IsNotFound(err) {
+		return nil, fmt.Errorf("could not get the secret %s: %v", name, err)
+	}
+
+	log.Infof("generating the keystore passwords in secret %s", name)
+	keystorePassword, err := generatePassword()
+	if err != nil {
+		return nil, err
+	}
+	truststorePassword, err := generatePassword()
+	if err != nil {
+		return nil, err
+	}
+	secret, err = secrets.Create(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       k.Env.GetNamespace(),
+			OwnerReferences: []metav1.OwnerReference{owner},
+		},
+		Type: v1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			KEYSTORE_PASSWORD_KEY:   []byte(keystorePassword),
+			TRUSTSTORE_PASSWORD_KEY: []byte(truststorePassword),
+		},
+	})
+	if errors.IsAlreadyExists(err) {
+		secret, err = secrets.Get(name, metav1.GetOptions{})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not create the secret %s: %v", name, err)
+	}
+	return passwordsFromSecret(secret)
+}
+
+// owner returns the reference to the StatefulSet of the broker
+func (k *KeystoreService) owner() (metav1.OwnerReference, error) {
+	statefulSet, err := service.GetStatefulSetName(k.Env.GetHostName())
+	if err != nil {
+		return metav1.OwnerReference{}, err
+	}
+	return service.StatefulSetOwner(k.Client, k.Env.GetNamespace(), statefulSet)
+}
+
+func passwordsFromSecret(secret *v1.Secret) (*Passwords, error) {
+	passwords := &Passwords{
+		Keystore:   string(secret.Data[KEYSTORE_PASSWORD_KEY]),
+		Truststore: string(secret.Data[TRUSTSTORE_PASSWORD_KEY]),
+	}
+	if len(passwords.Keystore) == 0 || len(passwords.Truststore) == 0 {
+		return nil, fmt.Errorf("secret %s is missing the '%s' or '%s' key", secret.Name, KEYSTORE_PASSWORD_KEY, TRUSTSTORE_PASSWORD_KEY)
+	}
+	return passwords, nil
+}
+
+func generatePassword() (string, error) {
+	b := make([]byte, PASSWORD_BYTES)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate a password: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,73 @@
+package keystore
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/events"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	CERTIFICATE_ROTATED_REASON = "CertificateRotated"
+	ROTATION_FAILED_REASON     = "CertificateRotationFailed"
+)
+
+// Rotator rebuilds the stores and makes the broker reload its keystore without a restart
+type Rotator struct {
+	Keystore *KeystoreService
+	Events   *events.Recorder
+	// NewAdmin connects a new admin client, the broker might have been restarted since the last rotation
+	NewAdmin  func() (sarama.ClusterAdmin, error)
+	BrokerID  int32
+	Listeners []string
+}
+
+// Rotate rebuilds the stores and updates the keystore location of every listener so kafka reloads it
+func (r *Rotator) Rotate() error {
+	bundle, err := r.Keystore.BuildStores()
+	if err != nil {
+		r.Events.Eventf(v1.EventTypeWarning, ROTATION_FAILED_REASON, "could not build the keystores: %v", err)
+		return err
+	}
+	if err := r.reloadKeystore(); err != nil {
+		r.Events.Eventf(v1.EventTypeWarning, ROTATION_FAILED_REASON, "could not reload the keystore of broker %d: %v", r.BrokerID, err)
+		return err
+	}
+	cert := bundle.Certificate()
+	r.Events.Eventf(v1.EventTypeNormal, CERTIFICATE_ROTATED_REASON, "broker %d reloaded the certificate with serial %s expiring on %s",
+		r.BrokerID, cert.SerialNumber.Text(16), cert.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+func (r *Rotator) reloadKeystore() error {
+	admin, err := r.NewAdmin()
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+
+	location := r.Keystore.KeystorePath()
+	entries := map[string]sarama.IncrementalAlterConfigsEntry{}
+	for _, listener := range r.Listeners {
+		entries[ListenerKeystoreLocationKey(listener)] = sarama.IncrementalAlterConfigsEntry{
+			Operation: sarama.IncrementalAlterConfigsOperationSet,
+			Value:     &location,
+		}
+	}
+	err = admin.IncrementalAlterConfig(sarama.BrokerResource, strconv.Itoa(int(r.BrokerID)), entries, false)
+	if err != nil {
+		return fmt.Errorf("could not alter the keystore location of broker %d: %v", r.BrokerID, err)
+	}
+	log.Infof("broker %d reloaded the keystore %s for listeners %s", r.BrokerID, location, strings.Join(r.Listeners, ","))
+	return nil
+}
+
+// ListenerKeystoreLocationKey returns the per listener keystore location broker config
+func ListenerKeystoreLocationKey(listener string) string {
+	return fmt.Sprintf("listener.name.%s.ssl.keystore.location", strings.ToLower(listener))
+}
@@ -0,0 +1,56 @@
+package keystore
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Watcher polls the mounted certificate files and calls OnChange when their content changes.
+// Kubernetes updates secret volumes by swapping a symlink, so polling the content is more reliable than inotify.
+type Watcher struct {
+	Dir      string
+	Interval time.Duration
+	OnChange func() error
+
+	checksum string
+}
+
+// Run blocks until stop is closed
+func (w *Watcher) Run(stop <-chan struct{}) {
+	checksum, err := Checksum(w.Dir)
+	if err != nil {
+		log.Errorf("could not read the certificates in %s: %v", w.Dir, err)
+	}
+	w.checksum = checksum
+	log.Infof("watching the certificates in %s every %s", w.Dir, w.Interval)
+
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			w.poll()
+		}
+	}
+}
+
+func (w *Watcher) poll() {
+	checksum, err := Checksum(w.Dir)
+	if err != nil {
+		log.Errorf("could not read the certificates in %s: %v", w.Dir, err)
+		return
+	}
+	if checksum == w.checksum {
+		return
+	}
+	log.Infof("detected a change of the certificates in %s", w.Dir)
+	if err := w.OnChange(); err != nil {
+		// keep the old checksum so the change is retried on the next tick
+		log.Errorf("could not apply the certificate change: %v", err)
+		return
+	}
+	w.checksum = checksum
+}
@@ -0,0 +1,391 @@
+package kraft
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/config"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/service"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/storage"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	KRAFT_PROPERTIES_FILE     = "kraft.properties"
+	KAFKA_STORAGE_SCRIPT      = "bin/kafka-storage.sh"
+	CONTROLLER_LISTENER       = "CONTROLLER"
+	CONTROLLER_PROTOCOL       = CONTROLLER_LISTENER + ":PLAINTEXT"
+	DEFAULT_PROCESS_ROLES     = "broker,controller"
+	DEFAULT_CONTROLLER_PORT   = "9097"
+	DEFAULT_CLUSTER_DOMAIN    = "cluster.local"
+	DEFAULT_HEADLESS_SERVICE  = "kafka-svc"
+	CLUSTER_ID_BYTES          = 16
+	KRAFT_ENABLED_ENV         = "KRAFT_ENABLED"
+	KRAFT_PROCESS_ROLES_ENV   = "KRAFT_PROCESS_ROLES"
+	KRAFT_CONTROLLER_PORT_ENV = "KRAFT_CONTROLLER_PORT"
+	KRAFT_REPLICAS_ENV        = "KRAFT_CONTROLLER_REPLICAS"
+	KRAFT_STATEFULSET_ENV     = "KRAFT_STATEFULSET"
+	KRAFT_HEADLESS_ENV        = "KRAFT_HEADLESS_SERVICE"
+	KRAFT_ID_OFFSET_ENV       = "KRAFT_CONTROLLER_ID_OFFSET"
+	KAFKA_HEADLESS_ENV        = "KAFKA_HEADLESS_SERVICE"
+	KAFKA_CLUSTER_DOMAIN_ENV  = "KAFKA_CLUSTER_DOMAIN"
+	// DEFAULT_LISTENERS and DEFAULT_PROTOCOL_MAP are the kafka defaults, used when the broker config sets none
+	DEFAULT_LISTENERS    = "PLAINTEXT://:9092"
+	DEFAULT_PROTOCOL_MAP = "PLAINTEXT:PLAINTEXT,SSL:SSL,SASL_PLAINTEXT:SASL_PLAINTEXT,SASL_SSL:SASL_SSL"
+)
+
+// Config holds the inputs used to render the KRaft configuration of a node
+type Config struct {
+	// StatefulSet is the name of the StatefulSet running the controllers
+	StatefulSet string
+	// Replicas is the number of controllers, read from the StatefulSet when 0
+	Replicas int32
+	// HeadlessService is the name of the service governing the controller StatefulSet
+	HeadlessService string
+	// ControllerIDOffset is added to the controller ordinals so their node ids don't clash with the broker ids
+	ControllerIDOffset int32
+	ClusterDomain      string
+	ControllerPort     string
+	ProcessRoles       string
+	LogDirs            []string
+}
+
+// KRaftService bootstraps a node of a ZooKeeper-less cluster
+type KRaftService struct {
+	Client    kubernetes.Interface
+	Env       service.Environment
+	Config    *Config
+	KafkaHome string
+	// RunCommand runs the kafka storage tool, exec.Command is used when nil
+	RunCommand func(name string, args ...string) error
+}
+
+// IsEnabled returns true when the broker runs in KRaft mode
+func IsEnabled() bool {
+	return strings.EqualFold(os.Getenv(KRAFT_ENABLED_ENV), "true")
+}
+
+// NewConfigFromEnv builds the KRaft configuration from the broker environment variables
+func NewConfigFromEnv(hostname string) (*Config, error) {
+	statefulSet := os.Getenv(KRAFT_STATEFULSET_ENV)
+	if len(statefulSet) == 0 {
+		var err error
+		if statefulSet, err = service.GetStatefulSetName(hostname); err != nil {
+			return nil, fmt.Errorf("could not detect the StatefulSet, set %s: %v", KRAFT_STATEFULSET_ENV, err)
+		}
+	}
+	replicas, err := getInt32Env(KRAFT_REPLICAS_ENV)
+	if err != nil {
+		return nil, err
+	}
+	offset, err := getInt32Env(KRAFT_ID_OFFSET_ENV)
+	if err != nil {
+		return nil, err
+	}
+	return &Config{
+		StatefulSet:        statefulSet,
+		Replicas:           replicas,
+		HeadlessService:    getEnvOrDefault(KRAFT_HEADLESS_ENV, getEnvOrDefault(KAFKA_HEADLESS_ENV, DEFAULT_HEADLESS_SERVICE)),
+		ControllerIDOffset: offset,
+		ClusterDomain:      getEnvOrDefault(KAFKA_CLUSTER_DOMAIN_ENV, DEFAULT_CLUSTER_DOMAIN),
+		ControllerPort:     getEnvOrDefault(KRAFT_CONTROLLER_PORT_ENV, DEFAULT_CONTROLLER_PORT),
+		ProcessRoles:       getEnvOrDefault(KRAFT_PROCESS_ROLES_ENV, DEFAULT_PROCESS_ROLES),
+		LogDirs:            storage.LogDirsFromEnv(),
+	}, nil
+}
+
+// IsController returns true when the node is a voter of the controller quorum
+func (c *Config) IsController() bool {
+	for _, role := range strings.Split(c.ProcessRoles, ",") {
+		if strings.TrimSpace(role) == "controller" {
+			return true
+		}
+	}
+	return false
+}
+
+// NodeID returns the node.id of the pod, controllers are shifted by the controller id offset
+func (c *Config) NodeID(hostname string) (int32, error) {
+	ordinal, err := service.GetOrdinal(hostname)
+	if err != nil {
+		return 0, err
+	}
+	if c.IsController() {
+		return ordinal + c.ControllerIDOffset, nil
+	}
+	return ordinal, nil
+}
+
+// QuorumVoters returns the controller.quorum.voters of the cluster, every StatefulSet pod is a voter
+func (k *KRaftService) QuorumVoters() (string, error) {
+	replicas, err := k.replicas()
+	if err != nil {
+		return "", err
+	}
+	voters := make([]string, 0, replicas)
+	for ordinal := int32(0); ordinal < replicas; ordinal++ {
+		voters = append(voters, fmt.Sprintf("%d@%s-%d.%s.%s.svc.%s:%s", ordinal+k.Config.ControllerIDOffset, k.Config.StatefulSet, ordinal,
+			k.Config.HeadlessService, k.Env.GetNamespace(), k.Config.ClusterDomain, k.Config.ControllerPort))
+	}
+	return strings.Join(voters, ","), nil
+}
+
+func (k *KRaftService) replicas() (int32, error) {
+	if k.Config.Replicas > 0 {
+		return k.Config.Replicas, nil
+	}
+	statefulSet, err := k.Client.AppsV1().StatefulSets(k.Env.GetNamespace()).Get(k.Config.StatefulSet, metav1.GetOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("could not get the StatefulSet %s: %v", k.Config.StatefulSet, err)
+	}
+	if statefulSet.Spec.Replicas == nil || *statefulSet.Spec.Replicas == 0 {
+		return 0, fmt.Errorf("StatefulSet %s has no replicas", k.Config.StatefulSet)
+	}
+	return *statefulSet.Spec.Replicas, nil
+}
+
+// GetOrCreateClusterID reads the cluster id persisted in the cluster secret, generating it for the first node.
+// The secret is owned by the StatefulSet, so a new instance of the StatefulSet never formats its storage with the
+// cluster id of a previous one.
+func (k *KRaftService) GetOrCreateClusterID() (string, error) {
+	secrets := k.Client.CoreV1().Secrets(k.Env.GetNamespace())
+	name := storage.ClusterIDSecretName(k.Config.StatefulSet)
+	owner, err := service.StatefulSetOwner(k.Client, k.Env.GetNamespace(), k.Config.StatefulSet)
+	if err != nil {
+		return "", err
+	}
+
+	secret, err := secrets.Get(name, metav1.GetOptions{})
+	if err == nil {
+		return k.checkOwner(secret, owner)
+	}
+	if !errors.IsNotFound(err) {
+		return "", fmt.Errorf("could not get the secret %s: %v", name, err)
+	}
+
+	clusterID, err := generateClusterID()
+	if err != nil {
+		return "", err
+	}
+	log.Infof("generating the cluster id in secret %s", name)
+	secret, err = secrets.Create(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       k.Env.GetNamespace(),
+			OwnerReferences: []metav1.OwnerReference{owner},
+		},
+		Type: v1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			storage.CLUSTER_ID_KEY: []byte(clusterID),
+		},
+	})
+	// another node may have won the race, its cluster id is the one to use
+	if errors.IsAlreadyExists(err) {
+		secret, err = secrets.Get(name, metav1.GetOptions{})
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not create the secret %s: %v", name, err)
+	}
+	return storage.ClusterIDFromSecret(secret)
+}
+
+// checkOwner returns the cluster id of a secret of the current StatefulSet. A secret without owner, created before the
+// secrets had one, is adopted. The secret of a previous instance which has not been garbage collected yet gets a new id.
+func (k *KRaftService) checkOwner(secret *v1.Secret, owner metav1.OwnerReference) (string, error) {
+	if service.IsOwnedBy(secret, owner) {
+		return storage.ClusterIDFromSecret(secret)
+	}
+	if len(secret.OwnerReferences) > 0 {
+		clusterID, err := generateClusterID()
+		if err != nil {
+			return "", err
+		}
+		log.Infof("replacing the cluster id of a previous instance of the StatefulSet %s in secret %s", k.Config.StatefulSet, secret.Name)
+		secret.Data = map[string][]byte{storage.CLUSTER_ID_KEY: []byte(clusterID)}
+	} else {
+		log.Infof("adopting the secret %s in the StatefulSet %s", secret.Name, k.Config.StatefulSet)
+	}
+	secret.OwnerReferences = []metav1.OwnerReference{owner}
+	updated, err := k.Client.CoreV1().Secrets(k.Env.GetNamespace()).Update(secret)
+	if err != nil {
+		return "", fmt.Errorf("could not update the secret %s: %v", secret.Name, err)
+	}
+	return storage.ClusterIDFromSecret(updated)
+}
+
+// WriteConfigToPath writes the KRaft properties of the node into path and returns the file written
+func (k *KRaftService) WriteConfigToPath(path string) (string, error) {
+	nodeID, err := k.Config.NodeID(k.Env.GetHostName())
+	if err != nil {
+		return "", err
+	}
+	voters, err := k.QuorumVoters()
+	if err != nil {
+		return "", err
+	}
+	properties := config.NewProperties()
+	properties.Set("process.roles", k.Config.ProcessRoles)
+	properties.Set(storage.NODE_ID_KEY, fmt.Sprintf("%d", nodeID))
+	properties.Set("controller.quorum.voters", voters)
+	properties.Set("controller.listener.names", CONTROLLER_LISTENER)
+	properties.Set("log.dirs", strings.Join(k.Config.LogDirs, ","))
+	if k.Config.IsController() {
+		listeners, protocolMap, err := k.controllerListeners(filepath.Join(path, config.SERVER_PROPERTIES_FILE))
+		if err != nil {
+			return "", err
+		}
+		properties.Set("listeners", listeners)
+		properties.Set("listener.security.protocol.map", protocolMap)
+	}
+	propertiesPath := filepath.Join(path, KRAFT_PROPERTIES_FILE)
+	if err := properties.WriteToPath(propertiesPath); err != nil {
+		return "", err
+	}
+	log.Infof("created the %s file", propertiesPath)
+	return propertiesPath, nil
+}
+
+// controllerListeners returns the listeners and the listener.security.protocol.map of a controller, the listeners of
+// the broker config at serverPropertiesPath followed by the controller listener. Dedicated controllers only have the latter.
+func (k *KRaftService) controllerListeners(serverPropertiesPath string) (string, string, error) {
+	controllerListener := fmt.Sprintf("%s://0.0.0.0:%s", CONTROLLER_LISTENER, k.Config.ControllerPort)
+	if k.Config.ProcessRoles == "controller" {
+		return controllerListener, CONTROLLER_PROTOCOL, nil
+	}
+	broker, err := ReadBrokerConfig(serverPropertiesPath, KRAFT_PROPERTIES_FILE)
+	if err != nil {
+		return "", "", err
+	}
+	listeners := DEFAULT_LISTENERS
+	if value, ok := broker.Get("listeners"); ok && len(value) > 0 {
+		listeners = value
+	}
+	return appendListener(listeners, controllerListener), ControllerProtocolMap(broker), nil
+}
+
+// ReadBrokerConfig reads the broker config at serverPropertiesPath as it was before the block named block was merged
+// into it, it is empty when the file does not exist
+func ReadBrokerConfig(serverPropertiesPath, block string) (*config.Properties, error) {
+	return config.ReadPropertiesWithoutBlock(serverPropertiesPath, block)
+}
+
+// ControllerProtocolMap returns the listener.security.protocol.map of the broker config with the controller listener
+func ControllerProtocolMap(broker *config.Properties) string {
+	protocolMap := DEFAULT_PROTOCOL_MAP
+	if value, ok := broker.Get("listener.security.protocol.map"); ok && len(value) > 0 {
+		protocolMap = value
+	}
+	return appendListener(protocolMap, CONTROLLER_PROTOCOL)
+}
+
+// appendListener appends the controller entry to a list of listeners, replacing the one merged by a previous bootstrap
+func appendListener(list, entry string) string {
+	var entries []string
+	for _, existing := range strings.Split(list, ",") {
+		existing = strings.TrimSpace(existing)
+		if len(existing) > 0 && !strings.HasPrefix(existing, CONTROLLER_LISTENER+":") {
+			entries = append(entries, existing)
+		}
+	}
+	return strings.Join(append(entries, entry), ",")
+}
+
+// FormatStorage formats the log dirs with the cluster id, the log dirs already formatted are never touched
+func (k *KRaftService) FormatStorage(clusterID, propertiesPath string) error {
+	formatted := 0
+	for _, logDir := range k.Config.LogDirs {
+		meta, err := storage.ReadMetaProperties(logDir)
+		if err != nil {
+			return err
+		}
+		if meta == nil {
+			continue
+		}
+		if meta.ClusterID() != clusterID {
+			return fmt.Errorf("log dir %s belongs to cluster '%s' and not to cluster '%s'", logDir, meta.ClusterID(), clusterID)
+		}
+		formatted++
+	}
+	if formatted == len(k.Config.LogDirs) {
+		log.Infof("the log dirs %s are already formatted for cluster %s", strings.Join(k.Config.LogDirs, ","), clusterID)
+		return nil
+	}
+
+	log.Infof("formatting the log dirs %s for cluster %s", strings.Join(k.Config.LogDirs, ","), clusterID)
+	script := filepath.Join(k.KafkaHome, KAFKA_STORAGE_SCRIPT)
+	run := k.RunCommand
+	if run == nil {
+		if _, err := os.Stat(script); err != nil {
+			return fmt.Errorf("KRaft mode requires a kafka distribution shipping %s: %v", KAFKA_STORAGE_SCRIPT, err)
+		}
+		run = runCommand
+	}
+	// --ignore-formatted keeps the dirs formatted by a previous attempt
+	if err := run(script, "format", "--cluster-id", clusterID, "--config", propertiesPath, "--ignore-formatted"); err != nil {
+		return fmt.Errorf("could not format the log dirs: %v", err)
+	}
+	return nil
+}
+
+// Bootstrap writes the KRaft configuration of the node, merges it into the broker config in path and formats the
+// storage when needed. The storage is formatted with the merged broker config, which has every listener of the node.
+func (k *KRaftService) Bootstrap(path string) error {
+	propertiesPath, err := k.WriteConfigToPath(path)
+	if err != nil {
+		return err
+	}
+	serverPropertiesPath := filepath.Join(path, config.SERVER_PROPERTIES_FILE)
+	if err := config.MergeFileIntoPath(propertiesPath, serverPropertiesPath); err != nil {
+		return err
+	}
+	clusterID, err := k.GetOrCreateClusterID()
+	if err != nil {
+		return err
+	}
+	return k.FormatStorage(clusterID, serverPropertiesPath)
+}
+
+// generateClusterID returns a random id in the base64 uuid form expected by kafka-storage
+func generateClusterID() (string, error) {
+	b := make([]byte, CLUSTER_ID_BYTES)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate the cluster id: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func runCommand(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func getInt32Env(key string) (int32, error) {
+	value := os.Getenv(key)
+	if len(value) == 0 {
+		return 0, nil
+	}
+	parsed, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s '%s': %v", key, value, err)
+	}
+	return int32(parsed), nil
+}
+
+func getEnvOrDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); len(value) > 0 {
+		return value
+	}
+	return defaultValue
+}
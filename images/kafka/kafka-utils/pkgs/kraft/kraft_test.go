@@ -0,0 +1,233 @@
+package kraft
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/config"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/mocks"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/storage"
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/reporters"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testclient "k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Describe("[Kafka KRaft]", func() {
+
+	var (
+		mockCtrl     *gomock.Controller
+		mockEnv      *mocks.MockEnvironment
+		dir          string
+		formats      int
+		formatConfig string
+		kraftService *KRaftService
+	)
+
+	Context("Quorum voters", func() {
+		It("lists every pod of the StatefulSet", func() {
+			kraftService.Config.Replicas = 3
+			voters, err := kraftService.QuorumVoters()
+			Expect(err).To(BeNil())
+			Expect(voters).To(Equal("0@kafka-kafka-0.kafka-svc.default.svc.cluster.local:9097," +
+				"1@kafka-kafka-1.kafka-svc.default.svc.cluster.local:9097," +
+				"2@kafka-kafka-2.kafka-svc.default.svc.cluster.local:9097"))
+		})
+		It("reads the replica count from the StatefulSet", func() {
+			replicas := int32(2)
+			_, err := kraftService.Client.AppsV1().StatefulSets("default").Create(&appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{Name: "kafka-kafka", Namespace: "default"},
+				Spec:       appsv1.StatefulSetSpec{Replicas: &replicas},
+			})
+			Expect(err).To(BeNil())
+			voters, err := kraftService.QuorumVoters()
+			Expect(err).To(BeNil())
+			Expect(voters).To(HaveSuffix("1@kafka-kafka-1.kafka-svc.default.svc.cluster.local:9097"))
+		})
+		It("fails without StatefulSet", func() {
+			_, err := kraftService.QuorumVoters()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	createStatefulSet := func() {
+		_, err := kraftService.Client.AppsV1().StatefulSets("default").Create(&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "kafka-kafka", Namespace: "default", UID: "current"},
+		})
+		Expect(err).To(BeNil())
+	}
+
+	Context("Cluster id", func() {
+		BeforeEach(createStatefulSet)
+
+		It("is generated once and persisted in a secret", func() {
+			first, err := kraftService.GetOrCreateClusterID()
+			Expect(err).To(BeNil())
+			Expect(first).To(HaveLen(22))
+			second, err := kraftService.GetOrCreateClusterID()
+			Expect(err).To(BeNil())
+			Expect(second).To(Equal(first))
+
+			secret, err := kraftService.Client.CoreV1().Secrets("default").Get("kafka-kafka-cluster-id", metav1.GetOptions{})
+			Expect(err).To(BeNil())
+			Expect(string(secret.Data[storage.CLUSTER_ID_KEY])).To(Equal(first))
+			Expect(secret.OwnerReferences).To(HaveLen(1))
+			Expect(secret.OwnerReferences[0].Kind).To(Equal("StatefulSet"))
+			Expect(secret.OwnerReferences[0].UID).To(BeEquivalentTo("current"))
+		})
+		It("is read from an existing secret, which is adopted", func() {
+			_, err := kraftService.Client.CoreV1().Secrets("default").Create(&v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "kafka-kafka-cluster-id", Namespace: "default"},
+				Data:       map[string][]byte{storage.CLUSTER_ID_KEY: []byte("MkU3OEVBNTcwNTJENDM2Qk")},
+			})
+			Expect(err).To(BeNil())
+			clusterID, err := kraftService.GetOrCreateClusterID()
+			Expect(err).To(BeNil())
+			Expect(clusterID).To(Equal("MkU3OEVBNTcwNTJENDM2Qk"))
+			secret, err := kraftService.Client.CoreV1().Secrets("default").Get("kafka-kafka-cluster-id", metav1.GetOptions{})
+			Expect(err).To(BeNil())
+			Expect(secret.OwnerReferences[0].UID).To(BeEquivalentTo("current"))
+		})
+		It("is generated again for a new instance of the StatefulSet", func() {
+			_, err := kraftService.Client.CoreV1().Secrets("default").Create(&v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            "kafka-kafka-cluster-id",
+					Namespace:       "default",
+					OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "StatefulSet", Name: "kafka-kafka", UID: "previous"}},
+				},
+				Data: map[string][]byte{storage.CLUSTER_ID_KEY: []byte("MkU3OEVBNTcwNTJENDM2Qk")},
+			})
+			Expect(err).To(BeNil())
+			clusterID, err := kraftService.GetOrCreateClusterID()
+			Expect(err).To(BeNil())
+			Expect(clusterID).NotTo(Equal("MkU3OEVBNTcwNTJENDM2Qk"))
+			again, err := kraftService.GetOrCreateClusterID()
+			Expect(err).To(BeNil())
+			Expect(again).To(Equal(clusterID))
+		})
+	})
+
+	Context("Bootstrap", func() {
+		BeforeEach(func() {
+			kraftService.Config.Replicas = 3
+			createStatefulSet()
+		})
+
+		It("writes the KRaft properties and formats the log dir", func() {
+			Expect(kraftService.Bootstrap(filepath.Join(dir, "config"))).To(BeNil())
+			Expect(formats).To(Equal(1))
+
+			properties, err := ioutil.ReadFile(filepath.Join(dir, "config", KRAFT_PROPERTIES_FILE))
+			Expect(err).To(BeNil())
+			Expect(string(properties)).To(ContainSubstring("node.id=1\n"))
+			Expect(string(properties)).To(ContainSubstring("process.roles=broker,controller\n"))
+			Expect(string(properties)).To(ContainSubstring("controller.quorum.voters=0@kafka-kafka-0.kafka-svc.default.svc.cluster.local:9097,"))
+			Expect(formatConfig).To(Equal(filepath.Join(dir, "config", config.SERVER_PROPERTIES_FILE)))
+		})
+		It("adds the controller listener to the listeners of the broker", func() {
+			serverProperties := filepath.Join(dir, "config", config.SERVER_PROPERTIES_FILE)
+			Expect(os.Mkdir(filepath.Join(dir, "config"), 0755)).To(BeNil())
+			Expect(ioutil.WriteFile(serverProperties, []byte("# broker\nlisteners=INTERNAL://0.0.0.0:9093\n"+
+				"listener.security.protocol.map=INTERNAL:SSL\ninter.broker.listener.name=INTERNAL\n"), 0644)).To(BeNil())
+			Expect(kraftService.Bootstrap(filepath.Join(dir, "config"))).To(BeNil())
+			// a second bootstrap doesn't add the controller listener twice
+			Expect(kraftService.Bootstrap(filepath.Join(dir, "config"))).To(BeNil())
+
+			merged, err := config.ReadProperties(serverProperties)
+			Expect(err).To(BeNil())
+			listeners, _ := merged.Get("listeners")
+			Expect(listeners).To(Equal("INTERNAL://0.0.0.0:9093,CONTROLLER://0.0.0.0:9097"))
+			protocolMap, _ := merged.Get("listener.security.protocol.map")
+			Expect(protocolMap).To(Equal("INTERNAL:SSL,CONTROLLER:PLAINTEXT"))
+			processRoles, _ := merged.Get("process.roles")
+			Expect(processRoles).To(Equal("broker,controller"))
+			content, err := ioutil.ReadFile(serverProperties)
+			Expect(err).To(BeNil())
+			Expect(string(content)).To(HavePrefix("# broker\n"))
+		})
+		It("only gives the controller listener to dedicated controllers", func() {
+			kraftService.Config.ProcessRoles = "controller"
+			propertiesPath, err := kraftService.WriteConfigToPath(filepath.Join(dir, "config"))
+			Expect(err).To(BeNil())
+			properties, err := config.ReadProperties(propertiesPath)
+			Expect(err).To(BeNil())
+			listeners, _ := properties.Get("listeners")
+			Expect(listeners).To(Equal("CONTROLLER://0.0.0.0:9097"))
+			protocolMap, _ := properties.Get("listener.security.protocol.map")
+			Expect(protocolMap).To(Equal("CONTROLLER:PLAINTEXT"))
+		})
+		It("leaves the listeners of KRaft brokers to the broker config", func() {
+			kraftService.Config.ProcessRoles = "broker"
+			propertiesPath, err := kraftService.WriteConfigToPath(filepath.Join(dir, "config"))
+			Expect(err).To(BeNil())
+			properties, err := config.ReadProperties(propertiesPath)
+			Expect(err).To(BeNil())
+			_, ok := properties.Get("listeners")
+			Expect(ok).To(BeFalse())
+		})
+		It("never formats a log dir twice", func() {
+			Expect(kraftService.Bootstrap(filepath.Join(dir, "config"))).To(BeNil())
+			Expect(kraftService.Bootstrap(filepath.Join(dir, "config"))).To(BeNil())
+			Expect(formats).To(Equal(1))
+		})
+		It("refuses a log dir formatted for another cluster", func() {
+			Expect(ioutil.WriteFile(filepath.Join(kraftService.Config.LogDirs[0], storage.META_PROPERTIES_FILE),
+				[]byte("version=1\ncluster.id=another\nnode.id=1\n"), 0644)).To(BeNil())
+			err := kraftService.Bootstrap(filepath.Join(dir, "config"))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("another"))
+			Expect(formats).To(Equal(0))
+		})
+	})
+
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockEnv = mocks.NewMockEnvironment(mockCtrl)
+		mockEnv.EXPECT().GetNamespace().Return("default").AnyTimes()
+		mockEnv.EXPECT().GetHostName().Return("kafka-kafka-1").AnyTimes()
+
+		var err error
+		dir, err = ioutil.TempDir("/tmp", "kafka-kraft-test")
+		Expect(err).To(BeNil())
+		logDir := filepath.Join(dir, "data")
+		Expect(os.Mkdir(logDir, 0755)).To(BeNil())
+		config, err := NewConfigFromEnv("kafka-kafka-1")
+		Expect(err).To(BeNil())
+		config.LogDirs = []string{logDir}
+
+		formats = 0
+		kraftService = &KRaftService{
+			Client:    testclient.NewSimpleClientset(),
+			Env:       mockEnv,
+			Config:    config,
+			KafkaHome: dir,
+			// the storage tool writes the meta.properties of the log dir
+			RunCommand: func(name string, args ...string) error {
+				formats++
+				Expect(name).To(Equal(filepath.Join(dir, KAFKA_STORAGE_SCRIPT)))
+				Expect(args[:2]).To(Equal([]string{"format", "--cluster-id"}))
+				formatConfig = args[4]
+				return ioutil.WriteFile(filepath.Join(logDir, storage.META_PROPERTIES_FILE),
+					[]byte(fmt.Sprintf("version=1\ncluster.id=%s\nnode.id=1\n", args[2])), 0644)
+			},
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+		os.RemoveAll(dir)
+	})
+})
+
+func TestKRaft(t *testing.T) {
+	RegisterFailHandler(Fail)
+	junitReporter := reporters.NewJUnitReporter(fmt.Sprintf("%s-junit.xml", "kafka-utils-kraft"))
+	RunSpecsWithDefaultAndCustomReporters(t, "KafkaUtils KRaft Suite", []Reporter{junitReporter})
+}
@@ -0,0 +1,25 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DEFAULT_METRICS_ADDRESS = ":9098"
+	METRICS_PATH            = "/metrics"
+)
+
+// StartServer serves the registered prometheus metrics on address in the background
+func StartServer(address string) {
+	mux := http.NewServeMux()
+	mux.Handle(METRICS_PATH, promhttp.Handler())
+	go func() {
+		log.Infof("serving the metrics on %s%s", address, METRICS_PATH)
+		if err := http.ListenAndServe(address, mux); err != nil {
+			log.Fatalf("could not serve the metrics on %s: %v", address, err)
+		}
+	}()
+}
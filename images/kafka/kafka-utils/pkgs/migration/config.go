@@ -0,0 +1,156 @@
+package migration
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/config"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/kraft"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/storage"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	MIGRATION_PROPERTIES_FILE = "migration.properties"
+	MIGRATION_ENABLE_KEY      = "zookeeper.metadata.migration.enable"
+	KRAFT_MIGRATION_PHASE_ENV = "KRAFT_MIGRATION_PHASE"
+	ZOOKEEPER_URI_ENV         = "ZOOKEEPER_URI"
+	PROTOCOL_VERSION_KEY      = "inter.broker.protocol.version"
+	// MIGRATION_PROTOCOL_VERSION is the first inter.broker.protocol.version supporting the migration
+	MIGRATION_PROTOCOL_VERSION = "3.4"
+	MIGRATION_MIN_MAJOR        = 3
+	MIGRATION_MIN_MINOR        = 4
+)
+
+// ZOOKEEPER_BROKER_KEYS are the properties of a ZooKeeper broker which a broker in KRaft mode refuses
+var ZOOKEEPER_BROKER_KEYS = []string{"zookeeper.connect", "broker.id", PROTOCOL_VERSION_KEY, MIGRATION_ENABLE_KEY}
+
+// PhaseFromEnv returns the migration phase the node is rolled into, if any
+func PhaseFromEnv() (Phase, bool, error) {
+	name := os.Getenv(KRAFT_MIGRATION_PHASE_ENV)
+	if len(name) == 0 {
+		return "", false, nil
+	}
+	phase, err := ParsePhase(name)
+	return phase, err == nil, err
+}
+
+// WriteConfigToPath writes the migration properties of the node for phase into path
+func WriteConfigToPath(path string, phase Phase, kraftService *kraft.KRaftService, zookeeperURI string) error {
+	broker, err := kraft.ReadBrokerConfig(filepath.Join(path, config.SERVER_PROPERTIES_FILE), MIGRATION_PROPERTIES_FILE)
+	if err != nil {
+		return err
+	}
+	properties, err := phaseProperties(phase, kraftService, zookeeperURI, broker)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	propertiesPath := filepath.Join(path, MIGRATION_PROPERTIES_FILE)
+	file, err := os.Create(propertiesPath)
+	if err != nil {
+		return fmt.Errorf("could not create %s: %v", propertiesPath, err)
+	}
+	defer file.Close()
+	writer := bufio.NewWriter(file)
+	for _, property := range properties {
+		fmt.Fprintf(writer, "%s=%s\n", property[0], property[1])
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	log.Infof("created the %s file for the migration phase %s", propertiesPath, phase)
+	return nil
+}
+
+// MergeConfigIntoPath merges the migration properties written by WriteConfigToPath into the server.properties of path,
+// the brokers in KRaft mode drop the ZooKeeper properties of their config
+func MergeConfigIntoPath(path string, phase Phase, kraftService *kraft.KRaftService) error {
+	return config.MergeFileIntoPath(filepath.Join(path, MIGRATION_PROPERTIES_FILE), filepath.Join(path, config.SERVER_PROPERTIES_FILE),
+		RemovedKeys(phase, kraftService.Config)...)
+}
+
+// RemovedKeys returns the keys of the broker config the node can't start with in phase
+func RemovedKeys(phase Phase, kraftConfig *kraft.Config) []string {
+	if kraftConfig.IsController() || !phase.AtLeast(PHASE_BROKERS_KRAFT) {
+		return nil
+	}
+	return ZOOKEEPER_BROKER_KEYS
+}
+
+// phaseProperties returns the properties of the node for phase, broker is its current config
+func phaseProperties(phase Phase, kraftService *kraft.KRaftService, zookeeperURI string, broker *config.Properties) ([][2]string, error) {
+	if kraftService.Config.IsController() {
+		// controllers keep the metadata in sync with ZooKeeper until the migration is finalized
+		if !phase.AtLeast(PHASE_CONTROLLERS) || phase.AtLeast(PHASE_FINALIZED) {
+			return nil, nil
+		}
+		if len(zookeeperURI) == 0 {
+			return nil, fmt.Errorf("the controllers need %s during the migration", ZOOKEEPER_URI_ENV)
+		}
+		return [][2]string{
+			{MIGRATION_ENABLE_KEY, "true"},
+			{"zookeeper.connect", zookeeperURI},
+		}, nil
+	}
+
+	if !phase.AtLeast(PHASE_BROKERS_MIGRATING) {
+		return nil, nil
+	}
+	voters, err := kraftService.QuorumVoters()
+	if err != nil {
+		return nil, err
+	}
+	if phase == PHASE_BROKERS_MIGRATING {
+		return [][2]string{
+			{MIGRATION_ENABLE_KEY, "true"},
+			{"controller.quorum.voters", voters},
+			{"controller.listener.names", kraft.CONTROLLER_LISTENER},
+			{"listener.security.protocol.map", kraft.ControllerProtocolMap(broker)},
+			{PROTOCOL_VERSION_KEY, migrationProtocolVersion(broker)},
+		}, nil
+	}
+	nodeID, err := kraftService.Config.NodeID(kraftService.Env.GetHostName())
+	if err != nil {
+		return nil, err
+	}
+	return [][2]string{
+		{"process.roles", "broker"},
+		{storage.NODE_ID_KEY, fmt.Sprintf("%d", nodeID)},
+		{"controller.quorum.voters", voters},
+		{"controller.listener.names", kraft.CONTROLLER_LISTENER},
+		{"listener.security.protocol.map", kraft.ControllerProtocolMap(broker)},
+	}, nil
+}
+
+// migrationProtocolVersion returns the inter.broker.protocol.version of the broker when it supports the migration,
+// MIGRATION_PROTOCOL_VERSION otherwise
+func migrationProtocolVersion(broker *config.Properties) string {
+	if current, ok := broker.Get(PROTOCOL_VERSION_KEY); ok && supportsMigration(current) {
+		return current
+	}
+	return MIGRATION_PROTOCOL_VERSION
+}
+
+// supportsMigration tells whether an inter.broker.protocol.version such as 3.5 or 3.4-IV0 is at least 3.4
+func supportsMigration(version string) bool {
+	parts := strings.SplitN(strings.SplitN(version, "-", 2)[0], ".", 3)
+	if len(parts) < 2 {
+		return false
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return false
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return false
+	}
+	return major > MIGRATION_MIN_MAJOR || major == MIGRATION_MIN_MAJOR && minor >= MIGRATION_MIN_MINOR
+}
@@ -0,0 +1,509 @@
+package migration
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/avast/retry-go"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/events"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/kraft"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/service"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/storage"
+	log "github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	CONTROLLER_SUFFIX            = "-controller"
+	CONTROLLER_LABEL             = "kafka.mesosphere.io/kraft-controller"
+	DEFAULT_CONTROLLER_REPLICAS  = 3
+	DEFAULT_CONTROLLER_ID_OFFSET = 3000
+	MIGRATION_PHASE_REASON       = "MigrationPhase"
+	MIGRATION_FAILED_REASON      = "MigrationFailed"
+	MIGRATION_ROLLBACK_REASON    = "MigrationRolledBack"
+)
+
+// migrationEnv are the environment variables set on the broker pods during the migration
+var migrationEnv = []string{
+	KRAFT_MIGRATION_PHASE_ENV,
+	kraft.KRAFT_PROCESS_ROLES_ENV,
+	kraft.KRAFT_STATEFULSET_ENV,
+	kraft.KRAFT_HEADLESS_ENV,
+	kraft.KRAFT_REPLICAS_ENV,
+	kraft.KRAFT_ID_OFFSET_ENV,
+	kraft.KRAFT_CONTROLLER_PORT_ENV,
+}
+
+// Migrator moves a ZooKeeper based cluster to KRaft one phase at a time
+type Migrator struct {
+	Client kubernetes.Interface
+	Env    service.Environment
+	Events *events.Recorder
+	// StatefulSet is the name of the broker StatefulSet
+	StatefulSet        string
+	ControllerReplicas int32
+	ControllerIDOffset int32
+	ControllerPort     string
+	ZookeeperURI       string
+	// ClusterID returns the id of the ZooKeeper cluster, the controllers are formatted with it
+	ClusterID func() (string, error)
+	// MigrationState returns the ZkMigrationState reported by the controllers
+	MigrationState func() (int, error)
+	PollInterval   time.Duration
+	Timeout        time.Duration
+}
+
+// ControllerStatefulSet returns the name of the StatefulSet running the KRaft controllers
+func (m *Migrator) ControllerStatefulSet() string {
+	return m.StatefulSet + CONTROLLER_SUFFIX
+}
+
+// ControllerService returns the name of the headless service governing the KRaft controllers
+func (m *Migrator) ControllerService() string {
+	return m.ControllerStatefulSet() + "-svc"
+}
+
+// ControllerMetricsURLs returns the metrics endpoints of the controllers
+func (m *Migrator) ControllerMetricsURLs(clusterDomain, port string) []string {
+	var urls []string
+	for ordinal := int32(0); ordinal < m.ControllerReplicas; ordinal++ {
+		urls = append(urls, fmt.Sprintf("http://%s-%d.%s.%s.svc.%s:%s/metrics", m.ControllerStatefulSet(), ordinal,
+			m.ControllerService(), m.Env.GetNamespace(), clusterDomain, port))
+	}
+	return urls
+}
+
+// Advance rolls the cluster into the next phase, a failed phase is retried
+func (m *Migrator) Advance() (*State, error) {
+	state, err := m.LoadState()
+	if err != nil {
+		return nil, err
+	}
+	target := state.Phase
+	if state.Status == STATUS_COMPLETED {
+		if target, err = state.Phase.Next(); err != nil {
+			return state, err
+		}
+	}
+	return m.runPhase(target, MIGRATION_PHASE_REASON, fmt.Sprintf("rolled into phase %s", target))
+}
+
+// Rollback rolls the cluster back into the phase preceding the current one, until the migration is finalized
+func (m *Migrator) Rollback() (*State, error) {
+	state, err := m.LoadState()
+	if err != nil {
+		return nil, err
+	}
+	target, err := state.Phase.Previous()
+	if err != nil {
+		return state, err
+	}
+	message := fmt.Sprintf("rolled back into phase %s", target)
+	switch target {
+	case PHASE_BROKERS_MIGRATING:
+		message += ", delete the /controller znode so a KRaft controller is elected again"
+	case PHASE_ZOOKEEPER:
+		message += ", delete the /controller and /migration znodes before migrating again"
+	}
+	return m.runPhase(target, MIGRATION_ROLLBACK_REASON, message)
+}
+
+func (m *Migrator) runPhase(target Phase, reason, message string) (*State, error) {
+	state := &State{Phase: target, Status: STATUS_IN_PROGRESS, Message: fmt.Sprintf("rolling into phase %s", target)}
+	if err := m.saveState(state); err != nil {
+		return nil, err
+	}
+	log.Infof("rolling the cluster into the migration phase %s", target)
+
+	if err := m.applyPhase(target); err != nil {
+		state.Status = STATUS_FAILED
+		state.Message = err.Error()
+		m.Events.Eventf(v1.EventTypeWarning, MIGRATION_FAILED_REASON, "migration phase %s failed: %v", target, err)
+		if saveErr := m.saveState(state); saveErr != nil {
+			log.Errorf("%v", saveErr)
+		}
+		return state, err
+	}
+
+	state.Status = STATUS_COMPLETED
+	state.Message = message
+	m.Events.Eventf(v1.EventTypeNormal, reason, "%s", message)
+	log.Infof("the cluster %s", message)
+	return state, m.saveState(state)
+}
+
+// applyPhase converges the controllers and the brokers to the configuration of phase
+func (m *Migrator) applyPhase(phase Phase) error {
+	if phase == PHASE_ZOOKEEPER {
+		if err := m.updateBrokers(phase); err != nil {
+			return err
+		}
+		return m.deleteControllers()
+	}
+
+	if err := m.ensureControllers(phase); err != nil {
+		return err
+	}
+	if err := m.updateBrokers(phase); err != nil {
+		return err
+	}
+	if phase == PHASE_BROKERS_MIGRATING {
+		return m.waitForMetadataMigration()
+	}
+	return nil
+}
+
+// brokerPhase returns the phase rendered by the brokers, they don't change once running in KRaft mode
+func brokerPhase(phase Phase) (Phase, bool) {
+	if !phase.AtLeast(PHASE_BROKERS_MIGRATING) {
+		return "", false
+	}
+	if phase == PHASE_FINALIZED {
+		return PHASE_BROKERS_KRAFT, true
+	}
+	return phase, true
+}
+
+// controllerPhase returns the phase rendered by the controllers, they only change when the migration is finalized
+func controllerPhase(phase Phase) Phase {
+	if phase == PHASE_FINALIZED {
+		return PHASE_FINALIZED
+	}
+	return PHASE_CONTROLLERS
+}
+
+func (m *Migrator) kraftEnv(processRoles string, phase Phase) map[string]string {
+	return map[string]string{
+		KRAFT_MIGRATION_PHASE_ENV:       string(phase),
+		kraft.KRAFT_PROCESS_ROLES_ENV:   processRoles,
+		kraft.KRAFT_STATEFULSET_ENV:     m.ControllerStatefulSet(),
+		kraft.KRAFT_HEADLESS_ENV:        m.ControllerService(),
+		kraft.KRAFT_REPLICAS_ENV:        strconv.Itoa(int(m.ControllerReplicas)),
+		kraft.KRAFT_ID_OFFSET_ENV:       strconv.Itoa(int(m.ControllerIDOffset)),
+		kraft.KRAFT_CONTROLLER_PORT_ENV: m.ControllerPort,
+	}
+}
+
+func (m *Migrator) updateBrokers(phase Phase) error {
+	statefulSets := m.Client.AppsV1().StatefulSets(m.Env.GetNamespace())
+	brokers, err := statefulSets.Get(m.StatefulSet, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("could not get the StatefulSet %s: %v", m.StatefulSet, err)
+	}
+	var changed bool
+	if rendered, migrating := brokerPhase(phase); migrating {
+		changed = setEnv(&brokers.Spec.Template, m.kraftEnv("broker", rendered))
+	} else {
+		changed = removeEnv(&brokers.Spec.Template, migrationEnv)
+	}
+	if !changed {
+		return nil
+	}
+	if _, err := statefulSets.Update(brokers); err != nil {
+		return fmt.Errorf("could not update the StatefulSet %s: %v", m.StatefulSet, err)
+	}
+	log.Infof("rolling the brokers of %s", m.StatefulSet)
+	return m.waitForRollout(m.StatefulSet)
+}
+
+func (m *Migrator) ensureControllers(phase Phase) error {
+	if err := m.ensureClusterID(); err != nil {
+		return err
+	}
+	if err := m.ensureControllerService(); err != nil {
+		return err
+	}
+
+	name := m.ControllerStatefulSet()
+	statefulSets := m.Client.AppsV1().StatefulSets(m.Env.GetNamespace())
+	env := m.kraftEnv("controller", controllerPhase(phase))
+	env[kraft.KRAFT_ENABLED_ENV] = "true"
+	env[ZOOKEEPER_URI_ENV] = m.ZookeeperURI
+
+	controllers, err := statefulSets.Get(name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		controllers, err = m.newControllerStatefulSet()
+		if err != nil {
+			return err
+		}
+		setEnv(&controllers.Spec.Template, env)
+		if _, err := statefulSets.Create(controllers); err != nil {
+			return fmt.Errorf("could not create the StatefulSet %s: %v", name, err)
+		}
+		log.Infof("created the KRaft controllers %s", name)
+		return m.waitForRollout(name)
+	}
+	if err != nil {
+		return fmt.Errorf("could not get the StatefulSet %s: %v", name, err)
+	}
+	if setEnv(&controllers.Spec.Template, env) {
+		if _, err := statefulSets.Update(controllers); err != nil {
+			return fmt.Errorf("could not update the StatefulSet %s: %v", name, err)
+		}
+		log.Infof("rolling the KRaft controllers %s", name)
+	}
+	return m.waitForRollout(name)
+}
+
+// newControllerStatefulSet derives the controllers from the broker StatefulSet so they run the same image and storage
+func (m *Migrator) newControllerStatefulSet() (*appsv1.StatefulSet, error) {
+	brokers, err := m.Client.AppsV1().StatefulSets(m.Env.GetNamespace()).Get(m.StatefulSet, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not get the StatefulSet %s: %v", m.StatefulSet, err)
+	}
+	name := m.ControllerStatefulSet()
+	labels := map[string]string{CONTROLLER_LABEL: name}
+	replicas := m.ControllerReplicas
+	template := brokers.Spec.Template.DeepCopy()
+	// the controllers must not be selected by the services of the brokers
+	template.Labels = labels
+	port, err := strconv.Atoi(m.ControllerPort)
+	if err != nil {
+		return nil, fmt.Errorf("invalid controller port '%s': %v", m.ControllerPort, err)
+	}
+	for i := range template.Spec.Containers {
+		template.Spec.Containers[i].LivenessProbe = nil
+		template.Spec.Containers[i].ReadinessProbe = &v1.Probe{
+			Handler: v1.Handler{
+				TCPSocket: &v1.TCPSocketAction{Port: intstr.FromInt(port)},
+			},
+		}
+	}
+	var claims []v1.PersistentVolumeClaim
+	for _, claim := range brokers.Spec.VolumeClaimTemplates {
+		claims = append(claims, *claim.DeepCopy())
+	}
+	return &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: m.Env.GetNamespace(),
+			Labels:    labels,
+		},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:    &replicas,
+			ServiceName: m.ControllerService(),
+			Selector: &metav1.LabelSelector{
+				MatchLabels: labels,
+			},
+			// the controllers only become ready once they have a quorum
+			PodManagementPolicy:  appsv1.ParallelPodManagement,
+			Template:             *template,
+			VolumeClaimTemplates: claims,
+		},
+	}, nil
+}
+
+func (m *Migrator) ensureControllerService() error {
+	name := m.ControllerService()
+	services := m.Client.CoreV1().Services(m.Env.GetNamespace())
+	if _, err := services.Get(name, metav1.GetOptions{}); err == nil || !errors.IsNotFound(err) {
+		return err
+	}
+	port, err := strconv.Atoi(m.ControllerPort)
+	if err != nil {
+		return fmt.Errorf("invalid controller port '%s': %v", m.ControllerPort, err)
+	}
+	_, err = services.Create(&v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: m.Env.GetNamespace(),
+		},
+		Spec: v1.ServiceSpec{
+			ClusterIP: v1.ClusterIPNone,
+			Selector:  map[string]string{CONTROLLER_LABEL: m.ControllerStatefulSet()},
+			Ports: []v1.ServicePort{
+				{Name: "controller", Port: int32(port)},
+			},
+			// the voters resolve each other before they are ready
+			PublishNotReadyAddresses: true,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("could not create the service %s: %v", name, err)
+	}
+	return nil
+}
+
+// ensureClusterID stores the id of the ZooKeeper cluster so the controllers are formatted with it
+func (m *Migrator) ensureClusterID() error {
+	clusterID, err := m.ClusterID()
+	if err != nil {
+		return err
+	}
+	name := storage.ClusterIDSecretName(m.ControllerStatefulSet())
+	secrets := m.Client.CoreV1().Secrets(m.Env.GetNamespace())
+	secret, err := secrets.Get(name, metav1.GetOptions{})
+	if err == nil {
+		stored, err := storage.ClusterIDFromSecret(secret)
+		if err != nil {
+			return err
+		}
+		if stored != clusterID {
+			return fmt.Errorf("secret %s holds the cluster id '%s' but the brokers belong to cluster '%s'", name, stored, clusterID)
+		}
+		// the secret of controllers deleted by a rollback is released, the next controllers adopt it instead of replacing the id
+		if len(secret.OwnerReferences) > 0 {
+			if err := m.releaseSecret(secret); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if !errors.IsNotFound(err) {
+		return fmt.Errorf("could not get the secret %s: %v", name, err)
+	}
+	_, err = secrets.Create(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: m.Env.GetNamespace(),
+		},
+		Type: v1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			storage.CLUSTER_ID_KEY: []byte(clusterID),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("could not create the secret %s: %v", name, err)
+	}
+	return nil
+}
+
+// releaseSecret removes the owners of secret unless the current controller StatefulSet is one of them
+func (m *Migrator) releaseSecret(secret *v1.Secret) error {
+	statefulSet, err := m.Client.AppsV1().StatefulSets(m.Env.GetNamespace()).Get(m.ControllerStatefulSet(), metav1.GetOptions{})
+	if err == nil && service.IsOwnedBy(secret, metav1.OwnerReference{UID: statefulSet.UID}) {
+		return nil
+	}
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("could not get the StatefulSet %s: %v", m.ControllerStatefulSet(), err)
+	}
+	secret.OwnerReferences = nil
+	if _, err := m.Client.CoreV1().Secrets(m.Env.GetNamespace()).Update(secret); err != nil {
+		return fmt.Errorf("could not update the secret %s: %v", secret.Name, err)
+	}
+	return nil
+}
+
+func (m *Migrator) deleteControllers() error {
+	namespace := m.Env.GetNamespace()
+	name := m.ControllerStatefulSet()
+	if err := m.Client.AppsV1().StatefulSets(namespace).Delete(name, &metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("could not delete the StatefulSet %s: %v", name, err)
+	}
+	if err := m.Client.CoreV1().Services(namespace).Delete(m.ControllerService(), &metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("could not delete the service %s: %v", m.ControllerService(), err)
+	}
+	log.Infof("deleted the KRaft controllers %s", name)
+	return nil
+}
+
+func (m *Migrator) waitForRollout(name string) error {
+	return m.poll(func() error {
+		statefulSet, err := m.Client.AppsV1().StatefulSets(m.Env.GetNamespace()).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		replicas := int32(1)
+		if statefulSet.Spec.Replicas != nil {
+			replicas = *statefulSet.Spec.Replicas
+		}
+		status := statefulSet.Status
+		if status.ObservedGeneration < statefulSet.Generation || status.UpdatedReplicas != replicas ||
+			status.ReadyReplicas != replicas || status.CurrentRevision != status.UpdateRevision {
+			log.Infof("waiting for the StatefulSet %s to roll out, %d/%d updated and %d/%d ready...",
+				name, status.UpdatedReplicas, replicas, status.ReadyReplicas, replicas)
+			return fmt.Errorf("StatefulSet %s is still rolling out", name)
+		}
+		return nil
+	})
+}
+
+func (m *Migrator) waitForMetadataMigration() error {
+	return m.poll(func() error {
+		state, err := m.MigrationState()
+		if err != nil {
+			return err
+		}
+		if state != ZK_MIGRATION_STATE_MIGRATION {
+			log.Infof("waiting for the controllers to migrate the metadata, migration state is %d...", state)
+			return fmt.Errorf("the metadata migration is not completed, migration state is %d", state)
+		}
+		log.Infoln("the controllers migrated the metadata from ZooKeeper")
+		return nil
+	})
+}
+
+func (m *Migrator) poll(check func() error) error {
+	return retry.Do(check,
+		retry.Attempts(uint(m.Timeout/m.PollInterval)+1),
+		retry.Delay(m.PollInterval),
+		retry.DelayType(retry.FixedDelay),
+		retry.LastErrorOnly(true),
+	)
+}
+
+// setEnv sets the environment variables on every container of template and returns true when it changed
+func setEnv(template *v1.PodTemplateSpec, env map[string]string) bool {
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	// a stable order keeps the pod template unchanged between two runs
+	sort.Strings(names)
+	changed := false
+	for i := range template.Spec.Containers {
+		container := &template.Spec.Containers[i]
+		for _, name := range names {
+			value := env[name]
+			found := false
+			for j := range container.Env {
+				if container.Env[j].Name == name {
+					found = true
+					if container.Env[j].Value != value || container.Env[j].ValueFrom != nil {
+						container.Env[j] = v1.EnvVar{Name: name, Value: value}
+						changed = true
+					}
+				}
+			}
+			if !found {
+				container.Env = append(container.Env, v1.EnvVar{Name: name, Value: value})
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// removeEnv removes the environment variables from every container of template and returns true when it changed
+func removeEnv(template *v1.PodTemplateSpec, names []string) bool {
+	changed := false
+	for i := range template.Spec.Containers {
+		container := &template.Spec.Containers[i]
+		var env []v1.EnvVar
+		for _, envVar := range container.Env {
+			if contains(names, envVar.Name) {
+				changed = true
+				continue
+			}
+			env = append(env, envVar)
+		}
+		container.Env = env
+	}
+	return changed
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,393 @@
+package migration
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/config"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/events"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/kraft"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/mocks"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/storage"
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/reporters"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	testclient "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var _ = Describe("[Kafka Migration]", func() {
+
+	var (
+		mockCtrl       *gomock.Controller
+		mockEnv        *mocks.MockEnvironment
+		client         *testclient.Clientset
+		migrationState int
+		migrator       *Migrator
+	)
+
+	// rolledOut marks every created or updated StatefulSet as rolled out like the StatefulSet controller would
+	rolledOut := func(action k8stesting.Action) (bool, runtime.Object, error) {
+		var statefulSet *appsv1.StatefulSet
+		switch a := action.(type) {
+		case k8stesting.CreateAction:
+			statefulSet = a.GetObject().(*appsv1.StatefulSet)
+		case k8stesting.UpdateAction:
+			statefulSet = a.GetObject().(*appsv1.StatefulSet)
+		}
+		statefulSet.Status = appsv1.StatefulSetStatus{
+			ReadyReplicas:   *statefulSet.Spec.Replicas,
+			UpdatedReplicas: *statefulSet.Spec.Replicas,
+		}
+		return false, nil, nil
+	}
+
+	getEnv := func(name string) map[string]string {
+		statefulSet, err := client.AppsV1().StatefulSets("default").Get(name, metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		env := map[string]string{}
+		for _, envVar := range statefulSet.Spec.Template.Spec.Containers[0].Env {
+			env[envVar.Name] = envVar.Value
+		}
+		return env
+	}
+
+	advanceTo := func(phase Phase) {
+		for {
+			state, err := migrator.Advance()
+			Expect(err).To(BeNil())
+			if state.Phase == phase {
+				return
+			}
+		}
+	}
+
+	Context("Phases", func() {
+		It("are ordered", func() {
+			next, err := PHASE_ZOOKEEPER.Next()
+			Expect(err).To(BeNil())
+			Expect(next).To(Equal(PHASE_CONTROLLERS))
+			_, err = PHASE_FINALIZED.Next()
+			Expect(err).To(HaveOccurred())
+			Expect(PHASE_BROKERS_KRAFT.AtLeast(PHASE_BROKERS_MIGRATING)).To(BeTrue())
+		})
+		It("can't be rolled back once finalized", func() {
+			previous, err := PHASE_BROKERS_KRAFT.Previous()
+			Expect(err).To(BeNil())
+			Expect(previous).To(Equal(PHASE_BROKERS_MIGRATING))
+			_, err = PHASE_FINALIZED.Previous()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Migration", func() {
+		It("starts in the ZooKeeper phase", func() {
+			state, err := migrator.LoadState()
+			Expect(err).To(BeNil())
+			Expect(state.Phase).To(Equal(PHASE_ZOOKEEPER))
+		})
+		It("deploys the controllers in migration mode with the cluster id of the brokers", func() {
+			state, err := migrator.Advance()
+			Expect(err).To(BeNil())
+			Expect(state.Phase).To(Equal(PHASE_CONTROLLERS))
+			Expect(state.Status).To(Equal(STATUS_COMPLETED))
+
+			controllers, err := client.AppsV1().StatefulSets("default").Get("kafka-kafka-controller", metav1.GetOptions{})
+			Expect(err).To(BeNil())
+			Expect(*controllers.Spec.Replicas).To(Equal(int32(3)))
+			Expect(controllers.Spec.ServiceName).To(Equal("kafka-kafka-controller-svc"))
+			Expect(controllers.Spec.Template.Labels).To(Equal(map[string]string{CONTROLLER_LABEL: "kafka-kafka-controller"}))
+			env := getEnv("kafka-kafka-controller")
+			Expect(env[kraft.KRAFT_ENABLED_ENV]).To(Equal("true"))
+			Expect(env[kraft.KRAFT_PROCESS_ROLES_ENV]).To(Equal("controller"))
+			Expect(env[KRAFT_MIGRATION_PHASE_ENV]).To(Equal(string(PHASE_CONTROLLERS)))
+			Expect(env[ZOOKEEPER_URI_ENV]).To(Equal("zk-0:2181"))
+
+			secret, err := client.CoreV1().Secrets("default").Get("kafka-kafka-controller-cluster-id", metav1.GetOptions{})
+			Expect(err).To(BeNil())
+			Expect(string(secret.Data[storage.CLUSTER_ID_KEY])).To(Equal("zk-cluster-id"))
+			_, err = client.CoreV1().Services("default").Get("kafka-kafka-controller-svc", metav1.GetOptions{})
+			Expect(err).To(BeNil())
+			Expect(getEnv("kafka-kafka")).NotTo(HaveKey(KRAFT_MIGRATION_PHASE_ENV))
+		})
+		It("releases the cluster id of the controllers deleted by a rollback", func() {
+			_, err := client.CoreV1().Secrets("default").Create(&v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            "kafka-kafka-controller-cluster-id",
+					Namespace:       "default",
+					OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "StatefulSet", Name: "kafka-kafka-controller", UID: "previous"}},
+				},
+				Data: map[string][]byte{storage.CLUSTER_ID_KEY: []byte("zk-cluster-id")},
+			})
+			Expect(err).To(BeNil())
+			_, err = migrator.Advance()
+			Expect(err).To(BeNil())
+			secret, err := client.CoreV1().Secrets("default").Get("kafka-kafka-controller-cluster-id", metav1.GetOptions{})
+			Expect(err).To(BeNil())
+			Expect(secret.OwnerReferences).To(BeEmpty())
+		})
+		It("waits for the metadata migration before completing the broker phase", func() {
+			advanceTo(PHASE_CONTROLLERS)
+			migrationState = ZK_MIGRATION_STATE_PRE_MIGRATION
+			state, err := migrator.Advance()
+			Expect(err).To(HaveOccurred())
+			Expect(state.Phase).To(Equal(PHASE_BROKERS_MIGRATING))
+			Expect(state.Status).To(Equal(STATUS_FAILED))
+			Expect(getEnv("kafka-kafka")[KRAFT_MIGRATION_PHASE_ENV]).To(Equal(string(PHASE_BROKERS_MIGRATING)))
+
+			migrationState = ZK_MIGRATION_STATE_MIGRATION
+			state, err = migrator.Advance()
+			Expect(err).To(BeNil())
+			Expect(state.Phase).To(Equal(PHASE_BROKERS_MIGRATING))
+			Expect(state.Status).To(Equal(STATUS_COMPLETED))
+		})
+		It("rolls the brokers into KRaft mode and finalizes the controllers", func() {
+			advanceTo(PHASE_FINALIZED)
+			brokerEnv := getEnv("kafka-kafka")
+			Expect(brokerEnv[KRAFT_MIGRATION_PHASE_ENV]).To(Equal(string(PHASE_BROKERS_KRAFT)))
+			Expect(brokerEnv[kraft.KRAFT_PROCESS_ROLES_ENV]).To(Equal("broker"))
+			Expect(brokerEnv).NotTo(HaveKey(kraft.KRAFT_ENABLED_ENV))
+			Expect(getEnv("kafka-kafka-controller")[KRAFT_MIGRATION_PHASE_ENV]).To(Equal(string(PHASE_FINALIZED)))
+
+			_, err := migrator.Advance()
+			Expect(err).To(HaveOccurred())
+			_, err = migrator.Rollback()
+			Expect(err).To(HaveOccurred())
+		})
+		It("rolls back to ZooKeeper", func() {
+			advanceTo(PHASE_BROKERS_KRAFT)
+			for _, phase := range []Phase{PHASE_BROKERS_MIGRATING, PHASE_CONTROLLERS, PHASE_ZOOKEEPER} {
+				state, err := migrator.Rollback()
+				Expect(err).To(BeNil())
+				Expect(state.Phase).To(Equal(phase))
+			}
+			Expect(getEnv("kafka-kafka")).NotTo(HaveKey(KRAFT_MIGRATION_PHASE_ENV))
+			_, err := client.AppsV1().StatefulSets("default").Get("kafka-kafka-controller", metav1.GetOptions{})
+			Expect(err).To(HaveOccurred())
+
+			eventList, err := client.CoreV1().Events("default").List(metav1.ListOptions{})
+			Expect(err).To(BeNil())
+			Expect(eventList.Items[len(eventList.Items)-1].Reason).To(Equal(MIGRATION_ROLLBACK_REASON))
+		})
+		It("refuses controllers formatted for another cluster", func() {
+			_, err := client.CoreV1().Secrets("default").Create(&v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "kafka-kafka-controller-cluster-id", Namespace: "default"},
+				Data:       map[string][]byte{storage.CLUSTER_ID_KEY: []byte("another")},
+			})
+			Expect(err).To(BeNil())
+			state, err := migrator.Advance()
+			Expect(err).To(HaveOccurred())
+			Expect(state.Status).To(Equal(STATUS_FAILED))
+		})
+	})
+
+	Context("Node configuration", func() {
+		var (
+			dir          string
+			kraftService *kraft.KRaftService
+		)
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("/tmp", "kafka-migration-test")
+			Expect(err).To(BeNil())
+			kraftService = &kraft.KRaftService{
+				Client: client,
+				Env:    mockEnv,
+				Config: &kraft.Config{
+					StatefulSet:        "kafka-kafka-controller",
+					Replicas:           3,
+					HeadlessService:    "kafka-kafka-controller-svc",
+					ControllerIDOffset: 3000,
+					ClusterDomain:      kraft.DEFAULT_CLUSTER_DOMAIN,
+					ControllerPort:     kraft.DEFAULT_CONTROLLER_PORT,
+					ProcessRoles:       "broker",
+				},
+			}
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		readProperties := func() string {
+			properties, err := ioutil.ReadFile(filepath.Join(dir, MIGRATION_PROPERTIES_FILE))
+			Expect(err).To(BeNil())
+			return string(properties)
+		}
+
+		It("enables the migration on the brokers", func() {
+			Expect(WriteConfigToPath(dir, PHASE_BROKERS_MIGRATING, kraftService, "")).To(BeNil())
+			properties := readProperties()
+			Expect(properties).To(ContainSubstring("zookeeper.metadata.migration.enable=true\n"))
+			Expect(properties).To(ContainSubstring("controller.quorum.voters=3000@kafka-kafka-controller-0.kafka-kafka-controller-svc.default.svc.cluster.local:9097,"))
+			Expect(properties).NotTo(ContainSubstring("process.roles"))
+			Expect(properties).To(ContainSubstring("listener.security.protocol.map=PLAINTEXT:PLAINTEXT,SSL:SSL,SASL_PLAINTEXT:SASL_PLAINTEXT,SASL_SSL:SASL_SSL,CONTROLLER:PLAINTEXT\n"))
+			Expect(properties).To(ContainSubstring("inter.broker.protocol.version=3.4\n"))
+		})
+		It("keeps the listeners and a recent protocol version of the brokers", func() {
+			Expect(ioutil.WriteFile(filepath.Join(dir, config.SERVER_PROPERTIES_FILE),
+				[]byte("listener.security.protocol.map=INTERNAL:SSL\ninter.broker.protocol.version=3.5-IV2\n"), 0644)).To(BeNil())
+			Expect(WriteConfigToPath(dir, PHASE_BROKERS_MIGRATING, kraftService, "")).To(BeNil())
+			properties := readProperties()
+			Expect(properties).To(ContainSubstring("listener.security.protocol.map=INTERNAL:SSL,CONTROLLER:PLAINTEXT\n"))
+			Expect(properties).To(ContainSubstring("inter.broker.protocol.version=3.5-IV2\n"))
+
+			Expect(ioutil.WriteFile(filepath.Join(dir, config.SERVER_PROPERTIES_FILE), []byte("inter.broker.protocol.version=2.8\n"), 0644)).To(BeNil())
+			Expect(WriteConfigToPath(dir, PHASE_BROKERS_MIGRATING, kraftService, "")).To(BeNil())
+			Expect(readProperties()).To(ContainSubstring("inter.broker.protocol.version=3.4\n"))
+		})
+		It("runs the brokers in KRaft mode", func() {
+			Expect(WriteConfigToPath(dir, PHASE_BROKERS_KRAFT, kraftService, "")).To(BeNil())
+			properties := readProperties()
+			Expect(properties).To(ContainSubstring("process.roles=broker\n"))
+			Expect(properties).To(ContainSubstring("node.id=1\n"))
+			Expect(properties).NotTo(ContainSubstring(MIGRATION_ENABLE_KEY))
+			Expect(properties).To(ContainSubstring(",CONTROLLER:PLAINTEXT\n"))
+		})
+		It("renders the final broker config of every phase", func() {
+			serverProperties := filepath.Join(dir, config.SERVER_PROPERTIES_FILE)
+			Expect(ioutil.WriteFile(serverProperties, []byte("broker.id=1\nzookeeper.connect=zk-0:2181\n"+
+				"inter.broker.protocol.version=3.3\nlisteners=INTERNAL://:9093\nlistener.security.protocol.map=INTERNAL:SSL\n"), 0644)).To(BeNil())
+			render := func(phase Phase) map[string]string {
+				Expect(WriteConfigToPath(dir, phase, kraftService, "")).To(BeNil())
+				Expect(MergeConfigIntoPath(dir, phase, kraftService)).To(BeNil())
+				properties, err := config.ReadProperties(serverProperties)
+				Expect(err).To(BeNil())
+				values := map[string]string{}
+				for _, key := range properties.Keys() {
+					values[key], _ = properties.Get(key)
+				}
+				return values
+			}
+
+			properties := render(PHASE_ZOOKEEPER)
+			Expect(properties).To(HaveKeyWithValue("broker.id", "1"))
+			Expect(properties).To(HaveKeyWithValue(PROTOCOL_VERSION_KEY, "3.3"))
+			Expect(properties).NotTo(HaveKey(MIGRATION_ENABLE_KEY))
+
+			properties = render(PHASE_BROKERS_MIGRATING)
+			Expect(properties).To(HaveKeyWithValue(MIGRATION_ENABLE_KEY, "true"))
+			Expect(properties).To(HaveKeyWithValue("zookeeper.connect", "zk-0:2181"))
+			Expect(properties).To(HaveKeyWithValue("broker.id", "1"))
+			Expect(properties).To(HaveKeyWithValue(PROTOCOL_VERSION_KEY, "3.4"))
+			Expect(properties).To(HaveKeyWithValue("listener.security.protocol.map", "INTERNAL:SSL,CONTROLLER:PLAINTEXT"))
+			Expect(properties).NotTo(HaveKey("process.roles"))
+
+			for _, phase := range []Phase{PHASE_BROKERS_KRAFT, PHASE_FINALIZED} {
+				properties = render(phase)
+				Expect(properties).To(HaveKeyWithValue("process.roles", "broker"))
+				Expect(properties).To(HaveKeyWithValue("node.id", "1"))
+				Expect(properties).To(HaveKeyWithValue("listeners", "INTERNAL://:9093"))
+				Expect(properties).To(HaveKeyWithValue("listener.security.protocol.map", "INTERNAL:SSL,CONTROLLER:PLAINTEXT"))
+				for _, key := range ZOOKEEPER_BROKER_KEYS {
+					Expect(properties).NotTo(HaveKey(key))
+				}
+			}
+
+			// the rollback restores the ZooKeeper config of the broker
+			properties = render(PHASE_BROKERS_MIGRATING)
+			Expect(properties).To(HaveKeyWithValue("zookeeper.connect", "zk-0:2181"))
+			Expect(properties).To(HaveKeyWithValue("broker.id", "1"))
+			Expect(properties).To(HaveKeyWithValue(PROTOCOL_VERSION_KEY, "3.4"))
+			Expect(properties).NotTo(HaveKey("process.roles"))
+		})
+		It("connects the controllers to ZooKeeper until the migration is finalized", func() {
+			kraftService.Config.ProcessRoles = "controller"
+			Expect(WriteConfigToPath(dir, PHASE_BROKERS_KRAFT, kraftService, "zk-0:2181")).To(BeNil())
+			Expect(readProperties()).To(ContainSubstring("zookeeper.connect=zk-0:2181\n"))
+
+			Expect(WriteConfigToPath(dir, PHASE_FINALIZED, kraftService, "zk-0:2181")).To(BeNil())
+			Expect(readProperties()).To(BeEmpty())
+		})
+	})
+
+	Context("Migration progress", func() {
+		It("reads the migration state of the active controller", func() {
+			inactive := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, "# TYPE %s gauge\n%s 0\n", ZK_MIGRATION_STATE_METRIC, ZK_MIGRATION_STATE_METRIC)
+			}))
+			defer inactive.Close()
+			active := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, "# TYPE %s gauge\n%s 1\n", ZK_MIGRATION_STATE_METRIC, ZK_MIGRATION_STATE_METRIC)
+			}))
+			defer active.Close()
+
+			state, err := NewMetricsMigrationState(http.DefaultClient, []string{inactive.URL, "http://127.0.0.1:1", active.URL})()
+			Expect(err).To(BeNil())
+			Expect(state).To(Equal(ZK_MIGRATION_STATE_MIGRATION))
+		})
+		It("fails when no controller exports the state", func() {
+			_, err := NewMetricsMigrationState(http.DefaultClient, []string{"http://127.0.0.1:1"})()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockEnv = mocks.NewMockEnvironment(mockCtrl)
+		mockEnv.EXPECT().GetNamespace().Return("default").AnyTimes()
+		mockEnv.EXPECT().GetHostName().Return("kafka-kafka-1").AnyTimes()
+		mockEnv.EXPECT().GetNodeName().Return("kubelet-0").AnyTimes()
+
+		replicas := int32(3)
+		client = testclient.NewSimpleClientset(&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "kafka-kafka", Namespace: "default"},
+			Spec: appsv1.StatefulSetSpec{
+				Replicas: &replicas,
+				Template: v1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "kafka"}},
+					Spec: v1.PodSpec{
+						Containers: []v1.Container{{Name: "kafka", Env: []v1.EnvVar{{Name: "ZOOKEEPER_URI", Value: "zk-0:2181"}}}},
+					},
+				},
+			},
+		})
+		client.PrependReactor("create", "statefulsets", rolledOut)
+		client.PrependReactor("update", "statefulsets", rolledOut)
+
+		migrationState = ZK_MIGRATION_STATE_MIGRATION
+		migrator = &Migrator{
+			Client: client,
+			Env:    mockEnv,
+			Events: &events.Recorder{
+				Client: client,
+				Env:    mockEnv,
+			},
+			StatefulSet:        "kafka-kafka",
+			ControllerReplicas: DEFAULT_CONTROLLER_REPLICAS,
+			ControllerIDOffset: DEFAULT_CONTROLLER_ID_OFFSET,
+			ControllerPort:     kraft.DEFAULT_CONTROLLER_PORT,
+			ZookeeperURI:       "zk-0:2181",
+			ClusterID: func() (string, error) {
+				return "zk-cluster-id", nil
+			},
+			MigrationState: func() (int, error) {
+				return migrationState, nil
+			},
+			PollInterval: 10 * time.Millisecond,
+			Timeout:      50 * time.Millisecond,
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+})
+
+func TestMigration(t *testing.T) {
+	RegisterFailHandler(Fail)
+	junitReporter := reporters.NewJUnitReporter(fmt.Sprintf("%s-junit.xml", "kafka-utils-migration"))
+	RunSpecsWithDefaultAndCustomReporters(t, "KafkaUtils Migration Suite", []Reporter{junitReporter})
+}
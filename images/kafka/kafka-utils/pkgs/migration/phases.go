@@ -0,0 +1,74 @@
+package migration
+
+import (
+	"fmt"
+)
+
+// Phase is a step of the ZooKeeper to KRaft migration
+type Phase string
+
+const (
+	// PHASE_ZOOKEEPER is the initial phase, the cluster only runs on ZooKeeper
+	PHASE_ZOOKEEPER Phase = "ZooKeeper"
+	// PHASE_CONTROLLERS runs the KRaft controllers in migration mode next to the ZooKeeper brokers
+	PHASE_CONTROLLERS Phase = "ControllersMigrating"
+	// PHASE_BROKERS_MIGRATING rolls the brokers with the migration enabled so the controllers copy the metadata
+	PHASE_BROKERS_MIGRATING Phase = "BrokersMigrating"
+	// PHASE_BROKERS_KRAFT rolls the brokers in KRaft mode while the controllers keep writing to ZooKeeper
+	PHASE_BROKERS_KRAFT Phase = "BrokersKRaft"
+	// PHASE_FINALIZED rolls the controllers without ZooKeeper, the migration can't be rolled back anymore
+	PHASE_FINALIZED Phase = "Finalized"
+)
+
+var phases = []Phase{
+	PHASE_ZOOKEEPER,
+	PHASE_CONTROLLERS,
+	PHASE_BROKERS_MIGRATING,
+	PHASE_BROKERS_KRAFT,
+	PHASE_FINALIZED,
+}
+
+// ParsePhase returns the phase named name
+func ParsePhase(name string) (Phase, error) {
+	for _, phase := range phases {
+		if string(phase) == name {
+			return phase, nil
+		}
+	}
+	return "", fmt.Errorf("unknown migration phase '%s'", name)
+}
+
+func (p Phase) index() int {
+	for i, phase := range phases {
+		if phase == p {
+			return i
+		}
+	}
+	return -1
+}
+
+// Next returns the phase following p
+func (p Phase) Next() (Phase, error) {
+	i := p.index()
+	if i < 0 || i == len(phases)-1 {
+		return "", fmt.Errorf("no migration phase after '%s'", p)
+	}
+	return phases[i+1], nil
+}
+
+// Previous returns the phase a rollback of p goes back to
+func (p Phase) Previous() (Phase, error) {
+	if p == PHASE_FINALIZED {
+		return "", fmt.Errorf("the migration is finalized and can't be rolled back")
+	}
+	i := p.index()
+	if i <= 0 {
+		return "", fmt.Errorf("no migration phase before '%s'", p)
+	}
+	return phases[i-1], nil
+}
+
+// AtLeast returns true when p is other or a later phase
+func (p Phase) AtLeast(other Phase) bool {
+	return p.index() >= other.index()
+}
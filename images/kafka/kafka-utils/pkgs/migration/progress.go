@@ -0,0 +1,68 @@
+package migration
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/common/expfmt"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// ZK_MIGRATION_STATE_METRIC is the ZkMigrationState of the controllers exported by the JMX exporter
+	ZK_MIGRATION_STATE_METRIC = "kafka_controller_zk_migration_state"
+	// ZK_MIGRATION_STATE_MIGRATION is reported by the active controller once the metadata has been copied from ZooKeeper
+	ZK_MIGRATION_STATE_MIGRATION = 1
+	// ZK_MIGRATION_STATE_PRE_MIGRATION is reported while the metadata is being copied
+	ZK_MIGRATION_STATE_PRE_MIGRATION = 2
+)
+
+// NewMetricsMigrationState returns a function reading the migration state from the metrics endpoints of the controllers
+func NewMetricsMigrationState(httpClient *http.Client, urls []string) func() (int, error) {
+	return func() (int, error) {
+		state := -1
+		for _, url := range urls {
+			value, err := scrapeMigrationState(httpClient, url)
+			if err != nil {
+				log.Infof("could not read the migration state from %s: %v", url, err)
+				continue
+			}
+			// only the active controller reports the migration as done
+			if value == ZK_MIGRATION_STATE_MIGRATION {
+				return value, nil
+			}
+			if state < 0 {
+				state = value
+			}
+		}
+		if state < 0 {
+			return 0, fmt.Errorf("no controller exported %s", ZK_MIGRATION_STATE_METRIC)
+		}
+		return state, nil
+	}
+}
+
+func scrapeMigrationState(httpClient *http.Client, url string) (int, error) {
+	response, err := httpClient.Get(url)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status %s", response.Status)
+	}
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(response.Body)
+	if err != nil {
+		return 0, err
+	}
+	family, found := families[ZK_MIGRATION_STATE_METRIC]
+	if !found || len(family.GetMetric()) == 0 {
+		return 0, fmt.Errorf("metric %s not found", ZK_MIGRATION_STATE_METRIC)
+	}
+	metric := family.GetMetric()[0]
+	if metric.GetGauge() != nil {
+		return int(metric.GetGauge().GetValue()), nil
+	}
+	return int(metric.GetUntyped().GetValue()), nil
+}
@@ -0,0 +1,88 @@
+package migration
+
+import (
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	STATUS_IN_PROGRESS = "InProgress"
+	STATUS_COMPLETED   = "Completed"
+	STATUS_FAILED      = "Failed"
+	PHASE_KEY          = "phase"
+	STATUS_KEY         = "status"
+	MESSAGE_KEY        = "message"
+	UPDATED_KEY        = "updated"
+)
+
+// State is the progress of the migration persisted in the migration ConfigMap
+type State struct {
+	Phase   Phase
+	Status  string
+	Message string
+	Updated time.Time
+}
+
+func (s *State) String() string {
+	return fmt.Sprintf("phase %s %s: %s", s.Phase, s.Status, s.Message)
+}
+
+func (m *Migrator) stateConfigMapName() string {
+	return fmt.Sprintf("%s-kraft-migration", m.StatefulSet)
+}
+
+// LoadState returns the persisted migration state, a cluster never migrated is in the ZooKeeper phase
+func (m *Migrator) LoadState() (*State, error) {
+	name := m.stateConfigMapName()
+	configMap, err := m.Client.CoreV1().ConfigMaps(m.Env.GetNamespace()).Get(name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return &State{Phase: PHASE_ZOOKEEPER, Status: STATUS_COMPLETED}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get the ConfigMap %s: %v", name, err)
+	}
+	phase, err := ParsePhase(configMap.Data[PHASE_KEY])
+	if err != nil {
+		return nil, err
+	}
+	state := &State{
+		Phase:   phase,
+		Status:  configMap.Data[STATUS_KEY],
+		Message: configMap.Data[MESSAGE_KEY],
+	}
+	state.Updated, _ = time.Parse(time.RFC3339, configMap.Data[UPDATED_KEY])
+	return state, nil
+}
+
+func (m *Migrator) saveState(state *State) error {
+	name := m.stateConfigMapName()
+	state.Updated = time.Now()
+	data := map[string]string{
+		PHASE_KEY:   string(state.Phase),
+		STATUS_KEY:  state.Status,
+		MESSAGE_KEY: state.Message,
+		UPDATED_KEY: state.Updated.UTC().Format(time.RFC3339),
+	}
+	configMaps := m.Client.CoreV1().ConfigMaps(m.Env.GetNamespace())
+	configMap, err := configMaps.Get(name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = configMaps.Create(&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: m.Env.GetNamespace(),
+			},
+			Data: data,
+		})
+	} else if err == nil {
+		configMap.Data = data
+		_, err = configMaps.Update(configMap)
+	}
+	if err != nil {
+		return fmt.Errorf("could not save the migration state in the ConfigMap %s: %v", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,418 @@
+package replacement
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/events"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/storage"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/throttle"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// MARKER_FILE is written into the first log dir while the broker is being replaced, kafka ignores files in the log dir root
+	MARKER_FILE                  = ".broker-replacement"
+	DEFAULT_THROTTLE_RATE        = 50 * 1024 * 1024
+	REPLACEMENT_ENABLED_ENV      = "BROKER_REPLACEMENT_ENABLED"
+	REPLACEMENT_RATE_ENV         = "BROKER_REPLACEMENT_THROTTLE_RATE"
+	REPLACEMENT_BOOTSTRAP_ENV    = "BROKER_REPLACEMENT_BOOTSTRAP_SERVERS"
+	REPLACEMENT_STARTED_REASON   = "BrokerReplacementStarted"
+	REPLACEMENT_PROGRESS_REASON  = "BrokerReplacementProgress"
+	REPLACEMENT_COMPLETED_REASON = "BrokerReplacementCompleted"
+	REPLACEMENT_FAILED_REASON    = "BrokerReplacementFailed"
+)
+
+// TopicReplicas are the throttled replicas of a topic
+type TopicReplicas struct {
+	Leader   []string `json:"leader"`
+	Follower []string `json:"follower"`
+}
+
+// Plan records the throttles applied for a replacement so they can be cleared by another process
+type Plan struct {
+	BrokerID int32 `json:"brokerId"`
+	Rate     int64 `json:"rate"`
+	// Leaders are the brokers throttled on the leader side
+	Leaders    []int32                   `json:"leaders"`
+	Topics     map[string]*TopicReplicas `json:"topics"`
+	Partitions int                       `json:"partitions"`
+	// PreviousRates are the rates the plan overwrote, the leader rate of the leaders and the follower rate of the
+	// replaced broker, an empty rate was unset. They are restored once the broker is back in sync.
+	PreviousRates map[int32]string `json:"previousRates"`
+}
+
+// Progress is the number of partitions of the replaced broker back in sync
+type Progress struct {
+	InSync     int
+	Partitions int
+}
+
+func (p Progress) Done() bool {
+	return p.InSync >= p.Partitions
+}
+
+func (p Progress) Percent() int {
+	if p.Partitions == 0 {
+		return 100
+	}
+	return p.InSync * 100 / p.Partitions
+}
+
+// Replacer throttles the re-replication of a broker which lost its log dirs until it is back in sync
+type Replacer struct {
+	NewAdmin func() (sarama.ClusterAdmin, error)
+	Events   *events.Recorder
+	BrokerID int32
+	LogDirs  []string
+	// Rate is the replication throttle in bytes per second
+	Rate     int64
+	Interval time.Duration
+}
+
+// IsEnabled returns false when the broker replacement throttling has been disabled
+func IsEnabled() bool {
+	return !strings.EqualFold(os.Getenv(REPLACEMENT_ENABLED_ENV), "false")
+}
+
+// BootstrapServersFromEnv returns the cluster-wide bootstrap address the replacement is prepared with, e.g. the headless
+// service of the brokers. The local broker isn't started yet when the replacement is prepared, nothing is returned when unset.
+func BootstrapServersFromEnv() []string {
+	var servers []string
+	for _, server := range strings.Split(os.Getenv(REPLACEMENT_BOOTSTRAP_ENV), ",") {
+		if server = strings.TrimSpace(server); len(server) > 0 {
+			servers = append(servers, server)
+		}
+	}
+	return servers
+}
+
+func (r *Replacer) markerPath() string {
+	return filepath.Join(r.LogDirs[0], MARKER_FILE)
+}
+
+// IsEmpty returns true when no log dir of the broker has been formatted yet
+func (r *Replacer) IsEmpty() (bool, error) {
+	for _, logDir := range r.LogDirs {
+		meta, err := storage.ReadMetaProperties(logDir)
+		if err != nil {
+			return false, err
+		}
+		if meta != nil {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Prepare throttles the replication towards the broker when it comes back with empty log dirs but still owns replicas
+func (r *Replacer) Prepare() error {
+	if plan, err := r.readPlan(); err != nil || plan != nil {
+		// a previous attempt already throttled the replication
+		return err
+	}
+	empty, err := r.IsEmpty()
+	if err != nil || !empty {
+		return err
+	}
+
+	admin, err := r.NewAdmin()
+	if err != nil {
+		r.Events.Eventf(v1.EventTypeWarning, REPLACEMENT_FAILED_REASON, "could not connect to the cluster to throttle the replication of broker %d: %v", r.BrokerID, err)
+		return fmt.Errorf("could not connect to the cluster: %v", err)
+	}
+	defer admin.Close()
+
+	plan, err := r.newPlan(admin)
+	if err != nil {
+		return err
+	}
+	if plan.Partitions == 0 {
+		log.Infof("broker %d has empty log dirs and no replica, nothing to throttle", r.BrokerID)
+		return nil
+	}
+	// the plan is written first so the throttles can always be cleared
+	if err := r.writePlan(plan); err != nil {
+		return err
+	}
+	if err := r.applyPlan(admin, plan); err != nil {
+		r.Events.Eventf(v1.EventTypeWarning, REPLACEMENT_FAILED_REASON, "could not throttle the replication of broker %d: %v", r.BrokerID, err)
+		return err
+	}
+	r.Events.Eventf(v1.EventTypeNormal, REPLACEMENT_STARTED_REASON, "broker %d lost its log dirs, its %d replicas are re-replicated at %d bytes/s",
+		r.BrokerID, plan.Partitions, plan.Rate)
+	return nil
+}
+
+func (r *Replacer) newPlan(admin sarama.ClusterAdmin) (*Plan, error) {
+	metadata, err := describeTopics(admin)
+	if err != nil {
+		return nil, err
+	}
+	plan := &Plan{
+		BrokerID:      r.BrokerID,
+		Rate:          r.Rate,
+		Topics:        map[string]*TopicReplicas{},
+		PreviousRates: map[int32]string{},
+	}
+	leaders := map[int32]bool{}
+	for _, topic := range metadata {
+		for _, partition := range topic.Partitions {
+			if !containsBroker(partition.Replicas, r.BrokerID) {
+				continue
+			}
+			replicas, found := plan.Topics[topic.Name]
+			if !found {
+				replicas = &TopicReplicas{}
+				plan.Topics[topic.Name] = replicas
+			}
+			replicas.Follower = append(replicas.Follower, throttle.Replica(partition.ID, r.BrokerID))
+			// the replicas are throttled on every broker the partition could be fetched from
+			for _, brokerID := range partition.Replicas {
+				if brokerID == r.BrokerID {
+					continue
+				}
+				replicas.Leader = append(replicas.Leader, throttle.Replica(partition.ID, brokerID))
+				leaders[brokerID] = true
+			}
+			plan.Partitions++
+		}
+	}
+	for brokerID := range leaders {
+		plan.Leaders = append(plan.Leaders, brokerID)
+	}
+	sort.Slice(plan.Leaders, func(i, j int) bool { return plan.Leaders[i] < plan.Leaders[j] })
+	throttler := &throttle.Throttler{Admin: admin}
+	for _, brokerID := range plan.Leaders {
+		throttles, err := throttler.DescribeBroker(brokerID)
+		if err != nil {
+			return nil, err
+		}
+		plan.PreviousRates[brokerID] = throttles[throttle.LEADER_RATE_KEY]
+	}
+	return plan, nil
+}
+
+func (r *Replacer) applyPlan(admin sarama.ClusterAdmin, plan *Plan) error {
+	throttler := &throttle.Throttler{Admin: admin}
+	for _, topic := range sortedTopics(plan) {
+		replicas := plan.Topics[topic]
+		if err := throttler.AddTopicReplicas(topic, replicas.Leader, replicas.Follower); err != nil {
+			return err
+		}
+	}
+	for _, brokerID := range plan.Leaders {
+		if err := throttler.SetBrokerRates(brokerID, plan.Rate, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WaitForCatchUp reports the progress of a pending replacement and clears the throttles once the broker is in every ISR
+func (r *Replacer) WaitForCatchUp(stop <-chan struct{}) error {
+	plan, err := r.readPlan()
+	if err != nil || plan == nil {
+		return err
+	}
+	log.Infof("waiting for broker %d to catch up on its %d replicas", r.BrokerID, plan.Partitions)
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	followerThrottled := false
+	reported := -1
+	for {
+		done, err := r.check(plan, &followerThrottled, &reported)
+		if err != nil {
+			log.Errorf("could not check the replacement of broker %d: %v", r.BrokerID, err)
+		} else if done {
+			return nil
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Replacer) check(plan *Plan, followerThrottled *bool, reported *int) (bool, error) {
+	admin, err := r.NewAdmin()
+	if err != nil {
+		return false, err
+	}
+	defer admin.Close()
+
+	// the follower side can only be throttled once the broker is running
+	if !*followerThrottled {
+		if err := r.throttleFollower(admin, plan); err != nil {
+			log.Infof("could not throttle broker %d yet: %v", r.BrokerID, err)
+		} else {
+			*followerThrottled = true
+		}
+	}
+
+	progress, err := r.Progress(admin, plan)
+	if err != nil {
+		return false, err
+	}
+	log.Infof("broker %d is in sync on %d/%d replicas (%d%%)", r.BrokerID, progress.InSync, progress.Partitions, progress.Percent())
+	// an event every quarter keeps the progress visible without flooding the events
+	if quarter := progress.Percent() / 25; quarter > *reported && !progress.Done() {
+		r.Events.Eventf(v1.EventTypeNormal, REPLACEMENT_PROGRESS_REASON, "broker %d is in sync on %d/%d replicas",
+			r.BrokerID, progress.InSync, progress.Partitions)
+		*reported = quarter
+	}
+	if !progress.Done() {
+		return false, nil
+	}
+
+	if err := r.clearPlan(admin, plan); err != nil {
+		return false, err
+	}
+	r.Events.Eventf(v1.EventTypeNormal, REPLACEMENT_COMPLETED_REASON, "broker %d is back in sync on its %d replicas, the replication throttles are cleared",
+		r.BrokerID, plan.Partitions)
+	return true, nil
+}
+
+// throttleFollower records the follower rate of the broker in the plan before it is overwritten, a rate recorded
+// before a restart is kept as it may already be the one of the plan
+func (r *Replacer) throttleFollower(admin sarama.ClusterAdmin, plan *Plan) error {
+	throttler := &throttle.Throttler{Admin: admin}
+	if _, found := plan.PreviousRates[r.BrokerID]; !found {
+		throttles, err := throttler.DescribeBroker(r.BrokerID)
+		if err != nil {
+			return err
+		}
+		plan.PreviousRates[r.BrokerID] = throttles[throttle.FOLLOWER_RATE_KEY]
+		if err := r.writePlan(plan); err != nil {
+			return err
+		}
+	}
+	return throttler.SetBrokerRates(r.BrokerID, 0, plan.Rate)
+}
+
+// Progress counts the replicas of the plan where the broker is back in the ISR
+func (r *Replacer) Progress(admin sarama.ClusterAdmin, plan *Plan) (*Progress, error) {
+	metadata, err := admin.DescribeTopics(sortedTopics(plan))
+	if err != nil {
+		return nil, fmt.Errorf("could not describe the topics: %v", err)
+	}
+	progress := &Progress{}
+	for _, topic := range metadata {
+		if topic.Err != sarama.ErrNoError {
+			// a deleted topic has nothing left to replicate
+			if topic.Err == sarama.ErrUnknownTopicOrPartition {
+				continue
+			}
+			return nil, fmt.Errorf("could not describe the topic %s: %v", topic.Name, topic.Err)
+		}
+		for _, partition := range topic.Partitions {
+			if !containsBroker(partition.Replicas, r.BrokerID) {
+				continue
+			}
+			progress.Partitions++
+			if containsBroker(partition.Isr, r.BrokerID) {
+				progress.InSync++
+			}
+		}
+	}
+	return progress, nil
+}
+
+func (r *Replacer) clearPlan(admin sarama.ClusterAdmin, plan *Plan) error {
+	throttler := &throttle.Throttler{Admin: admin}
+	for _, topic := range sortedTopics(plan) {
+		replicas := plan.Topics[topic]
+		if err := throttler.RemoveTopicReplicas(topic, replicas.Leader, replicas.Follower); err != nil {
+			return err
+		}
+	}
+	// only the rates set by the plan are restored, the other throttles of the brokers are left untouched
+	for _, brokerID := range plan.Leaders {
+		if err := throttler.RestoreBrokerRate(brokerID, throttle.LEADER_RATE_KEY, plan.PreviousRates[brokerID]); err != nil {
+			return err
+		}
+	}
+	if previous, found := plan.PreviousRates[plan.BrokerID]; found {
+		if err := throttler.RestoreBrokerRate(plan.BrokerID, throttle.FOLLOWER_RATE_KEY, previous); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(r.markerPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	log.Infof("cleared the replication throttles of the replacement of broker %d", r.BrokerID)
+	return nil
+}
+
+func (r *Replacer) readPlan() (*Plan, error) {
+	data, err := ioutil.ReadFile(r.markerPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	plan := &Plan{}
+	if err := json.Unmarshal(data, plan); err != nil {
+		return nil, fmt.Errorf("could not read the replacement plan %s: %v", r.markerPath(), err)
+	}
+	if plan.PreviousRates == nil {
+		plan.PreviousRates = map[int32]string{}
+	}
+	return plan, nil
+}
+
+func (r *Replacer) writePlan(plan *Plan) error {
+	data, err := json.Marshal(plan)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(r.markerPath(), data, 0644); err != nil {
+		return fmt.Errorf("could not write the replacement plan %s: %v", r.markerPath(), err)
+	}
+	return nil
+}
+
+func describeTopics(admin sarama.ClusterAdmin) ([]*sarama.TopicMetadata, error) {
+	topics, err := admin.ListTopics()
+	if err != nil {
+		return nil, fmt.Errorf("could not list the topics: %v", err)
+	}
+	names := make([]string, 0, len(topics))
+	for name := range topics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metadata, err := admin.DescribeTopics(names)
+	if err != nil {
+		return nil, fmt.Errorf("could not describe the topics: %v", err)
+	}
+	return metadata, nil
+}
+
+func sortedTopics(plan *Plan) []string {
+	topics := make([]string, 0, len(plan.Topics))
+	for topic := range plan.Topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+func containsBroker(brokers []int32, brokerID int32) bool {
+	for _, id := range brokers {
+		if id == brokerID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,214 @@
+package replacement
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/golang/mock/gomock"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/events"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/mocks"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/storage"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/throttle"
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/reporters"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testclient "k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Describe("[Kafka Broker Replacement]", func() {
+
+	var (
+		mockCtrl  *gomock.Controller
+		mockEnv   *mocks.MockEnvironment
+		mockAdmin *mocks.MockClusterAdmin
+		dir       string
+		client    *testclient.Clientset
+		replacer  *Replacer
+	)
+
+	// topics returns the metadata of a topic with two partitions, broker 1 is in the ISR of the partitions in inSync
+	topics := func(inSync ...int32) []*sarama.TopicMetadata {
+		partitions := []*sarama.PartitionMetadata{
+			{ID: 0, Leader: 0, Replicas: []int32{0, 1}, Isr: []int32{0}},
+			{ID: 1, Leader: 2, Replicas: []int32{2, 1}, Isr: []int32{2}},
+			{ID: 2, Leader: 0, Replicas: []int32{0, 2}, Isr: []int32{0, 2}},
+		}
+		for _, partition := range partitions {
+			for _, id := range inSync {
+				if partition.ID == id {
+					partition.Isr = append(partition.Isr, 1)
+				}
+			}
+		}
+		return []*sarama.TopicMetadata{{Name: "orders", Partitions: partitions}}
+	}
+
+	// describeRates returns the rates set on a broker
+	describeRates := func(brokerID string, entries ...sarama.ConfigEntry) {
+		mockAdmin.EXPECT().DescribeConfig(sarama.ConfigResource{
+			Type:        sarama.BrokerResource,
+			Name:        brokerID,
+			ConfigNames: []string{throttle.LEADER_RATE_KEY, throttle.FOLLOWER_RATE_KEY},
+		}).Return(entries, nil)
+	}
+
+	expectThrottles := func() {
+		mockAdmin.EXPECT().ListTopics().Return(map[string]sarama.TopicDetail{"orders": {}}, nil)
+		mockAdmin.EXPECT().DescribeTopics([]string{"orders"}).Return(topics(), nil)
+		// broker 0 already has rates set by an operator
+		describeRates("0",
+			sarama.ConfigEntry{Name: throttle.LEADER_RATE_KEY, Value: "2048"},
+			sarama.ConfigEntry{Name: throttle.FOLLOWER_RATE_KEY, Value: "4096"})
+		describeRates("2")
+		leader, follower := "0:0,1:2", "0:1,1:1"
+		mockAdmin.EXPECT().IncrementalAlterConfig(sarama.TopicResource, "orders", map[string]sarama.IncrementalAlterConfigsEntry{
+			throttle.LEADER_REPLICAS_KEY:   {Operation: sarama.IncrementalAlterConfigsOperationAppend, Value: &leader},
+			throttle.FOLLOWER_REPLICAS_KEY: {Operation: sarama.IncrementalAlterConfigsOperationAppend, Value: &follower},
+		}, false).Return(nil)
+		rate := "1024"
+		for _, brokerID := range []string{"0", "2"} {
+			mockAdmin.EXPECT().IncrementalAlterConfig(sarama.BrokerResource, brokerID, map[string]sarama.IncrementalAlterConfigsEntry{
+				throttle.LEADER_RATE_KEY: {Operation: sarama.IncrementalAlterConfigsOperationSet, Value: &rate},
+			}, false).Return(nil)
+		}
+		mockAdmin.EXPECT().Close().Return(nil)
+	}
+
+	reasons := func() []string {
+		eventList, err := client.CoreV1().Events("default").List(metav1.ListOptions{})
+		Expect(err).To(BeNil())
+		var reasons []string
+		for _, event := range eventList.Items {
+			reasons = append(reasons, event.Reason)
+		}
+		return reasons
+	}
+
+	Context("Detection", func() {
+		It("throttles the replicas of a broker back with empty log dirs", func() {
+			expectThrottles()
+			Expect(replacer.Prepare()).To(BeNil())
+			Expect(filepath.Join(dir, MARKER_FILE)).To(BeAnExistingFile())
+			Expect(reasons()).To(Equal([]string{REPLACEMENT_STARTED_REASON}))
+
+			// a restart before the catch up doesn't throttle twice
+			Expect(replacer.Prepare()).To(BeNil())
+		})
+		It("ignores a broker with formatted log dirs", func() {
+			Expect(ioutil.WriteFile(filepath.Join(dir, storage.META_PROPERTIES_FILE), []byte("broker.id=1\n"), 0644)).To(BeNil())
+			Expect(replacer.Prepare()).To(BeNil())
+			Expect(filepath.Join(dir, MARKER_FILE)).NotTo(BeAnExistingFile())
+		})
+		It("ignores a new broker without replicas", func() {
+			mockAdmin.EXPECT().ListTopics().Return(map[string]sarama.TopicDetail{}, nil)
+			mockAdmin.EXPECT().DescribeTopics([]string{}).Return(nil, nil)
+			mockAdmin.EXPECT().Close().Return(nil)
+			Expect(replacer.Prepare()).To(BeNil())
+			Expect(filepath.Join(dir, MARKER_FILE)).NotTo(BeAnExistingFile())
+		})
+		It("reports a cluster it can't connect to", func() {
+			replacer.NewAdmin = func() (sarama.ClusterAdmin, error) {
+				return nil, sarama.ErrOutOfBrokers
+			}
+			err := replacer.Prepare()
+			Expect(err).To(MatchError(ContainSubstring("could not connect to the cluster")))
+			Expect(filepath.Join(dir, MARKER_FILE)).NotTo(BeAnExistingFile())
+			Expect(reasons()).To(Equal([]string{REPLACEMENT_FAILED_REASON}))
+		})
+		It("reads the bootstrap address of the cluster", func() {
+			os.Setenv(REPLACEMENT_BOOTSTRAP_ENV, "kafka-svc.default.svc.cluster.local:9093, kafka-0:9093,")
+			defer os.Unsetenv(REPLACEMENT_BOOTSTRAP_ENV)
+			Expect(BootstrapServersFromEnv()).To(Equal([]string{"kafka-svc.default.svc.cluster.local:9093", "kafka-0:9093"}))
+			os.Unsetenv(REPLACEMENT_BOOTSTRAP_ENV)
+			Expect(BootstrapServersFromEnv()).To(BeEmpty())
+		})
+	})
+
+	Context("Catch up", func() {
+		BeforeEach(func() {
+			expectThrottles()
+			Expect(replacer.Prepare()).To(BeNil())
+		})
+
+		It("reports the progress and restores the previous rates once in sync", func() {
+			describeRates("1")
+			rate := "1024"
+			mockAdmin.EXPECT().IncrementalAlterConfig(sarama.BrokerResource, "1", map[string]sarama.IncrementalAlterConfigsEntry{
+				throttle.FOLLOWER_RATE_KEY: {Operation: sarama.IncrementalAlterConfigsOperationSet, Value: &rate},
+			}, false).Return(nil)
+			gomock.InOrder(
+				mockAdmin.EXPECT().DescribeTopics([]string{"orders"}).Return(topics(0), nil),
+				mockAdmin.EXPECT().DescribeTopics([]string{"orders"}).Return(topics(0, 1), nil),
+			)
+			leader, follower := "0:0,1:2", "0:1,1:1"
+			mockAdmin.EXPECT().IncrementalAlterConfig(sarama.TopicResource, "orders", map[string]sarama.IncrementalAlterConfigsEntry{
+				throttle.LEADER_REPLICAS_KEY:   {Operation: sarama.IncrementalAlterConfigsOperationSubtract, Value: &leader},
+				throttle.FOLLOWER_REPLICAS_KEY: {Operation: sarama.IncrementalAlterConfigsOperationSubtract, Value: &follower},
+			}, false).Return(nil)
+			// only the rates set by the replacement are touched, broker 0 gets its leader rate back
+			previous := "2048"
+			mockAdmin.EXPECT().IncrementalAlterConfig(sarama.BrokerResource, "0", map[string]sarama.IncrementalAlterConfigsEntry{
+				throttle.LEADER_RATE_KEY: {Operation: sarama.IncrementalAlterConfigsOperationSet, Value: &previous},
+			}, false).Return(nil)
+			mockAdmin.EXPECT().IncrementalAlterConfig(sarama.BrokerResource, "2", map[string]sarama.IncrementalAlterConfigsEntry{
+				throttle.LEADER_RATE_KEY: {Operation: sarama.IncrementalAlterConfigsOperationDelete},
+			}, false).Return(nil)
+			mockAdmin.EXPECT().IncrementalAlterConfig(sarama.BrokerResource, "1", map[string]sarama.IncrementalAlterConfigsEntry{
+				throttle.FOLLOWER_RATE_KEY: {Operation: sarama.IncrementalAlterConfigsOperationDelete},
+			}, false).Return(nil)
+			mockAdmin.EXPECT().Close().Return(nil).Times(2)
+
+			Expect(replacer.WaitForCatchUp(make(chan struct{}))).To(BeNil())
+			Expect(filepath.Join(dir, MARKER_FILE)).NotTo(BeAnExistingFile())
+			Expect(reasons()).To(Equal([]string{REPLACEMENT_STARTED_REASON, REPLACEMENT_PROGRESS_REASON, REPLACEMENT_COMPLETED_REASON}))
+		})
+		It("does nothing without pending replacement", func() {
+			Expect(os.Remove(filepath.Join(dir, MARKER_FILE))).To(BeNil())
+			Expect(replacer.WaitForCatchUp(make(chan struct{}))).To(BeNil())
+		})
+	})
+
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockEnv = mocks.NewMockEnvironment(mockCtrl)
+		mockEnv.EXPECT().GetNamespace().Return("default").AnyTimes()
+		mockEnv.EXPECT().GetHostName().Return("kafka-kafka-1").AnyTimes()
+		mockEnv.EXPECT().GetNodeName().Return("kubelet-0").AnyTimes()
+		mockAdmin = mocks.NewMockClusterAdmin(mockCtrl)
+
+		var err error
+		dir, err = ioutil.TempDir("/tmp", "kafka-replacement-test")
+		Expect(err).To(BeNil())
+		client = testclient.NewSimpleClientset()
+		replacer = &Replacer{
+			NewAdmin: func() (sarama.ClusterAdmin, error) {
+				return mockAdmin, nil
+			},
+			Events: &events.Recorder{
+				Client: client,
+				Env:    mockEnv,
+			},
+			BrokerID: 1,
+			LogDirs:  []string{dir},
+			Rate:     1024,
+			Interval: 10 * time.Millisecond,
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+		os.RemoveAll(dir)
+	})
+})
+
+func TestReplacement(t *testing.T) {
+	RegisterFailHandler(Fail)
+	junitReporter := reporters.NewJUnitReporter(fmt.Sprintf("%s-junit.xml", "kafka-utils-replacement"))
+	RunSpecsWithDefaultAndCustomReporters(t, "KafkaUtils Broker Replacement Suite", []Reporter{junitReporter})
+}
@@ -1,6 +1,11 @@
 package service
 
-import "os"
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
 
 //go:generate mockgen -destination=../mocks/environment_mock.go -package=mocks github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/service Environment
 
@@ -28,3 +33,24 @@ func (c *EnvironmentImpl) GetExternalIngressPort() string {
 func (c *EnvironmentImpl) GetNodeName() string {
 	return os.Getenv("NODE_NAME")
 }
+
+// GetOrdinal returns the StatefulSet ordinal of a broker pod from its hostname, which is also its broker id
+func GetOrdinal(hostname string) (int32, error) {
+	index := strings.LastIndex(hostname, "-")
+	if index < 0 {
+		return 0, fmt.Errorf("hostname '%s' is not a StatefulSet pod name", hostname)
+	}
+	ordinal, err := strconv.ParseInt(hostname[index+1:], 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("hostname '%s' is not a StatefulSet pod name: %v", hostname, err)
+	}
+	return int32(ordinal), nil
+}
+
+// GetStatefulSetName returns the name of the StatefulSet owning a broker pod from its hostname
+func GetStatefulSetName(hostname string) (string, error) {
+	if _, err := GetOrdinal(hostname); err != nil {
+		return "", err
+	}
+	return hostname[:strings.LastIndex(hostname, "-")], nil
+}
@@ -0,0 +1,32 @@
+package service
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// StatefulSetOwner returns the reference to statefulSet set on the resources which are deleted with it
+func StatefulSetOwner(client kubernetes.Interface, namespace, statefulSet string) (metav1.OwnerReference, error) {
+	object, err := client.AppsV1().StatefulSets(namespace).Get(statefulSet, metav1.GetOptions{})
+	if err != nil {
+		return metav1.OwnerReference{}, fmt.Errorf("could not get the StatefulSet %s: %v", statefulSet, err)
+	}
+	return metav1.OwnerReference{
+		APIVersion: "apps/v1",
+		Kind:       "StatefulSet",
+		Name:       object.Name,
+		UID:        object.UID,
+	}, nil
+}
+
+// IsOwnedBy tells whether owner is one of the owners of object
+func IsOwnedBy(object metav1.Object, owner metav1.OwnerReference) bool {
+	for _, reference := range object.GetOwnerReferences() {
+		if reference.UID == owner.UID {
+			return true
+		}
+	}
+	return false
+}
@@ -210,14 +210,17 @@ func (c *KafkaService) writeListenerDNS(ingresses []v1.LoadBalancerIngress, path
 	}
 	dataWriter := bufio.NewWriter(file)
 
+	// the addresses are separated by new lines, the csr puts each of them in the certificate
+	var addresses []string
 	for _, ingress := range ingresses {
 		if len(ingress.Hostname) > 0 {
-			dataWriter.WriteString(ingress.Hostname)
+			addresses = append(addresses, ingress.Hostname)
 		}
 		if len(ingress.IP) > 0 {
-			dataWriter.WriteString(ingress.IP)
+			addresses = append(addresses, ingress.IP)
 		}
 	}
+	dataWriter.WriteString(strings.Join(addresses, "\n"))
 	dataWriter.Flush()
 	file.Close()
 	log.Infof("created the %s file", path)
@@ -199,6 +199,23 @@ var _ = Describe("[Kafka KafkaService]", func() {
 		}
 	})
 
+	Context("Broker ordinal", func() {
+		It("parses the ordinal of the StatefulSet pod", func() {
+			ordinal, err := GetOrdinal("kafka-kafka-12")
+			Expect(err).To(BeNil())
+			Expect(ordinal).To(Equal(int32(12)))
+		})
+		It("rejects a hostname without ordinal", func() {
+			_, err := GetOrdinal("localhost")
+			Expect(err).To(HaveOccurred())
+		})
+		It("returns the StatefulSet name of the broker", func() {
+			name, err := GetStatefulSetName("kafka-kafka-12")
+			Expect(err).To(BeNil())
+			Expect(name).To(Equal("kafka-kafka"))
+		})
+	})
+
 	BeforeEach(func() {
 		mockCtrl = gomock.NewController(GinkgoT())
 		mockEnv = mocks.NewMockEnvironment(mockCtrl)
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/service"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	CLUSTER_ID_GUARD_ENV = "CLUSTER_ID_GUARD_ENABLED"
+)
+
+// IdentityMismatchError is returned when a log dir belongs to another cluster or broker
+type IdentityMismatchError struct {
+	LogDir   string
+	Key      string
+	Found    string
+	Expected string
+}
+
+func (e *IdentityMismatchError) Error() string {
+	return fmt.Sprintf("log dir %s has %s '%s' but '%s' is expected, its persistent volume comes from another cluster or broker: "+
+		"delete the PersistentVolumeClaim to start with an empty log dir", e.LogDir, e.Key, e.Found, e.Expected)
+}
+
+// IdentityGuard refuses to start a broker on log dirs written by another cluster or broker
+type IdentityGuard struct {
+	Client kubernetes.Interface
+	Env    service.Environment
+	// StatefulSet owns the secret, so the secret is deleted with the instance
+	StatefulSet string
+	// SecretName is the secret holding the expected cluster id, it is recorded from the log dirs when missing
+	SecretName string
+	NodeID     int32
+	LogDirs    []string
+}
+
+// IsGuardEnabled returns true when the cluster id guard has been enabled, it needs to get, create and update secrets
+func IsGuardEnabled() bool {
+	return strings.EqualFold(os.Getenv(CLUSTER_ID_GUARD_ENV), "true")
+}
+
+// ClusterIDSecretName returns the name of the secret holding the cluster id of the nodes of statefulSet
+func ClusterIDSecretName(statefulSet string) string {
+	return fmt.Sprintf("%s-cluster-id", statefulSet)
+}
+
+// ClusterIDFromSecret returns the cluster id stored in secret
+func ClusterIDFromSecret(secret *v1.Secret) (string, error) {
+	clusterID := string(secret.Data[CLUSTER_ID_KEY])
+	if len(clusterID) == 0 {
+		return "", fmt.Errorf("secret %s is missing the '%s' key", secret.Name, CLUSTER_ID_KEY)
+	}
+	return clusterID, nil
+}
+
+// Check compares the meta.properties of every log dir with the expected cluster id and the broker id
+func (g *IdentityGuard) Check() error {
+	var clusterID, clusterIDDir string
+	for _, logDir := range g.LogDirs {
+		meta, err := ReadMetaProperties(logDir)
+		if err != nil {
+			return err
+		}
+		// an empty log dir is formatted by the broker on its first start
+		if meta == nil {
+			continue
+		}
+		if err := g.checkNodeID(logDir, meta); err != nil {
+			return err
+		}
+		if len(clusterID) == 0 {
+			clusterID, clusterIDDir = meta.ClusterID(), logDir
+		} else if meta.ClusterID() != clusterID {
+			return fmt.Errorf("log dirs %s and %s belong to the clusters '%s' and '%s'", clusterIDDir, logDir, clusterID, meta.ClusterID())
+		}
+	}
+	if len(clusterID) == 0 {
+		log.Infof("no formatted log dir found in %s", strings.Join(g.LogDirs, ","))
+		return nil
+	}
+
+	expected, err := g.expectedClusterID(clusterID, clusterIDDir)
+	if err != nil {
+		return err
+	}
+	if clusterID != expected {
+		return &IdentityMismatchError{LogDir: clusterIDDir, Key: CLUSTER_ID_KEY, Found: clusterID, Expected: expected}
+	}
+	log.Infof("the log dirs %s belong to cluster %s", strings.Join(g.LogDirs, ","), clusterID)
+	return nil
+}
+
+func (g *IdentityGuard) checkNodeID(logDir string, meta MetaProperties) error {
+	expected := strconv.Itoa(int(g.NodeID))
+	// zookeeper brokers record a broker.id and KRaft nodes a node.id
+	for _, key := range []string{BROKER_ID_KEY, NODE_ID_KEY} {
+		if found, ok := meta[key]; ok && found != expected {
+			return &IdentityMismatchError{LogDir: logDir, Key: key, Found: found, Expected: expected}
+		}
+	}
+	return nil
+}
+
+// expectedClusterID reads the recorded cluster id, the first broker finding a formatted log dir records it
+func (g *IdentityGuard) expectedClusterID(clusterID, logDir string) (string, error) {
+	owner, err := service.StatefulSetOwner(g.Client, g.Env.GetNamespace(), g.StatefulSet)
+	if err != nil {
+		return "", err
+	}
+	secrets := g.Client.CoreV1().Secrets(g.Env.GetNamespace())
+	secret, err := secrets.Get(g.SecretName, metav1.GetOptions{})
+	if err == nil {
+		return g.checkOwner(secret, owner, clusterID, logDir)
+	}
+	if !errors.IsNotFound(err) {
+		return "", fmt.Errorf("could not get the secret %s: %v", g.SecretName, err)
+	}
+
+	log.Infof("recording the cluster id %s in secret %s", clusterID, g.SecretName)
+	secret, err = secrets.Create(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            g.SecretName,
+			Namespace:       g.Env.GetNamespace(),
+			OwnerReferences: []metav1.OwnerReference{owner},
+		},
+		Type: v1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			CLUSTER_ID_KEY: []byte(clusterID),
+		},
+	})
+	if errors.IsAlreadyExists(err) {
+		secret, err = secrets.Get(g.SecretName, metav1.GetOptions{})
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not create the secret %s: %v", g.SecretName, err)
+	}
+	return ClusterIDFromSecret(secret)
+}
+
+// checkOwner returns the cluster id of a secret of the current StatefulSet. A secret without owner, recorded before
+// the secrets had one or orphaned with its StatefulSet, is adopted. A secret of a previous instance which has not been
+// garbage collected yet is replaced, unless the log dirs belong to it: their persistent volumes come from that instance.
+func (g *IdentityGuard) checkOwner(secret *v1.Secret, owner metav1.OwnerReference, clusterID, logDir string) (string, error) {
+	recorded, err := ClusterIDFromSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	if service.IsOwnedBy(secret, owner) {
+		return recorded, nil
+	}
+	if len(secret.OwnerReferences) > 0 {
+		if recorded == clusterID {
+			return "", fmt.Errorf("log dir %s belongs to cluster '%s' of a previous instance of the StatefulSet %s, its persistent volume "+
+				"was not deleted with it: delete the PersistentVolumeClaim to start with an empty log dir", logDir, clusterID, g.StatefulSet)
+		}
+		log.Infof("replacing the cluster id %s of a previous instance of the StatefulSet %s with %s", recorded, g.StatefulSet, clusterID)
+		secret.Data = map[string][]byte{CLUSTER_ID_KEY: []byte(clusterID)}
+		recorded = clusterID
+	} else {
+		log.Infof("adopting the secret %s in the StatefulSet %s", g.SecretName, g.StatefulSet)
+	}
+	secret.OwnerReferences = []metav1.OwnerReference{owner}
+	if _, err := g.Client.CoreV1().Secrets(g.Env.GetNamespace()).Update(secret); err != nil {
+		return "", fmt.Errorf("could not update the secret %s: %v", g.SecretName, err)
+	}
+	return recorded, nil
+}
@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DEFAULT_LOG_DIRS         = "/var/lib/kafka/data"
+	KAFKA_LOG_DIRS_ENV       = "KAFKA_LOG_DIRS"
+	LOG_DIRS_PROPERTIES_FILE = "log-dirs.properties"
+	MOUNT_INFO_PATH          = "/proc/self/mountinfo"
+)
+
+// LogDirsFromEnv returns the log dirs of the broker, the mounted data volumes unless KAFKA_LOG_DIRS sets them
+func LogDirsFromEnv() []string {
+	if logDirs := os.Getenv(KAFKA_LOG_DIRS_ENV); len(logDirs) > 0 {
+		return strings.Split(logDirs, ",")
+	}
+	logDirs, err := DetectLogDirs(DEFAULT_LOG_DIRS, MOUNT_INFO_PATH)
+	if err != nil {
+		log.Warnf("could not detect the mounted log dirs, using %s: %v", DEFAULT_LOG_DIRS, err)
+		return []string{DEFAULT_LOG_DIRS}
+	}
+	return logDirs
+}
+
+// DetectLogDirs returns the primary log dir followed by the data volumes mounted next to it as '<primary>-<n>', ordered by n
+func DetectLogDirs(primary, mountInfoPath string) ([]string, error) {
+	file, err := os.Open(mountInfoPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %v", mountInfoPath, err)
+	}
+	defer file.Close()
+
+	primary = filepath.Clean(primary)
+	indexes := map[int]string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// the mount point is the fifth field of a mountinfo line
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		mountPoint := filepath.Clean(unescapeMountPoint(fields[4]))
+		if !strings.HasPrefix(mountPoint, primary+"-") {
+			continue
+		}
+		index, err := strconv.Atoi(strings.TrimPrefix(mountPoint, primary+"-"))
+		if err != nil || index < 1 {
+			continue
+		}
+		indexes[index] = mountPoint
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read %s: %v", mountInfoPath, err)
+	}
+
+	var sorted []int
+	for index := range indexes {
+		sorted = append(sorted, index)
+	}
+	sort.Ints(sorted)
+	logDirs := []string{primary}
+	for _, index := range sorted {
+		logDirs = append(logDirs, indexes[index])
+	}
+	return logDirs, nil
+}
+
+// WriteLogDirsConfigToPath writes the log.dirs of the broker into the log-dirs.properties file
+func WriteLogDirsConfigToPath(path string, logDirs []string) (string, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return "", err
+	}
+	propertiesPath := filepath.Join(path, LOG_DIRS_PROPERTIES_FILE)
+	content := fmt.Sprintf("log.dirs=%s\n", strings.Join(logDirs, ","))
+	if err := ioutil.WriteFile(propertiesPath, []byte(content), 0644); err != nil {
+		return "", fmt.Errorf("could not create %s: %v", propertiesPath, err)
+	}
+	log.Infof("created the %s file", propertiesPath)
+	return propertiesPath, nil
+}
+
+// unescapeMountPoint decodes the octal escapes of the spaces, tabs, newlines and backslashes of a mount point
+func unescapeMountPoint(mountPoint string) string {
+	if !strings.Contains(mountPoint, "\\") {
+		return mountPoint
+	}
+	var b strings.Builder
+	for i := 0; i < len(mountPoint); i++ {
+		if mountPoint[i] == '\\' && i+3 < len(mountPoint) {
+			if value, err := strconv.ParseUint(mountPoint[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(value))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(mountPoint[i])
+	}
+	return b.String()
+}
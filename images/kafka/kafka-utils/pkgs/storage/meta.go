@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	META_PROPERTIES_FILE = "meta.properties"
+	CLUSTER_ID_KEY       = "cluster.id"
+	NODE_ID_KEY          = "node.id"
+	BROKER_ID_KEY        = "broker.id"
+	VERSION_KEY          = "version"
+)
+
+// MetaProperties is the identity kafka stores in the meta.properties file of every log dir
+type MetaProperties map[string]string
+
+// ClusterID returns the id of the cluster the log dir belongs to
+func (m MetaProperties) ClusterID() string {
+	return m[CLUSTER_ID_KEY]
+}
+
+// ReadMetaProperties reads the meta.properties file of a log dir, it returns nil when the log dir is not formatted
+func ReadMetaProperties(logDir string) (MetaProperties, error) {
+	path := filepath.Join(logDir, META_PROPERTIES_FILE)
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %v", path, err)
+	}
+	defer file.Close()
+
+	properties := MetaProperties{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		properties[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read %s: %v", path, err)
+	}
+	return properties, nil
+}
@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	log "github.com/sirupsen/logrus"
+)
+
+// ReplicaMover moves the replicas of a broker between its log dirs
+type ReplicaMover struct {
+	Admin sarama.ClusterAdmin
+	// AlterReplicaLogDirs sends the AlterReplicaLogDirs request to a broker
+	AlterReplicaLogDirs func(brokerID int32, dir string, replicas map[string][]int32) error
+	PollInterval        time.Duration
+	Timeout             time.Duration
+}
+
+// Describe returns the log dirs of the given brokers, of all the brokers when none is given
+func (m *ReplicaMover) Describe(brokerIDs []int32) (map[int32][]sarama.DescribeLogDirsResponseDirMetadata, error) {
+	if len(brokerIDs) == 0 {
+		brokers, _, err := m.Admin.DescribeCluster()
+		if err != nil {
+			return nil, fmt.Errorf("could not list the brokers: %v", err)
+		}
+		for _, broker := range brokers {
+			brokerIDs = append(brokerIDs, broker.ID())
+		}
+	}
+	logDirs, err := m.Admin.DescribeLogDirs(brokerIDs)
+	if err != nil {
+		return nil, fmt.Errorf("could not describe the log dirs: %v", err)
+	}
+	return logDirs, nil
+}
+
+// Move moves the replicas of the topic partitions hosted by a broker into dir, all its partitions of the topic when none is given.
+// It returns once the broker has finished copying them.
+func (m *ReplicaMover) Move(brokerID int32, dir, topic string, partitions []int32) error {
+	dir = filepath.Clean(dir)
+	logDirs, err := m.Describe([]int32{brokerID})
+	if err != nil {
+		return err
+	}
+	if len(logDirs[brokerID]) == 0 {
+		return fmt.Errorf("broker %d reported no log dir", brokerID)
+	}
+	placement, err := placementOf(brokerID, logDirs[brokerID], dir, topic)
+	if err != nil {
+		return err
+	}
+	if len(partitions) == 0 {
+		for partition := range placement {
+			partitions = append(partitions, partition)
+		}
+		sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+	}
+
+	var moving []int32
+	for _, partition := range partitions {
+		current, ok := placement[partition]
+		if !ok {
+			return fmt.Errorf("broker %d hosts no replica of %s-%d", brokerID, topic, partition)
+		}
+		if current == dir {
+			log.Infof("the replica of %s-%d is already in %s", topic, partition, dir)
+			continue
+		}
+		moving = append(moving, partition)
+	}
+	if len(moving) == 0 {
+		return nil
+	}
+
+	if err := m.AlterReplicaLogDirs(brokerID, dir, map[string][]int32{topic: moving}); err != nil {
+		return err
+	}
+	log.Infof("moving the replicas of %s partitions %v of broker %d into %s", topic, moving, brokerID, dir)
+	return m.waitForMove(brokerID, dir, topic, moving)
+}
+
+func (m *ReplicaMover) waitForMove(brokerID int32, dir, topic string, partitions []int32) error {
+	deadline := time.Now().Add(m.Timeout)
+	for {
+		logDirs, err := m.Describe([]int32{brokerID})
+		if err != nil {
+			log.Warnf("could not check the move of the replicas: %v", err)
+		} else if placement, err := placementOf(brokerID, logDirs[brokerID], dir, topic); err != nil {
+			return err
+		} else {
+			var pending []string
+			for _, partition := range partitions {
+				if placement[partition] != dir {
+					pending = append(pending, fmt.Sprintf("%s-%d", topic, partition))
+				}
+			}
+			if len(pending) == 0 {
+				log.Infof("moved the replicas into %s", dir)
+				return nil
+			}
+			log.Infof("waiting for the replicas %s to be moved into %s", strings.Join(pending, ","), dir)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for the replicas to be moved into %s", dir)
+		}
+		time.Sleep(m.PollInterval)
+	}
+}
+
+// placementOf maps the partitions of topic to the log dir holding their replica, a replica being copied stays in its source dir
+func placementOf(brokerID int32, logDirs []sarama.DescribeLogDirsResponseDirMetadata, dir, topic string) (map[int32]string, error) {
+	found := false
+	placement := map[int32]string{}
+	for _, logDir := range logDirs {
+		path := filepath.Clean(logDir.Path)
+		if path == dir {
+			if logDir.ErrorCode != sarama.ErrNoError {
+				return nil, fmt.Errorf("log dir %s of broker %d is unavailable: %v", dir, brokerID, logDir.ErrorCode)
+			}
+			found = true
+		}
+		for _, t := range logDir.Topics {
+			if t.Topic != topic {
+				continue
+			}
+			for _, partition := range t.Partitions {
+				if !partition.IsTemporary {
+					placement[partition.PartitionID] = path
+				}
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("broker %d has no log dir %s", brokerID, dir)
+	}
+	return placement, nil
+}
+
+// FormatLogDirs lists the log dirs of the brokers with their size and replica count
+func FormatLogDirs(logDirs map[int32][]sarama.DescribeLogDirsResponseDirMetadata) string {
+	var brokerIDs []int
+	for brokerID := range logDirs {
+		brokerIDs = append(brokerIDs, int(brokerID))
+	}
+	sort.Ints(brokerIDs)
+
+	var b strings.Builder
+	for _, brokerID := range brokerIDs {
+		dirs := logDirs[int32(brokerID)]
+		sort.Slice(dirs, func(i, j int) bool { return dirs[i].Path < dirs[j].Path })
+		for _, dir := range dirs {
+			if dir.ErrorCode != sarama.ErrNoError {
+				fmt.Fprintf(&b, "broker %d: %s unavailable: %v\n", brokerID, dir.Path, dir.ErrorCode)
+				continue
+			}
+			var size int64
+			var replicas, moving int
+			for _, topic := range dir.Topics {
+				for _, partition := range topic.Partitions {
+					size += partition.Size
+					if partition.IsTemporary {
+						moving++
+					} else {
+						replicas++
+					}
+				}
+			}
+			fmt.Fprintf(&b, "broker %d: %s %d bytes, %d replicas", brokerID, dir.Path, size, replicas)
+			if moving > 0 {
+				fmt.Fprintf(&b, ", %d being moved in", moving)
+			}
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
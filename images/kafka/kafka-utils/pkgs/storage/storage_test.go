@@ -0,0 +1,267 @@
+package storage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/golang/mock/gomock"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/mocks"
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/reporters"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testclient "k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Describe("[Kafka Storage]", func() {
+
+	var (
+		mockCtrl *gomock.Controller
+		mockEnv  *mocks.MockEnvironment
+		dir      string
+		guard    *IdentityGuard
+	)
+
+	writeMeta := func(logDir, content string) {
+		Expect(ioutil.WriteFile(filepath.Join(logDir, META_PROPERTIES_FILE), []byte(content), 0644)).To(BeNil())
+	}
+
+	recordClusterID := func(clusterID string, owners ...metav1.OwnerReference) {
+		_, err := guard.Client.CoreV1().Secrets("default").Create(&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "kafka-kafka-cluster-id", Namespace: "default", OwnerReferences: owners},
+			Data:       map[string][]byte{CLUSTER_ID_KEY: []byte(clusterID)},
+		})
+		Expect(err).To(BeNil())
+	}
+
+	recordedSecret := func() *v1.Secret {
+		secret, err := guard.Client.CoreV1().Secrets("default").Get("kafka-kafka-cluster-id", metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		return secret
+	}
+
+	previousInstance := metav1.OwnerReference{APIVersion: "apps/v1", Kind: "StatefulSet", Name: "kafka-kafka", UID: "previous"}
+
+	Context("Meta properties", func() {
+		It("reads the identity of a log dir", func() {
+			writeMeta(guard.LogDirs[0], "#\n#Mon Oct 19 10:00:00 UTC 2026\nversion=0\nbroker.id=1\ncluster.id=RZ8a0q7cQbK2bBR-QGqwXw\n")
+			meta, err := ReadMetaProperties(guard.LogDirs[0])
+			Expect(err).To(BeNil())
+			Expect(meta.ClusterID()).To(Equal("RZ8a0q7cQbK2bBR-QGqwXw"))
+			Expect(meta[BROKER_ID_KEY]).To(Equal("1"))
+		})
+		It("returns nothing for an empty log dir", func() {
+			meta, err := ReadMetaProperties(guard.LogDirs[0])
+			Expect(err).To(BeNil())
+			Expect(meta).To(BeNil())
+		})
+	})
+
+	Context("Cluster id guard", func() {
+		It("accepts empty log dirs", func() {
+			Expect(guard.Check()).To(BeNil())
+			_, err := guard.Client.CoreV1().Secrets("default").Get("kafka-kafka-cluster-id", metav1.GetOptions{})
+			Expect(err).To(HaveOccurred())
+		})
+		It("records the cluster id of the first formatted log dir", func() {
+			writeMeta(guard.LogDirs[0], "version=0\nbroker.id=1\ncluster.id=first\n")
+			Expect(guard.Check()).To(BeNil())
+			secret, err := guard.Client.CoreV1().Secrets("default").Get("kafka-kafka-cluster-id", metav1.GetOptions{})
+			Expect(err).To(BeNil())
+			Expect(string(secret.Data[CLUSTER_ID_KEY])).To(Equal("first"))
+			Expect(secret.OwnerReferences).To(HaveLen(1))
+			Expect(secret.OwnerReferences[0].Kind).To(Equal("StatefulSet"))
+			Expect(string(secret.OwnerReferences[0].UID)).To(Equal("current"))
+		})
+		It("adopts a secret recorded without owner", func() {
+			recordClusterID("first")
+			writeMeta(guard.LogDirs[0], "version=0\nbroker.id=1\ncluster.id=first\n")
+			Expect(guard.Check()).To(BeNil())
+			Expect(string(recordedSecret().OwnerReferences[0].UID)).To(Equal("current"))
+		})
+		It("replaces the cluster id of a previous instance for the log dirs of a recreated instance", func() {
+			recordClusterID("previous", previousInstance)
+			writeMeta(guard.LogDirs[0], "version=0\nbroker.id=1\ncluster.id=recreated\n")
+			Expect(guard.Check()).To(BeNil())
+			secret := recordedSecret()
+			Expect(string(secret.Data[CLUSTER_ID_KEY])).To(Equal("recreated"))
+			Expect(string(secret.OwnerReferences[0].UID)).To(Equal("current"))
+			// the next restart compares with the replaced cluster id
+			Expect(guard.Check()).To(BeNil())
+		})
+		It("refuses the log dirs of a previous instance", func() {
+			recordClusterID("previous", previousInstance)
+			writeMeta(guard.LogDirs[0], "version=0\nbroker.id=1\ncluster.id=previous\n")
+			err := guard.Check()
+			Expect(err).To(MatchError(ContainSubstring("previous instance of the StatefulSet kafka-kafka")))
+			Expect(string(recordedSecret().OwnerReferences[0].UID)).To(Equal("previous"))
+		})
+		It("is disabled by default", func() {
+			os.Unsetenv(CLUSTER_ID_GUARD_ENV)
+			Expect(IsGuardEnabled()).To(BeFalse())
+			os.Setenv(CLUSTER_ID_GUARD_ENV, "true")
+			defer os.Unsetenv(CLUSTER_ID_GUARD_ENV)
+			Expect(IsGuardEnabled()).To(BeTrue())
+		})
+		It("accepts the log dirs of the expected cluster", func() {
+			recordClusterID("first")
+			writeMeta(guard.LogDirs[0], "version=1\nnode.id=1\ncluster.id=first\n")
+			Expect(guard.Check()).To(BeNil())
+		})
+		It("refuses a log dir of another cluster", func() {
+			recordClusterID("first")
+			writeMeta(guard.LogDirs[1], "version=0\nbroker.id=1\ncluster.id=another\n")
+			err := guard.Check()
+			Expect(err).To(BeAssignableToTypeOf(&IdentityMismatchError{}))
+			Expect(err.Error()).To(ContainSubstring("cluster.id 'another' but 'first' is expected"))
+		})
+		It("refuses a log dir of another broker", func() {
+			recordClusterID("first")
+			writeMeta(guard.LogDirs[0], "version=0\nbroker.id=2\ncluster.id=first\n")
+			err := guard.Check()
+			Expect(err).To(BeAssignableToTypeOf(&IdentityMismatchError{}))
+			Expect(err.Error()).To(ContainSubstring("broker.id '2' but '1' is expected"))
+		})
+		It("refuses log dirs of different clusters", func() {
+			writeMeta(guard.LogDirs[0], "version=0\nbroker.id=1\ncluster.id=first\n")
+			writeMeta(guard.LogDirs[1], "version=0\nbroker.id=1\ncluster.id=another\n")
+			Expect(guard.Check()).To(HaveOccurred())
+		})
+	})
+
+	Context("Log dirs", func() {
+		It("detects the data volumes mounted next to the primary log dir", func() {
+			mountInfo := filepath.Join(dir, "mountinfo")
+			Expect(ioutil.WriteFile(mountInfo, []byte(
+				"22 1 0:20 / / rw,relatime - overlay overlay rw\n"+
+					"120 22 8:16 / /var/lib/kafka/data rw,relatime - ext4 /dev/sdb rw\n"+
+					"121 22 8:48 / /var/lib/kafka/data-10 rw,relatime - ext4 /dev/sdd rw\n"+
+					"122 22 8:32 / /var/lib/kafka/data-2 rw,relatime - ext4 /dev/sdc rw\n"+
+					"123 22 8:64 / /var/lib/kafka/data-backup rw,relatime - ext4 /dev/sde rw\n"+
+					"124 22 8:80 / /var/lib/kafka/data-3\\040old rw,relatime - ext4 /dev/sdf rw\n"), 0644)).To(BeNil())
+			logDirs, err := DetectLogDirs("/var/lib/kafka/data/", mountInfo)
+			Expect(err).To(BeNil())
+			Expect(logDirs).To(Equal([]string{"/var/lib/kafka/data", "/var/lib/kafka/data-2", "/var/lib/kafka/data-10"}))
+		})
+		It("prefers the log dirs set in the environment", func() {
+			os.Setenv(KAFKA_LOG_DIRS_ENV, "/data/a,/data/b")
+			defer os.Unsetenv(KAFKA_LOG_DIRS_ENV)
+			Expect(LogDirsFromEnv()).To(Equal([]string{"/data/a", "/data/b"}))
+		})
+		It("writes the log.dirs of the broker", func() {
+			path, err := WriteLogDirsConfigToPath(filepath.Join(dir, "config"), []string{"/var/lib/kafka/data", "/var/lib/kafka/data-1"})
+			Expect(err).To(BeNil())
+			content, err := ioutil.ReadFile(path)
+			Expect(err).To(BeNil())
+			Expect(string(content)).To(Equal("log.dirs=/var/lib/kafka/data,/var/lib/kafka/data-1\n"))
+		})
+	})
+
+	Context("Replica mover", func() {
+		var (
+			mockAdmin *mocks.MockClusterAdmin
+			mover     *ReplicaMover
+			moved     map[string][]int32
+		)
+
+		logDirs := func(data, data1 []sarama.DescribeLogDirsResponsePartition) map[int32][]sarama.DescribeLogDirsResponseDirMetadata {
+			return map[int32][]sarama.DescribeLogDirsResponseDirMetadata{
+				1: {
+					{Path: "/var/lib/kafka/data", Topics: []sarama.DescribeLogDirsResponseTopic{{Topic: "orders", Partitions: data}}},
+					{Path: "/var/lib/kafka/data-1", Topics: []sarama.DescribeLogDirsResponseTopic{{Topic: "orders", Partitions: data1}}},
+				},
+			}
+		}
+
+		BeforeEach(func() {
+			moved = nil
+			mockAdmin = mocks.NewMockClusterAdmin(mockCtrl)
+			mover = &ReplicaMover{
+				Admin: mockAdmin,
+				AlterReplicaLogDirs: func(brokerID int32, dir string, replicas map[string][]int32) error {
+					Expect(brokerID).To(Equal(int32(1)))
+					Expect(dir).To(Equal("/var/lib/kafka/data-1"))
+					moved = replicas
+					return nil
+				},
+				PollInterval: time.Millisecond,
+				Timeout:      time.Second,
+			}
+		})
+
+		It("moves the replicas and waits for the copy", func() {
+			gomock.InOrder(
+				mockAdmin.EXPECT().DescribeLogDirs([]int32{1}).Return(logDirs(
+					[]sarama.DescribeLogDirsResponsePartition{{PartitionID: 0}, {PartitionID: 2}}, nil), nil),
+				mockAdmin.EXPECT().DescribeLogDirs([]int32{1}).Return(logDirs(
+					[]sarama.DescribeLogDirsResponsePartition{{PartitionID: 0}, {PartitionID: 2}},
+					[]sarama.DescribeLogDirsResponsePartition{{PartitionID: 0, IsTemporary: true}, {PartitionID: 2, IsTemporary: true}}), nil),
+				mockAdmin.EXPECT().DescribeLogDirs([]int32{1}).Return(logDirs(
+					nil, []sarama.DescribeLogDirsResponsePartition{{PartitionID: 0}, {PartitionID: 2}}), nil),
+			)
+			Expect(mover.Move(1, "/var/lib/kafka/data-1/", "orders", nil)).To(BeNil())
+			Expect(moved).To(Equal(map[string][]int32{"orders": {0, 2}}))
+		})
+		It("skips the replicas already in the log dir", func() {
+			mockAdmin.EXPECT().DescribeLogDirs([]int32{1}).Return(logDirs(
+				nil, []sarama.DescribeLogDirsResponsePartition{{PartitionID: 0}}), nil)
+			Expect(mover.Move(1, "/var/lib/kafka/data-1", "orders", []int32{0})).To(BeNil())
+			Expect(moved).To(BeNil())
+		})
+		It("refuses unknown log dirs and partitions", func() {
+			mockAdmin.EXPECT().DescribeLogDirs([]int32{1}).Return(logDirs(
+				[]sarama.DescribeLogDirsResponsePartition{{PartitionID: 0}}, nil), nil).Times(2)
+			Expect(mover.Move(1, "/var/lib/kafka/data-2", "orders", nil)).To(MatchError(ContainSubstring("has no log dir")))
+			Expect(mover.Move(1, "/var/lib/kafka/data-1", "orders", []int32{5})).To(MatchError(ContainSubstring("no replica of orders-5")))
+		})
+		It("lists the log dirs of the brokers", func() {
+			Expect(FormatLogDirs(logDirs(
+				[]sarama.DescribeLogDirsResponsePartition{{PartitionID: 0, Size: 100}, {PartitionID: 1, Size: 50}},
+				[]sarama.DescribeLogDirsResponsePartition{{PartitionID: 0, Size: 10, IsTemporary: true}}))).To(Equal(
+				"broker 1: /var/lib/kafka/data 150 bytes, 2 replicas\n" +
+					"broker 1: /var/lib/kafka/data-1 10 bytes, 0 replicas, 1 being moved in\n"))
+		})
+	})
+
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockEnv = mocks.NewMockEnvironment(mockCtrl)
+		mockEnv.EXPECT().GetNamespace().Return("default").AnyTimes()
+
+		var err error
+		dir, err = ioutil.TempDir("/tmp", "kafka-storage-test")
+		Expect(err).To(BeNil())
+		logDirs := []string{filepath.Join(dir, "data-0"), filepath.Join(dir, "data-1")}
+		for _, logDir := range logDirs {
+			Expect(os.Mkdir(logDir, 0755)).To(BeNil())
+		}
+		guard = &IdentityGuard{
+			Client: testclient.NewSimpleClientset(&appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{Name: "kafka-kafka", Namespace: "default", UID: "current"},
+			}),
+			Env:         mockEnv,
+			StatefulSet: "kafka-kafka",
+			SecretName:  ClusterIDSecretName("kafka-kafka"),
+			NodeID:      1,
+			LogDirs:     logDirs,
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+		os.RemoveAll(dir)
+	})
+})
+
+func TestStorage(t *testing.T) {
+	RegisterFailHandler(Fail)
+	junitReporter := reporters.NewJUnitReporter(fmt.Sprintf("%s-junit.xml", "kafka-utils-storage"))
+	RunSpecsWithDefaultAndCustomReporters(t, "KafkaUtils Storage Suite", []Reporter{junitReporter})
+}
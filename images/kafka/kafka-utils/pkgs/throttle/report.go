@@ -0,0 +1,67 @@
+package throttle
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Shopify/sarama"
+)
+
+// Throttles maps the throttle configs set on a broker or a topic to their value
+type Throttles map[string]string
+
+// Report holds the throttles of the brokers and topics
+type Report struct {
+	Brokers map[int32]Throttles
+	Topics  map[string]Throttles
+}
+
+func newThrottles(entries []sarama.ConfigEntry) Throttles {
+	throttles := Throttles{}
+	for _, entry := range entries {
+		if entry.Default || len(entry.Value) == 0 {
+			continue
+		}
+		throttles[entry.Name] = entry.Value
+	}
+	return throttles
+}
+
+func (t Throttles) String() string {
+	var keys []string
+	for key := range t {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var values []string
+	for _, key := range keys {
+		values = append(values, fmt.Sprintf("%s=%s", key, t[key]))
+	}
+	return strings.Join(values, " ")
+}
+
+func (r *Report) String() string {
+	if len(r.Brokers) == 0 && len(r.Topics) == 0 {
+		return "no replication throttle\n"
+	}
+	var brokerIDs []int
+	for brokerID := range r.Brokers {
+		brokerIDs = append(brokerIDs, int(brokerID))
+	}
+	sort.Ints(brokerIDs)
+	var topics []string
+	for topic := range r.Topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	var b strings.Builder
+	for _, brokerID := range brokerIDs {
+		fmt.Fprintf(&b, "broker %d: %s\n", brokerID, r.Brokers[int32(brokerID)])
+	}
+	for _, topic := range topics {
+		fmt.Fprintf(&b, "topic %s: %s\n", topic, r.Topics[topic])
+	}
+	return b.String()
+}
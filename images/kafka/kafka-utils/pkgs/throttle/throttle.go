@@ -0,0 +1,262 @@
+package throttle
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	LEADER_RATE_KEY       = "leader.replication.throttled.rate"
+	FOLLOWER_RATE_KEY     = "follower.replication.throttled.rate"
+	LEADER_REPLICAS_KEY   = "leader.replication.throttled.replicas"
+	FOLLOWER_REPLICAS_KEY = "follower.replication.throttled.replicas"
+	// ALL_REPLICAS throttles every replica of a topic
+	ALL_REPLICAS = "*"
+)
+
+// Throttler sets and clears the replication throttles through the admin API
+type Throttler struct {
+	Admin sarama.ClusterAdmin
+}
+
+// SetBrokerRates sets the replication rates of a broker in bytes per second, a rate of 0 is left unchanged
+func (t *Throttler) SetBrokerRates(brokerID int32, leaderRate, followerRate int64) error {
+	entries := map[string]sarama.IncrementalAlterConfigsEntry{}
+	if leaderRate > 0 {
+		entries[LEADER_RATE_KEY] = setEntry(strconv.FormatInt(leaderRate, 10))
+	}
+	if followerRate > 0 {
+		entries[FOLLOWER_RATE_KEY] = setEntry(strconv.FormatInt(followerRate, 10))
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	if err := t.Admin.IncrementalAlterConfig(sarama.BrokerResource, strconv.Itoa(int(brokerID)), entries, false); err != nil {
+		return fmt.Errorf("could not set the replication throttle of broker %d: %v", brokerID, err)
+	}
+	log.Infof("throttled the replication of broker %d, leader rate %d and follower rate %d", brokerID, leaderRate, followerRate)
+	return nil
+}
+
+// ClearBrokerRates removes the replication rates of a broker
+func (t *Throttler) ClearBrokerRates(brokerID int32) error {
+	entries := map[string]sarama.IncrementalAlterConfigsEntry{
+		LEADER_RATE_KEY:   {Operation: sarama.IncrementalAlterConfigsOperationDelete},
+		FOLLOWER_RATE_KEY: {Operation: sarama.IncrementalAlterConfigsOperationDelete},
+	}
+	if err := t.Admin.IncrementalAlterConfig(sarama.BrokerResource, strconv.Itoa(int(brokerID)), entries, false); err != nil {
+		return fmt.Errorf("could not clear the replication throttle of broker %d: %v", brokerID, err)
+	}
+	log.Infof("cleared the replication throttle of broker %d", brokerID)
+	return nil
+}
+
+// RestoreBrokerRate sets a replication rate of a broker back to a previous value, the rate is removed when the value is empty
+func (t *Throttler) RestoreBrokerRate(brokerID int32, key, value string) error {
+	entry := sarama.IncrementalAlterConfigsEntry{Operation: sarama.IncrementalAlterConfigsOperationDelete}
+	if len(value) > 0 {
+		entry = setEntry(value)
+	}
+	entries := map[string]sarama.IncrementalAlterConfigsEntry{key: entry}
+	if err := t.Admin.IncrementalAlterConfig(sarama.BrokerResource, strconv.Itoa(int(brokerID)), entries, false); err != nil {
+		return fmt.Errorf("could not restore the %s of broker %d: %v", key, brokerID, err)
+	}
+	log.Infof("restored the %s of broker %d to '%s'", key, brokerID, value)
+	return nil
+}
+
+// AddTopicReplicas adds 'partition:broker' replicas to the throttled replicas of a topic, the existing ones are kept
+func (t *Throttler) AddTopicReplicas(topic string, leaderReplicas, followerReplicas []string) error {
+	return t.alterTopicReplicas(topic, leaderReplicas, followerReplicas, sarama.IncrementalAlterConfigsOperationAppend)
+}
+
+// RemoveTopicReplicas removes 'partition:broker' replicas from the throttled replicas of a topic
+func (t *Throttler) RemoveTopicReplicas(topic string, leaderReplicas, followerReplicas []string) error {
+	return t.alterTopicReplicas(topic, leaderReplicas, followerReplicas, sarama.IncrementalAlterConfigsOperationSubtract)
+}
+
+func (t *Throttler) alterTopicReplicas(topic string, leaderReplicas, followerReplicas []string, operation sarama.IncrementalAlterConfigsOperation) error {
+	entries := map[string]sarama.IncrementalAlterConfigsEntry{}
+	if len(leaderReplicas) > 0 {
+		value := strings.Join(leaderReplicas, ",")
+		entries[LEADER_REPLICAS_KEY] = sarama.IncrementalAlterConfigsEntry{Operation: operation, Value: &value}
+	}
+	if len(followerReplicas) > 0 {
+		value := strings.Join(followerReplicas, ",")
+		entries[FOLLOWER_REPLICAS_KEY] = sarama.IncrementalAlterConfigsEntry{Operation: operation, Value: &value}
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	if err := t.Admin.IncrementalAlterConfig(sarama.TopicResource, topic, entries, false); err != nil {
+		return fmt.Errorf("could not alter the throttled replicas of topic %s: %v", topic, err)
+	}
+	return nil
+}
+
+func setEntry(value string) sarama.IncrementalAlterConfigsEntry {
+	return sarama.IncrementalAlterConfigsEntry{Operation: sarama.IncrementalAlterConfigsOperationSet, Value: &value}
+}
+
+// SetTopicReplicas replaces the throttled replicas of a topic, ALL_REPLICAS throttles every replica
+func (t *Throttler) SetTopicReplicas(topic string, leaderReplicas, followerReplicas []string) error {
+	for _, replica := range append(append([]string{}, leaderReplicas...), followerReplicas...) {
+		if err := validateReplica(replica); err != nil {
+			return err
+		}
+	}
+	if err := t.alterTopicReplicas(topic, leaderReplicas, followerReplicas, sarama.IncrementalAlterConfigsOperationSet); err != nil {
+		return err
+	}
+	log.Infof("throttled the replication of topic %s, leader replicas '%s' and follower replicas '%s'",
+		topic, strings.Join(leaderReplicas, ","), strings.Join(followerReplicas, ","))
+	return nil
+}
+
+// ClearTopicReplicas removes the throttled replicas of a topic
+func (t *Throttler) ClearTopicReplicas(topic string) error {
+	entries := map[string]sarama.IncrementalAlterConfigsEntry{
+		LEADER_REPLICAS_KEY:   {Operation: sarama.IncrementalAlterConfigsOperationDelete},
+		FOLLOWER_REPLICAS_KEY: {Operation: sarama.IncrementalAlterConfigsOperationDelete},
+	}
+	if err := t.Admin.IncrementalAlterConfig(sarama.TopicResource, topic, entries, false); err != nil {
+		return fmt.Errorf("could not clear the replication throttle of topic %s: %v", topic, err)
+	}
+	log.Infof("cleared the replication throttle of topic %s", topic)
+	return nil
+}
+
+// Clear removes the throttles of the given brokers and topics, all the throttled brokers and topics when none are given
+func (t *Throttler) Clear(brokerIDs []int32, topics []string) error {
+	if len(brokerIDs) == 0 && len(topics) == 0 {
+		report, err := t.Describe(nil, nil)
+		if err != nil {
+			return err
+		}
+		for brokerID := range report.Brokers {
+			brokerIDs = append(brokerIDs, brokerID)
+		}
+		for topic := range report.Topics {
+			topics = append(topics, topic)
+		}
+	}
+	for _, topic := range topics {
+		if err := t.ClearTopicReplicas(topic); err != nil {
+			return err
+		}
+	}
+	for _, brokerID := range brokerIDs {
+		if err := t.ClearBrokerRates(brokerID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DescribeBroker returns the replication rates set on a broker
+func (t *Throttler) DescribeBroker(brokerID int32) (Throttles, error) {
+	entries, err := t.Admin.DescribeConfig(sarama.ConfigResource{
+		Type:        sarama.BrokerResource,
+		Name:        strconv.Itoa(int(brokerID)),
+		ConfigNames: []string{LEADER_RATE_KEY, FOLLOWER_RATE_KEY},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not describe the configuration of broker %d: %v", brokerID, err)
+	}
+	return newThrottles(entries), nil
+}
+
+// DescribeTopic returns the throttled replicas set on a topic
+func (t *Throttler) DescribeTopic(topic string) (Throttles, error) {
+	entries, err := t.Admin.DescribeConfig(sarama.ConfigResource{
+		Type:        sarama.TopicResource,
+		Name:        topic,
+		ConfigNames: []string{LEADER_REPLICAS_KEY, FOLLOWER_REPLICAS_KEY},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not describe the configuration of topic %s: %v", topic, err)
+	}
+	return newThrottles(entries), nil
+}
+
+// Describe returns the throttles of the given brokers and topics, all the brokers and topics when none are given.
+// Brokers and topics without throttle are left out.
+func (t *Throttler) Describe(brokerIDs []int32, topics []string) (*Report, error) {
+	report := &Report{
+		Brokers: map[int32]Throttles{},
+		Topics:  map[string]Throttles{},
+	}
+	if len(brokerIDs) == 0 {
+		brokers, _, err := t.Admin.DescribeCluster()
+		if err != nil {
+			return nil, fmt.Errorf("could not list the brokers: %v", err)
+		}
+		for _, broker := range brokers {
+			brokerIDs = append(brokerIDs, broker.ID())
+		}
+	}
+	for _, brokerID := range brokerIDs {
+		throttles, err := t.DescribeBroker(brokerID)
+		if err != nil {
+			return nil, err
+		}
+		if len(throttles) > 0 {
+			report.Brokers[brokerID] = throttles
+		}
+	}
+
+	if len(topics) == 0 {
+		// the topic list already carries the configs which are not defaults
+		details, err := t.Admin.ListTopics()
+		if err != nil {
+			return nil, fmt.Errorf("could not list the topics: %v", err)
+		}
+		for topic, detail := range details {
+			throttles := Throttles{}
+			for _, key := range []string{LEADER_REPLICAS_KEY, FOLLOWER_REPLICAS_KEY} {
+				if value, ok := detail.ConfigEntries[key]; ok && value != nil && len(*value) > 0 {
+					throttles[key] = *value
+				}
+			}
+			if len(throttles) > 0 {
+				report.Topics[topic] = throttles
+			}
+		}
+		return report, nil
+	}
+	for _, topic := range topics {
+		throttles, err := t.DescribeTopic(topic)
+		if err != nil {
+			return nil, err
+		}
+		if len(throttles) > 0 {
+			report.Topics[topic] = throttles
+		}
+	}
+	return report, nil
+}
+
+// Replica returns the 'partition:broker' form used by the throttled replicas configs
+func Replica(partition, brokerID int32) string {
+	return fmt.Sprintf("%d:%d", partition, brokerID)
+}
+
+func validateReplica(replica string) error {
+	if replica == ALL_REPLICAS {
+		return nil
+	}
+	parts := strings.Split(replica, ":")
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid throttled replica '%s', expected 'partition:broker' or '%s'", replica, ALL_REPLICAS)
+	}
+	for _, part := range parts {
+		if _, err := strconv.ParseInt(part, 10, 32); err != nil {
+			return fmt.Errorf("invalid throttled replica '%s', expected 'partition:broker' or '%s'", replica, ALL_REPLICAS)
+		}
+	}
+	return nil
+}
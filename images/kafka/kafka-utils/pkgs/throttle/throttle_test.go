@@ -0,0 +1,136 @@
+package throttle
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/golang/mock/gomock"
+	"github.com/mesosphere/kudo-kafka-operator/images/kafka-utils/pkgs/mocks"
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/reporters"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("[Kafka Throttle]", func() {
+
+	var (
+		mockCtrl  *gomock.Controller
+		mockAdmin *mocks.MockClusterAdmin
+		throttler *Throttler
+	)
+
+	deleteEntries := func(keys ...string) map[string]sarama.IncrementalAlterConfigsEntry {
+		entries := map[string]sarama.IncrementalAlterConfigsEntry{}
+		for _, key := range keys {
+			entries[key] = sarama.IncrementalAlterConfigsEntry{Operation: sarama.IncrementalAlterConfigsOperationDelete}
+		}
+		return entries
+	}
+
+	Context("Set", func() {
+		It("sets the rates given on a broker", func() {
+			rate := "1048576"
+			mockAdmin.EXPECT().IncrementalAlterConfig(sarama.BrokerResource, "2", map[string]sarama.IncrementalAlterConfigsEntry{
+				FOLLOWER_RATE_KEY: {Operation: sarama.IncrementalAlterConfigsOperationSet, Value: &rate},
+			}, false).Return(nil)
+			Expect(throttler.SetBrokerRates(2, 0, 1048576)).To(BeNil())
+		})
+		It("replaces the throttled replicas of a topic", func() {
+			leader, follower := "*", "0:1,1:2"
+			mockAdmin.EXPECT().IncrementalAlterConfig(sarama.TopicResource, "orders", map[string]sarama.IncrementalAlterConfigsEntry{
+				LEADER_REPLICAS_KEY:   {Operation: sarama.IncrementalAlterConfigsOperationSet, Value: &leader},
+				FOLLOWER_REPLICAS_KEY: {Operation: sarama.IncrementalAlterConfigsOperationSet, Value: &follower},
+			}, false).Return(nil)
+			Expect(throttler.SetTopicReplicas("orders", []string{ALL_REPLICAS}, []string{"0:1", "1:2"})).To(BeNil())
+		})
+		It("refuses malformed replicas", func() {
+			Expect(throttler.SetTopicReplicas("orders", []string{"0-1"}, nil)).NotTo(BeNil())
+			Expect(throttler.SetTopicReplicas("orders", nil, []string{"a:1"})).NotTo(BeNil())
+		})
+		It("reports the admin errors", func() {
+			mockAdmin.EXPECT().IncrementalAlterConfig(sarama.BrokerResource, "0", gomock.Any(), false).Return(fmt.Errorf("broker down"))
+			Expect(throttler.SetBrokerRates(0, 1, 1)).To(MatchError(ContainSubstring("broker down")))
+		})
+	})
+
+	Context("Describe", func() {
+		It("lists the throttles of all the brokers and topics", func() {
+			throttled := "0:1"
+			mockAdmin.EXPECT().DescribeCluster().Return([]*sarama.Broker{sarama.NewBroker("kafka-0:9093"), sarama.NewBroker("kafka-1:9093")}, int32(0), nil)
+			// brokers created from an address have the id -1
+			mockAdmin.EXPECT().DescribeConfig(sarama.ConfigResource{
+				Type:        sarama.BrokerResource,
+				Name:        "-1",
+				ConfigNames: []string{LEADER_RATE_KEY, FOLLOWER_RATE_KEY},
+			}).Return([]sarama.ConfigEntry{
+				{Name: LEADER_RATE_KEY, Value: "1024"},
+				{Name: FOLLOWER_RATE_KEY, Value: "9223372036854775807", Default: true},
+			}, nil).Times(2)
+			mockAdmin.EXPECT().ListTopics().Return(map[string]sarama.TopicDetail{
+				"orders":   {ConfigEntries: map[string]*string{FOLLOWER_REPLICAS_KEY: &throttled}},
+				"payments": {ConfigEntries: map[string]*string{}},
+			}, nil)
+
+			report, err := throttler.Describe(nil, nil)
+			Expect(err).To(BeNil())
+			Expect(report.Brokers).To(Equal(map[int32]Throttles{-1: {LEADER_RATE_KEY: "1024"}}))
+			Expect(report.Topics).To(Equal(map[string]Throttles{"orders": {FOLLOWER_REPLICAS_KEY: "0:1"}}))
+			Expect(report.String()).To(Equal("broker -1: leader.replication.throttled.rate=1024\n" +
+				"topic orders: follower.replication.throttled.replicas=0:1\n"))
+		})
+		It("describes the given brokers and topics only", func() {
+			mockAdmin.EXPECT().DescribeConfig(sarama.ConfigResource{
+				Type:        sarama.BrokerResource,
+				Name:        "1",
+				ConfigNames: []string{LEADER_RATE_KEY, FOLLOWER_RATE_KEY},
+			}).Return(nil, nil)
+			mockAdmin.EXPECT().DescribeConfig(sarama.ConfigResource{
+				Type:        sarama.TopicResource,
+				Name:        "orders",
+				ConfigNames: []string{LEADER_REPLICAS_KEY, FOLLOWER_REPLICAS_KEY},
+			}).Return([]sarama.ConfigEntry{{Name: LEADER_REPLICAS_KEY, Value: "*"}}, nil)
+
+			report, err := throttler.Describe([]int32{1}, []string{"orders"})
+			Expect(err).To(BeNil())
+			Expect(report.Brokers).To(BeEmpty())
+			Expect(report.Topics).To(Equal(map[string]Throttles{"orders": {LEADER_REPLICAS_KEY: "*"}}))
+		})
+		It("reports when nothing is throttled", func() {
+			Expect((&Report{}).String()).To(Equal("no replication throttle\n"))
+		})
+	})
+
+	Context("Clear", func() {
+		It("clears the given brokers and topics", func() {
+			mockAdmin.EXPECT().IncrementalAlterConfig(sarama.TopicResource, "orders", deleteEntries(LEADER_REPLICAS_KEY, FOLLOWER_REPLICAS_KEY), false).Return(nil)
+			mockAdmin.EXPECT().IncrementalAlterConfig(sarama.BrokerResource, "3", deleteEntries(LEADER_RATE_KEY, FOLLOWER_RATE_KEY), false).Return(nil)
+			Expect(throttler.Clear([]int32{3}, []string{"orders"})).To(BeNil())
+		})
+		It("clears everything throttled when nothing is given", func() {
+			throttled := "*"
+			mockAdmin.EXPECT().DescribeCluster().Return(nil, int32(0), nil)
+			mockAdmin.EXPECT().ListTopics().Return(map[string]sarama.TopicDetail{
+				"orders": {ConfigEntries: map[string]*string{LEADER_REPLICAS_KEY: &throttled}},
+			}, nil)
+			mockAdmin.EXPECT().IncrementalAlterConfig(sarama.TopicResource, "orders", deleteEntries(LEADER_REPLICAS_KEY, FOLLOWER_REPLICAS_KEY), false).Return(nil)
+			Expect(throttler.Clear(nil, nil)).To(BeNil())
+		})
+	})
+
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockAdmin = mocks.NewMockClusterAdmin(mockCtrl)
+		throttler = &Throttler{Admin: mockAdmin}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+})
+
+func TestThrottle(t *testing.T) {
+	RegisterFailHandler(Fail)
+	junitReporter := reporters.NewJUnitReporter(fmt.Sprintf("%s-junit.xml", "kafka-utils-throttle"))
+	RunSpecsWithDefaultAndCustomReporters(t, "KafkaUtils Throttle Suite", []Reporter{junitReporter})
+}
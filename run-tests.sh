@@ -1,7 +1,7 @@
 #!/bin/bash
 set -exu
 
-DOCKER_IMAGE=golang:1.13.3-stretch
+DOCKER_IMAGE=golang:1.17-bullseye
 
 kafka_repo_root="$(realpath "$(dirname "$0")")"
 vendor_dir="${kafka_repo_root}/shared/vendor"